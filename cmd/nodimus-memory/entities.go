@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/wassmi/nodimus-memory/internal/kg"
+	"github.com/wassmi/nodimus-memory/internal/storage"
+)
+
+var (
+	mergeThreshold float64
+
+	entitiesCmd = &cobra.Command{
+		Use:   "entities",
+		Short: "Inspect and maintain the entities of the knowledge graph",
+	}
+	entitiesListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "Lists all entities with their aliases",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, _, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			entities, err := db.GetEntities()
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tNAME\tTYPE\tALIASES")
+			for _, entity := range entities {
+				aliases, err := db.GetAliases(entity.ID)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", entity.ID, entity.Name, entity.Type, strings.Join(aliases, ", "))
+			}
+			return w.Flush()
+		},
+	}
+	entitiesAliasCmd = &cobra.Command{
+		Use:   "alias <entity> <alias>",
+		Short: "Records another name for an entity",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, _, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			if err := db.AddAlias(args[0], args[1]); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%q is now an alias of %q\n", args[1], args[0])
+			return nil
+		},
+	}
+	entitiesMergeCmd = &cobra.Command{
+		Use:   "merge <source> <target>",
+		Short: "Merges the source entity into the target, keeping the source name as an alias",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, dataDir, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			target, err := db.MergeEntities(args[0], args[1])
+			if err != nil {
+				return err
+			}
+			if err := kg.Generate(db, filepath.Join(dataDir, "knowledge-graph.jsonld")); err != nil {
+				return fmt.Errorf("failed to regenerate knowledge graph: %w", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "merged %q into %q (id %d)\n", args[0], target.Name, target.ID)
+			return nil
+		},
+	}
+	entitiesSuggestCmd = &cobra.Command{
+		Use:   "suggest",
+		Short: "Reports entities that are likely duplicates of each other",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, _, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			suggestions, err := db.SuggestMerges(mergeThreshold)
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "SOURCE\tTARGET\tSCORE\tEDIT\tTOKENS")
+			for _, s := range suggestions {
+				fmt.Fprintf(w, "%s\t%s\t%.2f\t%.2f\t%.2f\n", s.Source.Name, s.Target.Name, s.Score, s.EditSimilarity, s.TokenOverlap)
+			}
+			return w.Flush()
+		},
+	}
+)
+
+func init() {
+	entitiesSuggestCmd.Flags().Float64Var(&mergeThreshold, "threshold", 0.75, "minimum name similarity to report")
+	entitiesCmd.AddCommand(entitiesListCmd, entitiesAliasCmd, entitiesMergeCmd, entitiesSuggestCmd)
+	rootCmd.AddCommand(entitiesCmd)
+}
+
+// openStore loads the configuration and opens the migrated database for
+// one-shot CLI commands.
+func openStore() (*storage.DB, string, error) {
+	cfg, err := ensureConfig(configFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load or create config: %w", err)
+	}
+	return setupCommon(log.New(os.Stderr, "", 0), cfg, &realDBProvider{})
+}
+
+// openSearchStore is openStore with the configured embedder and vector
+// index set up, for commands that embed or search memories.
+func openSearchStore() (*storage.DB, string, error) {
+	cfg, err := ensureConfig(configFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load or create config: %w", err)
+	}
+	logger := log.New(os.Stderr, "", 0)
+	db, dataDir, err := setupCommon(logger, cfg, &realDBProvider{})
+	if err != nil {
+		return nil, "", err
+	}
+	if err := setupEmbedder(logger, db, cfg); err != nil {
+		db.Close()
+		return nil, "", err
+	}
+	return db, dataDir, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wassmi/nodimus-memory/internal/kg"
+)
+
+var (
+	graphAsOf   string
+	graphOutput string
+
+	graphCmd = &cobra.Command{
+		Use:   "graph",
+		Short: "Exports the knowledge graph, optionally as it stood at a past date",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, dataDir, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			output := graphOutput
+			if output == "" {
+				output = filepath.Join(dataDir, "knowledge-graph.jsonld")
+			}
+			if graphAsOf == "" {
+				if err := kg.Generate(db, output); err != nil {
+					return err
+				}
+			} else {
+				asOf, err := parseDate(graphAsOf)
+				if err != nil {
+					return err
+				}
+				if err := kg.GenerateAsOf(db, output, asOf); err != nil {
+					return err
+				}
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "wrote %s\n", output)
+			return nil
+		},
+	}
+)
+
+func init() {
+	graphCmd.Flags().StringVar(&graphAsOf, "as-of", "", "export the graph as of this date (YYYY-MM-DD or RFC 3339)")
+	graphCmd.Flags().StringVarP(&graphOutput, "output", "o", "", "file to write (default is the data directory's knowledge-graph.jsonld)")
+	rootCmd.AddCommand(graphCmd)
+}
+
+// parseDate accepts an RFC 3339 timestamp or a plain UTC date.
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: want YYYY-MM-DD or RFC 3339", s)
+	}
+	return t, nil
+}
@@ -12,15 +12,19 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sync"
 	"syscall"
 
+	"github.com/spf13/cobra"
 	"github.com/wassmi/nodimus-memory/internal/config"
+	"github.com/wassmi/nodimus-memory/internal/embed"
+	"github.com/wassmi/nodimus-memory/internal/hnsw"
 	"github.com/wassmi/nodimus-memory/internal/kg"
 	"github.com/wassmi/nodimus-memory/internal/logger"
 	"github.com/wassmi/nodimus-memory/internal/server"
 	"github.com/wassmi/nodimus-memory/internal/snapshot"
 	"github.com/wassmi/nodimus-memory/internal/storage"
-	"github.com/spf13/cobra"
+	"github.com/wassmi/nodimus-memory/internal/tokens"
 )
 
 var (
@@ -87,11 +91,16 @@ type CommonLogger interface {
 	Println(v ...interface{})
 }
 
+// DataDirExpander resolves the configured data directory.
+type DataDirExpander interface {
+	ExpandDataDir() (string, error)
+}
+
 type DBProvider interface {
 	NewDB(dataSourceName string) (*storage.DB, error)
 }
 
-func setupCommon(log CommonLogger, cfg *config.Config, dbProvider DBProvider) (*storage.DB, string, error) {
+func setupCommon(log CommonLogger, cfg DataDirExpander, dbProvider DBProvider) (*storage.DB, string, error) {
 	dataDir, err := cfg.ExpandDataDir()
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to expand data dir: %w", err)
@@ -118,6 +127,39 @@ func setupCommon(log CommonLogger, cfg *config.Config, dbProvider DBProvider) (*
 	return db, dataDir, nil
 }
 
+// setupEmbedder sets the configured embedder on db, opens the vector index
+// and embeds the memories that have no embeddings from the embedder yet. An
+// embedding server that cannot be reached leaves db without an embedder, so
+// that searches fall back to keywords.
+func setupEmbedder(log CommonLogger, db *storage.DB, cfg *config.Config) error {
+	embedder, err := embed.New(embed.Options(cfg.Embeddings), embed.Metrics{
+		CacheHitRatio: server.EmbedCacheHitRatio.Set,
+		Latency:       server.EmbedLatency.WithLabelValues(cfg.Embeddings.Provider).Observe,
+	})
+	if errors.Is(err, embed.ErrServerUnavailable) {
+		log.Printf("warning: %v; semantic search is disabled until the server is reachable and nodimus-memory is restarted\n", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set up embeddings: %w", err)
+	}
+	if embedder == nil {
+		return nil
+	}
+	db.SetEmbedder(embedder)
+	if err := db.OpenVectorIndex(hnsw.Params(cfg.VectorIndex)); err != nil {
+		return err
+	}
+	n, err := db.SyncEmbeddings(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to embed memories: %w", err)
+	}
+	if n > 0 {
+		log.Printf("embedded %d memories with %s\n", n, embedder.Model())
+	}
+	return nil
+}
+
 type realDBProvider struct{}
 
 func (r *realDBProvider) NewDB(dataSourceName string) (*storage.DB, error) {
@@ -139,11 +181,19 @@ func runHTTPServer() {
 		appLogger.Fatalf("Setup failed: %v", err)
 	}
 	defer db.Close()
+	if err := setupEmbedder(appLogger, db, cfg); err != nil {
+		appLogger.Fatalf("Setup failed: %v", err)
+	}
+	tokenizer, err := tokens.New(cfg.Context.Tokenizer)
+	if err != nil {
+		appLogger.Fatalf("Setup failed: %v", err)
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	mcpService := &server.MemoryService{DB: db, DataDir: dataDir, Log: appLogger}
+	mcpService := &server.MemoryService{DB: db, DataDir: dataDir, Log: appLogger, Limits: server.Limits(cfg.Limits), Scoring: storage.ScoreFunctions(cfg.Scoring),
+		Notifier: server.NewNotifier(appLogger), Tokenizer: tokenizer, WebhookHosts: cfg.Notifications.WebhookHosts}
 	mcpServer := server.NewServer(cfg.Server.Port, cfg.Server.Bind, cfg.Server.Timeout, mcpService)
 	go func() {
 		appLogger.Printf("MCP server listening on %s:%d\n", cfg.Server.Bind, cfg.Server.Port)
@@ -166,7 +216,7 @@ func runHTTPServer() {
 	}
 	defer snapshotter.Stop()
 
-	<-	sigChan
+	<-sigChan
 	appLogger.Println("\nShutting down servers...")
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -176,20 +226,265 @@ func runHTTPServer() {
 	appLogger.Println("Servers stopped.")
 }
 
+// searchMemoryParameters is the JSON schema of the SearchMemory request.
+var searchMemoryParameters = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"query": schemaProperty("string", "The text to search for."),
+		"mode": map[string]interface{}{
+			"type":        "string",
+			"enum":        []string{"match", "query_string", "semantic", "hybrid", "graph"},
+			"description": "match (the default) for plain text; query_string for +required -excluded \"phrases\", field filters such as entity:, tag: or created:>date, prefix* and fuzzy~ words; semantic to search by meaning; hybrid for both; graph to add the memories of related entities.",
+		},
+		"structured_query": schemaProperty("object", "A JSON query tree of must, should and must_not clauses, used instead of query."),
+		"fuzziness":        schemaProperty("integer", "Typos allowed per word, up to 2."),
+		"prefix":           schemaProperty("boolean", "Matches the last word as a prefix."),
+		"history":          schemaProperty("boolean", "Includes memories superseded by another memory."),
+		"usage_boost":      schemaProperty("number", "Ranks frequently and recently used memories higher when positive."),
+		"facets": map[string]interface{}{
+			"type":        "array",
+			"items":       map[string]interface{}{"type": "string", "enum": []string{"entity", "tag", "source", "namespace", "language", "created"}},
+			"description": "Counts the matches by each of these fields.",
+		},
+		"facet_size":      schemaProperty("integer", "The number of terms per facet."),
+		"facet_interval":  schemaEnum("The bucket of the created facet.", "day", "week", "month", "year"),
+		"fusion":          schemaEnum("How hybrid results are combined, rrf by default.", "rrf", "weighted"),
+		"lexical_weight":  schemaProperty("number", "The weight of the lexical results of a hybrid search, 1 by default."),
+		"semantic_weight": schemaProperty("number", "The weight of the semantic results of a hybrid search, 1 by default."),
+		"mmr_lambda":      schemaProperty("number", "Between 0 and 1, re-ranks the results for diversity; lower values favor variety."),
+		"graph_hops":      schemaProperty("integer", "How far a graph search spreads: 1 to 4, or 0 for 2."),
+		"graph_decay":     schemaProperty("number", "The share of activation passed on at every hop, 0.5 by default."),
+		"scoring": map[string]interface{}{
+			"type":        "object",
+			"description": "Overrides the configured score functions for this search.",
+			"properties": map[string]interface{}{
+				"half_life_days":    schemaProperty("number", "The half life of the recency decay, in days."),
+				"decay_from":        schemaEnum("Whether memories age from their creation or last access.", "created", "accessed"),
+				"recency_weight":    schemaProperty("number", "How much of the score decays with age."),
+				"importance_weight": schemaProperty("number", "How much of the score scales with importance."),
+				"pinned_boost":      schemaProperty("number", "The boost of pinned memories."),
+			},
+		},
+		"explain":     schemaProperty("boolean", "Adds the score factors and components to each hit, and the compiled query and filters under debug."),
+		"language":    schemaEnum("Searches only memories in this language; every language by default.", "en", "fr", "de"),
+		"fuzzy_retry": schemaProperty("boolean", "Repeats a search that found nothing allowing typos, and sets retried."),
+		"compat":      schemaProperty("boolean", "Returns only the contents of the results."),
+	},
+}
+
+// schemaProperty describes a property of a JSON schema.
+func schemaProperty(typ, description string) map[string]interface{} {
+	return map[string]interface{}{"type": typ, "description": description}
+}
+
+// schemaEnum describes a string property of a JSON schema that takes one
+// of values.
+func schemaEnum(description string, values ...string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "enum": values, "description": description}
+}
+
+// mcpTools describes the methods advertised to MCP clients on initialize.
+var mcpTools = []map[string]interface{}{
+	{
+		"name":        "memory.AddMemory",
+		"description": "Adds a new memory to the system. Its language (en, fr or de) is detected from the content unless language is given, and decides how its words are stemmed for search.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.SearchMemory",
+		"description": "Searches for memories and returns hits with IDs, scores, snippets and entities, plus the total and any requested facets. When nothing matches, suggestions holds corrected queries.",
+		"parameters":  searchMemoryParameters,
+	},
+	{
+		"name":        "memory.GetContext",
+		"description": "Gets a memory, the memories linked to it and, with a window, the conversation turns around it.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.ListMemories",
+		"description": "Lists memories filtered by entity, date range, tag, source and namespace, one page at a time.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.StaleMemories",
+		"description": "Lists memories that have not been searched for or looked up in a given number of months.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.PinMemory",
+		"description": "Pins a memory so that searches rank it higher, or unpins it with pinned false.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.CreateStandingQuery",
+		"description": "Saves a query (query, mode match or query_string, structured_query, language) that every new memory is matched against; matches arrive as notifications/memory/matched notifications, on the HTTP server's /events stream and at the optional webhook URL, whose host must be one of the configured webhook_hosts.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.ListStandingQueries",
+		"description": "Lists the standing queries.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.DeleteStandingQuery",
+		"description": "Deletes a standing query by ID.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.BuildContext",
+		"description": "Assembles the context for a query or task description within a token budget: the best memories, summaries of the entities they mention and the relationships between them, deduplicated and packed by priority into markdown sections. Takes query, budget (tokens), optional tokenizer (chars or words, overriding the configured estimator), mode, language and history; returns the text, its token count, the IDs and names of what it includes, and how many candidates were omitted for lack of room or as duplicates. Use it instead of dumping search results into the prompt.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.LinkMemories",
+		"description": "Links one memory to another as supersedes, contradicts, elaborates or derived_from.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.UnlinkMemories",
+		"description": "Removes the links from one memory to another.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.StartSession",
+		"description": "Starts a new conversation session for a client.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.AppendTurn",
+		"description": "Appends a turn to a session, starting one if no session is given.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.CloseSession",
+		"description": "Closes a conversation session.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.GetTurns",
+		"description": "Lists the turns of a conversation session.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.GetEntity",
+		"description": "Resolves a name or alias to its canonical entity.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.AddEntityAlias",
+		"description": "Records another name for an entity.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.MergeEntities",
+		"description": "Merges a duplicate entity into another, keeping its name as an alias.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.SuggestEntityMerges",
+		"description": "Reports entities that are likely duplicates of each other.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.ListObservations",
+		"description": "Lists the observations attached to an entity, now or as of a past time.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.ExpireObservations",
+		"description": "Records that observations of entities stopped holding, keeping their history.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.CreateRelationship",
+		"description": "Creates a relationship between two named entities, creating them if needed.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.DeleteRelationship",
+		"description": "Deletes the relationships from one named entity to another.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.ExpireRelationship",
+		"description": "Records that the relationships from one named entity to another stopped holding.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "memory.ListRelationships",
+		"description": "Lists relationships, filtered by entity, direction, type and point in time.",
+		"parameters":  map[string]interface{}{},
+	},
+	// The tools below keep the names used by the reference MCP memory server.
+	{
+		"name":        "create_entities",
+		"description": "Creates entities with optional observations.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "delete_entities",
+		"description": "Deletes entities with their observations and relations.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "create_relations",
+		"description": "Creates relations between entities.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "delete_relations",
+		"description": "Deletes relations between entities.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "add_observations",
+		"description": "Adds observations to existing entities.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "delete_observations",
+		"description": "Deletes observations from entities.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "read_graph",
+		"description": "Reads the entire knowledge graph.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "search_nodes",
+		"description": "Searches entities by name, type and observation content.",
+		"parameters":  map[string]interface{}{},
+	},
+	{
+		"name":        "open_nodes",
+		"description": "Opens entities by name along with the relations between them.",
+		"parameters":  map[string]interface{}{},
+	},
+}
+
 type JSONRPCRequest struct {
-	JSONRPC string          `json:"jsonrpc"`
-	Method  string          `json:"method"`
-	Params  json.RawMessage `json:"params"`
+	JSONRPC string           `json:"jsonrpc"`
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params"`
 	ID      *json.RawMessage `json:"id"`
 }
 
 type JSONRPCResponse struct {
-	JSONRPC string          `json:"jsonrpc"`
-	Result  interface{}     `json:"result,omitempty"`
-	Error   *JSONRPCError   `json:"error,omitempty"`
+	JSONRPC string           `json:"jsonrpc"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *JSONRPCError    `json:"error,omitempty"`
 	ID      *json.RawMessage `json:"id"`
 }
 
+// JSONRPCNotification is a message sent to the client without a request,
+// such as a standing query match.
+type JSONRPCNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
 type JSONRPCError struct {
 	Code    int         `json:"code"`
 	Message string      `json:"message"`
@@ -214,10 +509,32 @@ func runStdioServer() {
 		os.Exit(1)
 	}
 	defer db.Close()
+	if err := setupEmbedder(appLogger, db, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Setup failed: %v\n", err)
+		os.Exit(1)
+	}
+	tokenizer, err := tokens.New(cfg.Context.Tokenizer)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Setup failed: %v\n", err)
+		os.Exit(1)
+	}
 
-	mcpService := &server.MemoryService{DB: db, DataDir: dataDir, Log: appLogger}
+	notifier := server.NewNotifier(appLogger)
+	mcpService := &server.MemoryService{DB: db, DataDir: dataDir, Log: appLogger, Limits: server.Limits(cfg.Limits), Scoring: storage.ScoreFunctions(cfg.Scoring),
+		Notifier: notifier, Tokenizer: tokenizer, WebhookHosts: cfg.Notifications.WebhookHosts}
 	reader := bufio.NewReader(os.Stdin)
 	writer := bufio.NewWriter(os.Stdout)
+	// Notifications of standing queries are written between responses.
+	var writeMu sync.Mutex
+	notes, unsubscribe := notifier.Subscribe()
+	defer unsubscribe()
+	go func() {
+		for note := range notes {
+			writeMu.Lock()
+			writeMessage(writer, JSONRPCNotification{JSONRPC: "2.0", Method: "notifications/memory/matched", Params: note}, appLogger)
+			writeMu.Unlock()
+		}
+	}()
 
 	for {
 		line, err := reader.ReadBytes('\n')
@@ -242,69 +559,116 @@ func runStdioServer() {
 			resp.Result = map[string]interface{}{
 				"protocolVersion": "2025-06-18",
 				"serverInfo": map[string]interface{}{
-					"name": "nodimus-memory",
-					"tools": []map[string]interface{}{
-						{
-							"name":        "memory.AddMemory",
-							"description": "Adds a new memory to the system.",
-							"parameters":  map[string]interface{}{},
-						},
-						{
-							"name":        "memory.SearchMemory",
-							"description": "Searches for memories based on a query.",
-							"parameters":  map[string]interface{}{},
-						},
-					},
+					"name":  "nodimus-memory",
+					"tools": mcpTools,
 				},
 			}
 		case "shutdown":
 			return // Exit cleanly
 		case "memory.AddMemory":
-			var params server.AddMemoryRequest
-			if err := json.Unmarshal(req.Params, &params); err == nil {
-				var reply server.AddMemoryResponse
-				if err := mcpService.AddMemory(nil, &params, &reply); err == nil {
-					resp.Result = reply
-				} else {
-					resp.Error = &JSONRPCError{Code: -32000, Message: "Server error", Data: err.Error()}
-				}
-			} else {
-				resp.Error = &JSONRPCError{Code: -32602, Message: "Invalid params", Data: err.Error()}
-			}
+			resp.Result, resp.Error = call(req.Params, mcpService.AddMemory)
 		case "memory.SearchMemory":
-			var params server.SearchMemoryRequest
-			if err := json.Unmarshal(req.Params, &params); err == nil {
-				var reply server.SearchMemoryResponse
-				if err := mcpService.SearchMemory(nil, &params, &reply); err == nil {
-					resp.Result = reply
-				} else {
-					resp.Error = &JSONRPCError{Code: -32000, Message: "Server error", Data: err.Error()}
-				}
-			} else {
-				resp.Error = &JSONRPCError{Code: -32602, Message: "Invalid params", Data: err.Error()}
-			}
+			resp.Result, resp.Error = call(req.Params, mcpService.SearchMemory)
 		case "memory.GetContext":
-			var params server.GetContextRequest
-			if err := json.Unmarshal(req.Params, &params); err == nil {
-				var reply server.GetContextResponse
-				if err := mcpService.GetContext(nil, &params, &reply); err == nil {
-					resp.Result = reply
-				} else {
-					resp.Error = &JSONRPCError{Code: -32000, Message: "Server error", Data: err.Error()}
-				}
-			} else {
-				resp.Error = &JSONRPCError{Code: -32602, Message: "Invalid params", Data: err.Error()}
-			}
+			resp.Result, resp.Error = call(req.Params, mcpService.GetContext)
+		case "memory.ListMemories":
+			resp.Result, resp.Error = call(req.Params, mcpService.ListMemories)
+		case "memory.StaleMemories":
+			resp.Result, resp.Error = call(req.Params, mcpService.StaleMemories)
+		case "memory.PinMemory":
+			resp.Result, resp.Error = call(req.Params, mcpService.PinMemory)
+		case "memory.CreateStandingQuery":
+			resp.Result, resp.Error = call(req.Params, mcpService.CreateStandingQuery)
+		case "memory.ListStandingQueries":
+			resp.Result, resp.Error = call(req.Params, mcpService.ListStandingQueries)
+		case "memory.DeleteStandingQuery":
+			resp.Result, resp.Error = call(req.Params, mcpService.DeleteStandingQuery)
+		case "memory.BuildContext":
+			resp.Result, resp.Error = call(req.Params, mcpService.BuildContext)
+		case "memory.LinkMemories":
+			resp.Result, resp.Error = call(req.Params, mcpService.LinkMemories)
+		case "memory.UnlinkMemories":
+			resp.Result, resp.Error = call(req.Params, mcpService.UnlinkMemories)
+		case "memory.StartSession":
+			resp.Result, resp.Error = call(req.Params, mcpService.StartSession)
+		case "memory.AppendTurn":
+			resp.Result, resp.Error = call(req.Params, mcpService.AppendTurn)
+		case "memory.CloseSession":
+			resp.Result, resp.Error = call(req.Params, mcpService.CloseSession)
+		case "memory.GetTurns":
+			resp.Result, resp.Error = call(req.Params, mcpService.GetTurns)
+		case "memory.GetEntity":
+			resp.Result, resp.Error = call(req.Params, mcpService.GetEntity)
+		case "memory.AddEntityAlias":
+			resp.Result, resp.Error = call(req.Params, mcpService.AddEntityAlias)
+		case "memory.MergeEntities":
+			resp.Result, resp.Error = call(req.Params, mcpService.MergeEntities)
+		case "memory.SuggestEntityMerges":
+			resp.Result, resp.Error = call(req.Params, mcpService.SuggestEntityMerges)
+		case "memory.ListObservations":
+			resp.Result, resp.Error = call(req.Params, mcpService.ListObservations)
+		case "memory.CreateEntities", "create_entities":
+			resp.Result, resp.Error = call(req.Params, mcpService.CreateEntities)
+		case "memory.DeleteEntities", "delete_entities":
+			resp.Result, resp.Error = call(req.Params, mcpService.DeleteEntities)
+		case "memory.AddObservations", "add_observations":
+			resp.Result, resp.Error = call(req.Params, mcpService.AddObservations)
+		case "memory.DeleteObservations", "delete_observations":
+			resp.Result, resp.Error = call(req.Params, mcpService.DeleteObservations)
+		case "memory.ExpireObservations":
+			resp.Result, resp.Error = call(req.Params, mcpService.ExpireObservations)
+		case "memory.ReadGraph", "read_graph":
+			resp.Result, resp.Error = call(req.Params, mcpService.ReadGraph)
+		case "memory.SearchNodes", "search_nodes":
+			resp.Result, resp.Error = call(req.Params, mcpService.SearchNodes)
+		case "memory.OpenNodes", "open_nodes":
+			resp.Result, resp.Error = call(req.Params, mcpService.OpenNodes)
+		case "memory.CreateRelationship":
+			resp.Result, resp.Error = call(req.Params, mcpService.CreateRelationship)
+		case "memory.DeleteRelationship":
+			resp.Result, resp.Error = call(req.Params, mcpService.DeleteRelationship)
+		case "memory.ExpireRelationship":
+			resp.Result, resp.Error = call(req.Params, mcpService.ExpireRelationship)
+		case "memory.ListRelationships":
+			resp.Result, resp.Error = call(req.Params, mcpService.ListRelationships)
+		case "memory.CreateRelations", "create_relations":
+			resp.Result, resp.Error = call(req.Params, mcpService.CreateRelations)
+		case "memory.DeleteRelations", "delete_relations":
+			resp.Result, resp.Error = call(req.Params, mcpService.DeleteRelations)
 		default:
 			resp.Error = &JSONRPCError{Code: -32601, Message: "Method not found"}
 		}
+		writeMu.Lock()
 		writeResponse(writer, resp, appLogger)
+		writeMu.Unlock()
 	}
 }
 
+// call decodes params into the request type of a MemoryService method,
+// invokes it and returns either its reply or the matching JSON-RPC error.
+func call[Req, Resp any](params json.RawMessage, method func(*http.Request, *Req, *Resp) error) (interface{}, *JSONRPCError) {
+	var args Req
+	if len(params) > 0 && string(params) != "null" {
+		if err := json.Unmarshal(params, &args); err != nil {
+			return nil, &JSONRPCError{Code: -32602, Message: "Invalid params", Data: err.Error()}
+		}
+	}
+	var reply Resp
+	if err := method(nil, &args, &reply); err != nil {
+		rpcErr := server.RPCError(err)
+		return nil, &JSONRPCError{Code: int(rpcErr.Code), Message: rpcErr.Message, Data: rpcErr.Data}
+	}
+	return reply, nil
+}
+
 func writeResponse(writer *bufio.Writer, resp JSONRPCResponse, log CommonLogger) {
-	respBytes, _ := json.Marshal(resp)
+	writeMessage(writer, resp, log)
+}
+
+// writeMessage writes a JSON-RPC message on a line of its own.
+func writeMessage(writer *bufio.Writer, msg interface{}, log CommonLogger) {
+	respBytes, _ := json.Marshal(msg)
 	writer.Write(respBytes)
 	writer.WriteString("\n")
 	writer.Flush()
-}
\ No newline at end of file
+}
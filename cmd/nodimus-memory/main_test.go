@@ -2,9 +2,17 @@ package main
 
 import (
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/wassmi/nodimus-memory/internal/config"
+	"github.com/wassmi/nodimus-memory/internal/embed"
+	"github.com/wassmi/nodimus-memory/internal/server"
 	"github.com/wassmi/nodimus-memory/internal/storage"
 )
 
@@ -110,4 +118,52 @@ func TestSetupCommon(t *testing.T) {
 			t.Error("Expected an error when NewDB fails, but got nil")
 		}
 	})
-}
\ No newline at end of file
+}
+func TestSetupEmbedderUnavailable(t *testing.T) {
+	db, err := storage.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	// A server that is gone leaves searches to keywords.
+	gone := httptest.NewServer(http.NotFoundHandler())
+	gone.Close()
+	cfg := config.Default()
+	cfg.Embeddings.Provider = embed.ProviderOllama
+	cfg.Embeddings.URL = gone.URL
+	cfg.Embeddings.ModelName = "mini"
+	cfg.Embeddings.Retries = 0
+	if err := setupEmbedder(&MockLogger{}, db, cfg); err != nil {
+		t.Fatalf("expected an unreachable server to be skipped, got %v", err)
+	}
+	if _, err := db.Search(storage.SearchOptions{Query: "payments", Mode: storage.QuerySemantic}); !errors.Is(err, storage.ErrNoEmbedder) {
+		t.Errorf("expected semantic search to be disabled, got %v", err)
+	}
+	if _, err := db.Search(storage.SearchOptions{Query: "payments"}); err != nil {
+		t.Errorf("expected keyword search to work, got %v", err)
+	}
+
+	// A configuration error still fails.
+	cfg.Embeddings.ModelName = ""
+	if err := setupEmbedder(&MockLogger{}, db, cfg); err == nil {
+		t.Error("expected an error for an embedding server without a model")
+	}
+}
+
+func TestSearchMemoryParameters(t *testing.T) {
+	properties := searchMemoryParameters["properties"].(map[string]interface{})
+	fields := reflect.TypeOf(server.SearchMemoryRequest{})
+	for i := 0; i < fields.NumField(); i++ {
+		name := strings.Split(fields.Field(i).Tag.Get("json"), ",")[0]
+		if _, ok := properties[name]; !ok {
+			t.Errorf("expected the schema to describe %s", name)
+		}
+	}
+	if len(properties) != fields.NumField() {
+		t.Errorf("expected %d properties, got %d", fields.NumField(), len(properties))
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/wassmi/nodimus-memory/internal/storage"
+)
+
+var (
+	listFilter   storage.MemoryFilter
+	listOptions  storage.ListOptions
+	listSince    string
+	listUntil    string
+	listAllPages bool
+
+	memoriesCmd = &cobra.Command{
+		Use:   "memories",
+		Short: "Inspect stored memories",
+	}
+	memoriesListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "Lists memories, newest first, one page at a time",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filter := listFilter
+			if listSince != "" {
+				since, err := parseDate(listSince)
+				if err != nil {
+					return err
+				}
+				filter.Since = since
+			}
+			if listUntil != "" {
+				until, err := parseDate(listUntil)
+				if err != nil {
+					return err
+				}
+				filter.Until = until
+			}
+
+			db, _, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tCREATED\tIMPORTANCE\tNAMESPACE\tSOURCE\tTAGS\tCONTENT")
+			opts := listOptions
+			for {
+				page, err := db.ListMemories(filter, opts)
+				if err != nil {
+					return err
+				}
+				for _, m := range page.Memories {
+					fmt.Fprintf(w, "%d\t%s\t%.2f\t%s\t%s\t%s\t%s\n", m.ID, m.CreatedAt.Format("2006-01-02 15:04"),
+						m.Importance, m.Namespace, m.Source, strings.Join(m.Tags, ","), summarize(m.Content, 60))
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				if !listAllPages {
+					if err := w.Flush(); err != nil {
+						return err
+					}
+					fmt.Fprintf(cmd.OutOrStdout(), "\nnext page: --cursor %s\n", page.NextCursor)
+					return nil
+				}
+				opts.Cursor = page.NextCursor
+			}
+			return w.Flush()
+		},
+	}
+)
+
+func init() {
+	flags := memoriesListCmd.Flags()
+	flags.StringVar(&listFilter.Entity, "entity", "", "only memories mentioning this entity")
+	flags.StringVar(&listSince, "since", "", "only memories created on or after this date (YYYY-MM-DD or RFC 3339)")
+	flags.StringVar(&listUntil, "until", "", "only memories created before this date (YYYY-MM-DD or RFC 3339)")
+	flags.StringVar(&listFilter.Tag, "tag", "", "only memories with this tag")
+	flags.StringVar(&listFilter.Source, "source", "", "only memories from this source")
+	flags.StringVar(&listFilter.Namespace, "namespace", "", "only memories in this namespace")
+	flags.StringVar(&listOptions.Sort, "sort", storage.SortCreated, "sort by created, updated or importance")
+	flags.BoolVar(&listOptions.Ascending, "asc", false, "list oldest or least important first")
+	flags.IntVar(&listOptions.Limit, "limit", 50, "memories per page")
+	flags.StringVar(&listOptions.Cursor, "cursor", "", "continue from a previous page")
+	flags.BoolVar(&listAllPages, "all", false, "list every page")
+	memoriesCmd.AddCommand(memoriesListCmd)
+	rootCmd.AddCommand(memoriesCmd)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/spf13/cobra"
+	"github.com/wassmi/nodimus-memory/internal/storage"
+)
+
+var (
+	searchOptions storage.SearchOptions
+
+	searchCmd = &cobra.Command{
+		Use:   "search <query>",
+		Short: "Searches memories, optionally explaining how each one was scored",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := ensureConfig(configFile)
+			if err != nil {
+				return fmt.Errorf("failed to load or create config: %w", err)
+			}
+			db, _, err := openSearchStore()
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			opts := searchOptions
+			opts.Query = strings.Join(args, " ")
+			opts.Scoring = storage.ScoreFunctions(cfg.Scoring)
+			result, err := db.Search(opts)
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			if result.Debug != nil {
+				printSearchDebug(out, result.Debug)
+			}
+			if len(result.Hits) == 0 {
+				fmt.Fprintln(out, "No memories found.")
+				if len(result.Suggestions) > 0 {
+					fmt.Fprintf(out, "Did you mean: %s?\n", strings.Join(result.Suggestions, ", "))
+				}
+				return nil
+			}
+			if result.Retried {
+				fmt.Fprintln(out, "Nothing matched exactly; showing results allowing typos.")
+			}
+			if !opts.Explain {
+				w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "RANK\tSCORE\tID\tCONTENT")
+				for i, hit := range result.Hits {
+					fmt.Fprintf(w, "%d\t%.4f\t%d\t%s\n", i+1, hit.Score, hit.ID, summarize(hit.Content, 60))
+				}
+				return w.Flush()
+			}
+			for i, hit := range result.Hits {
+				fmt.Fprintf(out, "\n#%d  memory %d  score %.4f\n  %s\n", i+1, hit.ID, hit.Score, summarize(hit.Content, 72))
+				printHitExplanation(out, hit)
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	searchCmd.Flags().StringVar(&searchOptions.Mode, "mode", storage.QueryMatch, "query mode: match, query_string, semantic, hybrid or graph")
+	searchCmd.Flags().StringVar(&searchOptions.Language, "language", "", "only search memories in this language (en, fr or de)")
+	searchCmd.Flags().IntVar(&searchOptions.Fuzziness, "fuzziness", 0, "typos allowed per word (0 to 2)")
+	searchCmd.Flags().BoolVar(&searchOptions.History, "history", false, "include superseded memories")
+	searchCmd.Flags().BoolVar(&searchOptions.FuzzyRetry, "fuzzy-retry", false, "retry a search that found nothing allowing typos")
+	searchCmd.Flags().BoolVar(&searchOptions.Explain, "explain", false, "show how each memory was scored and how the query ran")
+	rootCmd.AddCommand(searchCmd)
+}
+
+// printSearchDebug prints the mode, compiled query and filters of an
+// explained search.
+func printSearchDebug(w io.Writer, debug *storage.SearchDebug) {
+	fmt.Fprintf(w, "Mode: %s\n", debug.Mode)
+	if len(debug.Query) > 0 {
+		fmt.Fprintf(w, "Query: %s\n", debug.Query)
+	}
+	fmt.Fprintln(w, "Filters:")
+	for _, filter := range debug.Filters {
+		fmt.Fprintf(w, "  - %s\n", filter)
+	}
+}
+
+// printHitExplanation prints the score factors, components and bleve
+// explanation of a hit.
+func printHitExplanation(w io.Writer, hit storage.SearchHit) {
+	if e := hit.Explanation; e != nil {
+		fmt.Fprintf(w, "  factors: relevance %.4f × usage %.3f × recency %.3f × importance %.3f × pinned %.3f\n",
+			e.Relevance, e.Usage, e.Recency, e.Importance, e.Pinned)
+	}
+	if c := hit.Components; c != nil {
+		var parts []string
+		if c.LexicalRank > 0 {
+			parts = append(parts, fmt.Sprintf("lexical %.4f (rank %d)", c.Lexical, c.LexicalRank))
+		}
+		if c.SemanticRank > 0 {
+			parts = append(parts, fmt.Sprintf("semantic %.4f (rank %d)", c.Semantic, c.SemanticRank))
+		}
+		if c.Graph > 0 {
+			parts = append(parts, fmt.Sprintf("graph %.4f via %s", c.Graph, c.Via))
+		}
+		if len(parts) > 0 {
+			fmt.Fprintf(w, "  components: %s\n", strings.Join(parts, ", "))
+		}
+	}
+	if hit.Explanation != nil && hit.Explanation.Lexical != nil {
+		fmt.Fprintln(w, "  lexical score:")
+		printExplanation(w, hit.Explanation.Lexical, 2)
+	}
+}
+
+// printExplanation prints a bleve score explanation as an indented tree.
+func printExplanation(w io.Writer, expl *search.Explanation, depth int) {
+	fmt.Fprintf(w, "%s%.4f  %s\n", strings.Repeat("  ", depth), expl.Value, expl.Message)
+	for _, child := range expl.Children {
+		printExplanation(w, child, depth+1)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	staleMonths int
+	staleLimit  int
+
+	staleCmd = &cobra.Command{
+		Use:   "stale",
+		Short: "Lists memories that have not been used in a while",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if staleMonths <= 0 {
+				return fmt.Errorf("--months must be positive, got %d", staleMonths)
+			}
+			db, _, err := openStore()
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			memories, err := db.StaleMemories(time.Now().AddDate(0, -staleMonths, 0), staleLimit)
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tLAST USED\tACCESSES\tCONTENT")
+			for _, memory := range memories {
+				lastUsed := "never"
+				if memory.LastAccessedAt != nil {
+					lastUsed = memory.LastAccessedAt.Format("2006-01-02")
+				}
+				fmt.Fprintf(w, "%d\t%s\t%d\t%s\n", memory.ID, lastUsed, memory.AccessCount, summarize(memory.Content, 60))
+			}
+			return w.Flush()
+		},
+	}
+)
+
+func init() {
+	staleCmd.Flags().IntVar(&staleMonths, "months", 6, "report memories unused for this many months")
+	staleCmd.Flags().IntVar(&staleLimit, "limit", 50, "maximum number of memories to list (0 for all)")
+	rootCmd.AddCommand(staleCmd)
+}
+
+// summarize shortens content to a single line of at most n runes.
+func summarize(content string, n int) string {
+	line := strings.Join(strings.Fields(content), " ")
+	if runes := []rune(line); len(runes) > n {
+		return string(runes[:n-1]) + "…"
+	}
+	return line
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	vectorsCmd = &cobra.Command{
+		Use:   "vectors",
+		Short: "Maintain the vector index used by semantic search",
+	}
+	vectorsRebuildCmd = &cobra.Command{
+		Use:   "rebuild",
+		Short: "Rebuilds the vector index from the stored embeddings",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, _, err := openSearchStore()
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			if db.Embedder() == nil {
+				return errors.New("embeddings are disabled in the configuration")
+			}
+			n, err := db.RebuildVectorIndex()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "indexed %d vectors from %s\n", n, db.Embedder().Model())
+			return nil
+		},
+	}
+)
+
+func init() {
+	vectorsCmd.AddCommand(vectorsRebuildCmd)
+	rootCmd.AddCommand(vectorsCmd)
+}
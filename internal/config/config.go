@@ -16,6 +16,19 @@ type Config struct {
 	Server  ServerConfig  `toml:"server"`
 	Storage StorageConfig `toml:"storage"`
 	Logger  LoggerConfig  `toml:"logger"`
+	Limits  LimitsConfig  `toml:"limits"`
+	// Embeddings configures the vectors memories are embedded as for
+	// semantic search.
+	Embeddings EmbeddingsConfig `toml:"embeddings"`
+	// VectorIndex tunes the index semantic searches run against.
+	VectorIndex VectorIndexConfig `toml:"vector_index"`
+	// Scoring weighs search results by recency, importance and pinning.
+	Scoring ScoringConfig `toml:"scoring"`
+	// Context configures the assembly of token-budgeted context blocks.
+	Context ContextConfig `toml:"context"`
+	// Notifications configures the announcement of memories matching
+	// standing queries.
+	Notifications NotificationsConfig `toml:"notifications"`
 }
 
 // ServerConfig holds the server-related configuration.
@@ -40,9 +53,79 @@ type LoggerConfig struct {
 	Compress   bool   `toml:"compress"`
 }
 
-// Load loads the configuration from the given file path.
+// LimitsConfig holds the limits applied to incoming data. Zero disables a
+// limit.
+type LimitsConfig struct {
+	MaxContentBytes         int   `toml:"max_content_bytes"`
+	MaxEntities             int   `toml:"max_entities"`
+	MaxEntityNameLength     int   `toml:"max_entity_name_length"`
+	MaxTags                 int   `toml:"max_tags"`
+	MaxTagLength            int   `toml:"max_tag_length"`
+	MaxMemoriesPerNamespace int64 `toml:"max_memories_per_namespace"`
+	MaxStoreBytes           int64 `toml:"max_store_bytes"`
+}
+
+// EmbeddingsConfig holds the embedding-related configuration. Provider is
+// "hash" for the built-in hashing embedder, "local" for the word-vector
+// model file at ModelPath, checked against the checksum pinned for
+// ModelName or else ModelSHA256, "openai" or "ollama" for an embedding
+// server at URL running the model ModelName, or "none". Timeout is in
+// seconds.
+type EmbeddingsConfig struct {
+	Provider    string `toml:"provider"`
+	Dimensions  int    `toml:"dimensions"`
+	ModelName   string `toml:"model_name"`
+	ModelPath   string `toml:"model_path"`
+	ModelSHA256 string `toml:"model_sha256"`
+	CacheSize   int    `toml:"cache_size"`
+	URL         string `toml:"url"`
+	BatchSize   int    `toml:"batch_size"`
+	Timeout     int    `toml:"timeout"`
+	Retries     int    `toml:"retries"`
+}
+
+// VectorIndexConfig holds the parameters of the HNSW vector index: the
+// neighbours each node keeps and the candidates considered when inserting
+// and searching. Larger values trade speed and size for recall.
+type VectorIndexConfig struct {
+	M              int `toml:"m"`
+	EfConstruction int `toml:"ef_construction"`
+	EfSearch       int `toml:"ef_search"`
+}
+
+// ScoringConfig holds the default score functions of searches: the half
+// life in days of the recency decay, whether memories age from their
+// creation or last access ("created" or "accessed"), how much of the score
+// decays and scales with importance, and the boost of pinned memories. The
+// weights and the boost default to zero, which leaves scores alone.
+type ScoringConfig struct {
+	HalfLifeDays     float64 `toml:"half_life_days"`
+	DecayFrom        string  `toml:"decay_from"`
+	RecencyWeight    float64 `toml:"recency_weight"`
+	ImportanceWeight float64 `toml:"importance_weight"`
+	PinnedBoost      float64 `toml:"pinned_boost"`
+}
+
+// ContextConfig holds the context assembly configuration. Tokenizer names
+// the estimator that counts tokens against budgets: "chars" or "words".
+type ContextConfig struct {
+	Tokenizer string `toml:"tokenizer"`
+}
+
+// NotificationsConfig holds the notification configuration. WebhookHosts
+// are the hosts standing query webhooks may post to. The server makes
+// these requests itself on behalf of any client, so webhooks are refused
+// while the list is empty.
+type NotificationsConfig struct {
+	WebhookHosts []string `toml:"webhook_hosts"`
+}
+
+// Load loads the configuration from the given file path. Limits, embedding,
+// vector index, scoring and context settings missing from the file keep their
+// defaults, so older files stay protected.
 func Load(path string) (*Config, error) {
-	config := &Config{}
+	defaults := Default()
+	config := &Config{Limits: defaults.Limits, Embeddings: defaults.Embeddings, VectorIndex: defaults.VectorIndex, Scoring: defaults.Scoring, Context: defaults.Context}
 	_, err := toml.DecodeFile(path, config)
 	if err != nil {
 		return nil, err
@@ -69,6 +152,38 @@ func Default() *Config {
 			MaxAge:     30, // days
 			Compress:   true,
 		},
+		Limits: LimitsConfig{
+			MaxContentBytes:         64 << 10,
+			MaxEntities:             100,
+			MaxEntityNameLength:     256,
+			MaxTags:                 50,
+			MaxTagLength:            64,
+			MaxMemoriesPerNamespace: 100000,
+			MaxStoreBytes:           1 << 30,
+		},
+		Embeddings: EmbeddingsConfig{
+			Provider:   "hash",
+			Dimensions: 256,
+			CacheSize:  1024,
+			BatchSize:  32,
+			Timeout:    30,
+			Retries:    2,
+		},
+		VectorIndex: VectorIndexConfig{
+			M:              16,
+			EfConstruction: 200,
+			EfSearch:       64,
+		},
+		Scoring: ScoringConfig{
+			HalfLifeDays:     90,
+			DecayFrom:        "created",
+			RecencyWeight:    0,
+			ImportanceWeight: 0,
+			PinnedBoost:      0,
+		},
+		Context: ContextConfig{
+			Tokenizer: "chars",
+		},
 	}
 }
 
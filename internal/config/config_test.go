@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"testing"
 )
 
@@ -25,6 +26,9 @@ max_size = 10
 max_backups = 2
 max_age = 7
 compress = false
+
+[notifications]
+webhook_hosts = ["hooks.example.com"]
 `
 	tmpfile, err := ioutil.TempFile("", "config_test_*.toml")
 	if err != nil {
@@ -54,6 +58,77 @@ compress = false
 	if cfg.Logger.Level != "debug" {
 		t.Errorf("Expected logger level debug, got %s", cfg.Logger.Level)
 	}
+	if len(cfg.Notifications.WebhookHosts) != 1 || cfg.Notifications.WebhookHosts[0] != "hooks.example.com" {
+		t.Errorf("Expected webhook host hooks.example.com, got %v", cfg.Notifications.WebhookHosts)
+	}
+	if cfg.Limits != Default().Limits {
+		t.Errorf("Expected default limits for a file without a limits section, got %+v", cfg.Limits)
+	}
+	if cfg.Embeddings != Default().Embeddings {
+		t.Errorf("Expected default embeddings for a file without an embeddings section, got %+v", cfg.Embeddings)
+	}
+	if cfg.VectorIndex != Default().VectorIndex {
+		t.Errorf("Expected default vector index parameters for a file without a vector_index section, got %+v", cfg.VectorIndex)
+	}
+	if cfg.Scoring != Default().Scoring {
+		t.Errorf("Expected default scoring for a file without a scoring section, got %+v", cfg.Scoring)
+	}
+	if cfg.Context != Default().Context {
+		t.Errorf("Expected default context settings for a file without a context section, got %+v", cfg.Context)
+	}
+}
+
+func TestLoadLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	content := `
+[limits]
+max_content_bytes = 1024
+max_store_bytes = 0
+max_tags = 10
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Limits.MaxContentBytes != 1024 {
+		t.Errorf("Expected max_content_bytes 1024, got %d", cfg.Limits.MaxContentBytes)
+	}
+	if cfg.Limits.MaxStoreBytes != 0 {
+		t.Errorf("Expected max_store_bytes to be disabled, got %d", cfg.Limits.MaxStoreBytes)
+	}
+	if cfg.Limits.MaxEntities != Default().Limits.MaxEntities {
+		t.Errorf("Expected the default max_entities, got %d", cfg.Limits.MaxEntities)
+	}
+	if cfg.Limits.MaxTags != 10 || cfg.Limits.MaxTagLength != Default().Limits.MaxTagLength {
+		t.Errorf("Expected max_tags 10 and the default max_tag_length, got %d and %d", cfg.Limits.MaxTags, cfg.Limits.MaxTagLength)
+	}
+}
+
+func TestSampleConfig(t *testing.T) {
+	sample, err := os.ReadFile(filepath.Join("..", "..", "config.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The commented out settings of the sample are the defaults.
+	uncommented := regexp.MustCompile(`(?m)^#(\[|[a-z_]+ = )`).ReplaceAll(sample, []byte("$1"))
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, uncommented, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	defaults := Default()
+	if cfg.Limits != defaults.Limits || cfg.Embeddings != defaults.Embeddings || cfg.VectorIndex != defaults.VectorIndex ||
+		cfg.Scoring != defaults.Scoring || cfg.Context != defaults.Context || len(cfg.Notifications.WebhookHosts) != 0 {
+		t.Errorf("Expected the sample to document the defaults, got %+v", cfg)
+	}
 }
 
 func TestDefault(t *testing.T) {
@@ -65,6 +140,12 @@ func TestDefault(t *testing.T) {
 	if cfg.Storage.DataDir != "~/.nodimus-memory" {
 		t.Errorf("Expected default data dir ~/.nodimus-memory, got %s", cfg.Storage.DataDir)
 	}
+	if cfg.Scoring.RecencyWeight != 0 || cfg.Scoring.ImportanceWeight != 0 || cfg.Scoring.PinnedBoost != 0 {
+		t.Errorf("Expected score functions to be off by default, got %+v", cfg.Scoring)
+	}
+	if len(cfg.Notifications.WebhookHosts) != 0 {
+		t.Errorf("Expected no webhook hosts by default, got %v", cfg.Notifications.WebhookHosts)
+	}
 }
 
 func TestExpandDataDir(t *testing.T) {
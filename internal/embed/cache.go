@@ -0,0 +1,113 @@
+package embed
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"sync"
+)
+
+// Cache keeps the most recently used vectors of an embedder in memory, so
+// repeated texts such as common queries are embedded once.
+type Cache struct {
+	Embedder
+	size   int
+	report func(ratio float64)
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	order   *list.List // front is most recently used
+	hits    uint64
+	misses  uint64
+}
+
+type cacheKey [sha256.Size]byte
+
+type cacheEntry struct {
+	key    cacheKey
+	vector []float32
+}
+
+// NewCache wraps e with a cache of up to size vectors. After every call to
+// Embed the overall hit ratio is passed to report, which may be nil.
+func NewCache(e Embedder, size int, report func(ratio float64)) *Cache {
+	return &Cache{
+		Embedder: e,
+		size:     size,
+		report:   report,
+		entries:  map[cacheKey]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+// Embed implements Embedder, embedding only the texts not in the cache.
+// Cached vectors are shared, so callers must not modify them.
+func (c *Cache) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	keys := make([]cacheKey, len(texts))
+	var missing []string
+	var missingAt []int
+
+	c.mu.Lock()
+	for i, text := range texts {
+		keys[i] = c.key(text)
+		if el, ok := c.entries[keys[i]]; ok {
+			c.order.MoveToFront(el)
+			vectors[i] = el.Value.(*cacheEntry).vector
+			c.hits++
+			continue
+		}
+		missing = append(missing, text)
+		missingAt = append(missingAt, i)
+		c.misses++
+	}
+	c.mu.Unlock()
+
+	if len(missing) > 0 {
+		embedded, err := c.Embedder.Embed(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		for j, i := range missingAt {
+			vectors[i] = embedded[j]
+			c.add(keys[i], embedded[j])
+		}
+		c.mu.Unlock()
+	}
+
+	if c.report != nil {
+		c.report(c.HitRatio())
+	}
+	return vectors, nil
+}
+
+// HitRatio returns the share of texts that were served from the cache.
+func (c *Cache) HitRatio() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hits+c.misses == 0 {
+		return 0
+	}
+	return float64(c.hits) / float64(c.hits+c.misses)
+}
+
+// key identifies a text embedded by the wrapped model.
+func (c *Cache) key(text string) cacheKey {
+	return sha256.Sum256([]byte(c.Model() + "\x00" + text))
+}
+
+// add stores a vector, evicting the least recently used one when the cache
+// is full. The caller holds c.mu.
+func (c *Cache) add(key cacheKey, vector []float32) {
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, vector: vector})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,49 @@
+package embed
+
+import (
+	"context"
+	"testing"
+)
+
+// countingEmbedder counts the texts it is asked to embed.
+type countingEmbedder struct {
+	*HashEmbedder
+	texts int
+}
+
+func (e *countingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.texts += len(texts)
+	return e.HashEmbedder.Embed(ctx, texts)
+}
+
+func TestCache(t *testing.T) {
+	inner := &countingEmbedder{HashEmbedder: NewHashEmbedder(16)}
+	var ratio float64
+	cache := NewCache(inner, 2, func(r float64) { ratio = r })
+	ctx := context.Background()
+
+	if _, err := cache.Embed(ctx, []string{"a", "b"}); err != nil {
+		t.Fatalf("failed to embed: %v", err)
+	}
+	vectors, err := cache.Embed(ctx, []string{"a", "c"})
+	if err != nil {
+		t.Fatalf("failed to embed: %v", err)
+	}
+	if inner.texts != 3 || ratio != 0.25 || cache.HitRatio() != 0.25 {
+		t.Errorf("expected one hit in four texts, got %d embedded and a ratio of %v", inner.texts, ratio)
+	}
+	want, _ := inner.HashEmbedder.Embed(ctx, []string{"a", "c"})
+	for i := range want {
+		if Cosine(vectors[i], want[i]) < 0.99999 {
+			t.Errorf("vector %d differs from the embedder's", i)
+		}
+	}
+
+	// "b" was the least recently used and is evicted by "c".
+	if _, err := cache.Embed(ctx, []string{"b"}); err != nil {
+		t.Fatalf("failed to embed: %v", err)
+	}
+	if inner.texts != 4 {
+		t.Errorf("expected the evicted text to be embedded again, got %d embedded", inner.texts)
+	}
+}
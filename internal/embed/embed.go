@@ -0,0 +1,142 @@
+// Package embed turns text into dense vectors for semantic search.
+package embed
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Providers an embedder can be built from.
+const (
+	ProviderHash   = "hash"
+	ProviderLocal  = "local"
+	ProviderOpenAI = "openai"
+	ProviderOllama = "ollama"
+	ProviderNone   = "none"
+)
+
+// DefaultDimensions is the vector size of the hashing embedder.
+const DefaultDimensions = 256
+
+// Embedder turns texts into vectors of a fixed size. Vectors of different
+// models are not comparable, so stored vectors record the model that made
+// them.
+type Embedder interface {
+	// Model identifies the embedder and its configuration.
+	Model() string
+	// Dimensions is the length of every vector Embed returns.
+	Dimensions() int
+	// Embed returns one unit-length vector per text, in order.
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// Options configures the embedder New builds. The fields mirror the
+// embeddings section of the configuration file.
+type Options struct {
+	// Provider is "hash" (the default), "local", "openai", "ollama" or
+	// "none".
+	Provider string
+	// Dimensions is the vector size of the hashing embedder.
+	Dimensions int
+	// ModelName names a local model, checked against the checksum pinned
+	// for it in the models package, or the model an embedding server runs.
+	ModelName string
+	// ModelPath is the local model file.
+	ModelPath string
+	// ModelSHA256 is the checksum of a local model that has none pinned.
+	ModelSHA256 string
+	// CacheSize is the number of vectors kept in memory. Zero disables the
+	// cache.
+	CacheSize int
+	// URL is the endpoint of an embedding server.
+	URL string
+	// BatchSize is the number of texts sent to an embedding server at once.
+	BatchSize int
+	// Timeout is the number of seconds an embedding server has to answer a
+	// request.
+	Timeout int
+	// Retries is how many times a failed request is retried.
+	Retries int
+}
+
+// Metrics receives measurements from the embedders New builds. Either
+// function may be nil.
+type Metrics struct {
+	// CacheHitRatio is passed the hit ratio of the cache.
+	CacheHitRatio func(ratio float64)
+	// Latency is passed the duration in seconds of every request to an
+	// embedding server.
+	Latency func(seconds float64)
+}
+
+// New builds the embedder described by opts. It returns nil for the "none"
+// provider.
+func New(opts Options, metrics Metrics) (Embedder, error) {
+	var (
+		e   Embedder
+		err error
+	)
+	switch opts.Provider {
+	case "", ProviderHash:
+		e = NewHashEmbedder(opts.Dimensions)
+	case ProviderLocal:
+		e, err = LoadLocal(opts.ModelName, opts.ModelPath, opts.ModelSHA256)
+	case ProviderOpenAI, ProviderOllama:
+		e, err = NewHTTP(opts, metrics.Latency)
+	case ProviderNone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown embeddings provider %q: use hash, local, openai, ollama or none", opts.Provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.CacheSize > 0 {
+		e = NewCache(e, opts.CacheSize, metrics.CacheHitRatio)
+	}
+	return e, nil
+}
+
+// Cosine returns the cosine similarity of two vectors, or 0 when either is
+// all zeros.
+func Cosine(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		if i >= len(b) {
+			break
+		}
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// normalize scales v to unit length in place. All-zero vectors are left
+// alone.
+func normalize(v []float32) {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= norm
+	}
+}
+
+// tokenize splits text into lower-cased runs of letters and digits.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
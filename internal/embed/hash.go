@@ -0,0 +1,78 @@
+package embed
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+)
+
+// Weights of the features the hashing embedder counts. Words carry the
+// meaning, word pairs some of the order and character trigrams make
+// misspelled and inflected words land close to each other.
+const (
+	wordWeight    = 1.0
+	bigramWeight  = 0.5
+	trigramWeight = 0.25
+)
+
+// HashEmbedder embeds text with the hashing trick: every word, word pair
+// and character trigram is hashed to a signed position of the vector. It
+// needs no model or network and its vectors never change between runs.
+type HashEmbedder struct {
+	dims int
+}
+
+// NewHashEmbedder returns a hashing embedder with vectors of the given
+// size, or DefaultDimensions when dims is not positive.
+func NewHashEmbedder(dims int) *HashEmbedder {
+	if dims <= 0 {
+		dims = DefaultDimensions
+	}
+	return &HashEmbedder{dims: dims}
+}
+
+// Model implements Embedder.
+func (e *HashEmbedder) Model() string { return fmt.Sprintf("hash-v1-%d", e.dims) }
+
+// Dimensions implements Embedder.
+func (e *HashEmbedder) Dimensions() int { return e.dims }
+
+// Embed implements Embedder.
+func (e *HashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		vectors[i] = e.embed(text)
+	}
+	return vectors, nil
+}
+
+func (e *HashEmbedder) embed(text string) []float32 {
+	v := make([]float32, e.dims)
+	words := tokenize(text)
+	for i, word := range words {
+		e.add(v, "w:"+word, wordWeight)
+		if i > 0 {
+			e.add(v, "b:"+words[i-1]+" "+word, bigramWeight)
+		}
+		runes := []rune("^" + word + "$")
+		for j := 0; j+3 <= len(runes); j++ {
+			e.add(v, "t:"+string(runes[j:j+3]), trigramWeight)
+		}
+	}
+	normalize(v)
+	return v
+}
+
+// add hashes a feature to a position and sign and adds its weight there.
+func (e *HashEmbedder) add(v []float32, feature string, weight float32) {
+	h := fnv.New64a()
+	h.Write([]byte(feature))
+	sum := h.Sum64()
+	if sum>>63 == 1 {
+		weight = -weight
+	}
+	v[sum%uint64(e.dims)] += weight
+}
@@ -0,0 +1,62 @@
+package embed
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestHashEmbedder(t *testing.T) {
+	e := NewHashEmbedder(0)
+	if e.Dimensions() != DefaultDimensions || e.Model() != "hash-v1-256" {
+		t.Fatalf("unexpected embedder %s with %d dimensions", e.Model(), e.Dimensions())
+	}
+
+	vectors, err := e.Embed(context.Background(), []string{
+		"the deploy pipeline failed on staging",
+		"The deployment pipeline failed in staging!",
+		"grandma's apple pie recipe",
+		"",
+	})
+	if err != nil {
+		t.Fatalf("failed to embed: %v", err)
+	}
+	for i, v := range vectors[:3] {
+		var norm float64
+		for _, x := range v {
+			norm += float64(x) * float64(x)
+		}
+		if len(v) != DefaultDimensions || math.Abs(norm-1) > 1e-5 {
+			t.Errorf("vector %d: expected a unit vector of %d values, got %d values of norm %v", i, DefaultDimensions, len(v), norm)
+		}
+	}
+
+	similar, unrelated := Cosine(vectors[0], vectors[1]), Cosine(vectors[0], vectors[2])
+	if similar <= unrelated || similar < 0.5 {
+		t.Errorf("expected related texts to be closer, got %v and %v", similar, unrelated)
+	}
+	if Cosine(vectors[0], vectors[3]) != 0 {
+		t.Error("expected an empty text to have no similarity")
+	}
+
+	again, _ := e.Embed(context.Background(), []string{"the deploy pipeline failed on staging"})
+	if Cosine(vectors[0], again[0]) < 0.99999 {
+		t.Error("expected the same text to embed to the same vector")
+	}
+}
+
+func TestNew(t *testing.T) {
+	e, err := New(Options{Dimensions: 64, CacheSize: 8}, Metrics{})
+	if err != nil {
+		t.Fatalf("failed to build embedder: %v", err)
+	}
+	if _, ok := e.(*Cache); !ok || e.Dimensions() != 64 {
+		t.Errorf("expected a cached 64-dimension hashing embedder, got %T with %d", e, e.Dimensions())
+	}
+	if e, err := New(Options{Provider: ProviderNone}, Metrics{}); e != nil || err != nil {
+		t.Errorf("expected no embedder, got %v, %v", e, err)
+	}
+	if _, err := New(Options{Provider: "bert"}, Metrics{}); err == nil {
+		t.Error("expected an unknown provider to fail")
+	}
+}
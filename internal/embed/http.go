@@ -0,0 +1,223 @@
+package embed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Defaults of the embedding server settings.
+const (
+	DefaultOpenAIURL = "http://localhost:8080/v1/embeddings"
+	DefaultOllamaURL = "http://localhost:11434/api/embed"
+	DefaultBatchSize = 32
+	DefaultTimeout   = 30 // seconds
+	DefaultRetries   = 2
+)
+
+// retryDelay is the wait before the first retry of a failed request; it
+// doubles with every further retry.
+const retryDelay = 250 * time.Millisecond
+
+// maxResponseBytes bounds the response body read from an embedding server.
+const maxResponseBytes = 64 << 20
+
+// ErrServerUnavailable is returned by NewHTTP when the embedding server does
+// not answer the request for the model's dimensions.
+var ErrServerUnavailable = errors.New("embedding server unavailable")
+
+// HTTPEmbedder embeds texts through an embedding server, such as a local
+// llama.cpp, vLLM or Ollama, speaking either the OpenAI /v1/embeddings or
+// the Ollama /api/embed format. Texts are sent in batches, and requests
+// that time out or fail with a server error are retried.
+type HTTPEmbedder struct {
+	provider   string
+	url        string
+	model      string
+	dims       int
+	batchSize  int
+	timeout    time.Duration
+	retries    int
+	retryDelay time.Duration
+	client     *http.Client
+	latency    func(seconds float64)
+}
+
+// NewHTTP returns an embedder for the server opts describes, which must
+// name the model it runs. The server is asked for one vector to learn the
+// model's dimensions; ErrServerUnavailable is returned when it does not
+// answer. The duration of every request in seconds is passed to latency,
+// which may be nil.
+func NewHTTP(opts Options, latency func(seconds float64)) (*HTTPEmbedder, error) {
+	e := &HTTPEmbedder{
+		provider:   opts.Provider,
+		url:        opts.URL,
+		model:      opts.ModelName,
+		batchSize:  opts.BatchSize,
+		timeout:    time.Duration(opts.Timeout) * time.Second,
+		retries:    opts.Retries,
+		retryDelay: retryDelay,
+		client:     &http.Client{},
+		latency:    latency,
+	}
+	switch opts.Provider {
+	case ProviderOpenAI:
+		if e.url == "" {
+			e.url = DefaultOpenAIURL
+		}
+	case ProviderOllama:
+		if e.url == "" {
+			e.url = DefaultOllamaURL
+		}
+	default:
+		return nil, fmt.Errorf("%q is not an embedding server format: use openai or ollama", opts.Provider)
+	}
+	if e.model == "" {
+		return nil, errors.New("an embedding server needs a model name")
+	}
+	if e.batchSize <= 0 {
+		e.batchSize = DefaultBatchSize
+	}
+	if e.timeout <= 0 {
+		e.timeout = DefaultTimeout * time.Second
+	}
+	if e.retries < 0 {
+		e.retries = 0
+	}
+
+	vectors, err := e.embedBatch(context.Background(), []string{"dimensions"})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrServerUnavailable, e.url, err)
+	}
+	e.dims = len(vectors[0])
+	return e, nil
+}
+
+// Model implements Embedder. It changes with the configured model, so
+// memories are embedded again when the server is switched to another one.
+func (e *HTTPEmbedder) Model() string { return e.provider + "-" + e.model }
+
+// Dimensions implements Embedder.
+func (e *HTTPEmbedder) Dimensions() int { return e.dims }
+
+// Embed implements Embedder.
+func (e *HTTPEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += e.batchSize {
+		batch, err := e.embedBatch(ctx, texts[start:min(start+e.batchSize, len(texts))])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+// embedBatch embeds texts in one request, retrying it with exponential
+// backoff while it fails in a way a later attempt may not.
+func (e *HTTPEmbedder) embedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	delay := e.retryDelay
+	for attempt := 0; ; attempt++ {
+		vectors, retry, err := e.request(ctx, texts)
+		if err == nil {
+			return vectors, nil
+		}
+		if !retry || attempt == e.retries || ctx.Err() != nil {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// request sends one embedding request. It reports whether a failed request
+// is worth retrying: timeouts, connection errors, rate limiting and server
+// errors are.
+func (e *HTTPEmbedder) request(ctx context.Context, texts []string) ([][]float32, bool, error) {
+	body, err := json.Marshal(struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}{e.model, texts})
+	if err != nil {
+		return nil, false, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if e.latency != nil {
+		e.latency(time.Since(start).Seconds())
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return nil, retry, fmt.Errorf("embedding server returned %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+
+	vectors, err := e.decode(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid response from embedding server: %w", err)
+	}
+	if len(vectors) != len(texts) {
+		return nil, false, fmt.Errorf("embedding server returned %d vectors for %d texts", len(vectors), len(texts))
+	}
+	for _, v := range vectors {
+		if len(v) == 0 || e.dims != 0 && len(v) != e.dims {
+			return nil, false, fmt.Errorf("embedding server returned a vector of %d dimensions, expected %d", len(v), e.dims)
+		}
+		normalize(v)
+	}
+	return vectors, false, nil
+}
+
+// decode reads the vectors from a response body in the server's format.
+func (e *HTTPEmbedder) decode(data []byte) ([][]float32, error) {
+	if e.provider == ProviderOllama {
+		var resp struct {
+			Embeddings [][]float32 `json:"embeddings"`
+		}
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return nil, err
+		}
+		return resp.Embeddings, nil
+	}
+
+	var resp struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(resp.Data, func(a, b int) bool { return resp.Data[a].Index < resp.Data[b].Index })
+	vectors := make([][]float32, len(resp.Data))
+	for i, d := range resp.Data {
+		vectors[i] = d.Embedding
+	}
+	return vectors, nil
+}
@@ -0,0 +1,147 @@
+package embed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// embeddingServer stands in for an embedding server of the given format.
+// Each text is embedded as (its length, 1, 0). The first failures requests
+// are answered with a 503.
+func embeddingServer(t *testing.T, format string, failures int32, batches *[]int) *httptest.Server {
+	t.Helper()
+	var calls atomic.Int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			http.Error(w, "loading model", http.StatusServiceUnavailable)
+			return
+		}
+		var req struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Model != "mini" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if batches != nil {
+			*batches = append(*batches, len(req.Input))
+		}
+		vectors := make([][]float32, len(req.Input))
+		for i, text := range req.Input {
+			vectors[i] = []float32{float32(len(text)), 1, 0}
+		}
+		if format == ProviderOllama {
+			json.NewEncoder(w).Encode(map[string]any{"embeddings": vectors})
+			return
+		}
+		// Answer out of order; the index puts the vectors back in place.
+		data := make([]map[string]any, len(vectors))
+		for i, v := range vectors {
+			data[len(vectors)-1-i] = map[string]any{"index": i, "embedding": v}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}))
+}
+
+func TestHTTPEmbedder(t *testing.T) {
+	for _, format := range []string{ProviderOpenAI, ProviderOllama} {
+		t.Run(format, func(t *testing.T) {
+			var batches []int
+			server := embeddingServer(t, format, 0, &batches)
+			defer server.Close()
+
+			var requests int
+			e, err := NewHTTP(Options{Provider: format, URL: server.URL, ModelName: "mini", BatchSize: 2}, func(seconds float64) {
+				if seconds < 0 || seconds > 5 {
+					t.Errorf("unexpected latency %vs", seconds)
+				}
+				requests++
+			})
+			if err != nil {
+				t.Fatalf("failed to create embedder: %v", err)
+			}
+			if e.Dimensions() != 3 || e.Model() != format+"-mini" {
+				t.Errorf("unexpected model %s with %d dimensions", e.Model(), e.Dimensions())
+			}
+
+			vectors, err := e.Embed(context.Background(), []string{"a", "bb", "ccc", "dddd", "eeeee"})
+			if err != nil {
+				t.Fatalf("failed to embed: %v", err)
+			}
+			if len(vectors) != 5 {
+				t.Fatalf("expected 5 vectors, got %d", len(vectors))
+			}
+			for i, v := range vectors {
+				want := float64(i+1) / math.Sqrt(float64((i+1)*(i+1)+1))
+				if math.Abs(float64(v[0])-want) > 1e-6 {
+					t.Errorf("vector %d: expected a unit vector starting with %v, got %v", i, want, v)
+				}
+			}
+			// The probe, then the five texts in batches of two.
+			if want := []int{1, 2, 2, 1}; !slices.Equal(batches, want) {
+				t.Errorf("expected batches %v, got %v", want, batches)
+			}
+			if requests != 4 {
+				t.Errorf("expected the latency of 4 requests, got %d", requests)
+			}
+		})
+	}
+}
+
+func TestHTTPEmbedderRetry(t *testing.T) {
+	server := embeddingServer(t, ProviderOllama, 2, nil)
+	defer server.Close()
+	opts := Options{Provider: ProviderOllama, URL: server.URL, ModelName: "mini", Retries: 2}
+	if _, err := NewHTTP(opts, nil); err != nil {
+		t.Errorf("expected two failures to be retried, got %v", err)
+	}
+
+	server = embeddingServer(t, ProviderOllama, 3, nil)
+	defer server.Close()
+	opts.URL = server.URL
+	if _, err := NewHTTP(opts, nil); !errors.Is(err, ErrServerUnavailable) || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected the third failure to be returned, got %v", err)
+	}
+
+	// Client errors are not retried.
+	server = embeddingServer(t, ProviderOllama, 0, nil)
+	defer server.Close()
+	opts.URL, opts.ModelName = server.URL, "unknown"
+	if _, err := NewHTTP(opts, nil); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("expected a bad request to fail, got %v", err)
+	}
+}
+
+func TestHTTPEmbedderTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	e := &HTTPEmbedder{provider: ProviderOllama, url: server.URL, model: "mini", batchSize: 1, timeout: 50 * time.Millisecond, retries: 1, retryDelay: time.Millisecond, client: server.Client()}
+	start := time.Now()
+	if _, err := e.Embed(context.Background(), []string{"slow"}); err == nil {
+		t.Fatal("expected the request to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the timeout to cut the requests short, took %v", elapsed)
+	}
+	if _, err := NewHTTP(Options{Provider: ProviderOpenAI, URL: server.URL}, nil); err == nil {
+		t.Error("expected an embedder without a model to be rejected")
+	}
+}
@@ -0,0 +1,129 @@
+package embed
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/wassmi/nodimus-memory/internal/models"
+)
+
+// ErrChecksumMismatch is returned for a model file that does not match the
+// checksum pinned for its name or configured for it.
+var ErrChecksumMismatch = errors.New("model checksum mismatch")
+
+// WordVectorEmbedder embeds text as the mean of the vectors of its words,
+// read from a local model file. Words missing from the model are skipped.
+type WordVectorEmbedder struct {
+	name    string
+	dims    int
+	vectors map[string][]float32
+}
+
+// LoadLocal loads the word vectors of the named model from path. The file
+// must match the checksum pinned for name in the models package or, for a
+// model without one, checksum, its hex-encoded SHA-256. It is in the
+// word2vec and GloVe text format: one word per line followed by its
+// vector, optionally after a "count dimensions" header line.
+func LoadLocal(name, path, checksum string) (*WordVectorEmbedder, error) {
+	if name == "" || path == "" {
+		return nil, errors.New("a local model needs a model name and path")
+	}
+	checksum = strings.ToLower(strings.TrimSpace(checksum))
+	pinned, ok := models.ModelChecksums[name]
+	if ok && checksum != "" && checksum != pinned {
+		return nil, fmt.Errorf("%w: the configured checksum of %s differs from the pinned one", ErrChecksumMismatch, name)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model %s: %w", name, err)
+	}
+	switch {
+	case ok:
+		if !models.VerifyChecksum(name, data) {
+			return nil, fmt.Errorf("%w: %s does not match the checksum pinned for %s", ErrChecksumMismatch, path, name)
+		}
+	case checksum == "":
+		return nil, fmt.Errorf("%w: %s has no pinned checksum; set model_sha256", ErrChecksumMismatch, name)
+	case models.Checksum(data) != checksum:
+		return nil, fmt.Errorf("%w: %s does not match the configured checksum of %s", ErrChecksumMismatch, path, name)
+	}
+	vectors, dims, err := parseWordVectors(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse model %s: %w", name, err)
+	}
+	return &WordVectorEmbedder{name: name, dims: dims, vectors: vectors}, nil
+}
+
+// parseWordVectors reads word vectors in the text format LoadLocal takes.
+func parseWordVectors(data []byte) (map[string][]float32, int, error) {
+	vectors := map[string][]float32{}
+	dims := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if line == 1 && len(fields) == 2 {
+			if _, err := strconv.Atoi(fields[0]); err == nil {
+				continue
+			}
+		}
+		if dims == 0 {
+			dims = len(fields) - 1
+		}
+		if dims == 0 || len(fields)-1 != dims {
+			return nil, 0, fmt.Errorf("line %d: expected a word and %d values, got %d fields", line, dims, len(fields))
+		}
+		v := make([]float32, dims)
+		for i, field := range fields[1:] {
+			x, err := strconv.ParseFloat(field, 32)
+			if err != nil {
+				return nil, 0, fmt.Errorf("line %d: %w", line, err)
+			}
+			v[i] = float32(x)
+		}
+		vectors[strings.ToLower(fields[0])] = v
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(vectors) == 0 {
+		return nil, 0, errors.New("no word vectors")
+	}
+	return vectors, dims, nil
+}
+
+// Model implements Embedder.
+func (e *WordVectorEmbedder) Model() string { return "local-" + e.name }
+
+// Dimensions implements Embedder.
+func (e *WordVectorEmbedder) Dimensions() int { return e.dims }
+
+// Embed implements Embedder. Texts without a known word get a zero vector.
+func (e *WordVectorEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		v := make([]float32, e.dims)
+		for _, word := range tokenize(text) {
+			if wv, ok := e.vectors[word]; ok {
+				for j, x := range wv {
+					v[j] += x
+				}
+			}
+		}
+		normalize(v)
+		vectors[i] = v
+	}
+	return vectors, nil
+}
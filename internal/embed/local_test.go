@@ -0,0 +1,75 @@
+package embed
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wassmi/nodimus-memory/internal/models"
+)
+
+func TestLoadLocal(t *testing.T) {
+	data := []byte("3 2\ncat 1 0\nkitten 0.9 0.1\ntruck 0 1\n")
+	path := filepath.Join(t.TempDir(), "vectors.txt")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadLocal("test-vectors", path, ""); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected a model without a pinned checksum to be rejected, got %v", err)
+	}
+
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	// A model without a pinned checksum loads with the configured one.
+	if _, err := LoadLocal("test-vectors", path, strings.ToUpper(checksum)); err != nil {
+		t.Errorf("expected the configured checksum to be accepted, got %v", err)
+	}
+	if _, err := LoadLocal("test-vectors", path, strings.Repeat("0", 64)); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected a wrong configured checksum to be rejected, got %v", err)
+	}
+	if e, err := New(Options{Provider: ProviderLocal, ModelName: "test-vectors", ModelPath: path, ModelSHA256: checksum}, Metrics{}); err != nil || e.Dimensions() != 2 {
+		t.Errorf("expected the local provider to load the model, got %v", err)
+	}
+
+	models.ModelChecksums["test-vectors"] = checksum
+	defer delete(models.ModelChecksums, "test-vectors")
+	// The pinned checksum cannot be overridden.
+	if _, err := LoadLocal("test-vectors", path, strings.Repeat("0", 64)); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected a configured checksum differing from the pinned one to be rejected, got %v", err)
+	}
+
+	e, err := LoadLocal("test-vectors", path, "")
+	if err != nil {
+		t.Fatalf("failed to load model: %v", err)
+	}
+	if e.Dimensions() != 2 || e.Model() != "local-test-vectors" {
+		t.Errorf("unexpected model %s with %d dimensions", e.Model(), e.Dimensions())
+	}
+	vectors, err := e.Embed(context.Background(), []string{"Cat", "a kitten", "the truck", "zebra"})
+	if err != nil {
+		t.Fatalf("failed to embed: %v", err)
+	}
+	if Cosine(vectors[0], vectors[1]) <= Cosine(vectors[0], vectors[2]) {
+		t.Errorf("expected cat to be closer to kitten than to truck, got %v", vectors)
+	}
+	if vectors[3][0] != 0 || vectors[3][1] != 0 {
+		t.Errorf("expected a zero vector for unknown words, got %v", vectors[3])
+	}
+
+	if err := os.WriteFile(path, append(data, "broken 1\n"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadLocal("test-vectors", path, ""); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected a changed model file to be rejected, got %v", err)
+	}
+	if _, _, err := parseWordVectors([]byte("cat 1 0\nbroken 1\n")); err == nil {
+		t.Error("expected a short vector to fail to parse")
+	}
+}
@@ -0,0 +1,436 @@
+// Package hnsw is an on-disk approximate nearest neighbour index over unit
+// vectors, built on hierarchical navigable small world graphs.
+//
+// Changes are appended to a write-ahead log before they are applied and the
+// whole graph is written to a snapshot at checkpoints, so an index survives
+// a crash with at most the changes of a torn final log record lost.
+package hnsw
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Defaults of Params.
+const (
+	DefaultM              = 16
+	DefaultEfConstruction = 200
+	DefaultEfSearch       = 64
+)
+
+// ErrDimensions is returned for a vector of the wrong length.
+var ErrDimensions = errors.New("vector has the wrong dimensions")
+
+// Params tunes the graph. M is the number of neighbours a node keeps on each
+// layer (twice that on the bottom layer); EfConstruction and EfSearch are
+// the number of candidates considered when inserting and searching. Larger
+// values trade speed and size for recall. Zero fields take the defaults.
+type Params struct {
+	M              int
+	EfConstruction int
+	EfSearch       int
+}
+
+func (p Params) withDefaults() Params {
+	if p.M <= 1 {
+		p.M = DefaultM
+	}
+	if p.EfConstruction <= 0 {
+		p.EfConstruction = DefaultEfConstruction
+	}
+	if p.EfSearch <= 0 {
+		p.EfSearch = DefaultEfSearch
+	}
+	return p
+}
+
+// Item is a vector stored under a key.
+type Item struct {
+	Key    uint64
+	Vector []float32
+}
+
+// Result is a key found by Search with the cosine similarity of its vector
+// to the query.
+type Result struct {
+	Key        uint64
+	Similarity float64
+}
+
+type node struct {
+	key     uint64
+	vector  []float32
+	deleted bool
+	// friends holds the neighbours of the node on each of its layers.
+	friends [][]int32
+}
+
+// Index is a vector index stored in a directory. It is safe for concurrent
+// use.
+type Index struct {
+	mu     sync.RWMutex
+	dir    string
+	params Params
+	rng    *rand.Rand
+
+	// model and dims describe the vectors; vectors of other models are
+	// not comparable with them.
+	model string
+	dims  int
+
+	nodes    []*node
+	keys     map[uint64]int32
+	entry    int32 // -1 while the graph is empty
+	maxLevel int
+	deleted  int
+
+	// seq numbers the changes; the snapshot records the last one it holds.
+	seq      uint64
+	wal      *os.File
+	walBytes int64
+}
+
+// newGraph returns an empty graph.
+func newGraph(dir string, params Params, model string, dims int) *Index {
+	return &Index{
+		dir:    dir,
+		params: params.withDefaults(),
+		rng:    rand.New(rand.NewPCG(1, 2)),
+		model:  model,
+		dims:   dims,
+		keys:   map[uint64]int32{},
+		entry:  -1,
+	}
+}
+
+// Model returns the model the index holds vectors of, or "" for a new
+// index.
+func (x *Index) Model() string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.model
+}
+
+// Dimensions returns the length of the vectors in the index.
+func (x *Index) Dimensions() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.dims
+}
+
+// Len returns the number of vectors in the index.
+func (x *Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.keys)
+}
+
+// Keys returns the keys of all vectors in the index.
+func (x *Index) Keys() []uint64 {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	keys := make([]uint64, 0, len(x.keys))
+	for key := range x.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Add stores vectors under their keys, replacing any stored under the same
+// keys. Vectors are scaled to unit length.
+func (x *Index) Add(items ...Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for i := range items {
+		if len(items[i].Vector) != x.dims {
+			return fmt.Errorf("%w: expected %d values, got %d", ErrDimensions, x.dims, len(items[i].Vector))
+		}
+	}
+	if err := x.log(opAdd, items, nil); err != nil {
+		return err
+	}
+	for _, item := range items {
+		x.add(item)
+	}
+	return x.maybeCheckpoint()
+}
+
+// Delete removes the vectors stored under the given keys. Unknown keys are
+// ignored.
+func (x *Index) Delete(keys ...uint64) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err := x.log(opDelete, nil, keys); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		x.delete(key)
+	}
+	return x.maybeCheckpoint()
+}
+
+// Search returns the keys of up to k vectors most similar to query, most
+// similar first.
+func (x *Index) Search(query []float32, k int) ([]Result, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if len(query) != x.dims {
+		return nil, fmt.Errorf("%w: expected %d values, got %d", ErrDimensions, x.dims, len(query))
+	}
+	if x.entry < 0 || k <= 0 {
+		return nil, nil
+	}
+	q := unit(query)
+	ep := x.entry
+	for level := x.maxLevel; level > 0; level-- {
+		ep = x.greedy(q, ep, level)
+	}
+	ef := x.params.EfSearch
+	if ef < k {
+		ef = k
+	}
+	var results []Result
+	for _, c := range x.searchLayer(q, ep, ef, 0) {
+		if n := x.nodes[c.id]; !n.deleted {
+			results = append(results, Result{Key: n.key, Similarity: 1 - float64(c.dist)})
+			if len(results) == k {
+				break
+			}
+		}
+	}
+	return results, nil
+}
+
+// add inserts a vector into the graph.
+func (x *Index) add(item Item) {
+	x.delete(item.Key)
+	id := int32(len(x.nodes))
+	level := x.randomLevel()
+	n := &node{key: item.Key, vector: unit(item.Vector), friends: make([][]int32, level+1)}
+	x.nodes = append(x.nodes, n)
+	x.keys[item.Key] = id
+	if x.entry < 0 {
+		x.entry, x.maxLevel = id, level
+		return
+	}
+
+	ep := x.entry
+	for l := x.maxLevel; l > level; l-- {
+		ep = x.greedy(n.vector, ep, l)
+	}
+	for l := min(level, x.maxLevel); l >= 0; l-- {
+		candidates := x.searchLayer(n.vector, ep, x.params.EfConstruction, l)
+		n.friends[l] = x.selectNeighbors(candidates, x.maxFriends(l))
+		for _, friend := range n.friends[l] {
+			x.link(friend, id, l)
+		}
+		ep = candidates[0].id
+	}
+	if level > x.maxLevel {
+		x.entry, x.maxLevel = id, level
+	}
+}
+
+// delete marks the vector under key as deleted. Deleted nodes keep guiding
+// searches through the graph until the next checkpoint compacts it.
+func (x *Index) delete(key uint64) {
+	id, ok := x.keys[key]
+	if !ok {
+		return
+	}
+	x.nodes[id].deleted = true
+	delete(x.keys, key)
+	x.deleted++
+}
+
+// compact rebuilds the graph from the vectors that are not deleted.
+func (x *Index) compact() {
+	nodes := x.nodes
+	x.nodes, x.keys, x.entry, x.maxLevel, x.deleted = nil, map[uint64]int32{}, -1, 0, 0
+	for _, n := range nodes {
+		if !n.deleted {
+			x.add(Item{Key: n.key, Vector: n.vector})
+		}
+	}
+}
+
+// maxFriends is the number of neighbours a node keeps on a layer.
+func (x *Index) maxFriends(level int) int {
+	if level == 0 {
+		return 2 * x.params.M
+	}
+	return x.params.M
+}
+
+// randomLevel draws the top layer of a new node from an exponentially
+// decaying distribution.
+func (x *Index) randomLevel() int {
+	ml := 1 / math.Log(float64(x.params.M))
+	return int(-math.Log(1-x.rng.Float64()) * ml)
+}
+
+// link adds a neighbour to a node's layer, pruning the layer back to its
+// best neighbours when it grows too large.
+func (x *Index) link(id, friend int32, level int) {
+	n := x.nodes[id]
+	n.friends[level] = append(n.friends[level], friend)
+	if len(n.friends[level]) <= x.maxFriends(level) {
+		return
+	}
+	candidates := make([]candidate, len(n.friends[level]))
+	for i, f := range n.friends[level] {
+		candidates[i] = candidate{f, distance(n.vector, x.nodes[f].vector)}
+	}
+	sort.Slice(candidates, func(a, b int) bool { return candidates[a].dist < candidates[b].dist })
+	n.friends[level] = x.selectNeighbors(candidates, x.maxFriends(level))
+}
+
+// selectNeighbors picks up to m of the candidates, sorted by distance,
+// preferring ones that are closer to the new node than to any neighbour
+// already picked, so the neighbours point in different directions.
+func (x *Index) selectNeighbors(candidates []candidate, m int) []int32 {
+	picked := make([]int32, 0, m)
+	var skipped []int32
+	for _, c := range candidates {
+		if len(picked) == m {
+			break
+		}
+		diverse := true
+		for _, p := range picked {
+			if distance(x.nodes[c.id].vector, x.nodes[p].vector) < c.dist {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			picked = append(picked, c.id)
+		} else {
+			skipped = append(skipped, c.id)
+		}
+	}
+	for _, id := range skipped {
+		if len(picked) == m {
+			break
+		}
+		picked = append(picked, id)
+	}
+	return picked
+}
+
+// greedy walks a layer from ep towards q and returns the closest node it
+// reaches.
+func (x *Index) greedy(q []float32, ep int32, level int) int32 {
+	best := distance(q, x.nodes[ep].vector)
+	for changed := true; changed; {
+		changed = false
+		for _, f := range x.nodes[ep].friends[level] {
+			if d := distance(q, x.nodes[f].vector); d < best {
+				ep, best, changed = f, d, true
+			}
+		}
+	}
+	return ep
+}
+
+// searchLayer returns up to ef nodes of a layer closest to q, closest
+// first.
+func (x *Index) searchLayer(q []float32, ep int32, ef, level int) []candidate {
+	visited := map[int32]bool{ep: true}
+	first := candidate{ep, distance(q, x.nodes[ep].vector)}
+	queue := &nearHeap{first}
+	found := &farHeap{first}
+	for queue.Len() > 0 {
+		c := heap.Pop(queue).(candidate)
+		if c.dist > (*found)[0].dist && found.Len() >= ef {
+			break
+		}
+		for _, f := range x.nodes[c.id].friends[level] {
+			if visited[f] {
+				continue
+			}
+			visited[f] = true
+			d := distance(q, x.nodes[f].vector)
+			if found.Len() < ef || d < (*found)[0].dist {
+				heap.Push(queue, candidate{f, d})
+				heap.Push(found, candidate{f, d})
+				if found.Len() > ef {
+					heap.Pop(found)
+				}
+			}
+		}
+	}
+	results := []candidate(*found)
+	sort.Slice(results, func(a, b int) bool { return results[a].dist < results[b].dist })
+	return results
+}
+
+type candidate struct {
+	id   int32
+	dist float32
+}
+
+// nearHeap pops the closest candidate first.
+type nearHeap []candidate
+
+func (h nearHeap) Len() int            { return len(h) }
+func (h nearHeap) Less(a, b int) bool  { return h[a].dist < h[b].dist }
+func (h nearHeap) Swap(a, b int)       { h[a], h[b] = h[b], h[a] }
+func (h *nearHeap) Push(v interface{}) { *h = append(*h, v.(candidate)) }
+func (h *nearHeap) Pop() interface{} {
+	old := *h
+	v := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return v
+}
+
+// farHeap pops the farthest candidate first.
+type farHeap []candidate
+
+func (h farHeap) Len() int            { return len(h) }
+func (h farHeap) Less(a, b int) bool  { return h[a].dist > h[b].dist }
+func (h farHeap) Swap(a, b int)       { h[a], h[b] = h[b], h[a] }
+func (h *farHeap) Push(v interface{}) { *h = append(*h, v.(candidate)) }
+func (h *farHeap) Pop() interface{} {
+	old := *h
+	v := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return v
+}
+
+// distance is the cosine distance of two unit vectors.
+func distance(a, b []float32) float32 {
+	var dot float32
+	for i := range a {
+		dot += a[i] * b[i]
+	}
+	return 1 - dot
+}
+
+// unit returns a copy of v scaled to unit length.
+func unit(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	u := make([]float32, len(v))
+	if sum == 0 {
+		return u
+	}
+	norm := float32(math.Sqrt(sum))
+	for i, x := range v {
+		u[i] = x / norm
+	}
+	return u
+}
@@ -0,0 +1,119 @@
+package hnsw
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sort"
+	"testing"
+)
+
+// randomItems returns n random vectors under the keys 0 to n-1.
+func randomItems(n, dims int, seed uint64) []Item {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	items := make([]Item, n)
+	for i := range items {
+		v := make([]float32, dims)
+		for j := range v {
+			v[j] = float32(rng.NormFloat64())
+		}
+		items[i] = Item{Key: uint64(i), Vector: v}
+	}
+	return items
+}
+
+// bruteForce returns the keys of the k items most similar to q.
+func bruteForce(items []Item, q []float32, k int) []uint64 {
+	uq := unit(q)
+	sorted := append([]Item(nil), items...)
+	sort.Slice(sorted, func(a, b int) bool {
+		return distance(uq, unit(sorted[a].Vector)) < distance(uq, unit(sorted[b].Vector))
+	})
+	keys := make([]uint64, k)
+	for i := range keys {
+		keys[i] = sorted[i].Key
+	}
+	return keys
+}
+
+func newTestIndex(t *testing.T, dims int) *Index {
+	t.Helper()
+	x, err := Open(t.TempDir(), Params{M: 8, EfConstruction: 100, EfSearch: 50})
+	if err != nil {
+		t.Fatalf("failed to open index: %v", err)
+	}
+	t.Cleanup(func() { x.Close() })
+	if err := x.Reset("test", dims); err != nil {
+		t.Fatalf("failed to reset index: %v", err)
+	}
+	return x
+}
+
+func TestSearchRecall(t *testing.T) {
+	const dims, k = 16, 10
+	x := newTestIndex(t, dims)
+	items := randomItems(1000, dims, 1)
+	if err := x.Add(items...); err != nil {
+		t.Fatalf("failed to add: %v", err)
+	}
+
+	found, total := 0, 0
+	for _, q := range randomItems(20, dims, 2) {
+		results, err := x.Search(q.Vector, k)
+		if err != nil {
+			t.Fatalf("failed to search: %v", err)
+		}
+		want := map[uint64]bool{}
+		for _, key := range bruteForce(items, q.Vector, k) {
+			want[key] = true
+		}
+		for i, r := range results {
+			if want[r.Key] {
+				found++
+			}
+			if i > 0 && r.Similarity > results[i-1].Similarity {
+				t.Errorf("expected results sorted by similarity, got %v", results)
+			}
+		}
+		total += k
+	}
+	if recall := float64(found) / float64(total); recall < 0.9 {
+		t.Errorf("expected a recall of at least 0.9, got %v", recall)
+	}
+
+	if _, err := x.Search(make([]float32, 3), k); !errors.Is(err, ErrDimensions) {
+		t.Errorf("expected a dimensions error, got %v", err)
+	}
+	if err := x.Add(Item{Key: 1, Vector: make([]float32, 3)}); !errors.Is(err, ErrDimensions) {
+		t.Errorf("expected a dimensions error, got %v", err)
+	}
+}
+
+func TestDeleteAndReplace(t *testing.T) {
+	x := newTestIndex(t, 2)
+	if err := x.Add(Item{1, []float32{1, 0}}, Item{2, []float32{0, 1}}, Item{3, []float32{1, 1}}); err != nil {
+		t.Fatalf("failed to add: %v", err)
+	}
+	if err := x.Delete(1, 42); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	results, _ := x.Search([]float32{1, 0}, 3)
+	if len(results) != 2 || results[0].Key != 3 || x.Len() != 2 {
+		t.Errorf("expected the deleted vector to be gone, got %v", results)
+	}
+
+	if err := x.Add(Item{2, []float32{1, 0.01}}); err != nil {
+		t.Fatalf("failed to replace: %v", err)
+	}
+	results, _ = x.Search([]float32{1, 0}, 1)
+	if len(results) != 1 || results[0].Key != 2 || results[0].Similarity < 0.99 {
+		t.Errorf("expected the replaced vector to match, got %v", results)
+	}
+
+	// Checkpoints compact the graph once enough of it is deleted.
+	if err := x.Checkpoint(); err != nil {
+		t.Fatalf("failed to checkpoint: %v", err)
+	}
+	if len(x.nodes) != 2 || x.deleted != 0 {
+		t.Errorf("expected a compacted graph of two nodes, got %d with %d deleted", len(x.nodes), x.deleted)
+	}
+}
@@ -0,0 +1,384 @@
+package hnsw
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+const (
+	snapshotFile = "graph"
+	walFile      = "wal"
+	magic        = "NMHNSW01"
+
+	opAdd    = 1
+	opDelete = 2
+
+	// checkpointBytes is the size the log may grow to before the graph is
+	// written to a new snapshot and the log emptied.
+	checkpointBytes = 16 << 20
+)
+
+// ErrCorrupt is returned by Open for a snapshot that fails its checksum.
+var ErrCorrupt = errors.New("vector index is corrupt")
+
+// Open opens the index in dir, creating the directory if needed. The
+// snapshot is loaded and the changes logged after it are replayed; a torn
+// record at the end of the log, left by a crash, is dropped.
+func Open(dir string, params Params) (*Index, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	x := newGraph(dir, params, "", 0)
+	if err := x.readSnapshot(); err != nil {
+		return nil, err
+	}
+	wal, err := os.OpenFile(filepath.Join(dir, walFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	x.wal = wal
+	if err := x.replay(); err != nil {
+		wal.Close()
+		return nil, err
+	}
+	return x, nil
+}
+
+// Reset empties the index and sets the model and dimensions of the vectors
+// it will hold.
+func (x *Index) Reset(model string, dims int) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	fresh := newGraph(x.dir, x.params, model, dims)
+	x.model, x.dims = model, dims
+	x.nodes, x.keys, x.entry, x.maxLevel, x.deleted = fresh.nodes, fresh.keys, fresh.entry, fresh.maxLevel, fresh.deleted
+	return x.checkpoint()
+}
+
+// Checkpoint writes the graph to a new snapshot and empties the log.
+func (x *Index) Checkpoint() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.checkpoint()
+}
+
+// Close checkpoints the index if the log holds changes and closes it.
+func (x *Index) Close() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.wal == nil {
+		return nil
+	}
+	var err error
+	if x.walBytes > 0 {
+		err = x.checkpoint()
+	}
+	if cerr := x.wal.Close(); err == nil {
+		err = cerr
+	}
+	x.wal = nil
+	return err
+}
+
+func (x *Index) maybeCheckpoint() error {
+	if x.walBytes < checkpointBytes {
+		return nil
+	}
+	return x.checkpoint()
+}
+
+// checkpoint compacts the graph if many of its nodes are deleted, replaces
+// the snapshot atomically and then empties the log. A crash in between
+// leaves log records the new snapshot already holds, which replay skips by
+// their sequence numbers.
+func (x *Index) checkpoint() error {
+	if x.deleted > 0 && x.deleted*4 > len(x.nodes) {
+		x.compact()
+	}
+	if err := x.writeSnapshot(); err != nil {
+		return fmt.Errorf("failed to write vector index snapshot: %w", err)
+	}
+	if err := x.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := x.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	x.walBytes = 0
+	return x.wal.Sync()
+}
+
+// log appends a change to the write-ahead log and syncs it to disk. A
+// record is its payload length, the payload's CRC-32 and the payload: the
+// sequence number, the operation, the number of keys and the keys, each
+// followed by its vector for additions.
+func (x *Index) log(op byte, items []Item, keys []uint64) error {
+	x.seq++
+	n := len(keys)
+	if op == opAdd {
+		n = len(items)
+	}
+	payload := binary.LittleEndian.AppendUint64(nil, x.seq)
+	payload = append(payload, op)
+	payload = binary.LittleEndian.AppendUint32(payload, uint32(n))
+	if op == opAdd {
+		for _, item := range items {
+			payload = binary.LittleEndian.AppendUint64(payload, item.Key)
+			for _, v := range item.Vector {
+				payload = binary.LittleEndian.AppendUint32(payload, math.Float32bits(v))
+			}
+		}
+	} else {
+		for _, key := range keys {
+			payload = binary.LittleEndian.AppendUint64(payload, key)
+		}
+	}
+
+	record := binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))
+	record = binary.LittleEndian.AppendUint32(record, crc32.ChecksumIEEE(payload))
+	record = append(record, payload...)
+	if _, err := x.wal.Write(record); err != nil {
+		return fmt.Errorf("failed to write vector index log: %w", err)
+	}
+	x.walBytes += int64(len(record))
+	return x.wal.Sync()
+}
+
+// replay applies the log records newer than the snapshot and truncates the
+// log after the last intact record.
+func (x *Index) replay() error {
+	data, err := io.ReadAll(x.wal)
+	if err != nil {
+		return err
+	}
+	snapshotSeq := x.seq
+	offset := 0
+	for len(data)-offset >= 8 {
+		size := int(binary.LittleEndian.Uint32(data[offset:]))
+		sum := binary.LittleEndian.Uint32(data[offset+4:])
+		if size < 13 || len(data)-offset-8 < size {
+			break
+		}
+		payload := data[offset+8 : offset+8+size]
+		if crc32.ChecksumIEEE(payload) != sum {
+			break
+		}
+		if !x.apply(payload, snapshotSeq) {
+			break
+		}
+		offset += 8 + size
+	}
+	if offset < len(data) {
+		if err := x.wal.Truncate(int64(offset)); err != nil {
+			return err
+		}
+	}
+	if _, err := x.wal.Seek(int64(offset), io.SeekStart); err != nil {
+		return err
+	}
+	x.walBytes = int64(offset)
+	return nil
+}
+
+// apply applies one log record unless the snapshot already holds it. It
+// reports false for a record that cannot be decoded.
+func (x *Index) apply(payload []byte, snapshotSeq uint64) bool {
+	seq := binary.LittleEndian.Uint64(payload)
+	op := payload[8]
+	n := int(binary.LittleEndian.Uint32(payload[9:]))
+	body := payload[13:]
+	entry := 8
+	if op == opAdd {
+		entry += 4 * x.dims
+	} else if op != opDelete {
+		return false
+	}
+	if len(body) != n*entry {
+		return false
+	}
+	if seq > x.seq {
+		x.seq = seq
+	}
+	if seq <= snapshotSeq {
+		return true
+	}
+	for i := 0; i < n; i++ {
+		rec := body[i*entry:]
+		key := binary.LittleEndian.Uint64(rec)
+		if op == opDelete {
+			x.delete(key)
+			continue
+		}
+		v := make([]float32, x.dims)
+		for j := range v {
+			v[j] = math.Float32frombits(binary.LittleEndian.Uint32(rec[8+4*j:]))
+		}
+		x.add(Item{Key: key, Vector: v})
+	}
+	return true
+}
+
+// writeSnapshot writes the graph to a temporary file, syncs it and renames
+// it over the snapshot, so a crash leaves either the old or the new one.
+func (x *Index) writeSnapshot() error {
+	path := filepath.Join(x.dir, snapshotFile)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path + ".tmp")
+
+	crc := crc32.NewIEEE()
+	w := &snapshotWriter{w: bufio.NewWriter(io.MultiWriter(f, crc))}
+	w.bytes([]byte(magic))
+	w.u64(x.seq)
+	w.u32(uint32(len(x.model)))
+	w.bytes([]byte(x.model))
+	w.u32(uint32(x.dims))
+	w.u32(uint32(x.entry))
+	w.u32(uint32(x.maxLevel))
+	w.u32(uint32(len(x.nodes)))
+	for _, n := range x.nodes {
+		w.u64(n.key)
+		if n.deleted {
+			w.bytes([]byte{1})
+		} else {
+			w.bytes([]byte{0})
+		}
+		for _, v := range n.vector {
+			w.u32(math.Float32bits(v))
+		}
+		w.u32(uint32(len(n.friends)))
+		for _, friends := range n.friends {
+			w.u32(uint32(len(friends)))
+			for _, f := range friends {
+				w.u32(uint32(f))
+			}
+		}
+	}
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	if w.err == nil {
+		_, w.err = f.Write(binary.LittleEndian.AppendUint32(nil, crc.Sum32()))
+	}
+	if w.err == nil {
+		w.err = f.Sync()
+	}
+	if err := f.Close(); w.err == nil {
+		w.err = err
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return syncDir(x.dir)
+}
+
+// readSnapshot loads the snapshot, if there is one.
+func (x *Index) readSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(x.dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(data) < len(magic)+4 || string(data[:len(magic)]) != magic {
+		return fmt.Errorf("%w: not a vector index snapshot", ErrCorrupt)
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return fmt.Errorf("%w: snapshot checksum mismatch", ErrCorrupt)
+	}
+
+	r := &snapshotReader{data: body[len(magic):]}
+	x.seq = r.u64()
+	x.model = string(r.bytes(int(r.u32())))
+	x.dims = int(r.u32())
+	x.entry = int32(r.u32())
+	x.maxLevel = int(r.u32())
+	count := int(r.u32())
+	for i := 0; i < count && r.err == nil; i++ {
+		n := &node{key: r.u64(), deleted: r.bytes(1)[0] == 1, vector: make([]float32, x.dims)}
+		for j := range n.vector {
+			n.vector[j] = math.Float32frombits(r.u32())
+		}
+		n.friends = make([][]int32, r.u32())
+		for l := range n.friends {
+			n.friends[l] = make([]int32, r.u32())
+			for j := range n.friends[l] {
+				n.friends[l][j] = int32(r.u32())
+			}
+		}
+		x.nodes = append(x.nodes, n)
+		if n.deleted {
+			x.deleted++
+		} else {
+			x.keys[n.key] = int32(i)
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, r.err)
+	}
+	return nil
+}
+
+// syncDir syncs a directory so a rename in it is durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		return err
+	}
+	return nil
+}
+
+// snapshotWriter writes little-endian values, keeping the first error.
+type snapshotWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (w *snapshotWriter) bytes(b []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(b)
+	}
+}
+
+func (w *snapshotWriter) u32(v uint32) { w.bytes(binary.LittleEndian.AppendUint32(nil, v)) }
+func (w *snapshotWriter) u64(v uint64) { w.bytes(binary.LittleEndian.AppendUint64(nil, v)) }
+
+// snapshotReader reads little-endian values, keeping the first error and
+// returning zeros after it.
+type snapshotReader struct {
+	data []byte
+	err  error
+}
+
+func (r *snapshotReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.data) {
+		if r.err == nil {
+			r.err = io.ErrUnexpectedEOF
+		}
+		return make([]byte, 8)
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *snapshotReader) u32() uint32 { return binary.LittleEndian.Uint32(r.bytes(4)) }
+func (r *snapshotReader) u64() uint64 { return binary.LittleEndian.Uint64(r.bytes(8)) }
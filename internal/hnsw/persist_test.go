@@ -0,0 +1,94 @@
+package hnsw
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPersistence(t *testing.T) {
+	dir := t.TempDir()
+	x, err := Open(dir, Params{})
+	if err != nil {
+		t.Fatalf("failed to open index: %v", err)
+	}
+	if x.Model() != "" || x.Len() != 0 {
+		t.Fatalf("expected a new index, got model %q with %d vectors", x.Model(), x.Len())
+	}
+	if err := x.Reset("test", 8); err != nil {
+		t.Fatalf("failed to reset: %v", err)
+	}
+	items := randomItems(50, 8, 3)
+	if err := x.Add(items[:40]...); err != nil {
+		t.Fatalf("failed to add: %v", err)
+	}
+	if err := x.Checkpoint(); err != nil {
+		t.Fatalf("failed to checkpoint: %v", err)
+	}
+	if err := x.Add(items[40:]...); err != nil {
+		t.Fatalf("failed to add: %v", err)
+	}
+	if err := x.Delete(0); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	// Simulate a crash: the log holds the changes since the checkpoint and
+	// the index is never closed.
+	x.wal.Close()
+
+	reopen := func() *Index {
+		t.Helper()
+		x, err := Open(dir, Params{})
+		if err != nil {
+			t.Fatalf("failed to reopen index: %v", err)
+		}
+		return x
+	}
+	x = reopen()
+	if x.Model() != "test" || x.Dimensions() != 8 || x.Len() != 49 {
+		t.Fatalf("expected 49 vectors of the test model, got %d of %q", x.Len(), x.Model())
+	}
+	results, err := x.Search(items[45].Vector, 1)
+	if err != nil || len(results) != 1 || results[0].Key != 45 {
+		t.Errorf("expected a logged vector to be found, got %v, %v", results, err)
+	}
+
+	// A torn record at the end of the log is dropped.
+	if err := x.Add(items[0]); err != nil {
+		t.Fatalf("failed to add: %v", err)
+	}
+	x.wal.Close()
+	wal := filepath.Join(dir, walFile)
+	info, _ := os.Stat(wal)
+	if err := os.Truncate(wal, info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+	x = reopen()
+	if x.Len() != 49 {
+		t.Errorf("expected the torn addition to be dropped, got %d vectors", x.Len())
+	}
+	if err := x.Add(items[0]); err != nil {
+		t.Fatalf("failed to add after recovery: %v", err)
+	}
+	if err := x.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if info, _ := os.Stat(wal); info.Size() != 0 {
+		t.Errorf("expected closing to checkpoint the log, got %d bytes", info.Size())
+	}
+	x = reopen()
+	if x.Len() != 50 {
+		t.Errorf("expected all vectors after a clean close, got %d", x.Len())
+	}
+	x.Close()
+
+	snapshot := filepath.Join(dir, snapshotFile)
+	data, _ := os.ReadFile(snapshot)
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(snapshot, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir, Params{}); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected a corrupt snapshot to be detected, got %v", err)
+	}
+}
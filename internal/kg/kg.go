@@ -2,7 +2,9 @@ package kg
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"time"
 
 	"github.com/wassmi/nodimus-memory/internal/storage"
 )
@@ -11,6 +13,17 @@ import (
 type KGDB interface {
 	GetEntities() ([]storage.Entity, error)
 	GetRelationships() ([]storage.Relationship, error)
+	GetObservations() ([]storage.Observation, error)
+	GetMemoryLinks() ([]storage.MemoryLink, error)
+}
+
+// TemporalKGDB defines the database operations required to generate the
+// knowledge graph as it stood at a point in time.
+type TemporalKGDB interface {
+	GetEntities() ([]storage.Entity, error)
+	GetRelationshipsAsOf(asOf time.Time) ([]storage.Relationship, error)
+	GetObservationsAsOf(asOf time.Time) ([]storage.Observation, error)
+	GetMemoryLinks() ([]storage.MemoryLink, error)
 }
 
 // Generate generates a knowledge graph file in JSON-LD format.
@@ -25,6 +38,56 @@ func Generate(db KGDB, path string) error {
 		return err
 	}
 
+	observations, err := db.GetObservations()
+	if err != nil {
+		return err
+	}
+
+	links, err := db.GetMemoryLinks()
+	if err != nil {
+		return err
+	}
+	return write(path, entities, relationships, observations, links)
+}
+
+// GenerateAsOf generates a knowledge graph file in JSON-LD format holding
+// only the relationships and observations that were valid at asOf.
+func GenerateAsOf(db TemporalKGDB, path string, asOf time.Time) error {
+	entities, err := db.GetEntities()
+	if err != nil {
+		return err
+	}
+
+	relationships, err := db.GetRelationshipsAsOf(asOf)
+	if err != nil {
+		return err
+	}
+
+	observations, err := db.GetObservationsAsOf(asOf)
+	if err != nil {
+		return err
+	}
+
+	links, err := db.GetMemoryLinks()
+	if err != nil {
+		return err
+	}
+	return write(path, entities, relationships, observations, links)
+}
+
+// nodeID returns the @id of a node of the graph. Entities, relationships,
+// memories and memory links are numbered apart, so the kind of node
+// prefixes its number.
+func nodeID(kind string, id int64) string {
+	return fmt.Sprintf("%s/%d", kind, id)
+}
+
+func write(path string, entities []storage.Entity, relationships []storage.Relationship, observations []storage.Observation, links []storage.MemoryLink) error {
+	observationsByEntity := make(map[int64][]string)
+	for _, observation := range observations {
+		observationsByEntity[observation.EntityID] = append(observationsByEntity[observation.EntityID], observation.Content)
+	}
+
 	graph := map[string]interface{}{
 		"@context": "https://schema.org/",
 		"@graph":   []interface{}{},
@@ -35,22 +98,52 @@ func Generate(db KGDB, path string) error {
 	for _, entity := range entities {
 		entityNode := map[string]interface{}{
 			"@type": "Thing",
-			"@id":   entity.ID,
+			"@id":   nodeID("entity", entity.ID),
 			"name":  entity.Name,
 			"type":  entity.Type,
 		}
+		if contents, ok := observationsByEntity[entity.ID]; ok {
+			entityNode["observations"] = contents
+		}
 		graph["@graph"] = append(graph["@graph"].([]interface{}), entityNode)
 		entityMap[entity.ID] = entityNode
 	}
 
 	for _, rel := range relationships {
-		graph["@graph"] = append(graph["@graph"].([]interface{}), map[string]interface{}{
-			"@type":       "Relationship",
-			"@id":         rel.ID,
-			"source":      rel.SourceID,
-			"target":      rel.TargetID,
+		relNode := map[string]interface{}{
+			"@type":            "Relationship",
+			"@id":              nodeID("relationship", rel.ID),
+			"source":           nodeID("entity", rel.SourceID),
+			"target":           nodeID("entity", rel.TargetID),
 			"relationshipType": rel.Type,
-		})
+			"weight":           rel.Weight,
+			"confidence":       rel.Confidence,
+		}
+		if len(rel.Properties) > 0 {
+			relNode["properties"] = rel.Properties
+		}
+		if rel.SourceMemoryID != 0 {
+			relNode["sourceMemory"] = nodeID("memory", rel.SourceMemoryID)
+		}
+		if rel.ValidFrom != nil {
+			relNode["validFrom"] = rel.ValidFrom.Format(time.RFC3339)
+		}
+		// validUntil is exclusive, unlike schema.org's validThrough.
+		if rel.ValidTo != nil {
+			relNode["validUntil"] = rel.ValidTo.Format(time.RFC3339)
+		}
+		graph["@graph"] = append(graph["@graph"].([]interface{}), relNode)
+	}
+
+	for _, link := range links {
+		linkNode := map[string]interface{}{
+			"@type":    "MemoryLink",
+			"@id":      nodeID("memory-link", link.ID),
+			"source":   nodeID("memory", link.SourceID),
+			"target":   nodeID("memory", link.TargetID),
+			"linkType": link.Type,
+		}
+		graph["@graph"] = append(graph["@graph"].([]interface{}), linkNode)
 	}
 
 	file, err := os.Create(path)
@@ -63,4 +156,4 @@ func Generate(db KGDB, path string) error {
 	encoder.SetIndent("", "  ")
 
 	return encoder.Encode(graph)
-}
\ No newline at end of file
+}
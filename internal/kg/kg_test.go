@@ -4,15 +4,22 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/wassmi/nodimus-memory/internal/storage"
 )
 
 // MockDB implements the KGDB interface for testing.
 type MockDB struct {
-	GetEntitiesFunc     func() ([]storage.Entity, error)
+	GetEntitiesFunc      func() ([]storage.Entity, error)
 	GetRelationshipsFunc func() ([]storage.Relationship, error)
+	GetObservationsFunc  func() ([]storage.Observation, error)
+	GetMemoryLinksFunc   func() ([]storage.MemoryLink, error)
+
+	GetRelationshipsAsOfFunc func(asOf time.Time) ([]storage.Relationship, error)
+	GetObservationsAsOfFunc  func(asOf time.Time) ([]storage.Observation, error)
 }
 
 func (m *MockDB) GetEntities() ([]storage.Entity, error) {
@@ -23,6 +30,22 @@ func (m *MockDB) GetRelationships() ([]storage.Relationship, error) {
 	return m.GetRelationshipsFunc()
 }
 
+func (m *MockDB) GetObservations() ([]storage.Observation, error) {
+	return m.GetObservationsFunc()
+}
+
+func (m *MockDB) GetMemoryLinks() ([]storage.MemoryLink, error) {
+	return m.GetMemoryLinksFunc()
+}
+
+func (m *MockDB) GetRelationshipsAsOf(asOf time.Time) ([]storage.Relationship, error) {
+	return m.GetRelationshipsAsOfFunc(asOf)
+}
+
+func (m *MockDB) GetObservationsAsOf(asOf time.Time) ([]storage.Observation, error) {
+	return m.GetObservationsAsOfFunc(asOf)
+}
+
 func TestGenerate(t *testing.T) {
 	// Create a temporary file for the knowledge graph
 	kgFile := "test_kg.jsonld"
@@ -36,6 +59,12 @@ func TestGenerate(t *testing.T) {
 	mockRelationships := []storage.Relationship{
 		{ID: 101, SourceID: 1, TargetID: 2, Type: "LOCATED_IN"},
 	}
+	mockObservations := []storage.Observation{
+		{ID: 1, EntityID: 1, Content: "capital of France"},
+	}
+	mockLinks := []storage.MemoryLink{
+		{ID: 1, SourceID: 2, TargetID: 1, Type: storage.LinkSupersedes},
+	}
 
 	// Create a mock DB
 	mockDB := &MockDB{
@@ -45,6 +74,12 @@ func TestGenerate(t *testing.T) {
 		GetRelationshipsFunc: func() ([]storage.Relationship, error) {
 			return mockRelationships, nil
 		},
+		GetObservationsFunc: func() ([]storage.Observation, error) {
+			return mockObservations, nil
+		},
+		GetMemoryLinksFunc: func() ([]storage.MemoryLink, error) {
+			return mockLinks, nil
+		},
 	}
 
 	// Generate the knowledge graph
@@ -70,9 +105,111 @@ func TestGenerate(t *testing.T) {
 	}
 
 	graphNodes, ok := graph["@graph"].([]interface{})
-	if !ok || len(graphNodes) != 3 { // 2 entities + 1 relationship
-		t.Errorf("Expected 3 graph nodes, got %d", len(graphNodes))
+	if !ok || len(graphNodes) != 4 { // 2 entities + 1 relationship + 1 memory link
+		t.Fatalf("Expected 4 graph nodes, got %d", len(graphNodes))
+	}
+	if link, ok := graphNodes[3].(map[string]interface{}); !ok || link["@type"] != "MemoryLink" || link["linkType"] != "supersedes" {
+		t.Errorf("Expected the last node to be a supersedes memory link, got %v", graphNodes[3])
+	}
+
+	paris, ok := graphNodes[0].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected first node to be an object, got %T", graphNodes[0])
+	}
+	if observations, ok := paris["observations"].([]interface{}); !ok || len(observations) != 1 || observations[0] != "capital of France" {
+		t.Errorf("Expected Paris to carry its observation, got %v", paris["observations"])
+	}
+}
+
+func TestGenerateAsOf(t *testing.T) {
+	kgFile := "test_kg_asof.jsonld"
+	defer os.Remove(kgFile)
+
+	asOf := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	validFrom := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	validTo := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var gotAsOf time.Time
+	mockDB := &MockDB{
+		GetEntitiesFunc: func() ([]storage.Entity, error) {
+			return []storage.Entity{{ID: 1, Name: "Alice", Type: "person"}, {ID: 2, Name: "Acme", Type: "org"}}, nil
+		},
+		GetRelationshipsAsOfFunc: func(t time.Time) ([]storage.Relationship, error) {
+			gotAsOf = t
+			return []storage.Relationship{{ID: 7, SourceID: 1, TargetID: 2, Type: "works_at", ValidFrom: &validFrom, ValidTo: &validTo}}, nil
+		},
+		GetObservationsAsOfFunc: func(t time.Time) ([]storage.Observation, error) {
+			return nil, nil
+		},
+		GetMemoryLinksFunc: func() ([]storage.MemoryLink, error) {
+			return nil, nil
+		},
+	}
+
+	if err := GenerateAsOf(mockDB, kgFile, asOf); err != nil {
+		t.Fatalf("GenerateAsOf failed: %v", err)
+	}
+	if !gotAsOf.Equal(asOf) {
+		t.Errorf("Expected relationships as of %v, got %v", asOf, gotAsOf)
+	}
+
+	content, err := ioutil.ReadFile(kgFile)
+	if err != nil {
+		t.Fatalf("Failed to read generated KG file: %v", err)
+	}
+	var graph map[string]interface{}
+	if err := json.Unmarshal(content, &graph); err != nil {
+		t.Fatalf("Failed to unmarshal generated KG: %v", err)
+	}
+	nodes := graph["@graph"].([]interface{})
+	rel := nodes[2].(map[string]interface{})
+	if rel["validFrom"] != "2023-01-01T00:00:00Z" || rel["validUntil"] != "2025-01-01T00:00:00Z" {
+		t.Errorf("Expected validity on the relationship node, got %v", rel)
+	}
+}
+
+func TestGenerateIDs(t *testing.T) {
+	kgFile := filepath.Join(t.TempDir(), "kg.jsonld")
+	// Entities, relationships and links are numbered apart.
+	mockDB := &MockDB{
+		GetEntitiesFunc: func() ([]storage.Entity, error) {
+			return []storage.Entity{{ID: 1, Name: "Paris"}, {ID: 2, Name: "France"}}, nil
+		},
+		GetRelationshipsFunc: func() ([]storage.Relationship, error) {
+			return []storage.Relationship{{ID: 1, SourceID: 1, TargetID: 2, Type: "LOCATED_IN", SourceMemoryID: 2}}, nil
+		},
+		GetObservationsFunc: func() ([]storage.Observation, error) {
+			return nil, nil
+		},
+		GetMemoryLinksFunc: func() ([]storage.MemoryLink, error) {
+			return []storage.MemoryLink{{ID: 1, SourceID: 2, TargetID: 1, Type: storage.LinkSupersedes}}, nil
+		},
+	}
+	if err := Generate(mockDB, kgFile); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	content, err := os.ReadFile(kgFile)
+	if err != nil {
+		t.Fatalf("Failed to read generated KG file: %v", err)
+	}
+	var graph struct {
+		Graph []map[string]interface{} `json:"@graph"`
+	}
+	if err := json.Unmarshal(content, &graph); err != nil {
+		t.Fatalf("Failed to unmarshal generated KG: %v", err)
 	}
 
-	// Further assertions can be made to check the content of each node
-}
\ No newline at end of file
+	ids := map[interface{}]bool{}
+	for _, node := range graph.Graph {
+		if ids[node["@id"]] {
+			t.Errorf("Expected unique @ids, got %v twice", node["@id"])
+		}
+		ids[node["@id"]] = true
+	}
+	rel, link := graph.Graph[2], graph.Graph[3]
+	if rel["@id"] != "relationship/1" || rel["source"] != "entity/1" || rel["target"] != "entity/2" || rel["sourceMemory"] != "memory/2" {
+		t.Errorf("Expected the relationship to reference entities and its memory, got %v", rel)
+	}
+	if link["@id"] != "memory-link/1" || link["source"] != "memory/2" || link["target"] != "memory/1" {
+		t.Errorf("Expected the link to reference memories, got %v", link)
+	}
+}
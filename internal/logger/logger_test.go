@@ -22,7 +22,7 @@ func TestNewLoggerWithFile(t *testing.T) {
 		Compress:   false,
 	}
 
-	logger := New(cfg)
+	logger := New(cfg, "")
 	if logger == nil {
 		t.Fatal("New returned nil")
 	}
@@ -49,7 +49,7 @@ func TestNewLoggerWithoutFile(t *testing.T) {
 	r, w, _ := os.Pipe()
 	os.Stdout = w
 
-	logger := New(cfg)
+	logger := New(cfg, "")
 	if logger == nil {
 		t.Fatal("New returned nil")
 	}
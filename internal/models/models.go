@@ -12,6 +12,11 @@ var ModelChecksums = map[string]string{
 
 // VerifyChecksum verifies the checksum of a model.
 func VerifyChecksum(modelName string, data []byte) bool {
+	return ModelChecksums[modelName] == Checksum(data)
+}
+
+// Checksum returns the hex-encoded SHA-256 checksum of a model file.
+func Checksum(data []byte) string {
 	hash := sha256.Sum256(data)
-	return ModelChecksums[modelName] == hex.EncodeToString(hash[:])
+	return hex.EncodeToString(hash[:])
 }
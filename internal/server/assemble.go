@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/wassmi/nodimus-memory/internal/storage"
+	"github.com/wassmi/nodimus-memory/internal/tokens"
+)
+
+// BuildContextRequest is the request for the BuildContext method. Query is
+// the question or task to assemble context for and Budget the number of
+// tokens the context may take up. Tokenizer, "chars" or "words", overrides
+// the configured estimator that counts them. Mode ("match", "query_string",
+// "semantic", "hybrid" or "graph") is how memories are searched for,
+// hybrid when embeddings are enabled and match otherwise by default;
+// Language and History are those of SearchMemoryRequest.
+type BuildContextRequest struct {
+	Query     string `json:"query"`
+	Budget    int    `json:"budget"`
+	Tokenizer string `json:"tokenizer,omitempty"`
+	Mode      string `json:"mode,omitempty"`
+	Language  string `json:"language,omitempty"`
+	History   bool   `json:"history,omitempty"`
+}
+
+// BuildContextResponse is the response for the BuildContext method.
+type BuildContextResponse struct {
+	storage.ContextBlock
+}
+
+// BuildContext assembles the memories, entity summaries and relationships
+// relevant to a query into a context block within a token budget.
+func (s *MemoryService) BuildContext(r *http.Request, args *BuildContextRequest, reply *BuildContextResponse) error {
+	if strings.TrimSpace(args.Query) == "" {
+		return invalidParam("query", "query must not be empty")
+	}
+	if args.Budget <= 0 {
+		return invalidParam("budget", "budget must be positive, got %d", args.Budget)
+	}
+	switch args.Mode {
+	case "", storage.QueryMatch, storage.QueryString, storage.QuerySemantic, storage.QueryHybrid, storage.QueryGraph:
+	default:
+		return invalidParam("mode", "mode must be %q, %q, %q, %q or %q, got %q", storage.QueryMatch, storage.QueryString, storage.QuerySemantic, storage.QueryHybrid, storage.QueryGraph, args.Mode)
+	}
+	if err := storage.ValidLanguage(args.Language); err != nil {
+		return invalidParam("language", "%v", err)
+	}
+	tokenizer := s.Tokenizer
+	if args.Tokenizer != "" {
+		t, err := tokens.New(args.Tokenizer)
+		if err != nil {
+			return invalidParam("tokenizer", "%v", err)
+		}
+		tokenizer = t
+	}
+	block, err := s.DB.BuildContext(storage.ContextOptions{
+		Query:     args.Query,
+		Budget:    args.Budget,
+		Tokenizer: tokenizer,
+		Mode:      args.Mode,
+		Language:  args.Language,
+		History:   args.History,
+		Scoring:   s.Scoring,
+	})
+	if err != nil {
+		return err
+	}
+	reply.ContextBlock = block
+	return nil
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/wassmi/nodimus-memory/internal/storage"
+	"github.com/wassmi/nodimus-memory/internal/tokens"
+)
+
+func TestBuildContext(t *testing.T) {
+	var got storage.ContextOptions
+	service := &MemoryService{DB: &MockDB{
+		BuildContextFunc: func(opts storage.ContextOptions) (storage.ContextBlock, error) {
+			got = opts
+			return storage.ContextBlock{Text: "## Memories\n- [#1, 2026-10-18] payments\n", Tokens: 10, Budget: opts.Budget, Memories: []int64{1}}, nil
+		},
+	}, Scoring: storage.ScoreFunctions{PinnedBoost: 2}, Tokenizer: tokens.CharEstimator{PerToken: 3}}
+
+	reply := &BuildContextResponse{}
+	req := &BuildContextRequest{Query: "payments api", Budget: 500, Mode: storage.QueryGraph, Language: storage.LanguageEnglish}
+	if err := service.BuildContext(nil, req, reply); err != nil {
+		t.Fatalf("BuildContext failed: %v", err)
+	}
+	if got.Query != req.Query || got.Budget != 500 || got.Mode != storage.QueryGraph || got.Language != storage.LanguageEnglish || got.Scoring != service.Scoring {
+		t.Errorf("expected the options to be passed on, got %+v", got)
+	}
+	if got.Tokenizer != service.Tokenizer {
+		t.Errorf("expected the configured tokenizer, got %#v", got.Tokenizer)
+	}
+	if reply.Tokens != 10 || reply.Budget != 500 || len(reply.Memories) != 1 {
+		t.Errorf("expected the context block in the reply, got %+v", reply)
+	}
+
+	req.Tokenizer = tokens.Words
+	if err := service.BuildContext(nil, req, reply); err != nil {
+		t.Fatalf("BuildContext failed: %v", err)
+	}
+	if _, ok := got.Tokenizer.(tokens.WordEstimator); !ok {
+		t.Errorf("expected the requested tokenizer, got %#v", got.Tokenizer)
+	}
+
+	for _, req := range []*BuildContextRequest{{Budget: 100}, {Query: "payments"}, {Query: "payments", Budget: -1}, {Query: "payments", Budget: 100, Mode: "regex"},
+		{Query: "payments", Budget: 100, Language: "xx"}, {Query: "payments", Budget: 100, Tokenizer: "tiktoken"}} {
+		var reqErr *RequestError
+		if err := service.BuildContext(nil, req, &BuildContextResponse{}); !errors.As(err, &reqErr) || reqErr.Code != CodeInvalidParams {
+			t.Errorf("expected an invalid params error for %+v, got %v", req, err)
+		}
+	}
+}
@@ -1,12 +1,56 @@
 package server
 
-import "github.com/wassmi/nodimus-memory/internal/storage"
+import (
+	"time"
+
+	"github.com/wassmi/nodimus-memory/internal/storage"
+)
 
 // DB defines the interface for database operations required by the server.
 type DB interface {
-	AddMemory(content string, entityNames []string) (int64, error)
-	SearchMemories(query string) ([]string, error)
+	CreateMemory(in storage.MemoryInput) (int64, error)
+	Search(opts storage.SearchOptions) (storage.SearchResult, error)
+	RecordAccess(ids ...int64)
+	GetAccessStats(id int64) (storage.AccessStats, error)
+	StaleMemories(before time.Time, limit int) ([]storage.Memory, error)
+	ListMemories(filter storage.MemoryFilter, opts storage.ListOptions) (storage.MemoryPage, error)
+	CountMemories(namespace string) (int64, error)
+	SetPinned(id int64, pinned bool) error
+	StoreSize() (int64, error)
 	GetMemory(id int64) (string, error)
 	GetEntities() ([]storage.Entity, error)
 	GetRelationships() ([]storage.Relationship, error)
+	StartSession(clientID string) (int64, error)
+	AppendTurn(sessionID int64, role, content, clientID string) (storage.Turn, error)
+	CloseSession(id int64) error
+	GetTurns(sessionID int64) ([]storage.Turn, error)
+	GetTurnsAround(memoryID int64, window int) (*storage.Session, []storage.Turn, error)
+	GetEntity(name string) (storage.Entity, error)
+	GetAliases(entityID int64) ([]string, error)
+	AddAlias(entityName, alias string) error
+	MergeEntities(sourceName, targetName string) (storage.Entity, error)
+	SuggestMerges(threshold float64) ([]storage.MergeSuggestion, error)
+	CreateEntity(name, entityType string) (storage.Entity, error)
+	DeleteEntity(name string) error
+	AddObservations(entityName string, contents []string) ([]storage.Observation, error)
+	DeleteObservations(entityName string, contents []string) (int64, error)
+	ExpireObservations(entityName string, contents []string, at time.Time) (int64, error)
+	ListObservations(entityName string, asOf time.Time) ([]storage.Observation, error)
+	GetObservations() ([]storage.Observation, error)
+	GetObservationsAsOf(asOf time.Time) ([]storage.Observation, error)
+	SearchNodes(query string) ([]storage.Entity, error)
+	CreateRelationship(in storage.RelationshipInput) (storage.Relationship, bool, error)
+	DeleteRelationships(source, target, relType string) (int64, error)
+	ExpireRelationships(source, target, relType string, at time.Time) (int64, error)
+	GetRelationshipsAsOf(asOf time.Time) ([]storage.Relationship, error)
+	ListRelationships(filter storage.RelationshipFilter) ([]storage.Relationship, error)
+	LinkMemories(sourceID, targetID int64, linkType string) (storage.MemoryLink, bool, error)
+	UnlinkMemories(sourceID, targetID int64, linkType string) (int64, error)
+	GetMemoryLinks() ([]storage.MemoryLink, error)
+	GetLinkedMemories(memoryID int64) ([]storage.LinkedMemory, error)
+	CreateStandingQuery(q storage.StandingQuery) (storage.StandingQuery, error)
+	ListStandingQueries() ([]storage.StandingQuery, error)
+	DeleteStandingQuery(id int64) error
+	MatchStandingQueries(memoryID int64) (storage.StandingMatch, error)
+	BuildContext(opts storage.ContextOptions) (storage.ContextBlock, error)
 }
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/wassmi/nodimus-memory/internal/storage"
+)
+
+// defaultMergeThreshold is the similarity above which two entity names are
+// reported as likely duplicates when the caller does not choose one.
+const defaultMergeThreshold = 0.75
+
+// GetEntityRequest is the request for the GetEntity method.
+type GetEntityRequest struct {
+	Name string `json:"name"`
+}
+
+// GetEntityResponse is the response for the GetEntity method.
+type GetEntityResponse struct {
+	Entity  storage.Entity `json:"entity"`
+	Aliases []string       `json:"aliases"`
+}
+
+// GetEntity resolves a name or alias to its canonical entity.
+func (s *MemoryService) GetEntity(r *http.Request, args *GetEntityRequest, reply *GetEntityResponse) error {
+	entity, err := s.DB.GetEntity(args.Name)
+	if err != nil {
+		return err
+	}
+	aliases, err := s.DB.GetAliases(entity.ID)
+	if err != nil {
+		return err
+	}
+	reply.Entity = entity
+	reply.Aliases = aliases
+	return nil
+}
+
+// AddEntityAliasRequest is the request for the AddEntityAlias method.
+type AddEntityAliasRequest struct {
+	Entity string `json:"entity"`
+	Alias  string `json:"alias"`
+}
+
+// AddEntityAliasResponse is the response for the AddEntityAlias method.
+type AddEntityAliasResponse struct {
+	Entity storage.Entity `json:"entity"`
+}
+
+// AddEntityAlias records another name for an entity.
+func (s *MemoryService) AddEntityAlias(r *http.Request, args *AddEntityAliasRequest, reply *AddEntityAliasResponse) error {
+	if err := s.checkEntityName("alias", args.Alias); err != nil {
+		return err
+	}
+	if err := s.DB.AddAlias(args.Entity, args.Alias); err != nil {
+		return err
+	}
+	entity, err := s.DB.GetEntity(args.Entity)
+	if err != nil {
+		return err
+	}
+	reply.Entity = entity
+	return nil
+}
+
+// MergeEntitiesRequest is the request for the MergeEntities method.
+type MergeEntitiesRequest struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// MergeEntitiesResponse is the response for the MergeEntities method.
+type MergeEntitiesResponse struct {
+	Entity storage.Entity `json:"entity"`
+}
+
+// MergeEntities folds the source entity into the target, keeping the
+// source's name as an alias.
+func (s *MemoryService) MergeEntities(r *http.Request, args *MergeEntitiesRequest, reply *MergeEntitiesResponse) error {
+	entity, err := s.DB.MergeEntities(args.Source, args.Target)
+	if err != nil {
+		return err
+	}
+	reply.Entity = entity
+	s.regenerateGraph()
+	return nil
+}
+
+// SuggestEntityMergesRequest is the request for the SuggestEntityMerges method.
+type SuggestEntityMergesRequest struct {
+	Threshold float64 `json:"threshold,omitempty"`
+}
+
+// SuggestEntityMergesResponse is the response for the SuggestEntityMerges method.
+type SuggestEntityMergesResponse struct {
+	Suggestions []storage.MergeSuggestion `json:"suggestions"`
+}
+
+// SuggestEntityMerges reports pairs of entities that are likely duplicates.
+func (s *MemoryService) SuggestEntityMerges(r *http.Request, args *SuggestEntityMergesRequest, reply *SuggestEntityMergesResponse) error {
+	threshold := args.Threshold
+	if threshold <= 0 {
+		threshold = defaultMergeThreshold
+	}
+	suggestions, err := s.DB.SuggestMerges(threshold)
+	if err != nil {
+		return err
+	}
+	reply.Suggestions = suggestions
+	return nil
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+
+	"github.com/wassmi/nodimus-memory/internal/storage"
+)
+
+func TestMergeEntities(t *testing.T) {
+	mockDB := &MockDB{
+		MergeEntitiesFunc: func(sourceName, targetName string) (storage.Entity, error) {
+			if sourceName != "Postgres" || targetName != "PostgreSQL" {
+				return storage.Entity{}, errors.New("unexpected arguments")
+			}
+			return storage.Entity{ID: 2, Name: "PostgreSQL", Type: "library"}, nil
+		},
+		GetEntitiesFunc:      func() ([]storage.Entity, error) { return nil, nil },
+		GetRelationshipsFunc: func() ([]storage.Relationship, error) { return nil, nil },
+		GetObservationsFunc:  func() ([]storage.Observation, error) { return nil, nil },
+		GetMemoryLinksFunc:   func() ([]storage.MemoryLink, error) { return nil, nil },
+	}
+	var logBuffer bytes.Buffer
+	service := &MemoryService{DB: mockDB, DataDir: t.TempDir(), Log: log.New(&logBuffer, "", 0)}
+
+	reply := &MergeEntitiesResponse{}
+	if err := service.MergeEntities(nil, &MergeEntitiesRequest{Source: "Postgres", Target: "PostgreSQL"}, reply); err != nil {
+		t.Fatalf("MergeEntities failed: %v", err)
+	}
+	if reply.Entity.Name != "PostgreSQL" {
+		t.Errorf("Expected PostgreSQL, got %+v", reply.Entity)
+	}
+}
+
+func TestSuggestEntityMergesDefaultThreshold(t *testing.T) {
+	var got float64
+	mockDB := &MockDB{
+		SuggestMergesFunc: func(threshold float64) ([]storage.MergeSuggestion, error) {
+			got = threshold
+			return []storage.MergeSuggestion{{Score: 0.8}}, nil
+		},
+	}
+	service := &MemoryService{DB: mockDB}
+
+	reply := &SuggestEntityMergesResponse{}
+	if err := service.SuggestEntityMerges(nil, &SuggestEntityMergesRequest{}, reply); err != nil {
+		t.Fatalf("SuggestEntityMerges failed: %v", err)
+	}
+	if got != defaultMergeThreshold {
+		t.Errorf("Expected default threshold %v, got %v", defaultMergeThreshold, got)
+	}
+	if len(reply.Suggestions) != 1 {
+		t.Errorf("Expected 1 suggestion, got %d", len(reply.Suggestions))
+	}
+}
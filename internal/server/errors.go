@@ -0,0 +1,119 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/gorilla/rpc/v2/json2"
+	"github.com/wassmi/nodimus-memory/internal/storage"
+)
+
+// Error codes returned in JSON-RPC error responses. Clients may rely on
+// them; new codes are only ever added.
+const (
+	CodeServerError   = -32000 // unexpected failure; the message has details
+	CodeNotFound      = -32001 // a referenced memory, entity, session or relationship does not exist
+	CodeConflict      = -32002 // the request clashes with the stored state
+	CodeLimitExceeded = -32003 // a value is larger than a configured limit
+	CodeQuotaExceeded = -32004 // storing the data would exceed a configured quota
+	CodeInvalidParams = -32602 // a parameter is missing or malformed
+)
+
+// ErrorData is the machine-readable part of an error response. Limit and
+// Actual are set for CodeLimitExceeded and CodeQuotaExceeded; Column is the
+// one-based position of a syntax error in a query string.
+type ErrorData struct {
+	Field  string `json:"field,omitempty"`
+	Limit  int64  `json:"limit,omitempty"`
+	Actual int64  `json:"actual,omitempty"`
+	Column int    `json:"column,omitempty"`
+}
+
+// RequestError is an error caused by the request rather than the server.
+type RequestError struct {
+	Code    int
+	Message string
+	Data    ErrorData
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+func invalidParam(field, format string, args ...interface{}) *RequestError {
+	return &RequestError{Code: CodeInvalidParams, Message: fmt.Sprintf(format, args...), Data: ErrorData{Field: field}}
+}
+
+func limitExceeded(field string, limit, actual int64) *RequestError {
+	return &RequestError{
+		Code:    CodeLimitExceeded,
+		Message: fmt.Sprintf("%s is too large: %d exceeds the limit of %d", field, actual, limit),
+		Data:    ErrorData{Field: field, Limit: limit, Actual: actual},
+	}
+}
+
+func quotaExceeded(field string, limit, actual int64) *RequestError {
+	return &RequestError{
+		Code:    CodeQuotaExceeded,
+		Message: fmt.Sprintf("%s quota reached: %d of %d used", field, actual, limit),
+		Data:    ErrorData{Field: field, Limit: limit, Actual: actual},
+	}
+}
+
+// RPCError converts an error returned by a MemoryService method into a
+// JSON-RPC error with a stable code.
+func RPCError(err error) *json2.Error {
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		return &json2.Error{Code: json2.ErrorCode(reqErr.Code), Message: reqErr.Message, Data: reqErr.Data}
+	}
+	var rpcErr *json2.Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+	var queryErr *storage.QueryError
+	if errors.As(err, &queryErr) {
+		data := ErrorData{Field: "query", Column: queryErr.Pos + 1}
+		if queryErr.Path != "" {
+			data = ErrorData{Field: "structured_" + queryErr.Path}
+		}
+		return &json2.Error{Code: CodeInvalidParams, Message: queryErr.Error(), Data: data}
+	}
+
+	code := CodeServerError
+	switch {
+	case errors.Is(err, sql.ErrNoRows),
+		errors.Is(err, storage.ErrMemoryNotFound),
+		errors.Is(err, storage.ErrEntityNotFound),
+		errors.Is(err, storage.ErrRelationshipNotFound),
+		errors.Is(err, storage.ErrSessionNotFound),
+		errors.Is(err, storage.ErrTurnNotFound):
+		code = CodeNotFound
+	case errors.Is(err, storage.ErrAliasConflict),
+		errors.Is(err, storage.ErrSessionClosed):
+		code = CodeConflict
+	case errors.Is(err, storage.ErrInvalidLinkType),
+		errors.Is(err, storage.ErrInvalidCursor),
+		errors.Is(err, storage.ErrInvalidFacet),
+		errors.Is(err, storage.ErrNoEmbedder),
+		errors.Is(err, storage.ErrInvalidFusion),
+		errors.Is(err, storage.ErrInvalidMMR),
+		errors.Is(err, storage.ErrInvalidScoring),
+		errors.Is(err, storage.ErrInvalidGraph),
+		errors.Is(err, storage.ErrUnknownLanguage),
+		errors.Is(err, storage.ErrInvalidStandingQuery),
+		errors.Is(err, storage.ErrInvalidContext):
+		code = CodeInvalidParams
+	}
+	message := err.Error()
+	if errors.Is(err, sql.ErrNoRows) {
+		message = "not found"
+	}
+	return &json2.Error{Code: json2.ErrorCode(code), Message: message}
+}
+
+// errorMapper adapts RPCError to the json2 codec.
+func errorMapper(err error) error {
+	return RPCError(err)
+}
@@ -0,0 +1,364 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/wassmi/nodimus-memory/internal/storage"
+)
+
+// The types and methods in this file mirror the tools of the reference MCP
+// memory server, including its camelCase field names, so that prompts
+// written for that server work unchanged.
+
+// GraphEntity is an entity together with its observations.
+type GraphEntity struct {
+	Name         string   `json:"name"`
+	EntityType   string   `json:"entityType"`
+	Observations []string `json:"observations"`
+}
+
+// GraphRelation is a directed relationship between two named entities.
+type GraphRelation struct {
+	From         string `json:"from"`
+	To           string `json:"to"`
+	RelationType string `json:"relationType"`
+}
+
+// Graph is a view of the knowledge graph.
+type Graph struct {
+	Entities  []GraphEntity   `json:"entities"`
+	Relations []GraphRelation `json:"relations"`
+}
+
+// buildGraph assembles a graph of the given entities, their observations and
+// the relationships between them, as they stand now or, when asOf is set, as
+// they stood at that time.
+func (s *MemoryService) buildGraph(entities []storage.Entity, asOf *time.Time) (*Graph, error) {
+	var (
+		observations  []storage.Observation
+		relationships []storage.Relationship
+		err           error
+	)
+	if asOf != nil {
+		observations, err = s.DB.GetObservationsAsOf(*asOf)
+		if err == nil {
+			relationships, err = s.DB.GetRelationshipsAsOf(*asOf)
+		}
+	} else {
+		observations, err = s.DB.GetObservations()
+		if err == nil {
+			relationships, err = s.DB.GetRelationships()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[int64]string, len(entities))
+	for _, entity := range entities {
+		names[entity.ID] = entity.Name
+	}
+	byEntity := make(map[int64][]string)
+	for _, observation := range observations {
+		if _, ok := names[observation.EntityID]; ok {
+			byEntity[observation.EntityID] = append(byEntity[observation.EntityID], observation.Content)
+		}
+	}
+
+	graph := &Graph{Entities: []GraphEntity{}, Relations: []GraphRelation{}}
+	for _, entity := range entities {
+		contents := byEntity[entity.ID]
+		if contents == nil {
+			contents = []string{}
+		}
+		graph.Entities = append(graph.Entities, GraphEntity{Name: entity.Name, EntityType: entity.Type, Observations: contents})
+	}
+	for _, rel := range relationships {
+		from, okFrom := names[rel.SourceID]
+		to, okTo := names[rel.TargetID]
+		if okFrom && okTo {
+			graph.Relations = append(graph.Relations, GraphRelation{From: from, To: to, RelationType: rel.Type})
+		}
+	}
+	return graph, nil
+}
+
+// CreateEntitiesRequest is the request for the CreateEntities method.
+type CreateEntitiesRequest struct {
+	Entities []GraphEntity `json:"entities"`
+}
+
+// CreateEntitiesResponse is the response for the CreateEntities method.
+type CreateEntitiesResponse struct {
+	Entities []GraphEntity `json:"entities"`
+}
+
+// CreateEntities creates entities, or reuses existing ones with the same
+// name, and attaches the given observations to them.
+func (s *MemoryService) CreateEntities(r *http.Request, args *CreateEntitiesRequest, reply *CreateEntitiesResponse) error {
+	for i, spec := range args.Entities {
+		if err := s.checkEntityName(fmt.Sprintf("entities[%d].name", i), spec.Name); err != nil {
+			return err
+		}
+		if err := s.checkContents(fmt.Sprintf("entities[%d].observations", i), spec.Observations); err != nil {
+			return err
+		}
+	}
+	if err := s.checkStoreQuota(); err != nil {
+		return err
+	}
+	reply.Entities = []GraphEntity{}
+	for _, spec := range args.Entities {
+		entity, err := s.DB.CreateEntity(spec.Name, spec.EntityType)
+		if err != nil {
+			return err
+		}
+		added, err := s.DB.AddObservations(entity.Name, spec.Observations)
+		if err != nil {
+			return err
+		}
+		contents := []string{}
+		for _, observation := range added {
+			contents = append(contents, observation.Content)
+		}
+		reply.Entities = append(reply.Entities, GraphEntity{Name: entity.Name, EntityType: entity.Type, Observations: contents})
+	}
+	s.regenerateGraph()
+	return nil
+}
+
+// DeleteEntitiesRequest is the request for the DeleteEntities method.
+type DeleteEntitiesRequest struct {
+	EntityNames []string `json:"entityNames"`
+}
+
+// DeleteEntitiesResponse is the response for the DeleteEntities method.
+type DeleteEntitiesResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+// DeleteEntities removes entities along with their observations and
+// relationships. Names that match no entity are ignored.
+func (s *MemoryService) DeleteEntities(r *http.Request, args *DeleteEntitiesRequest, reply *DeleteEntitiesResponse) error {
+	for _, name := range args.EntityNames {
+		err := s.DB.DeleteEntity(name)
+		if err == storage.ErrEntityNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		reply.Deleted++
+	}
+	s.regenerateGraph()
+	return nil
+}
+
+// ObservationInput names an entity and the observations to add to it.
+type ObservationInput struct {
+	EntityName string   `json:"entityName"`
+	Contents   []string `json:"contents"`
+}
+
+// AddObservationsRequest is the request for the AddObservations method.
+type AddObservationsRequest struct {
+	Observations []ObservationInput `json:"observations"`
+}
+
+// ObservationResult reports the observations actually added to an entity.
+type ObservationResult struct {
+	EntityName        string   `json:"entityName"`
+	AddedObservations []string `json:"addedObservations"`
+}
+
+// AddObservationsResponse is the response for the AddObservations method.
+type AddObservationsResponse struct {
+	Results []ObservationResult `json:"results"`
+}
+
+// AddObservations attaches observations to existing entities.
+func (s *MemoryService) AddObservations(r *http.Request, args *AddObservationsRequest, reply *AddObservationsResponse) error {
+	for i, input := range args.Observations {
+		if err := s.checkContents(fmt.Sprintf("observations[%d].contents", i), input.Contents); err != nil {
+			return err
+		}
+	}
+	if err := s.checkStoreQuota(); err != nil {
+		return err
+	}
+	reply.Results = []ObservationResult{}
+	for _, input := range args.Observations {
+		added, err := s.DB.AddObservations(input.EntityName, input.Contents)
+		if err != nil {
+			return err
+		}
+		result := ObservationResult{EntityName: input.EntityName, AddedObservations: []string{}}
+		for _, observation := range added {
+			result.AddedObservations = append(result.AddedObservations, observation.Content)
+		}
+		reply.Results = append(reply.Results, result)
+	}
+	s.regenerateGraph()
+	return nil
+}
+
+// ObservationDeletion names an entity and the observations to remove from it.
+type ObservationDeletion struct {
+	EntityName   string   `json:"entityName"`
+	Observations []string `json:"observations"`
+}
+
+// DeleteObservationsRequest is the request for the DeleteObservations method.
+type DeleteObservationsRequest struct {
+	Deletions []ObservationDeletion `json:"deletions"`
+}
+
+// DeleteObservationsResponse is the response for the DeleteObservations method.
+type DeleteObservationsResponse struct {
+	Deleted int64 `json:"deleted"`
+}
+
+// DeleteObservations removes observations, including their history, from
+// entities.
+func (s *MemoryService) DeleteObservations(r *http.Request, args *DeleteObservationsRequest, reply *DeleteObservationsResponse) error {
+	for _, deletion := range args.Deletions {
+		n, err := s.DB.DeleteObservations(deletion.EntityName, deletion.Observations)
+		if err != nil {
+			return err
+		}
+		reply.Deleted += n
+	}
+	s.regenerateGraph()
+	return nil
+}
+
+// ExpireObservationsRequest is the request for the ExpireObservations
+// method. At defaults to now.
+type ExpireObservationsRequest struct {
+	Deletions []ObservationDeletion `json:"deletions"`
+	At        *time.Time            `json:"at,omitempty"`
+}
+
+// ExpireObservationsResponse is the response for the ExpireObservations method.
+type ExpireObservationsResponse struct {
+	Expired int64 `json:"expired"`
+}
+
+// ExpireObservations records that observations of entities stopped holding,
+// keeping them for queries about the past.
+func (s *MemoryService) ExpireObservations(r *http.Request, args *ExpireObservationsRequest, reply *ExpireObservationsResponse) error {
+	var at time.Time
+	if args.At != nil {
+		at = *args.At
+	}
+	for _, deletion := range args.Deletions {
+		n, err := s.DB.ExpireObservations(deletion.EntityName, deletion.Observations, at)
+		if err != nil {
+			return err
+		}
+		reply.Expired += n
+	}
+	s.regenerateGraph()
+	return nil
+}
+
+// ListObservationsRequest is the request for the ListObservations method.
+// AsOf lists the observations that held at that time instead of now.
+type ListObservationsRequest struct {
+	Entity string     `json:"entity"`
+	AsOf   *time.Time `json:"asOf,omitempty"`
+}
+
+// ListObservationsResponse is the response for the ListObservations method.
+type ListObservationsResponse struct {
+	Observations []storage.Observation `json:"observations"`
+}
+
+// ListObservations lists the observations of an entity.
+func (s *MemoryService) ListObservations(r *http.Request, args *ListObservationsRequest, reply *ListObservationsResponse) error {
+	var asOf time.Time
+	if args.AsOf != nil {
+		asOf = *args.AsOf
+	}
+	observations, err := s.DB.ListObservations(args.Entity, asOf)
+	if err != nil {
+		return err
+	}
+	reply.Observations = observations
+	return nil
+}
+
+// ReadGraphRequest is the request for the ReadGraph method. AsOf reads the
+// graph as it stood at that time.
+type ReadGraphRequest struct {
+	AsOf *time.Time `json:"asOf,omitempty"`
+}
+
+// ReadGraph returns the whole knowledge graph.
+func (s *MemoryService) ReadGraph(r *http.Request, args *ReadGraphRequest, reply *Graph) error {
+	entities, err := s.DB.GetEntities()
+	if err != nil {
+		return err
+	}
+	graph, err := s.buildGraph(entities, args.AsOf)
+	if err != nil {
+		return err
+	}
+	*reply = *graph
+	return nil
+}
+
+// SearchNodesRequest is the request for the SearchNodes method.
+type SearchNodesRequest struct {
+	Query string     `json:"query"`
+	AsOf  *time.Time `json:"asOf,omitempty"`
+}
+
+// SearchNodes returns the part of the graph whose entity names, types,
+// aliases or observations match the query.
+func (s *MemoryService) SearchNodes(r *http.Request, args *SearchNodesRequest, reply *Graph) error {
+	entities, err := s.DB.SearchNodes(args.Query)
+	if err != nil {
+		return err
+	}
+	graph, err := s.buildGraph(entities, args.AsOf)
+	if err != nil {
+		return err
+	}
+	*reply = *graph
+	return nil
+}
+
+// OpenNodesRequest is the request for the OpenNodes method.
+type OpenNodesRequest struct {
+	Names []string   `json:"names"`
+	AsOf  *time.Time `json:"asOf,omitempty"`
+}
+
+// OpenNodes returns the named entities and the relationships between them.
+// Names that match no entity are ignored.
+func (s *MemoryService) OpenNodes(r *http.Request, args *OpenNodesRequest, reply *Graph) error {
+	var entities []storage.Entity
+	seen := make(map[int64]bool)
+	for _, name := range args.Names {
+		entity, err := s.DB.GetEntity(name)
+		if err == storage.ErrEntityNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !seen[entity.ID] {
+			seen[entity.ID] = true
+			entities = append(entities, entity)
+		}
+	}
+	graph, err := s.buildGraph(entities, args.AsOf)
+	if err != nil {
+		return err
+	}
+	*reply = *graph
+	return nil
+}
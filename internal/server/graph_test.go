@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/wassmi/nodimus-memory/internal/storage"
+)
+
+func graphMockDB() *MockDB {
+	entities := []storage.Entity{
+		{ID: 1, Name: "Alice", Type: "person"},
+		{ID: 2, Name: "billing", Type: "service"},
+		{ID: 3, Name: "Bob", Type: "person"},
+	}
+	return &MockDB{
+		GetEntitiesFunc: func() ([]storage.Entity, error) { return entities, nil },
+		GetEntityFunc: func(name string) (storage.Entity, error) {
+			for _, entity := range entities {
+				if storage.NormalizeEntityName(entity.Name) == storage.NormalizeEntityName(name) {
+					return entity, nil
+				}
+			}
+			return storage.Entity{}, storage.ErrEntityNotFound
+		},
+		GetRelationshipsFunc: func() ([]storage.Relationship, error) {
+			return []storage.Relationship{
+				{ID: 1, SourceID: 1, TargetID: 2, Type: "owns"},
+				{ID: 2, SourceID: 3, TargetID: 1, Type: "reports_to"},
+			}, nil
+		},
+		GetObservationsFunc: func() ([]storage.Observation, error) {
+			return []storage.Observation{{ID: 1, EntityID: 1, Content: "prefers tabs"}}, nil
+		},
+		GetMemoryLinksFunc: func() ([]storage.MemoryLink, error) { return nil, nil },
+	}
+}
+
+func TestReadGraph(t *testing.T) {
+	service := &MemoryService{DB: graphMockDB()}
+
+	reply := &Graph{}
+	if err := service.ReadGraph(nil, &ReadGraphRequest{}, reply); err != nil {
+		t.Fatalf("ReadGraph failed: %v", err)
+	}
+	if len(reply.Entities) != 3 || len(reply.Relations) != 2 {
+		t.Fatalf("Expected 3 entities and 2 relations, got %+v", reply)
+	}
+	if reply.Entities[0].EntityType != "person" || len(reply.Entities[0].Observations) != 1 {
+		t.Errorf("Expected Alice with one observation, got %+v", reply.Entities[0])
+	}
+	if reply.Relations[0].From != "Alice" || reply.Relations[0].To != "billing" || reply.Relations[0].RelationType != "owns" {
+		t.Errorf("Unexpected relation %+v", reply.Relations[0])
+	}
+}
+
+func TestOpenNodes(t *testing.T) {
+	service := &MemoryService{DB: graphMockDB()}
+
+	reply := &Graph{}
+	if err := service.OpenNodes(nil, &OpenNodesRequest{Names: []string{"alice", "Billing", "nobody"}}, reply); err != nil {
+		t.Fatalf("OpenNodes failed: %v", err)
+	}
+	if len(reply.Entities) != 2 {
+		t.Fatalf("Expected 2 entities, got %+v", reply.Entities)
+	}
+	if len(reply.Relations) != 1 || reply.Relations[0].RelationType != "owns" {
+		t.Errorf("Expected only the relation between the opened nodes, got %+v", reply.Relations)
+	}
+}
+
+func TestReadGraphAsOf(t *testing.T) {
+	db := graphMockDB()
+	asOf := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	var gotAsOf time.Time
+	db.GetRelationshipsAsOfFunc = func(t time.Time) ([]storage.Relationship, error) {
+		gotAsOf = t
+		return []storage.Relationship{{ID: 3, SourceID: 1, TargetID: 3, Type: "mentors"}}, nil
+	}
+	db.GetObservationsAsOfFunc = func(t time.Time) ([]storage.Observation, error) { return nil, nil }
+	service := &MemoryService{DB: db}
+
+	reply := &Graph{}
+	if err := service.ReadGraph(nil, &ReadGraphRequest{AsOf: &asOf}, reply); err != nil {
+		t.Fatalf("ReadGraph failed: %v", err)
+	}
+	if !gotAsOf.Equal(asOf) {
+		t.Errorf("Expected relationships as of %v, got %v", asOf, gotAsOf)
+	}
+	if len(reply.Relations) != 1 || reply.Relations[0].RelationType != "mentors" {
+		t.Errorf("Expected the relations valid at that time, got %+v", reply.Relations)
+	}
+	if len(reply.Entities[0].Observations) != 0 {
+		t.Errorf("Expected no observations as of that time, got %+v", reply.Entities[0])
+	}
+}
+
+func TestAsOfParam(t *testing.T) {
+	params := []byte(`{"entity": "Alice", "asOf": "2021-01-01T00:00:00Z"}`)
+	var (
+		graph         ReadGraphRequest
+		observations  ListObservationsRequest
+		relationships ListRelationshipsRequest
+	)
+	for _, req := range []interface{}{&graph, &observations, &relationships} {
+		if err := json.Unmarshal(params, req); err != nil {
+			t.Fatalf("%T: failed to decode: %v", req, err)
+		}
+	}
+	want := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, asOf := range []*time.Time{graph.AsOf, observations.AsOf, relationships.AsOf} {
+		if asOf == nil || !asOf.Equal(want) {
+			t.Errorf("Expected asOf to be decoded, got %v", asOf)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The store size checkStoreQuota works from is measured again after
+// storeSizeRefreshWrites checks or once it is storeSizeRefreshInterval old,
+// so that not every write walks the index directories.
+const (
+	storeSizeRefreshWrites   = 100
+	storeSizeRefreshInterval = time.Minute
+)
+
+// Limits bounds the data clients can store. A zero field disables that
+// limit.
+type Limits struct {
+	// MaxContentBytes bounds the content of a memory, turn or observation.
+	MaxContentBytes int
+	// MaxEntities bounds the number of entities attached to one memory.
+	MaxEntities int
+	// MaxEntityNameLength bounds entity names and aliases, in bytes.
+	MaxEntityNameLength int
+	// MaxTags bounds the number of tags attached to one memory.
+	MaxTags int
+	// MaxTagLength bounds tags, in bytes.
+	MaxTagLength int
+	// MaxMemoriesPerNamespace bounds the number of memories in a namespace.
+	MaxMemoriesPerNamespace int64
+	// MaxStoreBytes bounds the size of the database and search index.
+	MaxStoreBytes int64
+}
+
+// checkContent rejects empty content and content over MaxContentBytes.
+func (s *MemoryService) checkContent(field, content string) error {
+	if strings.TrimSpace(content) == "" {
+		return invalidParam(field, "%s must not be empty", field)
+	}
+	return s.checkContentSize(field, content)
+}
+
+// checkContentSize rejects content over MaxContentBytes.
+func (s *MemoryService) checkContentSize(field, content string) error {
+	if max := s.Limits.MaxContentBytes; max > 0 && len(content) > max {
+		return limitExceeded(field, int64(max), int64(len(content)))
+	}
+	return nil
+}
+
+// checkContents applies checkContentSize to each element of a list.
+func (s *MemoryService) checkContents(field string, contents []string) error {
+	for i, content := range contents {
+		if err := s.checkContentSize(fmt.Sprintf("%s[%d]", field, i), content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkEntityName rejects entity names over MaxEntityNameLength.
+func (s *MemoryService) checkEntityName(field, name string) error {
+	if max := s.Limits.MaxEntityNameLength; max > 0 && len(name) > max {
+		return limitExceeded(field, int64(max), int64(len(name)))
+	}
+	return nil
+}
+
+// checkEntities rejects entity lists over MaxEntities and the names in
+// them that are too long.
+func (s *MemoryService) checkEntities(field string, names []string) error {
+	if max := s.Limits.MaxEntities; max > 0 && len(names) > max {
+		return limitExceeded(field, int64(max), int64(len(names)))
+	}
+	for i, name := range names {
+		if err := s.checkEntityName(fmt.Sprintf("%s[%d]", field, i), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTags rejects tag lists over MaxTags and the tags in them over
+// MaxTagLength.
+func (s *MemoryService) checkTags(field string, tags []string) error {
+	if max := s.Limits.MaxTags; max > 0 && len(tags) > max {
+		return limitExceeded(field, int64(max), int64(len(tags)))
+	}
+	for i, tag := range tags {
+		if max := s.Limits.MaxTagLength; max > 0 && len(tag) > max {
+			return limitExceeded(fmt.Sprintf("%s[%d]", field, i), int64(max), int64(len(tag)))
+		}
+	}
+	return nil
+}
+
+// checkNamespaceQuota rejects a new memory in a namespace that already
+// holds MaxMemoriesPerNamespace memories.
+func (s *MemoryService) checkNamespaceQuota(namespace string) error {
+	max := s.Limits.MaxMemoriesPerNamespace
+	if max <= 0 {
+		return nil
+	}
+	n, err := s.DB.CountMemories(namespace)
+	if err != nil {
+		return err
+	}
+	if n >= max {
+		return quotaExceeded("namespace", max, n)
+	}
+	return nil
+}
+
+// storeSize is the last measured size of the store.
+type storeSize struct {
+	mu         sync.Mutex
+	bytes      int64
+	measuredAt time.Time
+	checks     int
+}
+
+// checkStoreQuota rejects writes once the store has grown to MaxStoreBytes.
+// The size is measured again every storeSizeRefreshWrites checks and every
+// storeSizeRefreshInterval, so a store may overshoot the limit by the
+// writes in between.
+func (s *MemoryService) checkStoreQuota() error {
+	max := s.Limits.MaxStoreBytes
+	if max <= 0 {
+		return nil
+	}
+	size := &s.storeSize
+	size.mu.Lock()
+	defer size.mu.Unlock()
+	if size.measuredAt.IsZero() || size.checks >= storeSizeRefreshWrites || time.Since(size.measuredAt) >= storeSizeRefreshInterval {
+		bytes, err := s.DB.StoreSize()
+		if err != nil {
+			return err
+		}
+		size.bytes, size.measuredAt, size.checks = bytes, time.Now(), 0
+	}
+	size.checks++
+	if size.bytes >= max {
+		return quotaExceeded("store_bytes", max, size.bytes)
+	}
+	return nil
+}
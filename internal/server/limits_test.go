@@ -0,0 +1,196 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wassmi/nodimus-memory/internal/storage"
+)
+
+func TestAddMemoryLimits(t *testing.T) {
+	mockDB := &MockDB{
+		CreateMemoryFunc: func(in storage.MemoryInput) (int64, error) {
+			t.Fatal("CreateMemory called for a rejected request")
+			return 0, nil
+		},
+		CountMemoriesFunc: func(namespace string) (int64, error) { return 10, nil },
+		StoreSizeFunc:     func() (int64, error) { return 100, nil },
+	}
+	service := &MemoryService{
+		DB:  mockDB,
+		Log: log.New(os.Stderr, "", 0),
+		Limits: Limits{
+			MaxContentBytes:         8,
+			MaxEntities:             2,
+			MaxEntityNameLength:     4,
+			MaxTags:                 3,
+			MaxTagLength:            5,
+			MaxMemoriesPerNamespace: 10,
+			MaxStoreBytes:           1000,
+		},
+	}
+
+	tests := []struct {
+		name string
+		args AddMemoryRequest
+		code int
+		data ErrorData
+	}{
+		{"empty content", AddMemoryRequest{Content: "  "}, CodeInvalidParams, ErrorData{Field: "content"}},
+		{"content too large", AddMemoryRequest{Content: "123456789"}, CodeLimitExceeded, ErrorData{Field: "content", Limit: 8, Actual: 9}},
+		{"too many entities", AddMemoryRequest{Content: "ok", Entities: []string{"a", "b", "c"}}, CodeLimitExceeded, ErrorData{Field: "entities", Limit: 2, Actual: 3}},
+		{"entity name too long", AddMemoryRequest{Content: "ok", Entities: []string{"a", "abcde"}}, CodeLimitExceeded, ErrorData{Field: "entities[1]", Limit: 4, Actual: 5}},
+		{"too many tags", AddMemoryRequest{Content: "ok", Tags: []string{"a", "b", "c", "d"}}, CodeLimitExceeded, ErrorData{Field: "tags", Limit: 3, Actual: 4}},
+		{"tag too long", AddMemoryRequest{Content: "ok", Tags: []string{"ops", "runbooks"}}, CodeLimitExceeded, ErrorData{Field: "tags[1]", Limit: 5, Actual: 8}},
+		{"namespace full", AddMemoryRequest{Content: "ok", Namespace: "work"}, CodeQuotaExceeded, ErrorData{Field: "namespace", Limit: 10, Actual: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := service.AddMemory(nil, &tt.args, &AddMemoryResponse{})
+			var reqErr *RequestError
+			if !errors.As(err, &reqErr) {
+				t.Fatalf("expected a RequestError, got %v", err)
+			}
+			if reqErr.Code != tt.code || reqErr.Data != tt.data {
+				t.Errorf("got code %d data %+v, want code %d data %+v", reqErr.Code, reqErr.Data, tt.code, tt.data)
+			}
+		})
+	}
+
+	mockDB.CountMemoriesFunc = func(namespace string) (int64, error) { return 0, nil }
+	mockDB.StoreSizeFunc = func() (int64, error) { return 1000, nil }
+	err := service.AddMemory(nil, &AddMemoryRequest{Content: "ok"}, &AddMemoryResponse{})
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) || reqErr.Code != CodeQuotaExceeded || reqErr.Data.Field != "store_bytes" {
+		t.Errorf("expected a store quota error, got %v", err)
+	}
+}
+
+func TestStoreQuotaCache(t *testing.T) {
+	var measured int
+	size := int64(100)
+	service := &MemoryService{
+		DB: &MockDB{StoreSizeFunc: func() (int64, error) {
+			measured++
+			return size, nil
+		}},
+		Limits: Limits{MaxStoreBytes: 1000},
+	}
+	for i := 0; i < storeSizeRefreshWrites; i++ {
+		if err := service.checkStoreQuota(); err != nil {
+			t.Fatalf("unexpected quota error: %v", err)
+		}
+	}
+	if measured != 1 {
+		t.Errorf("expected the store to be measured once, got %d", measured)
+	}
+
+	// The next check measures again and sees the store has filled up.
+	size = 1000
+	var reqErr *RequestError
+	if err := service.checkStoreQuota(); !errors.As(err, &reqErr) || reqErr.Code != CodeQuotaExceeded {
+		t.Errorf("expected a store quota error, got %v", err)
+	}
+	if measured != 2 {
+		t.Errorf("expected the store to be measured again, got %d measurements", measured)
+	}
+
+	// A stale measurement is refreshed whatever the number of checks.
+	service.storeSize.measuredAt = time.Now().Add(-storeSizeRefreshInterval)
+	size = 100
+	if err := service.checkStoreQuota(); err != nil || measured != 3 {
+		t.Errorf("expected a fresh measurement under the quota, got %v after %d measurements", err, measured)
+	}
+}
+
+func TestCreateEntitiesLimits(t *testing.T) {
+	service := &MemoryService{
+		DB:     &MockDB{StoreSizeFunc: func() (int64, error) { return 0, nil }},
+		Log:    log.New(os.Stderr, "", 0),
+		Limits: Limits{MaxContentBytes: 4, MaxEntityNameLength: 4},
+	}
+	err := service.CreateEntities(nil, &CreateEntitiesRequest{Entities: []GraphEntity{
+		{Name: "ok", EntityType: "thing", Observations: []string{"fine", "too long"}},
+	}}, &CreateEntitiesResponse{})
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) || reqErr.Data.Field != "entities[0].observations[1]" {
+		t.Errorf("expected a limit error for the second observation, got %v", err)
+	}
+}
+
+func TestRPCError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{storage.ErrMemoryNotFound, CodeNotFound},
+		{fmt.Errorf("link: %w", storage.ErrEntityNotFound), CodeNotFound},
+		{storage.ErrAliasConflict, CodeConflict},
+		{storage.ErrInvalidCursor, CodeInvalidParams},
+		{fmt.Errorf("%w %q", storage.ErrInvalidFacet, "content"), CodeInvalidParams},
+		{storage.ErrNoEmbedder, CodeInvalidParams},
+		{storage.ErrInvalidFusion, CodeInvalidParams},
+		{storage.ErrInvalidMMR, CodeInvalidParams},
+		{storage.ErrInvalidScoring, CodeInvalidParams},
+		{storage.ErrInvalidGraph, CodeInvalidParams},
+		{storage.ErrUnknownLanguage, CodeInvalidParams},
+		{storage.ErrInvalidStandingQuery, CodeInvalidParams},
+		{storage.ErrInvalidContext, CodeInvalidParams},
+		{limitExceeded("content", 1, 2), CodeLimitExceeded},
+		{errors.New("disk on fire"), CodeServerError},
+	}
+	for _, tt := range tests {
+		if got := RPCError(tt.err); int(got.Code) != tt.code {
+			t.Errorf("RPCError(%v) code = %d, want %d", tt.err, got.Code, tt.code)
+		}
+	}
+
+	got := RPCError(fmt.Errorf("search: %w", &storage.QueryError{Pos: 4, Msg: "unterminated phrase"}))
+	if int(got.Code) != CodeInvalidParams || got.Data != (ErrorData{Field: "query", Column: 5}) {
+		t.Errorf("expected the query column in the error data, got %+v", got)
+	}
+	got = RPCError(&storage.QueryError{Pos: -1, Path: "query.must[0]", Msg: "the node is empty"})
+	if got.Data != (ErrorData{Field: "structured_query.must[0]"}) {
+		t.Errorf("expected the node path in the error data, got %+v", got)
+	}
+}
+
+func TestServerErrorResponse(t *testing.T) {
+	service := &MemoryService{
+		DB:     &MockDB{},
+		Log:    log.New(os.Stderr, "", 0),
+		Limits: Limits{MaxContentBytes: 4},
+	}
+	ts := httptest.NewServer(NewServer(0, "127.0.0.1", 1, service).Handler)
+	defer ts.Close()
+
+	req := `{"jsonrpc":"2.0","method":"memory.AddMemory","params":[{"content":"too long"}],"id":1}`
+	resp, err := http.Post(ts.URL+"/rpc", "application/json", strings.NewReader(req))
+	if err != nil {
+		t.Fatalf("RPC AddMemory request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var reply struct {
+		Error struct {
+			Code    int       `json:"code"`
+			Message string    `json:"message"`
+			Data    ErrorData `json:"data"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		t.Fatalf("Failed to decode error response: %v", err)
+	}
+	want := ErrorData{Field: "content", Limit: 4, Actual: 8}
+	if reply.Error.Code != CodeLimitExceeded || reply.Error.Data != want {
+		t.Errorf("got error %+v, want code %d data %+v", reply.Error, CodeLimitExceeded, want)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/wassmi/nodimus-memory/internal/storage"
+)
+
+// LinkMemoriesRequest is the request for the LinkMemories method. Type is
+// one of "supersedes", "contradicts", "elaborates" or "derived_from" and
+// reads as "source <type> target".
+type LinkMemoriesRequest struct {
+	SourceID int64  `json:"source_id"`
+	TargetID int64  `json:"target_id"`
+	Type     string `json:"type"`
+}
+
+// LinkMemoriesResponse is the response for the LinkMemories method. Created
+// is false when an identical link already existed.
+type LinkMemoriesResponse struct {
+	Link    storage.MemoryLink `json:"link"`
+	Created bool               `json:"created"`
+}
+
+// LinkMemories creates a typed link from one memory to another.
+func (s *MemoryService) LinkMemories(r *http.Request, args *LinkMemoriesRequest, reply *LinkMemoriesResponse) error {
+	link, created, err := s.DB.LinkMemories(args.SourceID, args.TargetID, args.Type)
+	if err != nil {
+		return err
+	}
+	reply.Link = link
+	reply.Created = created
+	if created {
+		s.regenerateGraph()
+	}
+	return nil
+}
+
+// UnlinkMemoriesRequest is the request for the UnlinkMemories method. An
+// empty Type removes links of every type between the memories.
+type UnlinkMemoriesRequest struct {
+	SourceID int64  `json:"source_id"`
+	TargetID int64  `json:"target_id"`
+	Type     string `json:"type,omitempty"`
+}
+
+// UnlinkMemoriesResponse is the response for the UnlinkMemories method.
+type UnlinkMemoriesResponse struct {
+	Deleted int64 `json:"deleted"`
+}
+
+// UnlinkMemories removes the links from one memory to another.
+func (s *MemoryService) UnlinkMemories(r *http.Request, args *UnlinkMemoriesRequest, reply *UnlinkMemoriesResponse) error {
+	n, err := s.DB.UnlinkMemories(args.SourceID, args.TargetID, args.Type)
+	if err != nil {
+		return err
+	}
+	reply.Deleted = n
+	if n > 0 {
+		s.regenerateGraph()
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"github.com/wassmi/nodimus-memory/internal/storage"
+)
+
+func TestLinkMemories(t *testing.T) {
+	var gotType string
+	mockDB := &MockDB{
+		LinkMemoriesFunc: func(sourceID, targetID int64, linkType string) (storage.MemoryLink, bool, error) {
+			gotType = linkType
+			return storage.MemoryLink{ID: 1, SourceID: sourceID, TargetID: targetID, Type: linkType}, true, nil
+		},
+		GetEntitiesFunc:      func() ([]storage.Entity, error) { return nil, nil },
+		GetRelationshipsFunc: func() ([]storage.Relationship, error) { return nil, nil },
+		GetObservationsFunc:  func() ([]storage.Observation, error) { return nil, nil },
+		GetMemoryLinksFunc:   func() ([]storage.MemoryLink, error) { return nil, nil },
+	}
+	service := &MemoryService{DB: mockDB, DataDir: t.TempDir(), Log: log.New(os.Stderr, "", 0)}
+
+	reply := &LinkMemoriesResponse{}
+	if err := service.LinkMemories(nil, &LinkMemoriesRequest{SourceID: 2, TargetID: 1, Type: storage.LinkSupersedes}, reply); err != nil {
+		t.Fatalf("LinkMemories failed: %v", err)
+	}
+	if gotType != storage.LinkSupersedes || !reply.Created || reply.Link.SourceID != 2 || reply.Link.TargetID != 1 {
+		t.Errorf("Unexpected reply %+v", reply)
+	}
+}
+
+func TestGetContextLinks(t *testing.T) {
+	mockDB := &MockDB{
+		GetMemoryFunc: func(id int64) (string, error) { return "the deploy window is Friday", nil },
+		GetLinkedMemoriesFunc: func(memoryID int64) ([]storage.LinkedMemory, error) {
+			return []storage.LinkedMemory{{
+				Link:      storage.MemoryLink{ID: 1, SourceID: 2, TargetID: memoryID, Type: storage.LinkSupersedes},
+				Direction: storage.DirectionIn,
+				MemoryID:  2,
+				Content:   "the deploy window moved to Thursday",
+			}}, nil
+		},
+		GetAccessStatsFunc: func(id int64) (storage.AccessStats, error) { return storage.AccessStats{}, nil },
+		RecordAccessFunc:   func(ids ...int64) {},
+	}
+	service := &MemoryService{DB: mockDB}
+
+	reply := &GetContextResponse{}
+	if err := service.GetContext(nil, &GetContextRequest{ID: 1}, reply); err != nil {
+		t.Fatalf("GetContext failed: %v", err)
+	}
+	if len(reply.Links) != 1 || reply.Links[0].MemoryID != 2 || reply.Links[0].Link.Type != storage.LinkSupersedes {
+		t.Errorf("Expected the superseding memory in the context, got %+v", reply.Links)
+	}
+}
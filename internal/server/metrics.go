@@ -31,12 +31,21 @@ var (
 			Help: "Cache hit ratio for embeddings.",
 		},
 	)
+
+	EmbedLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "nodimus_embed_latency_seconds",
+			Help: "Latency of embedding server requests in seconds.",
+		},
+		[]string{"provider"},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(SearchLatency)
 	prometheus.MustRegister(StorageBytes)
 	prometheus.MustRegister(EmbedCacheHitRatio)
+	prometheus.MustRegister(EmbedLatency)
 }
 
 // MetricsServer is the server for Prometheus metrics.
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// notificationBuffer is how many notifications a subscriber may fall
+	// behind by; further ones are dropped for it until it catches up.
+	notificationBuffer = 64
+	// webhookTimeout bounds a webhook delivery.
+	webhookTimeout = 10 * time.Second
+	// sseKeepAlive is the interval of the comments that keep an idle event
+	// stream open through proxies.
+	sseKeepAlive = 30 * time.Second
+)
+
+// Notification tells that a new memory matches a standing query.
+type Notification struct {
+	QueryID   int64    `json:"query_id"`
+	QueryName string   `json:"query_name,omitempty"`
+	MemoryID  int64    `json:"memory_id"`
+	Content   string   `json:"content"`
+	Entities  []string `json:"entities,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Source    string   `json:"source,omitempty"`
+	Namespace string   `json:"namespace,omitempty"`
+}
+
+// Notifier delivers the notifications of standing queries: to its
+// subscribers, such as the MCP stdio loop and the event streams it serves,
+// and to the webhook of the query when it has one.
+type Notifier struct {
+	log         *log.Logger
+	client      *http.Client
+	mu          sync.Mutex
+	subscribers map[chan Notification]struct{}
+}
+
+// NewNotifier creates a notifier that logs failed webhook deliveries to
+// log. Webhooks are checked against the allowed hosts when a standing
+// query is saved, so redirects are not followed: an allowed host could
+// otherwise send the notification anywhere.
+func NewNotifier(log *log.Logger) *Notifier {
+	return &Notifier{
+		log: log,
+		client: &http.Client{
+			Timeout: webhookTimeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		subscribers: map[chan Notification]struct{}{},
+	}
+}
+
+// Subscribe returns a channel receiving every notification published from
+// now on, and a function that ends the subscription and closes it.
+func (n *Notifier) Subscribe() (<-chan Notification, func()) {
+	ch := make(chan Notification, notificationBuffer)
+	n.mu.Lock()
+	n.subscribers[ch] = struct{}{}
+	n.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			n.mu.Lock()
+			delete(n.subscribers, ch)
+			n.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish sends a notification to the subscribers without waiting for
+// them, and posts it to webhook in the background when it is set.
+func (n *Notifier) Publish(note Notification, webhook string) {
+	n.mu.Lock()
+	for ch := range n.subscribers {
+		select {
+		case ch <- note:
+		default:
+			n.log.Printf("dropped notification of standing query %d for a slow subscriber\n", note.QueryID)
+		}
+	}
+	n.mu.Unlock()
+	if webhook != "" {
+		go n.post(webhook, note)
+	}
+}
+
+// post delivers a notification to a webhook as a JSON POST request.
+func (n *Notifier) post(url string, note Notification) {
+	body, err := json.Marshal(note)
+	if err != nil {
+		n.log.Printf("failed to encode notification: %v\n", err)
+		return
+	}
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		n.log.Printf("failed to notify webhook of standing query %d: %v\n", note.QueryID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		n.log.Printf("webhook of standing query %d answered %s\n", note.QueryID, resp.Status)
+	}
+}
+
+// ServeHTTP streams notifications as server-sent events named "match",
+// each carrying a Notification as JSON. The query_id parameter restricts
+// the stream to one standing query.
+func (n *Notifier) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var queryID int64
+	if s := r.URL.Query().Get("query_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			http.Error(w, "query_id must be a number", http.StatusBadRequest)
+			return
+		}
+		queryID = id
+	}
+	// The stream outlives the server's write timeout.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	notes, cancel := n.Subscribe()
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	rc.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case note := <-notes:
+			if queryID != 0 && note.QueryID != queryID {
+				continue
+			}
+			data, err := json.Marshal(note)
+			if err != nil {
+				n.log.Printf("failed to encode notification: %v\n", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: match\ndata: %s\n\n", data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
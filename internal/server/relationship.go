@@ -0,0 +1,231 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/wassmi/nodimus-memory/internal/storage"
+)
+
+// CreateRelationshipRequest is the request for the CreateRelationship method.
+// Entities that do not exist yet are created. ValidFrom defaults to now.
+// Functional closes the source's other current relationships of the same
+// type, so that e.g. a new works_at replaces the old one.
+type CreateRelationshipRequest struct {
+	Source         string          `json:"source"`
+	Target         string          `json:"target"`
+	Type           string          `json:"type"`
+	Weight         *float64        `json:"weight,omitempty"`
+	Confidence     *float64        `json:"confidence,omitempty"`
+	Properties     json.RawMessage `json:"properties,omitempty"`
+	SourceMemoryID int64           `json:"source_memory_id,omitempty"`
+	ValidFrom      *time.Time      `json:"valid_from,omitempty"`
+	Functional     bool            `json:"functional,omitempty"`
+}
+
+// CreateRelationshipResponse is the response for the CreateRelationship method.
+// Created is false when an identical relationship already existed.
+type CreateRelationshipResponse struct {
+	Relationship storage.Relationship `json:"relationship"`
+	Created      bool                 `json:"created"`
+}
+
+// CreateRelationship creates a relationship between two named entities.
+func (s *MemoryService) CreateRelationship(r *http.Request, args *CreateRelationshipRequest, reply *CreateRelationshipResponse) error {
+	if err := s.checkEntityName("source", args.Source); err != nil {
+		return err
+	}
+	if err := s.checkEntityName("target", args.Target); err != nil {
+		return err
+	}
+	in := storage.RelationshipInput{
+		Source:         args.Source,
+		Target:         args.Target,
+		Type:           args.Type,
+		Weight:         args.Weight,
+		Confidence:     args.Confidence,
+		Properties:     args.Properties,
+		SourceMemoryID: args.SourceMemoryID,
+		Functional:     args.Functional,
+	}
+	if args.ValidFrom != nil {
+		in.ValidFrom = *args.ValidFrom
+	}
+	rel, created, err := s.DB.CreateRelationship(in)
+	if err != nil {
+		return err
+	}
+	reply.Relationship = rel
+	reply.Created = created
+	if created {
+		s.regenerateGraph()
+	}
+	return nil
+}
+
+// DeleteRelationshipRequest is the request for the DeleteRelationship method.
+// An empty Type deletes relationships of every type between the entities.
+type DeleteRelationshipRequest struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Type   string `json:"type,omitempty"`
+}
+
+// DeleteRelationshipResponse is the response for the DeleteRelationship method.
+type DeleteRelationshipResponse struct {
+	Deleted int64 `json:"deleted"`
+}
+
+// DeleteRelationship deletes the relationships from one named entity to another.
+func (s *MemoryService) DeleteRelationship(r *http.Request, args *DeleteRelationshipRequest, reply *DeleteRelationshipResponse) error {
+	n, err := s.DB.DeleteRelationships(args.Source, args.Target, args.Type)
+	if err != nil {
+		return err
+	}
+	reply.Deleted = n
+	if n > 0 {
+		s.regenerateGraph()
+	}
+	return nil
+}
+
+// ExpireRelationshipRequest is the request for the ExpireRelationship method.
+// An empty Type expires relationships of every type between the entities,
+// and At defaults to now.
+type ExpireRelationshipRequest struct {
+	Source string     `json:"source"`
+	Target string     `json:"target"`
+	Type   string     `json:"type,omitempty"`
+	At     *time.Time `json:"at,omitempty"`
+}
+
+// ExpireRelationshipResponse is the response for the ExpireRelationship method.
+type ExpireRelationshipResponse struct {
+	Expired int64 `json:"expired"`
+}
+
+// ExpireRelationship records that the relationships from one named entity
+// to another stopped holding, keeping them for queries about the past.
+func (s *MemoryService) ExpireRelationship(r *http.Request, args *ExpireRelationshipRequest, reply *ExpireRelationshipResponse) error {
+	var at time.Time
+	if args.At != nil {
+		at = *args.At
+	}
+	n, err := s.DB.ExpireRelationships(args.Source, args.Target, args.Type, at)
+	if err != nil {
+		return err
+	}
+	reply.Expired = n
+	if n > 0 {
+		s.regenerateGraph()
+	}
+	return nil
+}
+
+// ListRelationshipsRequest is the request for the ListRelationships method.
+// Direction is "out", "in" or "both" relative to Entity. AsOf lists the
+// relationships that held at that time instead of now, and History lists
+// every version regardless of validity.
+type ListRelationshipsRequest struct {
+	Entity    string     `json:"entity,omitempty"`
+	Direction string     `json:"direction,omitempty"`
+	Type      string     `json:"type,omitempty"`
+	AsOf      *time.Time `json:"asOf,omitempty"`
+	History   bool       `json:"history,omitempty"`
+}
+
+// ListRelationshipsResponse is the response for the ListRelationships method.
+type ListRelationshipsResponse struct {
+	Relationships []storage.Relationship `json:"relationships"`
+}
+
+// ListRelationships lists relationships, optionally those of one entity.
+func (s *MemoryService) ListRelationships(r *http.Request, args *ListRelationshipsRequest, reply *ListRelationshipsResponse) error {
+	filter := storage.RelationshipFilter{
+		Entity:    args.Entity,
+		Direction: args.Direction,
+		Type:      args.Type,
+		History:   args.History,
+	}
+	if args.AsOf != nil {
+		filter.AsOf = *args.AsOf
+	}
+	relationships, err := s.DB.ListRelationships(filter)
+	if err != nil {
+		return err
+	}
+	reply.Relationships = relationships
+	return nil
+}
+
+// CreateRelationsRequest is the request for the CreateRelations method.
+type CreateRelationsRequest struct {
+	Relations []GraphRelation `json:"relations"`
+}
+
+// CreateRelationsResponse is the response for the CreateRelations method.
+type CreateRelationsResponse struct {
+	Relations []GraphRelation `json:"relations"`
+}
+
+// CreateRelations creates relations in the shape used by the reference MCP
+// memory server. Relations that already exist are skipped and not returned.
+func (s *MemoryService) CreateRelations(r *http.Request, args *CreateRelationsRequest, reply *CreateRelationsResponse) error {
+	for i, relation := range args.Relations {
+		if err := s.checkEntityName(fmt.Sprintf("relations[%d].from", i), relation.From); err != nil {
+			return err
+		}
+		if err := s.checkEntityName(fmt.Sprintf("relations[%d].to", i), relation.To); err != nil {
+			return err
+		}
+	}
+	reply.Relations = []GraphRelation{}
+	for _, relation := range args.Relations {
+		rel, created, err := s.DB.CreateRelationship(storage.RelationshipInput{
+			Source: relation.From,
+			Target: relation.To,
+			Type:   relation.RelationType,
+		})
+		if err != nil {
+			return err
+		}
+		if created {
+			reply.Relations = append(reply.Relations, GraphRelation{From: rel.Source, To: rel.Target, RelationType: rel.Type})
+		}
+	}
+	s.regenerateGraph()
+	return nil
+}
+
+// DeleteRelationsRequest is the request for the DeleteRelations method.
+type DeleteRelationsRequest struct {
+	Relations []GraphRelation `json:"relations"`
+}
+
+// DeleteRelationsResponse is the response for the DeleteRelations method.
+type DeleteRelationsResponse struct {
+	Deleted int64 `json:"deleted"`
+}
+
+// DeleteRelations deletes relations in the shape used by the reference MCP
+// memory server. Relations between unknown entities are ignored.
+func (s *MemoryService) DeleteRelations(r *http.Request, args *DeleteRelationsRequest, reply *DeleteRelationsResponse) error {
+	for _, relation := range args.Relations {
+		if relation.RelationType == "" {
+			continue
+		}
+		n, err := s.DB.DeleteRelationships(relation.From, relation.To, relation.RelationType)
+		if errors.Is(err, storage.ErrEntityNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		reply.Deleted += n
+	}
+	s.regenerateGraph()
+	return nil
+}
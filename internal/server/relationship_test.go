@@ -0,0 +1,64 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/wassmi/nodimus-memory/internal/storage"
+)
+
+func TestCreateRelations(t *testing.T) {
+	existing := map[string]bool{"Alice|billing|owns": true}
+	mockDB := &MockDB{
+		CreateRelationshipFunc: func(in storage.RelationshipInput) (storage.Relationship, bool, error) {
+			key := in.Source + "|" + in.Target + "|" + in.Type
+			rel := storage.Relationship{Source: in.Source, Target: in.Target, Type: in.Type}
+			if existing[key] {
+				return rel, false, nil
+			}
+			existing[key] = true
+			return rel, true, nil
+		},
+		GetEntitiesFunc:      func() ([]storage.Entity, error) { return nil, nil },
+		GetRelationshipsFunc: func() ([]storage.Relationship, error) { return nil, nil },
+		GetObservationsFunc:  func() ([]storage.Observation, error) { return nil, nil },
+		GetMemoryLinksFunc:   func() ([]storage.MemoryLink, error) { return nil, nil },
+	}
+	var logBuffer bytes.Buffer
+	service := &MemoryService{DB: mockDB, DataDir: t.TempDir(), Log: log.New(&logBuffer, "", 0)}
+
+	reply := &CreateRelationsResponse{}
+	err := service.CreateRelations(nil, &CreateRelationsRequest{Relations: []GraphRelation{
+		{From: "Alice", To: "billing", RelationType: "owns"},
+		{From: "Bob", To: "Alice", RelationType: "reports_to"},
+	}}, reply)
+	if err != nil {
+		t.Fatalf("CreateRelations failed: %v", err)
+	}
+	if len(reply.Relations) != 1 || reply.Relations[0].From != "Bob" {
+		t.Errorf("Expected only the new relation to be returned, got %+v", reply.Relations)
+	}
+}
+
+func TestListRelationships(t *testing.T) {
+	var got storage.RelationshipFilter
+	mockDB := &MockDB{
+		ListRelationshipsFunc: func(filter storage.RelationshipFilter) ([]storage.Relationship, error) {
+			got = filter
+			return []storage.Relationship{{ID: 1, Source: "Alice", Target: "billing", Type: "owns"}}, nil
+		},
+	}
+	service := &MemoryService{DB: mockDB}
+
+	reply := &ListRelationshipsResponse{}
+	if err := service.ListRelationships(nil, &ListRelationshipsRequest{Entity: "Alice", Direction: "out", Type: "owns"}, reply); err != nil {
+		t.Fatalf("ListRelationships failed: %v", err)
+	}
+	if got.Entity != "Alice" || got.Direction != "out" || got.Type != "owns" {
+		t.Errorf("Filter not passed through: %+v", got)
+	}
+	if len(reply.Relationships) != 1 {
+		t.Errorf("Expected 1 relationship, got %d", len(reply.Relationships))
+	}
+}
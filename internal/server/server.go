@@ -12,6 +12,8 @@ import (
 	"github.com/gorilla/rpc/v2"
 	"github.com/gorilla/rpc/v2/json2"
 	"github.com/wassmi/nodimus-memory/internal/kg"
+	"github.com/wassmi/nodimus-memory/internal/storage"
+	"github.com/wassmi/nodimus-memory/internal/tokens"
 )
 
 // Server is the JSON-RPC 2.0 server.
@@ -22,11 +24,14 @@ type Server struct {
 // NewServer creates a new JSON-RPC 2.0 server.
 func NewServer(port int, bind string, timeout int, service *MemoryService) *Server {
 	rpcServer := rpc.NewServer()
-	rpcServer.RegisterCodec(json2.NewCodec(), "application/json")
+	rpcServer.RegisterCodec(json2.NewCustomCodecWithErrorMapper(rpc.DefaultEncoderSelector, errorMapper), "application/json")
 	rpcServer.RegisterService(service, "memory")
 
 	router := mux.NewRouter()
 	router.Handle("/rpc", rpcServer)
+	if service.Notifier != nil {
+		router.Handle("/events", service.Notifier).Methods(http.MethodGet)
+	}
 
 	return &Server{
 		Server: &http.Server{
@@ -53,12 +58,36 @@ type MemoryService struct {
 	DB      DB
 	DataDir string
 	Log     *log.Logger
+	Limits  Limits
+	// Scoring holds the score functions searches use unless a request
+	// overrides them.
+	Scoring storage.ScoreFunctions
+	// Notifier, when set, announces new memories that match standing
+	// queries.
+	Notifier *Notifier
+	// Tokenizer counts tokens against the budget of a context unless a
+	// request names another estimator; nil counts characters.
+	Tokenizer tokens.Estimator
+	// WebhookHosts are the hosts standing queries may post to. The server
+	// makes these requests on behalf of any client, so webhooks are refused
+	// while it is empty.
+	WebhookHosts []string
+
+	storeSize storeSize
 }
 
 // AddMemoryRequest is the request for the AddMemory method.
 type AddMemoryRequest struct {
-	Content  string   `json:"content"`
-	Entities []string `json:"entities"`
+	Content    string   `json:"content"`
+	Entities   []string `json:"entities"`
+	SessionID  int64    `json:"session_id,omitempty"`
+	TurnID     int64    `json:"turn_id,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Source     string   `json:"source,omitempty"`
+	Namespace  string   `json:"namespace,omitempty"`
+	Importance *float64 `json:"importance,omitempty"`
+	Pinned     bool     `json:"pinned,omitempty"`
+	Language   string   `json:"language,omitempty"`
 }
 
 // AddMemoryResponse is the response for the AddMemory method.
@@ -68,58 +97,381 @@ type AddMemoryResponse struct {
 
 // AddMemory adds a new memory to the database.
 func (s *MemoryService) AddMemory(r *http.Request, args *AddMemoryRequest, reply *AddMemoryResponse) error {
-	id, err := s.DB.AddMemory(args.Content, args.Entities)
+	if err := s.checkContent("content", args.Content); err != nil {
+		return err
+	}
+	if err := s.checkEntities("entities", args.Entities); err != nil {
+		return err
+	}
+	if err := s.checkTags("tags", args.Tags); err != nil {
+		return err
+	}
+	if args.Importance != nil && (*args.Importance < 0 || *args.Importance > 1) {
+		return invalidParam("importance", "importance must be between 0 and 1, got %v", *args.Importance)
+	}
+	if err := storage.ValidLanguage(args.Language); err != nil {
+		return invalidParam("language", "%v", err)
+	}
+	if err := s.checkNamespaceQuota(args.Namespace); err != nil {
+		return err
+	}
+	if err := s.checkStoreQuota(); err != nil {
+		return err
+	}
+	id, err := s.DB.CreateMemory(storage.MemoryInput{
+		Content:    args.Content,
+		Entities:   args.Entities,
+		SessionID:  args.SessionID,
+		TurnID:     args.TurnID,
+		Tags:       args.Tags,
+		Source:     args.Source,
+		Namespace:  args.Namespace,
+		Importance: args.Importance,
+		Pinned:     args.Pinned,
+		Language:   args.Language,
+	})
 	if err != nil {
 		return err
 	}
 	reply.ID = id
+	s.notifyMatches(id)
+	s.regenerateGraph()
+	return nil
+}
+
+// PinMemoryRequest is the request for the PinMemory method.
+type PinMemoryRequest struct {
+	ID     int64 `json:"id"`
+	Pinned bool  `json:"pinned"`
+}
+
+// PinMemoryResponse is the response for the PinMemory method.
+type PinMemoryResponse struct{}
+
+// PinMemory pins a memory so that searches boost it, or unpins it.
+func (s *MemoryService) PinMemory(r *http.Request, args *PinMemoryRequest, reply *PinMemoryResponse) error {
+	return s.DB.SetPinned(args.ID, args.Pinned)
+}
 
-	// Regenerate the knowledge graph in the background.
+// regenerateGraph rewrites the knowledge graph file in the background.
+func (s *MemoryService) regenerateGraph() {
 	go func() {
 		if err := kg.Generate(s.DB, filepath.Join(s.DataDir, "knowledge-graph.jsonld")); err != nil {
 			s.Log.Printf("failed to regenerate knowledge graph: %v\n", err)
 		}
 	}()
-
-	return nil
 }
 
 // SearchMemoryRequest is the request for the SearchMemory method.
 type SearchMemoryRequest struct {
 	Query string `json:"query"`
+	// Mode is "match" (the default) for plain text, "query_string" for the
+	// query syntax, "semantic" to search by meaning, "hybrid" for both, or
+	// "graph" to add the memories of related entities.
+	Mode string `json:"mode,omitempty"`
+	// StructuredQuery is a JSON query tree used instead of Query.
+	StructuredQuery *storage.QueryNode `json:"structured_query,omitempty"`
+	// Fuzziness allows up to two typos per word.
+	Fuzziness int `json:"fuzziness,omitempty"`
+	// Prefix matches the last word as a prefix.
+	Prefix bool `json:"prefix,omitempty"`
+	// History includes memories superseded by another memory.
+	History bool `json:"history,omitempty"`
+	// UsageBoost, when positive, ranks frequently and recently used
+	// memories higher.
+	UsageBoost float64 `json:"usage_boost,omitempty"`
+	// Facets counts the matches by entity, tag, source, namespace, language
+	// or created date.
+	Facets []string `json:"facets,omitempty"`
+	// FacetSize bounds the terms per facet.
+	FacetSize int `json:"facet_size,omitempty"`
+	// FacetInterval is the created histogram's day, week, month or year.
+	FacetInterval string `json:"facet_interval,omitempty"`
+	// Fusion combines hybrid results: "rrf" (the default) or "weighted".
+	Fusion string `json:"fusion,omitempty"`
+	// LexicalWeight and SemanticWeight weigh the two sides of a hybrid
+	// search; both default to 1.
+	LexicalWeight  float64 `json:"lexical_weight,omitempty"`
+	SemanticWeight float64 `json:"semantic_weight,omitempty"`
+	// MMRLambda, between 0 and 1, re-ranks the results for diversity; lower
+	// values favor variety over relevance.
+	MMRLambda float64 `json:"mmr_lambda,omitempty"`
+	// GraphHops is how far a graph search spreads: 1 to 4, or 0 for 2.
+	GraphHops int `json:"graph_hops,omitempty"`
+	// GraphDecay is the share of activation passed on at every hop, 0.5
+	// when zero.
+	GraphDecay float64 `json:"graph_decay,omitempty"`
+	// Scoring overrides the configured score functions.
+	Scoring *ScoringOverrides `json:"scoring,omitempty"`
+	// Explain adds the factors and components of each score to its hit,
+	// and the compiled query and filters to the response.
+	Explain bool `json:"explain,omitempty"`
+	// Language, "en", "fr" or "de", searches only memories in that
+	// language; by default every language is searched.
+	Language string `json:"language,omitempty"`
+	// FuzzyRetry repeats a search that found nothing allowing typos.
+	FuzzyRetry bool `json:"fuzzy_retry,omitempty"`
+	// Compat returns only the contents of the results, as older clients
+	// expect.
+	Compat bool `json:"compat,omitempty"`
+}
+
+// ScoringOverrides replace the configured score functions for one search.
+// Fields left out keep their configured values.
+type ScoringOverrides struct {
+	HalfLifeDays     *float64 `json:"half_life_days,omitempty"`
+	DecayFrom        *string  `json:"decay_from,omitempty"`
+	RecencyWeight    *float64 `json:"recency_weight,omitempty"`
+	ImportanceWeight *float64 `json:"importance_weight,omitempty"`
+	PinnedBoost      *float64 `json:"pinned_boost,omitempty"`
+}
+
+// apply returns f with the overridden fields replaced.
+func (o *ScoringOverrides) apply(f storage.ScoreFunctions) storage.ScoreFunctions {
+	if o == nil {
+		return f
+	}
+	if o.HalfLifeDays != nil {
+		f.HalfLifeDays = *o.HalfLifeDays
+	}
+	if o.DecayFrom != nil {
+		f.DecayFrom = *o.DecayFrom
+	}
+	if o.RecencyWeight != nil {
+		f.RecencyWeight = *o.RecencyWeight
+	}
+	if o.ImportanceWeight != nil {
+		f.ImportanceWeight = *o.ImportanceWeight
+	}
+	if o.PinnedBoost != nil {
+		f.PinnedBoost = *o.PinnedBoost
+	}
+	return f
 }
 
-// SearchMemoryResponse is the response for the SearchMemory method.
+// SearchMemoryResponse is the response for the SearchMemory method. Results
+// holds the contents of Hits, in the same order. Total is the number of
+// matches, which may exceed the hits returned. When nothing matched,
+// Suggestions holds corrected queries, and Retried is set when FuzzyRetry
+// found something. Debug describes how an explained search ran.
 type SearchMemoryResponse struct {
-	Results []string `json:"results"`
+	Results     []string                 `json:"results"`
+	Hits        []storage.SearchHit      `json:"hits,omitempty"`
+	Total       uint64                   `json:"total,omitempty"`
+	Facets      map[string]storage.Facet `json:"facets,omitempty"`
+	Suggestions []string                 `json:"suggestions,omitempty"`
+	Retried     bool                     `json:"retried,omitempty"`
+	Debug       *storage.SearchDebug     `json:"debug,omitempty"`
 }
 
 // SearchMemory searches for memories in the database.
 func (s *MemoryService) SearchMemory(r *http.Request, args *SearchMemoryRequest, reply *SearchMemoryResponse) error {
-	results, err := s.DB.SearchMemories(args.Query)
+	if args.UsageBoost < 0 {
+		return invalidParam("usage_boost", "usage_boost must not be negative")
+	}
+	if args.Fuzziness < 0 || args.Fuzziness > 2 {
+		return invalidParam("fuzziness", "fuzziness must be between 0 and 2, got %d", args.Fuzziness)
+	}
+	switch args.Mode {
+	case "", storage.QueryMatch, storage.QueryString, storage.QuerySemantic, storage.QueryHybrid, storage.QueryGraph:
+	default:
+		return invalidParam("mode", "mode must be %q, %q, %q, %q or %q, got %q", storage.QueryMatch, storage.QueryString, storage.QuerySemantic, storage.QueryHybrid, storage.QueryGraph, args.Mode)
+	}
+	switch args.Fusion {
+	case "", storage.FusionRRF, storage.FusionWeighted:
+	default:
+		return invalidParam("fusion", "fusion must be %q or %q, got %q", storage.FusionRRF, storage.FusionWeighted, args.Fusion)
+	}
+	if args.LexicalWeight < 0 {
+		return invalidParam("lexical_weight", "lexical_weight must not be negative")
+	}
+	if args.SemanticWeight < 0 {
+		return invalidParam("semantic_weight", "semantic_weight must not be negative")
+	}
+	if args.MMRLambda < 0 || args.MMRLambda > 1 {
+		return invalidParam("mmr_lambda", "mmr_lambda must be between 0 and 1, got %v", args.MMRLambda)
+	}
+	if args.GraphHops < 0 || args.GraphHops > storage.MaxGraphHops {
+		return invalidParam("graph_hops", "graph_hops must be between 1 and %d, or 0 for the default of %d, got %d", storage.MaxGraphHops, storage.DefaultGraphHops, args.GraphHops)
+	}
+	if args.GraphDecay < 0 || args.GraphDecay > 1 {
+		return invalidParam("graph_decay", "graph_decay must be between 0 and 1, got %v", args.GraphDecay)
+	}
+	if args.FacetSize < 0 {
+		return invalidParam("facet_size", "facet_size must not be negative")
+	}
+	if err := storage.ValidLanguage(args.Language); err != nil {
+		return invalidParam("language", "%v", err)
+	}
+	result, err := s.DB.Search(storage.SearchOptions{
+		Query:          args.Query,
+		Mode:           args.Mode,
+		Structured:     args.StructuredQuery,
+		Fuzziness:      args.Fuzziness,
+		Prefix:         args.Prefix,
+		History:        args.History,
+		UsageBoost:     args.UsageBoost,
+		Facets:         args.Facets,
+		FacetSize:      args.FacetSize,
+		FacetInterval:  args.FacetInterval,
+		Fusion:         args.Fusion,
+		LexicalWeight:  args.LexicalWeight,
+		SemanticWeight: args.SemanticWeight,
+		MMRLambda:      args.MMRLambda,
+		GraphHops:      args.GraphHops,
+		GraphDecay:     args.GraphDecay,
+		Scoring:        args.Scoring.apply(s.Scoring),
+		Explain:        args.Explain,
+		Language:       args.Language,
+		FuzzyRetry:     args.FuzzyRetry,
+	})
 	if err != nil {
 		return err
 	}
-	reply.Results = results
+	reply.Results = []string{}
+	for _, hit := range result.Hits {
+		reply.Results = append(reply.Results, hit.Content)
+	}
+	if !args.Compat {
+		reply.Hits = result.Hits
+		reply.Total = result.Total
+		reply.Facets = result.Facets
+		reply.Suggestions = result.Suggestions
+		reply.Retried = result.Retried
+		reply.Debug = result.Debug
+	}
 	return nil
 }
 
 // GetContextRequest is the request for the GetContext method.
 type GetContextRequest struct {
 	ID int64 `json:"id"`
+	// Window is the number of turns to include on either side of the turn
+	// the memory was recorded in. Zero returns the memory alone.
+	Window int `json:"window,omitempty"`
 }
 
 // GetContextResponse is the response for the GetContext method.
 type GetContextResponse struct {
-	Context string `json:"context"`
+	Context string                 `json:"context"`
+	Session *storage.Session       `json:"session,omitempty"`
+	Turns   []storage.Turn         `json:"turns,omitempty"`
+	Links   []storage.LinkedMemory `json:"links,omitempty"`
+	Stats   storage.AccessStats    `json:"stats"`
 }
 
-// GetContext gets the context for a given memory.
+// GetContext gets the context for a given memory, including the memories
+// linked to or from it, and records the access.
 func (s *MemoryService) GetContext(r *http.Request, args *GetContextRequest, reply *GetContextResponse) error {
 	context, err := s.DB.GetMemory(args.ID)
 	if err != nil {
 		return err
 	}
 	reply.Context = context
+
+	stats, err := s.DB.GetAccessStats(args.ID)
+	if err != nil {
+		return err
+	}
+	reply.Stats = stats
+	s.DB.RecordAccess(args.ID)
+
+	links, err := s.DB.GetLinkedMemories(args.ID)
+	if err != nil {
+		return err
+	}
+	reply.Links = links
+
+	if args.Window > 0 {
+		session, turns, err := s.DB.GetTurnsAround(args.ID, args.Window)
+		if err != nil {
+			return err
+		}
+		reply.Session = session
+		reply.Turns = turns
+	}
+	return nil
+}
+
+// ListMemoriesRequest is the request for the ListMemories method. Since and
+// Until bound the creation time. Sort is "created" (the default), "updated"
+// or "importance", newest or most important first unless Ascending is set.
+// Cursor is the next_cursor of the previous page.
+type ListMemoriesRequest struct {
+	Entity    string     `json:"entity,omitempty"`
+	Since     *time.Time `json:"since,omitempty"`
+	Until     *time.Time `json:"until,omitempty"`
+	Tag       string     `json:"tag,omitempty"`
+	Source    string     `json:"source,omitempty"`
+	Namespace string     `json:"namespace,omitempty"`
+	Sort      string     `json:"sort,omitempty"`
+	Ascending bool       `json:"ascending,omitempty"`
+	Limit     int        `json:"limit,omitempty"`
+	Cursor    string     `json:"cursor,omitempty"`
+}
+
+// ListMemories lists stored memories one page at a time.
+func (s *MemoryService) ListMemories(r *http.Request, args *ListMemoriesRequest, reply *storage.MemoryPage) error {
+	switch args.Sort {
+	case "", storage.SortCreated, storage.SortUpdated, storage.SortImportance:
+	default:
+		return invalidParam("sort", "sort must be %q, %q or %q, got %q", storage.SortCreated, storage.SortUpdated, storage.SortImportance, args.Sort)
+	}
+	filter := storage.MemoryFilter{
+		Entity:    args.Entity,
+		Tag:       args.Tag,
+		Source:    args.Source,
+		Namespace: args.Namespace,
+	}
+	if args.Since != nil {
+		filter.Since = *args.Since
+	}
+	if args.Until != nil {
+		filter.Until = *args.Until
+	}
+	page, err := s.DB.ListMemories(filter, storage.ListOptions{
+		Sort:      args.Sort,
+		Ascending: args.Ascending,
+		Limit:     args.Limit,
+		Cursor:    args.Cursor,
+	})
+	if err != nil {
+		return err
+	}
+	*reply = page
+	return nil
+}
+
+// StaleMemoriesRequest is the request for the StaleMemories method. Memories
+// not used for Months months are reported, or since Before when it is set.
+// Limit caps the number of memories returned; zero returns them all.
+type StaleMemoriesRequest struct {
+	Months int        `json:"months,omitempty"`
+	Before *time.Time `json:"before,omitempty"`
+	Limit  int        `json:"limit,omitempty"`
+}
+
+// StaleMemoriesResponse is the response for the StaleMemories method.
+type StaleMemoriesResponse struct {
+	Memories []storage.Memory `json:"memories"`
+}
+
+// StaleMemories reports the memories that have not been searched for or
+// looked up in a while, least recently used first.
+func (s *MemoryService) StaleMemories(r *http.Request, args *StaleMemoriesRequest, reply *StaleMemoriesResponse) error {
+	before := time.Now().AddDate(0, -args.Months, 0)
+	if args.Before != nil {
+		before = *args.Before
+	} else if args.Months <= 0 {
+		return invalidParam("months", "either months or before is required")
+	}
+	memories, err := s.DB.StaleMemories(before, args.Limit)
+	if err != nil {
+		return err
+	}
+	reply.Memories = memories
+	if reply.Memories == nil {
+		reply.Memories = []storage.Memory{}
+	}
 	return nil
 }
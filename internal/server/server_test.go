@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"log"
@@ -19,18 +20,82 @@ import (
 
 // MockDB implements the DB interface for testing.
 type MockDB struct {
-	AddMemoryFunc        func(content string, entityNames []string) (int64, error)
-	SearchMemoriesFunc   func(query string) ([]string, error)
-	GetMemoryFunc        func(id int64) (string, error)
-	GetEntitiesFunc      func() ([]storage.Entity, error)
-	GetRelationshipsFunc func() ([]storage.Relationship, error)
+	CreateMemoryFunc        func(in storage.MemoryInput) (int64, error)
+	SearchFunc              func(opts storage.SearchOptions) (storage.SearchResult, error)
+	RecordAccessFunc        func(ids ...int64)
+	GetAccessStatsFunc      func(id int64) (storage.AccessStats, error)
+	StaleMemoriesFunc       func(before time.Time, limit int) ([]storage.Memory, error)
+	ListMemoriesFunc        func(filter storage.MemoryFilter, opts storage.ListOptions) (storage.MemoryPage, error)
+	CountMemoriesFunc       func(namespace string) (int64, error)
+	SetPinnedFunc           func(id int64, pinned bool) error
+	StoreSizeFunc           func() (int64, error)
+	GetMemoryFunc           func(id int64) (string, error)
+	GetEntitiesFunc         func() ([]storage.Entity, error)
+	GetRelationshipsFunc    func() ([]storage.Relationship, error)
+	StartSessionFunc        func(clientID string) (int64, error)
+	AppendTurnFunc          func(sessionID int64, role, content, clientID string) (storage.Turn, error)
+	CloseSessionFunc        func(id int64) error
+	GetTurnsFunc            func(sessionID int64) ([]storage.Turn, error)
+	GetTurnsAroundFunc      func(memoryID int64, window int) (*storage.Session, []storage.Turn, error)
+	GetEntityFunc           func(name string) (storage.Entity, error)
+	GetAliasesFunc          func(entityID int64) ([]string, error)
+	AddAliasFunc            func(entityName, alias string) error
+	MergeEntitiesFunc       func(sourceName, targetName string) (storage.Entity, error)
+	SuggestMergesFunc       func(threshold float64) ([]storage.MergeSuggestion, error)
+	CreateEntityFunc        func(name, entityType string) (storage.Entity, error)
+	DeleteEntityFunc        func(name string) error
+	AddObservationsFunc     func(entityName string, contents []string) ([]storage.Observation, error)
+	DeleteObservationsFunc  func(entityName string, contents []string) (int64, error)
+	ExpireObservationsFunc  func(entityName string, contents []string, at time.Time) (int64, error)
+	ListObservationsFunc    func(entityName string, asOf time.Time) ([]storage.Observation, error)
+	GetObservationsFunc     func() ([]storage.Observation, error)
+	GetObservationsAsOfFunc func(asOf time.Time) ([]storage.Observation, error)
+	SearchNodesFunc         func(query string) ([]storage.Entity, error)
+	CreateRelationshipFunc  func(in storage.RelationshipInput) (storage.Relationship, bool, error)
+	DeleteRelationshipsFunc func(source, target, relType string) (int64, error)
+	ExpireRelationshipsFunc func(source, target, relType string, at time.Time) (int64, error)
+	ListRelationshipsFunc   func(filter storage.RelationshipFilter) ([]storage.Relationship, error)
+
+	GetRelationshipsAsOfFunc func(asOf time.Time) ([]storage.Relationship, error)
+	LinkMemoriesFunc         func(sourceID, targetID int64, linkType string) (storage.MemoryLink, bool, error)
+	UnlinkMemoriesFunc       func(sourceID, targetID int64, linkType string) (int64, error)
+	GetMemoryLinksFunc       func() ([]storage.MemoryLink, error)
+	GetLinkedMemoriesFunc    func(memoryID int64) ([]storage.LinkedMemory, error)
+
+	CreateStandingQueryFunc  func(q storage.StandingQuery) (storage.StandingQuery, error)
+	ListStandingQueriesFunc  func() ([]storage.StandingQuery, error)
+	DeleteStandingQueryFunc  func(id int64) error
+	MatchStandingQueriesFunc func(memoryID int64) (storage.StandingMatch, error)
+
+	BuildContextFunc func(opts storage.ContextOptions) (storage.ContextBlock, error)
 }
 
-func (m *MockDB) AddMemory(content string, entityNames []string) (int64, error) {
-	return m.AddMemoryFunc(content, entityNames)
+func (m *MockDB) CreateMemory(in storage.MemoryInput) (int64, error) {
+	return m.CreateMemoryFunc(in)
 }
-func (m *MockDB) SearchMemories(query string) ([]string, error) {
-	return m.SearchMemoriesFunc(query)
+func (m *MockDB) Search(opts storage.SearchOptions) (storage.SearchResult, error) {
+	return m.SearchFunc(opts)
+}
+func (m *MockDB) RecordAccess(ids ...int64) {
+	m.RecordAccessFunc(ids...)
+}
+func (m *MockDB) GetAccessStats(id int64) (storage.AccessStats, error) {
+	return m.GetAccessStatsFunc(id)
+}
+func (m *MockDB) StaleMemories(before time.Time, limit int) ([]storage.Memory, error) {
+	return m.StaleMemoriesFunc(before, limit)
+}
+func (m *MockDB) ListMemories(filter storage.MemoryFilter, opts storage.ListOptions) (storage.MemoryPage, error) {
+	return m.ListMemoriesFunc(filter, opts)
+}
+func (m *MockDB) CountMemories(namespace string) (int64, error) {
+	return m.CountMemoriesFunc(namespace)
+}
+func (m *MockDB) SetPinned(id int64, pinned bool) error {
+	return m.SetPinnedFunc(id, pinned)
+}
+func (m *MockDB) StoreSize() (int64, error) {
+	return m.StoreSizeFunc()
 }
 func (m *MockDB) GetMemory(id int64) (string, error) {
 	return m.GetMemoryFunc(id)
@@ -41,17 +106,119 @@ func (m *MockDB) GetEntities() ([]storage.Entity, error) {
 func (m *MockDB) GetRelationships() ([]storage.Relationship, error) {
 	return m.GetRelationshipsFunc()
 }
+func (m *MockDB) StartSession(clientID string) (int64, error) {
+	return m.StartSessionFunc(clientID)
+}
+func (m *MockDB) AppendTurn(sessionID int64, role, content, clientID string) (storage.Turn, error) {
+	return m.AppendTurnFunc(sessionID, role, content, clientID)
+}
+func (m *MockDB) CloseSession(id int64) error {
+	return m.CloseSessionFunc(id)
+}
+func (m *MockDB) GetTurns(sessionID int64) ([]storage.Turn, error) {
+	return m.GetTurnsFunc(sessionID)
+}
+func (m *MockDB) GetTurnsAround(memoryID int64, window int) (*storage.Session, []storage.Turn, error) {
+	return m.GetTurnsAroundFunc(memoryID, window)
+}
+func (m *MockDB) GetEntity(name string) (storage.Entity, error) {
+	return m.GetEntityFunc(name)
+}
+func (m *MockDB) GetAliases(entityID int64) ([]string, error) {
+	return m.GetAliasesFunc(entityID)
+}
+func (m *MockDB) AddAlias(entityName, alias string) error {
+	return m.AddAliasFunc(entityName, alias)
+}
+func (m *MockDB) MergeEntities(sourceName, targetName string) (storage.Entity, error) {
+	return m.MergeEntitiesFunc(sourceName, targetName)
+}
+func (m *MockDB) SuggestMerges(threshold float64) ([]storage.MergeSuggestion, error) {
+	return m.SuggestMergesFunc(threshold)
+}
+func (m *MockDB) CreateEntity(name, entityType string) (storage.Entity, error) {
+	return m.CreateEntityFunc(name, entityType)
+}
+func (m *MockDB) DeleteEntity(name string) error {
+	return m.DeleteEntityFunc(name)
+}
+func (m *MockDB) AddObservations(entityName string, contents []string) ([]storage.Observation, error) {
+	return m.AddObservationsFunc(entityName, contents)
+}
+func (m *MockDB) DeleteObservations(entityName string, contents []string) (int64, error) {
+	return m.DeleteObservationsFunc(entityName, contents)
+}
+func (m *MockDB) ExpireObservations(entityName string, contents []string, at time.Time) (int64, error) {
+	return m.ExpireObservationsFunc(entityName, contents, at)
+}
+func (m *MockDB) ListObservations(entityName string, asOf time.Time) ([]storage.Observation, error) {
+	return m.ListObservationsFunc(entityName, asOf)
+}
+func (m *MockDB) GetObservations() ([]storage.Observation, error) {
+	return m.GetObservationsFunc()
+}
+func (m *MockDB) GetObservationsAsOf(asOf time.Time) ([]storage.Observation, error) {
+	return m.GetObservationsAsOfFunc(asOf)
+}
+func (m *MockDB) SearchNodes(query string) ([]storage.Entity, error) {
+	return m.SearchNodesFunc(query)
+}
+func (m *MockDB) CreateRelationship(in storage.RelationshipInput) (storage.Relationship, bool, error) {
+	return m.CreateRelationshipFunc(in)
+}
+func (m *MockDB) DeleteRelationships(source, target, relType string) (int64, error) {
+	return m.DeleteRelationshipsFunc(source, target, relType)
+}
+func (m *MockDB) ExpireRelationships(source, target, relType string, at time.Time) (int64, error) {
+	return m.ExpireRelationshipsFunc(source, target, relType, at)
+}
+func (m *MockDB) GetRelationshipsAsOf(asOf time.Time) ([]storage.Relationship, error) {
+	return m.GetRelationshipsAsOfFunc(asOf)
+}
+func (m *MockDB) LinkMemories(sourceID, targetID int64, linkType string) (storage.MemoryLink, bool, error) {
+	return m.LinkMemoriesFunc(sourceID, targetID, linkType)
+}
+func (m *MockDB) UnlinkMemories(sourceID, targetID int64, linkType string) (int64, error) {
+	return m.UnlinkMemoriesFunc(sourceID, targetID, linkType)
+}
+func (m *MockDB) GetMemoryLinks() ([]storage.MemoryLink, error) {
+	return m.GetMemoryLinksFunc()
+}
+func (m *MockDB) GetLinkedMemories(memoryID int64) ([]storage.LinkedMemory, error) {
+	return m.GetLinkedMemoriesFunc(memoryID)
+}
+func (m *MockDB) ListRelationships(filter storage.RelationshipFilter) ([]storage.Relationship, error) {
+	return m.ListRelationshipsFunc(filter)
+}
+func (m *MockDB) CreateStandingQuery(q storage.StandingQuery) (storage.StandingQuery, error) {
+	return m.CreateStandingQueryFunc(q)
+}
+func (m *MockDB) ListStandingQueries() ([]storage.StandingQuery, error) {
+	return m.ListStandingQueriesFunc()
+}
+func (m *MockDB) DeleteStandingQuery(id int64) error {
+	return m.DeleteStandingQueryFunc(id)
+}
+func (m *MockDB) MatchStandingQueries(memoryID int64) (storage.StandingMatch, error) {
+	return m.MatchStandingQueriesFunc(memoryID)
+}
+
+func (m *MockDB) BuildContext(opts storage.ContextOptions) (storage.ContextBlock, error) {
+	return m.BuildContextFunc(opts)
+}
 
 func TestAddMemory(t *testing.T) {
 	mockDB := &MockDB{
-		AddMemoryFunc: func(content string, entityNames []string) (int64, error) {
-			if content == "test content" && len(entityNames) == 1 && entityNames[0] == "test entity" {
+		CreateMemoryFunc: func(in storage.MemoryInput) (int64, error) {
+			if in.Content == "test content" && len(in.Entities) == 1 && in.Entities[0] == "test entity" {
 				return 1, nil
 			}
 			return 0, errors.New("invalid input")
 		},
 		GetEntitiesFunc:      func() ([]storage.Entity, error) { return nil, nil },
 		GetRelationshipsFunc: func() ([]storage.Relationship, error) { return nil, nil },
+		GetObservationsFunc:  func() ([]storage.Observation, error) { return nil, nil },
+		GetMemoryLinksFunc:   func() ([]storage.MemoryLink, error) { return nil, nil },
 	}
 
 	// Create a temporary directory for the knowledge graph
@@ -98,11 +265,14 @@ func TestAddMemory(t *testing.T) {
 
 func TestSearchMemory(t *testing.T) {
 	mockDB := &MockDB{
-		SearchMemoriesFunc: func(query string) ([]string, error) {
-			if query == "test query" {
-				return []string{"memory 1", "memory 2"}, nil
+		SearchFunc: func(opts storage.SearchOptions) (storage.SearchResult, error) {
+			if opts.Query == "test query" {
+				return storage.SearchResult{Hits: []storage.SearchHit{
+					{Memory: storage.Memory{ID: 1, Content: "memory 1"}, Score: 2, Entities: []string{"go"}},
+					{Memory: storage.Memory{ID: 2, Content: "memory 2"}, Score: 1},
+				}, Total: 2}, nil
 			}
-			return nil, errors.New("no results")
+			return storage.SearchResult{}, errors.New("no results")
 		},
 	}
 
@@ -123,6 +293,112 @@ func TestSearchMemory(t *testing.T) {
 	if len(reply.Results) != 2 || reply.Results[0] != "memory 1" || reply.Results[1] != "memory 2" {
 		t.Errorf("Expected [\"memory 1\", \"memory 2\"], got %v", reply.Results)
 	}
+	if len(reply.Hits) != 2 || reply.Hits[0].ID != 1 || reply.Hits[0].Score != 2 || reply.Hits[0].Entities[0] != "go" {
+		t.Errorf("Expected hits with IDs, scores and entities, got %+v", reply.Hits)
+	}
+
+	compat := &SearchMemoryResponse{}
+	if err := service.SearchMemory(nil, &SearchMemoryRequest{Query: "test query", Compat: true}, compat); err != nil {
+		t.Fatalf("SearchMemory failed: %v", err)
+	}
+	if len(compat.Results) != 2 || compat.Hits != nil || compat.Total != 0 {
+		t.Errorf("Expected only results in compat mode, got %+v", compat)
+	}
+}
+
+func TestSearchMemoryOptions(t *testing.T) {
+	var got storage.SearchOptions
+	service := &MemoryService{DB: &MockDB{
+		SearchFunc: func(opts storage.SearchOptions) (storage.SearchResult, error) {
+			got = opts
+			result := storage.SearchResult{Total: 4, Facets: map[string]storage.Facet{
+				storage.FacetTag: {Total: 4, Terms: []storage.FacetTerm{{Term: "incident", Count: 4}}},
+			}, Suggestions: []string{"auth"}, Retried: opts.FuzzyRetry}
+			if opts.Explain {
+				result.Debug = &storage.SearchDebug{Mode: storage.QueryMatch, Filters: []string{"observations excluded"}}
+			}
+			return result, nil
+		},
+	}, Scoring: storage.ScoreFunctions{HalfLifeDays: 90, RecencyWeight: 0.5, PinnedBoost: 2}}
+
+	req := &SearchMemoryRequest{Query: "+auth -legacy", Mode: storage.QueryString, Fuzziness: 1, Prefix: true}
+	if err := service.SearchMemory(nil, req, &SearchMemoryResponse{}); err != nil {
+		t.Fatalf("SearchMemory failed: %v", err)
+	}
+	if got.Query != req.Query || got.Mode != storage.QueryString || got.Fuzziness != 1 || !got.Prefix {
+		t.Errorf("expected the query options to be passed on, got %+v", got)
+	}
+
+	reply := &SearchMemoryResponse{}
+	req = &SearchMemoryRequest{Query: "auth", Facets: []string{storage.FacetTag, storage.FacetCreated}, FacetSize: 5, FacetInterval: storage.IntervalWeek, FuzzyRetry: true}
+	if err := service.SearchMemory(nil, req, reply); err != nil {
+		t.Fatalf("SearchMemory failed: %v", err)
+	}
+	if len(got.Facets) != 2 || got.FacetSize != 5 || got.FacetInterval != storage.IntervalWeek || !got.FuzzyRetry {
+		t.Errorf("expected the facet and retry options to be passed on, got %+v", got)
+	}
+	if reply.Total != 4 || reply.Facets[storage.FacetTag].Terms[0].Count != 4 || len(reply.Suggestions) != 1 || !reply.Retried {
+		t.Errorf("expected the total, facets and suggestions in the reply, got %+v", reply)
+	}
+
+	req = &SearchMemoryRequest{Query: "loadSettings", Mode: storage.QueryHybrid, Fusion: storage.FusionWeighted, LexicalWeight: 0.3, SemanticWeight: 0.7, MMRLambda: 0.5}
+	if err := service.SearchMemory(nil, req, &SearchMemoryResponse{}); err != nil {
+		t.Fatalf("SearchMemory failed: %v", err)
+	}
+	if got.Mode != storage.QueryHybrid || got.Fusion != storage.FusionWeighted || got.LexicalWeight != 0.3 || got.SemanticWeight != 0.7 || got.MMRLambda != 0.5 {
+		t.Errorf("expected the fusion options to be passed on, got %+v", got)
+	}
+
+	req = &SearchMemoryRequest{Query: "billing", Mode: storage.QueryGraph, GraphHops: 3, GraphDecay: 0.4, Language: storage.LanguageFrench}
+	if err := service.SearchMemory(nil, req, &SearchMemoryResponse{}); err != nil {
+		t.Fatalf("SearchMemory failed: %v", err)
+	}
+	if got.Mode != storage.QueryGraph || got.GraphHops != 3 || got.GraphDecay != 0.4 || got.Language != storage.LanguageFrench {
+		t.Errorf("expected the graph and language options to be passed on, got %+v", got)
+	}
+	if got.Scoring != service.Scoring || got.Explain {
+		t.Errorf("expected the configured score functions, got %+v", got.Scoring)
+	}
+	halfLife, accessed := 7.0, storage.DecayAccessed
+	req = &SearchMemoryRequest{Query: "auth", Scoring: &ScoringOverrides{HalfLifeDays: &halfLife, DecayFrom: &accessed}, Explain: true}
+	reply = &SearchMemoryResponse{}
+	if err := service.SearchMemory(nil, req, reply); err != nil {
+		t.Fatalf("SearchMemory failed: %v", err)
+	}
+	want := storage.ScoreFunctions{HalfLifeDays: 7, DecayFrom: storage.DecayAccessed, RecencyWeight: 0.5, PinnedBoost: 2}
+	if got.Scoring != want || !got.Explain {
+		t.Errorf("expected the overrides on top of the configured score functions, got %+v", got.Scoring)
+	}
+	if reply.Debug == nil || reply.Debug.Mode != storage.QueryMatch {
+		t.Errorf("expected the search description in the reply, got %+v", reply.Debug)
+	}
+
+	for _, req := range []*SearchMemoryRequest{{Mode: "regex"}, {Fuzziness: 3}, {FacetSize: -1}, {Fusion: "borda"}, {SemanticWeight: -1}, {MMRLambda: 2}, {GraphHops: 5}, {GraphDecay: -0.5}, {Language: "xx"}} {
+		var reqErr *RequestError
+		if err := service.SearchMemory(nil, req, &SearchMemoryResponse{}); !errors.As(err, &reqErr) || reqErr.Code != CodeInvalidParams {
+			t.Errorf("expected an invalid params error for %+v, got %v", req, err)
+		}
+	}
+}
+
+func TestPinMemory(t *testing.T) {
+	pins := map[int64]bool{}
+	service := &MemoryService{DB: &MockDB{
+		SetPinnedFunc: func(id int64, pinned bool) error {
+			if id != 1 {
+				return sql.ErrNoRows
+			}
+			pins[id] = pinned
+			return nil
+		},
+	}}
+	if err := service.PinMemory(nil, &PinMemoryRequest{ID: 1, Pinned: true}, &PinMemoryResponse{}); err != nil || !pins[1] {
+		t.Errorf("expected the memory to be pinned, got %v", err)
+	}
+	err := service.PinMemory(nil, &PinMemoryRequest{ID: 2, Pinned: true}, &PinMemoryResponse{})
+	if rpcErr := RPCError(err); int(rpcErr.Code) != CodeNotFound {
+		t.Errorf("expected a not found error, got %v", err)
+	}
 }
 
 func TestGetContext(t *testing.T) {
@@ -133,6 +409,9 @@ func TestGetContext(t *testing.T) {
 			}
 			return "", errors.New("not found")
 		},
+		GetLinkedMemoriesFunc: func(memoryID int64) ([]storage.LinkedMemory, error) { return nil, nil },
+		GetAccessStatsFunc:    func(id int64) (storage.AccessStats, error) { return storage.AccessStats{}, nil },
+		RecordAccessFunc:      func(ids ...int64) {},
 	}
 
 	service := &MemoryService{
@@ -158,11 +437,13 @@ func TestNewServer(t *testing.T) {
 	// Create a mock service
 	mockService := &MemoryService{
 		DB: &MockDB{ // Provide a mock DB that satisfies all methods
-			AddMemoryFunc:        func(content string, entityNames []string) (int64, error) { return 0, nil },
-			SearchMemoriesFunc:   func(query string) ([]string, error) { return nil, nil },
+			CreateMemoryFunc:     func(in storage.MemoryInput) (int64, error) { return 0, nil },
+			SearchFunc:           func(opts storage.SearchOptions) (storage.SearchResult, error) { return storage.SearchResult{}, nil },
 			GetMemoryFunc:        func(id int64) (string, error) { return "", nil },
 			GetEntitiesFunc:      func() ([]storage.Entity, error) { return nil, nil },
 			GetRelationshipsFunc: func() ([]storage.Relationship, error) { return nil, nil },
+			GetObservationsFunc:  func() ([]storage.Observation, error) { return nil, nil },
+			GetMemoryLinksFunc:   func() ([]storage.MemoryLink, error) { return nil, nil },
 		},
 		DataDir: "/tmp",
 		Log:     log.New(os.Stdout, "", 0),
@@ -195,17 +476,22 @@ func TestNewServer(t *testing.T) {
 
 func TestServerRPCMethods(t *testing.T) {
 	mockDB := &MockDB{
-		AddMemoryFunc: func(content string, entityNames []string) (int64, error) {
+		CreateMemoryFunc: func(in storage.MemoryInput) (int64, error) {
 			return 123, nil
 		},
-		SearchMemoriesFunc: func(query string) ([]string, error) {
-			return []string{"found memory"}, nil
+		SearchFunc: func(opts storage.SearchOptions) (storage.SearchResult, error) {
+			return storage.SearchResult{Hits: []storage.SearchHit{{Memory: storage.Memory{ID: 1, Content: "found memory"}}}, Total: 1}, nil
 		},
 		GetMemoryFunc: func(id int64) (string, error) {
 			return "retrieved context", nil
 		},
-		GetEntitiesFunc:      func() ([]storage.Entity, error) { return nil, nil },
-		GetRelationshipsFunc: func() ([]storage.Relationship, error) { return nil, nil },
+		GetLinkedMemoriesFunc: func(memoryID int64) ([]storage.LinkedMemory, error) { return nil, nil },
+		GetAccessStatsFunc:    func(id int64) (storage.AccessStats, error) { return storage.AccessStats{}, nil },
+		RecordAccessFunc:      func(ids ...int64) {},
+		GetEntitiesFunc:       func() ([]storage.Entity, error) { return nil, nil },
+		GetRelationshipsFunc:  func() ([]storage.Relationship, error) { return nil, nil },
+		GetObservationsFunc:   func() ([]storage.Observation, error) { return nil, nil },
+		GetMemoryLinksFunc:    func() ([]storage.MemoryLink, error) { return nil, nil },
 	}
 
 	// Create a temporary directory for the knowledge graph
@@ -242,7 +528,7 @@ func TestServerRPCMethods(t *testing.T) {
 		Result  struct {
 			ID int64 `json:"id"`
 		} `json:"result"`
-		ID      int `json:"id"`
+		ID int `json:"id"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&addReply); err != nil {
 		t.Fatalf("Failed to decode AddMemory response: %v", err)
@@ -264,7 +550,7 @@ func TestServerRPCMethods(t *testing.T) {
 		Result  struct {
 			Results []string `json:"results"`
 		} `json:"result"`
-		ID      int `json:"id"`
+		ID int `json:"id"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&searchReply); err != nil {
 		t.Fatalf("Failed to decode SearchMemory response: %v", err)
@@ -286,7 +572,7 @@ func TestServerRPCMethods(t *testing.T) {
 		Result  struct {
 			Context string `json:"context"`
 		} `json:"result"`
-		ID      int `json:"id"`
+		ID int `json:"id"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&getContextReply); err != nil {
 		t.Fatalf("Failed to decode GetContext response: %v", err)
@@ -295,3 +581,63 @@ func TestServerRPCMethods(t *testing.T) {
 		t.Errorf("Expected GetContext \"retrieved context\", got %s", getContextReply.Result.Context)
 	}
 }
+
+func TestStaleMemories(t *testing.T) {
+	var gotBefore time.Time
+	mockDB := &MockDB{
+		StaleMemoriesFunc: func(before time.Time, limit int) ([]storage.Memory, error) {
+			gotBefore = before
+			return []storage.Memory{{ID: 1, Content: "old"}}, nil
+		},
+	}
+	service := &MemoryService{DB: mockDB}
+
+	if err := service.StaleMemories(nil, &StaleMemoriesRequest{}, &StaleMemoriesResponse{}); err == nil {
+		t.Error("Expected an error without months or before")
+	}
+	reply := &StaleMemoriesResponse{}
+	if err := service.StaleMemories(nil, &StaleMemoriesRequest{Months: 6}, reply); err != nil {
+		t.Fatalf("StaleMemories failed: %v", err)
+	}
+	if want := time.Now().AddDate(0, -6, 0); gotBefore.After(want) || want.Sub(gotBefore) > time.Minute {
+		t.Errorf("Expected a cutoff six months ago, got %v", gotBefore)
+	}
+	if len(reply.Memories) != 1 {
+		t.Errorf("Expected one stale memory, got %+v", reply.Memories)
+	}
+}
+
+func TestListMemories(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var (
+		gotFilter storage.MemoryFilter
+		gotOpts   storage.ListOptions
+	)
+	mockDB := &MockDB{
+		ListMemoriesFunc: func(filter storage.MemoryFilter, opts storage.ListOptions) (storage.MemoryPage, error) {
+			gotFilter, gotOpts = filter, opts
+			return storage.MemoryPage{Memories: []storage.Memory{{ID: 7, Content: "m"}}, NextCursor: "next"}, nil
+		},
+	}
+	service := &MemoryService{DB: mockDB}
+
+	reply := &storage.MemoryPage{}
+	req := &ListMemoriesRequest{Entity: "billing", Since: &since, Tag: "ops", Sort: storage.SortImportance, Limit: 10, Cursor: "abc"}
+	if err := service.ListMemories(nil, req, reply); err != nil {
+		t.Fatalf("ListMemories failed: %v", err)
+	}
+	if gotFilter.Entity != "billing" || !gotFilter.Since.Equal(since) || !gotFilter.Until.IsZero() || gotFilter.Tag != "ops" {
+		t.Errorf("Unexpected filter %+v", gotFilter)
+	}
+	if gotOpts.Sort != storage.SortImportance || gotOpts.Limit != 10 || gotOpts.Cursor != "abc" {
+		t.Errorf("Unexpected options %+v", gotOpts)
+	}
+	if len(reply.Memories) != 1 || reply.NextCursor != "next" {
+		t.Errorf("Unexpected page %+v", reply)
+	}
+
+	var reqErr *RequestError
+	if err := service.ListMemories(nil, &ListMemoriesRequest{Sort: "size"}, reply); !errors.As(err, &reqErr) || reqErr.Code != CodeInvalidParams {
+		t.Errorf("Expected an invalid params error for an unknown sort, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/wassmi/nodimus-memory/internal/storage"
+)
+
+// StartSessionRequest is the request for the StartSession method.
+type StartSessionRequest struct {
+	ClientID string `json:"client_id"`
+}
+
+// StartSessionResponse is the response for the StartSession method.
+type StartSessionResponse struct {
+	SessionID int64 `json:"session_id"`
+}
+
+// StartSession opens a new conversation session.
+func (s *MemoryService) StartSession(r *http.Request, args *StartSessionRequest, reply *StartSessionResponse) error {
+	id, err := s.DB.StartSession(args.ClientID)
+	if err != nil {
+		return err
+	}
+	reply.SessionID = id
+	return nil
+}
+
+// AppendTurnRequest is the request for the AppendTurn method. When SessionID
+// is zero a new session is started for ClientID.
+type AppendTurnRequest struct {
+	SessionID int64  `json:"session_id,omitempty"`
+	ClientID  string `json:"client_id"`
+	Role      string `json:"role"`
+	Content   string `json:"content"`
+}
+
+// AppendTurnResponse is the response for the AppendTurn method.
+type AppendTurnResponse struct {
+	SessionID int64        `json:"session_id"`
+	Turn      storage.Turn `json:"turn"`
+}
+
+// AppendTurn adds a turn to a session.
+func (s *MemoryService) AppendTurn(r *http.Request, args *AppendTurnRequest, reply *AppendTurnResponse) error {
+	if err := s.checkContentSize("content", args.Content); err != nil {
+		return err
+	}
+	if err := s.checkStoreQuota(); err != nil {
+		return err
+	}
+	sessionID := args.SessionID
+	if sessionID == 0 {
+		id, err := s.DB.StartSession(args.ClientID)
+		if err != nil {
+			return err
+		}
+		sessionID = id
+	}
+
+	turn, err := s.DB.AppendTurn(sessionID, args.Role, args.Content, args.ClientID)
+	if err != nil {
+		return err
+	}
+	reply.SessionID = sessionID
+	reply.Turn = turn
+	return nil
+}
+
+// CloseSessionRequest is the request for the CloseSession method.
+type CloseSessionRequest struct {
+	SessionID int64 `json:"session_id"`
+}
+
+// CloseSessionResponse is the response for the CloseSession method.
+type CloseSessionResponse struct {
+	Closed bool `json:"closed"`
+}
+
+// CloseSession closes a session so that no further turns can be appended.
+func (s *MemoryService) CloseSession(r *http.Request, args *CloseSessionRequest, reply *CloseSessionResponse) error {
+	if err := s.DB.CloseSession(args.SessionID); err != nil {
+		return err
+	}
+	reply.Closed = true
+	return nil
+}
+
+// GetTurnsRequest is the request for the GetTurns method.
+type GetTurnsRequest struct {
+	SessionID int64 `json:"session_id"`
+}
+
+// GetTurnsResponse is the response for the GetTurns method.
+type GetTurnsResponse struct {
+	Turns []storage.Turn `json:"turns"`
+}
+
+// GetTurns returns every turn of a session in order.
+func (s *MemoryService) GetTurns(r *http.Request, args *GetTurnsRequest, reply *GetTurnsResponse) error {
+	turns, err := s.DB.GetTurns(args.SessionID)
+	if err != nil {
+		return err
+	}
+	reply.Turns = turns
+	return nil
+}
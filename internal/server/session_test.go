@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/wassmi/nodimus-memory/internal/storage"
+)
+
+func TestAppendTurnStartsSession(t *testing.T) {
+	var started string
+	mockDB := &MockDB{
+		StartSessionFunc: func(clientID string) (int64, error) {
+			started = clientID
+			return 7, nil
+		},
+		AppendTurnFunc: func(sessionID int64, role, content, clientID string) (storage.Turn, error) {
+			if sessionID != 7 {
+				return storage.Turn{}, errors.New("unexpected session")
+			}
+			return storage.Turn{ID: 1, SessionID: sessionID, Seq: 1, Role: role, Content: content, ClientID: clientID}, nil
+		},
+	}
+	service := &MemoryService{DB: mockDB}
+
+	reply := &AppendTurnResponse{}
+	err := service.AppendTurn(nil, &AppendTurnRequest{ClientID: "cli", Role: "user", Content: "hello"}, reply)
+	if err != nil {
+		t.Fatalf("AppendTurn failed: %v", err)
+	}
+	if started != "cli" {
+		t.Errorf("Expected a session to be started for client cli, got %q", started)
+	}
+	if reply.SessionID != 7 || reply.Turn.Seq != 1 || reply.Turn.Content != "hello" {
+		t.Errorf("Unexpected reply: %+v", reply)
+	}
+}
+
+func TestGetContextWindow(t *testing.T) {
+	mockDB := &MockDB{
+		GetMemoryFunc:         func(id int64) (string, error) { return "decided to use sqlite", nil },
+		GetLinkedMemoriesFunc: func(memoryID int64) ([]storage.LinkedMemory, error) { return nil, nil },
+		GetAccessStatsFunc:    func(id int64) (storage.AccessStats, error) { return storage.AccessStats{}, nil },
+		RecordAccessFunc:      func(ids ...int64) {},
+		GetTurnsAroundFunc: func(memoryID int64, window int) (*storage.Session, []storage.Turn, error) {
+			if memoryID != 3 || window != 1 {
+				return nil, nil, errors.New("unexpected arguments")
+			}
+			return &storage.Session{ID: 2}, []storage.Turn{
+				{Seq: 4, Role: "user", Content: "which database?"},
+				{Seq: 5, Role: "assistant", Content: "sqlite"},
+			}, nil
+		},
+	}
+	service := &MemoryService{DB: mockDB}
+
+	reply := &GetContextResponse{}
+	if err := service.GetContext(nil, &GetContextRequest{ID: 3, Window: 1}, reply); err != nil {
+		t.Fatalf("GetContext failed: %v", err)
+	}
+	if reply.Context != "decided to use sqlite" {
+		t.Errorf("Expected memory content, got %q", reply.Context)
+	}
+	if reply.Session == nil || reply.Session.ID != 2 {
+		t.Errorf("Expected session 2, got %+v", reply.Session)
+	}
+	if len(reply.Turns) != 2 {
+		t.Errorf("Expected 2 turns, got %d", len(reply.Turns))
+	}
+}
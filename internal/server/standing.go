@@ -0,0 +1,140 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/wassmi/nodimus-memory/internal/storage"
+)
+
+// CreateStandingQueryRequest is the request for the CreateStandingQuery
+// method. Query, Mode ("match" or "query_string"), StructuredQuery and
+// Language are those of SearchMemoryRequest. Every memory added afterwards
+// that matches the query is announced on the MCP notification channel and
+// the /events stream, and posted to Webhook when it is set. The server
+// posts to the webhook itself, so its host must be one of the configured
+// webhook hosts.
+type CreateStandingQueryRequest struct {
+	Name            string             `json:"name,omitempty"`
+	Query           string             `json:"query,omitempty"`
+	Mode            string             `json:"mode,omitempty"`
+	StructuredQuery *storage.QueryNode `json:"structured_query,omitempty"`
+	Language        string             `json:"language,omitempty"`
+	Webhook         string             `json:"webhook,omitempty"`
+}
+
+// CreateStandingQueryResponse is the response for the CreateStandingQuery
+// method.
+type CreateStandingQueryResponse struct {
+	StandingQuery storage.StandingQuery `json:"standing_query"`
+}
+
+// CreateStandingQuery registers a standing query.
+func (s *MemoryService) CreateStandingQuery(r *http.Request, args *CreateStandingQueryRequest, reply *CreateStandingQueryResponse) error {
+	if webhook := strings.TrimSpace(args.Webhook); webhook != "" {
+		u, err := url.Parse(webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return invalidParam("webhook", "webhook must be an http or https URL, got %q", args.Webhook)
+		}
+		if !s.webhookAllowed(u) {
+			return invalidParam("webhook", "webhook host %q is not one of the configured webhook hosts", u.Hostname())
+		}
+	}
+	q, err := s.DB.CreateStandingQuery(storage.StandingQuery{
+		Name:       args.Name,
+		Query:      args.Query,
+		Mode:       args.Mode,
+		Structured: args.StructuredQuery,
+		Language:   args.Language,
+		Webhook:    args.Webhook,
+	})
+	if err != nil {
+		return err
+	}
+	reply.StandingQuery = q
+	return nil
+}
+
+// ListStandingQueriesRequest is the request for the ListStandingQueries
+// method.
+type ListStandingQueriesRequest struct{}
+
+// ListStandingQueriesResponse is the response for the ListStandingQueries
+// method.
+type ListStandingQueriesResponse struct {
+	StandingQueries []storage.StandingQuery `json:"standing_queries"`
+}
+
+// ListStandingQueries lists the standing queries.
+func (s *MemoryService) ListStandingQueries(r *http.Request, args *ListStandingQueriesRequest, reply *ListStandingQueriesResponse) error {
+	queries, err := s.DB.ListStandingQueries()
+	if err != nil {
+		return err
+	}
+	reply.StandingQueries = queries
+	if reply.StandingQueries == nil {
+		reply.StandingQueries = []storage.StandingQuery{}
+	}
+	return nil
+}
+
+// DeleteStandingQueryRequest is the request for the DeleteStandingQuery
+// method.
+type DeleteStandingQueryRequest struct {
+	ID int64 `json:"id"`
+}
+
+// DeleteStandingQueryResponse is the response for the DeleteStandingQuery
+// method.
+type DeleteStandingQueryResponse struct{}
+
+// DeleteStandingQuery removes a standing query.
+func (s *MemoryService) DeleteStandingQuery(r *http.Request, args *DeleteStandingQueryRequest, reply *DeleteStandingQueryResponse) error {
+	return s.DB.DeleteStandingQuery(args.ID)
+}
+
+// webhookAllowed reports whether the host of a webhook URL is one of the
+// configured webhook hosts.
+func (s *MemoryService) webhookAllowed(u *url.URL) bool {
+	for _, host := range s.WebhookHosts {
+		if strings.EqualFold(u.Hostname(), host) || strings.EqualFold(u.Host, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyMatches publishes a notification for every standing query a new
+// memory matches. Failures are logged rather than failing the request that
+// stored the memory, and a query that fails does not keep the others from
+// being announced. A webhook whose host is no longer allowed is not posted
+// to.
+func (s *MemoryService) notifyMatches(id int64) {
+	if s.Notifier == nil {
+		return
+	}
+	match, err := s.DB.MatchStandingQueries(id)
+	if err != nil {
+		s.Log.Printf("failed to match standing queries: %v\n", err)
+	}
+	for _, q := range match.Queries {
+		webhook := q.Webhook
+		if webhook != "" {
+			if u, err := url.Parse(webhook); err != nil || !s.webhookAllowed(u) {
+				s.Log.Printf("not posting to the webhook of standing query %d: host is not allowed\n", q.ID)
+				webhook = ""
+			}
+		}
+		s.Notifier.Publish(Notification{
+			QueryID:   q.ID,
+			QueryName: q.Name,
+			MemoryID:  id,
+			Content:   match.Memory.Content,
+			Entities:  match.Entities,
+			Tags:      match.Memory.Tags,
+			Source:    match.Memory.Source,
+			Namespace: match.Memory.Namespace,
+		}, webhook)
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wassmi/nodimus-memory/internal/storage"
+)
+
+func TestCreateStandingQuery(t *testing.T) {
+	var got storage.StandingQuery
+	service := &MemoryService{DB: &MockDB{
+		CreateStandingQueryFunc: func(q storage.StandingQuery) (storage.StandingQuery, error) {
+			got = q
+			q.ID = 1
+			return q, nil
+		},
+	}, WebhookHosts: []string{"Example.com"}}
+	req := &CreateStandingQueryRequest{Name: "payments", Query: "payments api", Language: storage.LanguageEnglish, Webhook: "https://example.com/hook"}
+	reply := &CreateStandingQueryResponse{}
+	if err := service.CreateStandingQuery(nil, req, reply); err != nil {
+		t.Fatalf("CreateStandingQuery failed: %v", err)
+	}
+	if got.Query != "payments api" || got.Webhook != req.Webhook || got.Language != storage.LanguageEnglish || reply.StandingQuery.ID != 1 {
+		t.Errorf("expected the query to be saved, got %+v, %+v", got, reply)
+	}
+
+	for _, webhook := range []string{"example.com/hook", "ftp://example.com", "http://", "http://169.254.169.254/latest", "https://example.com.evil.test/hook"} {
+		var reqErr *RequestError
+		err := service.CreateStandingQuery(nil, &CreateStandingQueryRequest{Query: "payments", Webhook: webhook}, reply)
+		if !errors.As(err, &reqErr) || reqErr.Code != CodeInvalidParams {
+			t.Errorf("%q: expected an invalid params error, got %v", webhook, err)
+		}
+	}
+}
+
+func TestStandingQueryNotifications(t *testing.T) {
+	hooked := make(chan Notification, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var note Notification
+		if err := json.NewDecoder(r.Body).Decode(&note); err != nil {
+			t.Errorf("failed to decode webhook body: %v", err)
+		}
+		hooked <- note
+	}))
+	defer hook.Close()
+
+	logger := log.New(os.Stderr, "", 0)
+	service := &MemoryService{DB: &MockDB{
+		CreateMemoryFunc: func(in storage.MemoryInput) (int64, error) { return 7, nil },
+		// A query that failed to match is reported alongside those that did.
+		MatchStandingQueriesFunc: func(memoryID int64) (storage.StandingMatch, error) {
+			return storage.StandingMatch{
+				Memory:   storage.Memory{ID: memoryID, Content: "we decided to version the payments API", Tags: []string{"decision"}},
+				Entities: []string{"Payments-API"},
+				Queries:  []storage.StandingQuery{{ID: 3, Name: "payments decisions", Webhook: hook.URL}},
+			}, errors.New("standing query 4: invalid query")
+		},
+		GetEntitiesFunc:      func() ([]storage.Entity, error) { return nil, nil },
+		GetRelationshipsFunc: func() ([]storage.Relationship, error) { return nil, nil },
+		GetObservationsFunc:  func() ([]storage.Observation, error) { return nil, nil },
+		GetMemoryLinksFunc:   func() ([]storage.MemoryLink, error) { return nil, nil },
+	}, DataDir: t.TempDir(), Log: logger, Notifier: NewNotifier(logger), WebhookHosts: []string{"127.0.0.1"}}
+	notes, unsubscribe := service.Notifier.Subscribe()
+	defer unsubscribe()
+
+	ts := httptest.NewServer(NewServer(0, "127.0.0.1", 1, service).Handler)
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/events?query_id=3")
+	if err != nil {
+		t.Fatalf("failed to open the event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", ct)
+	}
+	stream := bufio.NewReader(resp.Body)
+	if line, err := stream.ReadString('\n'); err != nil || line != ": connected\n" {
+		t.Fatalf("expected the stream to open, got %q, %v", line, err)
+	}
+
+	args := &AddMemoryRequest{Content: "we decided to version the payments API", Entities: []string{" payments-api"}, Tags: []string{"decision"}}
+	if err := service.AddMemory(nil, args, &AddMemoryResponse{}); err != nil {
+		t.Fatalf("AddMemory failed: %v", err)
+	}
+	check := func(channel string, note Notification) {
+		t.Helper()
+		if note.QueryID != 3 || note.QueryName != "payments decisions" || note.MemoryID != 7 || note.Content != args.Content ||
+			len(note.Entities) != 1 || note.Entities[0] != "Payments-API" || len(note.Tags) != 1 {
+			t.Errorf("%s: unexpected notification %+v", channel, note)
+		}
+	}
+
+	select {
+	case note := <-notes:
+		check("subscriber", note)
+	case <-time.After(time.Second):
+		t.Error("expected a notification for the subscriber")
+	}
+	select {
+	case note := <-hooked:
+		check("webhook", note)
+	case <-time.After(5 * time.Second):
+		t.Error("expected the webhook to be called")
+	}
+	var event []string
+	for len(event) < 2 {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read the event stream: %v", err)
+		}
+		if line = strings.TrimSuffix(line, "\n"); line != "" {
+			event = append(event, line)
+		}
+	}
+	if event[0] != "event: match" || !strings.HasPrefix(event[1], "data: ") {
+		t.Fatalf("unexpected event %q", event)
+	}
+	var note Notification
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(event[1], "data: ")), &note); err != nil {
+		t.Fatalf("failed to decode event: %v", err)
+	}
+	check("event stream", note)
+}
+
+func TestWebhookRedirect(t *testing.T) {
+	redirected := make(chan struct{}, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected <- struct{}{}
+	}))
+	defer target.Close()
+	// The allowed host redirects to one outside the allow-list.
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, strings.Replace(target.URL, "127.0.0.1", "localhost", 1), http.StatusTemporaryRedirect)
+	}))
+	defer hook.Close()
+
+	var logged strings.Builder
+	notifier := NewNotifier(log.New(&logged, "", 0))
+	notifier.post(hook.URL, Notification{QueryID: 3, MemoryID: 7, Content: "payments"})
+	select {
+	case <-redirected:
+		t.Fatal("expected the redirect not to be followed")
+	default:
+	}
+	if !strings.Contains(logged.String(), "307") {
+		t.Errorf("expected the redirect to be logged, got %q", logged.String())
+	}
+}
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// accessFlushInterval is how often recorded accesses are written out.
+	accessFlushInterval = 10 * time.Second
+	// accessFlushThreshold is the number of memories with unwritten
+	// accesses that triggers an early write.
+	accessFlushThreshold = 256
+	// usageHalfLife is the time after which a memory's last access counts
+	// half as much towards its usage boost.
+	usageHalfLife = 30 * 24 * time.Hour
+)
+
+// AccessStats reports how often and how recently a memory was returned to
+// a caller.
+type AccessStats struct {
+	AccessCount    int64      `json:"access_count"`
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+}
+
+type pendingAccess struct {
+	count int64
+	last  time.Time
+}
+
+// accessTracker collects memory accesses in memory and writes them in
+// batches, so that searching does not turn every read into a write. All
+// writes but the last happen on the background writer; flush asks it for
+// an early one.
+type accessTracker struct {
+	mu      sync.Mutex
+	pending map[int64]*pendingAccess
+	start   sync.Once
+	flush   chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newAccessTracker() *accessTracker {
+	return &accessTracker{
+		pending: make(map[int64]*pendingAccess),
+		flush:   make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// RecordAccess notes that the given memories were returned to a caller. The
+// accesses are written to the database in the background.
+func (db *DB) RecordAccess(ids ...int64) {
+	if len(ids) == 0 {
+		return
+	}
+	db.access.start.Do(func() { go db.flushAccessesPeriodically() })
+
+	now := time.Now().UTC().Truncate(time.Second)
+	db.access.mu.Lock()
+	for _, id := range ids {
+		p, ok := db.access.pending[id]
+		if !ok {
+			p = &pendingAccess{}
+			db.access.pending[id] = p
+		}
+		p.count++
+		p.last = now
+	}
+	full := len(db.access.pending) >= accessFlushThreshold
+	db.access.mu.Unlock()
+
+	if full {
+		select {
+		case db.access.flush <- struct{}{}:
+		default:
+			// An early write is already due.
+		}
+	}
+}
+
+func (db *DB) flushAccessesPeriodically() {
+	defer close(db.access.done)
+	ticker := time.NewTicker(accessFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			db.FlushAccesses()
+		case <-db.access.flush:
+			db.FlushAccesses()
+		case <-db.access.stop:
+			return
+		}
+	}
+}
+
+// stopAccessTracking stops the background writer, if it was started, and
+// writes out the remaining accesses.
+func (db *DB) stopAccessTracking() error {
+	started := true
+	db.access.start.Do(func() { started = false })
+	if started {
+		close(db.access.stop)
+		<-db.access.done
+	}
+	return db.FlushAccesses()
+}
+
+// FlushAccesses writes the accesses recorded so far to the database. If the
+// write fails the accesses are kept for the next attempt.
+func (db *DB) FlushAccesses() error {
+	db.access.mu.Lock()
+	batch := db.access.pending
+	db.access.pending = make(map[int64]*pendingAccess)
+	db.access.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := db.writeAccesses(batch)
+	if err != nil {
+		db.access.mu.Lock()
+		for id, p := range batch {
+			if q, ok := db.access.pending[id]; ok {
+				q.count += p.count
+				if p.last.After(q.last) {
+					q.last = p.last
+				}
+			} else {
+				db.access.pending[id] = p
+			}
+		}
+		db.access.mu.Unlock()
+	}
+	return err
+}
+
+func (db *DB) writeAccesses(batch map[int64]*pendingAccess) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("UPDATE memories SET access_count = access_count + ?, last_accessed_at = ? WHERE id = ?")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for id, p := range batch {
+		if _, err := stmt.Exec(p.count, formatTime(p.last), id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// withPending adds the accesses of a memory that have not been written yet
+// to its stored statistics.
+func (db *DB) withPending(id int64, stats AccessStats) AccessStats {
+	db.access.mu.Lock()
+	defer db.access.mu.Unlock()
+	if p, ok := db.access.pending[id]; ok {
+		stats.AccessCount += p.count
+		if stats.LastAccessedAt == nil || p.last.After(*stats.LastAccessedAt) {
+			last := p.last
+			stats.LastAccessedAt = &last
+		}
+	}
+	return stats
+}
+
+// GetAccessStats returns the access statistics of a memory.
+func (db *DB) GetAccessStats(id int64) (AccessStats, error) {
+	memory, err := db.GetMemoryRecord(id)
+	return memory.AccessStats, err
+}
+
+// StaleMemories lists the memories that have not been accessed, or created
+// if they were never accessed, since before, least recently used first. A
+// limit of zero or less returns them all.
+func (db *DB) StaleMemories(before time.Time, limit int) ([]Memory, error) {
+	if err := db.FlushAccesses(); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = -1
+	}
+	return db.queryMemories(memoryColumns+` WHERE COALESCE(m.last_accessed_at, m.created_at) < ?
+		ORDER BY COALESCE(m.last_accessed_at, m.created_at), m.id LIMIT ?`, formatTime(before), limit)
+}
+
+// usageScore rates how much a memory is used: it grows with the number of
+// accesses and decays with the time since the last one.
+func usageScore(stats AccessStats, now time.Time) float64 {
+	if stats.AccessCount == 0 || stats.LastAccessedAt == nil {
+		return 0
+	}
+	age := now.Sub(*stats.LastAccessedAt)
+	if age < 0 {
+		age = 0
+	}
+	return math.Log1p(float64(stats.AccessCount)) * math.Pow(0.5, float64(age)/float64(usageHalfLife))
+}
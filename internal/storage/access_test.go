@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessTracking(t *testing.T) {
+	db := newTestDB(t)
+
+	id, err := db.AddMemory("the staging database is postgres", nil)
+	if err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+	result, err := db.Search(SearchOptions{Query: "postgres"})
+	results := result.Hits
+	if err != nil || len(results) != 1 {
+		t.Fatalf("expected one result, got %+v (err %v)", results, err)
+	}
+	if results[0].AccessCount != 0 || results[0].LastAccessedAt != nil {
+		t.Errorf("expected the first search to report no earlier accesses, got %+v", results[0].AccessStats)
+	}
+
+	// Unwritten accesses are already reflected in the statistics.
+	stats, err := db.GetAccessStats(id)
+	if err != nil {
+		t.Fatalf("failed to get access stats: %v", err)
+	}
+	if stats.AccessCount != 1 || stats.LastAccessedAt == nil {
+		t.Errorf("expected one pending access, got %+v", stats)
+	}
+
+	db.RecordAccess(id)
+	if err := db.FlushAccesses(); err != nil {
+		t.Fatalf("failed to flush accesses: %v", err)
+	}
+	var count int64
+	if err := db.QueryRow("SELECT access_count FROM memories WHERE id = ?", id).Scan(&count); err != nil {
+		t.Fatalf("failed to read access count: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("expected two accesses to be written, got %d", count)
+	}
+	if stats, _ := db.GetAccessStats(id); stats.AccessCount != 2 {
+		t.Errorf("expected flushed accesses not to be counted twice, got %+v", stats)
+	}
+
+	// Enough pending memories make the background writer flush early.
+	ids := make([]int64, accessFlushThreshold)
+	for i := range ids {
+		ids[i] = id + int64(i)
+	}
+	db.RecordAccess(ids...)
+	deadline := time.Now().Add(5 * time.Second)
+	for count != 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		if err := db.QueryRow("SELECT access_count FROM memories WHERE id = ?", id).Scan(&count); err != nil {
+			t.Fatalf("failed to read access count: %v", err)
+		}
+	}
+	if count != 3 {
+		t.Errorf("expected an early write of three accesses, got %d", count)
+	}
+}
+
+func TestUsageBoost(t *testing.T) {
+	db := newTestDB(t)
+
+	plain, err := db.AddMemory("deploys go out on Thursday deploys", nil)
+	if err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+	used, err := db.AddMemory("the release train deploys weekly", nil)
+	if err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		db.RecordAccess(used)
+	}
+
+	result, err := db.Search(SearchOptions{Query: "deploys"})
+	results := result.Hits
+	if err != nil || len(results) != 2 {
+		t.Fatalf("expected two results, got %+v (err %v)", results, err)
+	}
+	if results[0].ID != plain {
+		t.Fatalf("expected the better text match first without a boost, got %+v", results)
+	}
+	result, err = db.Search(SearchOptions{Query: "deploys", UsageBoost: 5})
+	results = result.Hits
+	if err != nil || len(results) != 2 {
+		t.Fatalf("expected two results, got %+v (err %v)", results, err)
+	}
+	if results[0].ID != used {
+		t.Errorf("expected the frequently used memory first with a boost, got %+v", results)
+	}
+}
+
+func TestStaleMemories(t *testing.T) {
+	db := newTestDB(t)
+
+	if _, err := db.Exec("INSERT INTO memories (content, created_at) VALUES ('old and unused', '2020-01-01 00:00:00'), ('old but used', '2020-01-01 00:00:00')"); err != nil {
+		t.Fatalf("failed to insert memories: %v", err)
+	}
+	if _, err := db.AddMemory("new", nil); err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+	db.RecordAccess(2)
+
+	stale, err := db.StaleMemories(time.Now().AddDate(0, -6, 0), 0)
+	if err != nil {
+		t.Fatalf("failed to list stale memories: %v", err)
+	}
+	if len(stale) != 1 || stale[0].Content != "old and unused" {
+		t.Errorf("expected only the unused old memory, got %+v", stale)
+	}
+}
@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// DefaultGraphHops is how far activation spreads from the seed
+	// entities of a QueryGraph search; MaxGraphHops bounds it.
+	DefaultGraphHops = 2
+	MaxGraphHops     = 4
+	// DefaultGraphDecay is the share of its activation an entity passes
+	// on with every hop.
+	DefaultGraphDecay = 0.5
+)
+
+const (
+	// graphSeedHits is the number of top lexical hits whose entities seed
+	// a graph search.
+	graphSeedHits = 5
+	// coMentionWeight weakens the spread between entities that are only
+	// mentioned in the same memory, compared to a relationship of weight
+	// 1.
+	coMentionWeight = 0.5
+	// minActivation is the activation below which an entity neither
+	// spreads further nor pulls in its memories.
+	minActivation = 0.05
+	// maxEntityWords is the length of the longest entity name looked for
+	// in a query.
+	maxEntityWords = 4
+)
+
+// ErrInvalidGraph is returned for graph search options out of range.
+var ErrInvalidGraph = errors.New("invalid graph expansion")
+
+// graphSearch runs a search in QueryGraph mode. The entities named in the
+// query and those of the top lexical hits are activated, and activation
+// spreads over the relationships between entities and their mentions in
+// the same memories, losing GraphDecay with every hop. Memories mentioning
+// strongly activated entities join the lexical hits, scored by the
+// activation of their strongest entity added to their relevance scaled to
+// [0, 1]. Facets count the lexical matches.
+func (db *DB) graphSearch(opts SearchOptions) (SearchResult, error) {
+	hops, decay, err := graphParams(opts)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	lexicalOpts := opts
+	lexicalOpts.Mode = QueryMatch
+	lexical, err := db.lexicalSearch(lexicalOpts, searchDepth(opts))
+	if err != nil {
+		return SearchResult{}, err
+	}
+	found, err := lexicalMatches(lexical)
+	if err != nil {
+		return SearchResult{}, err
+	}
+
+	seeds, err := db.seedEntities(opts.Query, found)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	activation, err := db.spreadActivation(seeds, hops, decay)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	expanded, err := db.activatedMemories(found, activation, opts)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	hits, err := db.buildHits(expanded, opts)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	return SearchResult{Hits: hits, Total: uint64(len(expanded)), Facets: convertFacets(lexical.Facets)}, nil
+}
+
+// graphParams validates the graph options of a search and returns its
+// hops and decay, applying the defaults.
+func graphParams(opts SearchOptions) (int, float64, error) {
+	hops, decay := opts.GraphHops, opts.GraphDecay
+	if hops < 0 || hops > MaxGraphHops {
+		return 0, 0, fmt.Errorf("%w: hops must be between 1 and %d, or 0 for the default of %d, got %d", ErrInvalidGraph, MaxGraphHops, DefaultGraphHops, hops)
+	}
+	if decay < 0 || decay > 1 {
+		return 0, 0, fmt.Errorf("%w: decay must be between 0 and 1, got %v", ErrInvalidGraph, decay)
+	}
+	if hops == 0 {
+		hops = DefaultGraphHops
+	}
+	if decay == 0 {
+		decay = DefaultGraphDecay
+	}
+	return hops, decay, nil
+}
+
+// seedEntities returns the initial activation of the entities named in the
+// query, which is 1, and of those mentioned by the top lexical hits, which
+// is the hit's relevance scaled to [0, 1].
+func (db *DB) seedEntities(query string, found []scoredMemory) (map[int64]float64, error) {
+	seeds := map[int64]float64{}
+	named, err := db.queryEntities(query)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range named {
+		seeds[id] = 1
+	}
+
+	top := found[:min(len(found), graphSeedHits)]
+	if len(top) == 0 {
+		return seeds, nil
+	}
+	relevance := normalizer(len(top), func(i int) float64 { return top[i].score })
+	byMemory := make(map[int64]float64, len(top))
+	ids := make([]int64, len(top))
+	for i, f := range top {
+		byMemory[f.id] = relevance(f.score)
+		ids[i] = f.id
+	}
+	placeholders, args := inList(ids)
+	rows, err := db.Query("SELECT memory_id, entity_id FROM memory_entities WHERE memory_id IN ("+placeholders+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var memoryID, entityID int64
+		if err := rows.Scan(&memoryID, &entityID); err != nil {
+			return nil, err
+		}
+		seeds[entityID] = max(seeds[entityID], byMemory[memoryID])
+	}
+	return seeds, rows.Err()
+}
+
+// queryEntities returns the entities whose name or alias appears in the
+// query as a run of up to maxEntityWords words.
+func (db *DB) queryEntities(query string) ([]int64, error) {
+	words := strings.Fields(query)
+	for i, word := range words {
+		words[i] = strings.TrimFunc(word, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	}
+	seen := map[string]bool{}
+	var names []interface{}
+	for i := range words {
+		for n := 1; n <= maxEntityWords && i+n <= len(words); n++ {
+			name := NormalizeEntityName(strings.Join(words[i:i+n], " "))
+			if name != "" && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	return db.queryIDs(`SELECT id FROM entities WHERE norm_name IN (`+placeholders+`)
+		UNION SELECT entity_id FROM entity_aliases WHERE norm_alias IN (`+placeholders+`)`, append(names, names...)...)
+}
+
+// spreadActivation spreads the activation of the seed entities over the
+// graph for the given number of hops. An entity keeps the strongest
+// activation that reaches it; each hop multiplies it by decay and by the
+// strength of the edge: the weight times the confidence of a current
+// relationship, capped at 1, or coMentionWeight for entities mentioned in
+// the same memory.
+func (db *DB) spreadActivation(seeds map[int64]float64, hops int, decay float64) (map[int64]float64, error) {
+	activation := make(map[int64]float64, len(seeds))
+	frontier := map[int64]float64{}
+	for id, a := range seeds {
+		activation[id] = a
+		if a >= minActivation {
+			frontier[id] = a
+		}
+	}
+	cond, condArgs := validAt("r", time.Time{})
+	for hop := 0; hop < hops && len(frontier) > 0; hop++ {
+		ids := make([]int64, 0, len(frontier))
+		for id := range frontier {
+			ids = append(ids, id)
+		}
+		placeholders, args := inList(ids)
+		// Relationships spread activation both ways; entities mentioned
+		// together spread it at coMentionWeight.
+		query := `SELECT r.source_id, r.target_id, MIN(r.weight * r.confidence, 1) FROM relationships r
+				WHERE ` + cond + ` AND r.source_id IN (` + placeholders + `)
+			UNION ALL SELECT r.target_id, r.source_id, MIN(r.weight * r.confidence, 1) FROM relationships r
+				WHERE ` + cond + ` AND r.target_id IN (` + placeholders + `)
+			UNION ALL SELECT DISTINCT a.entity_id, b.entity_id, ? FROM memory_entities a
+				JOIN memory_entities b ON b.memory_id = a.memory_id AND b.entity_id != a.entity_id
+				WHERE a.entity_id IN (` + placeholders + `)`
+		var queryArgs []interface{}
+		queryArgs = append(queryArgs, condArgs...)
+		queryArgs = append(queryArgs, args...)
+		queryArgs = append(queryArgs, condArgs...)
+		queryArgs = append(queryArgs, args...)
+		queryArgs = append(queryArgs, coMentionWeight)
+		queryArgs = append(queryArgs, args...)
+		rows, err := db.Query(query, queryArgs...)
+		if err != nil {
+			return nil, err
+		}
+		next := map[int64]float64{}
+		for rows.Next() {
+			var (
+				from, to int64
+				strength float64
+			)
+			if err := rows.Scan(&from, &to, &strength); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			a := frontier[from] * decay * max(strength, 0)
+			if a >= minActivation && a > activation[to] {
+				activation[to] = a
+				next[to] = a
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		frontier = next
+	}
+	return activation, nil
+}
+
+// activatedMemories merges the lexical matches with the memories of the
+// activated entities, best first. The activated memories are filtered like
+// the matches: superseded memories are left out unless opts.History is set,
+// and memories in another language than opts.Language when it is.
+func (db *DB) activatedMemories(found []scoredMemory, activation map[int64]float64, opts SearchOptions) ([]scoredMemory, error) {
+	relevance := normalizer(len(found), func(i int) float64 { return found[i].score })
+	byID := make(map[int64]*scoredMemory, len(found))
+	merged := make([]*scoredMemory, 0, len(found))
+	for i, f := range found {
+		m := &scoredMemory{id: f.id, score: relevance(f.score), locations: f.locations, chunk: -1,
+			components: &ScoreComponents{Lexical: f.score, LexicalRank: i + 1}, explanation: f.explanation}
+		byID[f.id] = m
+		merged = append(merged, m)
+	}
+
+	var active []int64
+	for id, a := range activation {
+		if a >= minActivation {
+			active = append(active, id)
+		}
+	}
+	if len(active) > 0 {
+		skip := map[int64]bool{}
+		if !opts.History {
+			superseded, err := db.queryIDs("SELECT DISTINCT target_id FROM memory_links WHERE type = ?", LinkSupersedes)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range superseded {
+				skip[id] = true
+			}
+		}
+		placeholders, args := inList(active)
+		query := `SELECT me.memory_id, me.entity_id, e.name FROM memory_entities me
+			JOIN entities e ON e.id = me.entity_id JOIN memories m ON m.id = me.memory_id
+			WHERE me.entity_id IN (` + placeholders + `)`
+		if opts.Language != "" {
+			query += " AND m.language = ?"
+			args = append(args, opts.Language)
+		}
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var (
+				memoryID, entityID int64
+				name               string
+			)
+			if err := rows.Scan(&memoryID, &entityID, &name); err != nil {
+				return nil, err
+			}
+			if skip[memoryID] {
+				continue
+			}
+			m, ok := byID[memoryID]
+			if !ok {
+				m = &scoredMemory{id: memoryID, chunk: -1, components: &ScoreComponents{}}
+				byID[memoryID] = m
+				merged = append(merged, m)
+			}
+			if a := activation[entityID]; a > m.components.Graph {
+				m.components.Graph, m.components.Via = a, name
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make([]scoredMemory, len(merged))
+	for i, m := range merged {
+		m.score += m.components.Graph
+		result[i] = *m
+	}
+	sort.SliceStable(result, func(a, b int) bool { return result[a].score > result[b].score })
+	return result, nil
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGraphSearch(t *testing.T) {
+	db := newTestDB(t)
+	add := func(content string, entities ...string) int64 {
+		t.Helper()
+		id, err := db.AddMemory(content, entities)
+		if err != nil {
+			t.Fatalf("failed to add memory: %v", err)
+		}
+		return id
+	}
+	billing := add("the billing service retries failed charges", "billing", "postgres")
+	database := add("postgres runs on db-01", "postgres", "db-01")
+	host := add("db-01 was patched on friday", "db-01")
+	webhooks := add("webhooks are retried three times", "stripe")
+	cards := add("card tokens never touch our servers", "visa")
+	lunch := add("lunch is at noon", "cafeteria")
+	french, err := db.CreateMemory(MemoryInput{Content: "stripe relance les paiements refusés", Entities: []string{"stripe"}, Language: LanguageFrench})
+	if err != nil {
+		t.Fatalf("failed to create memory: %v", err)
+	}
+	for _, rel := range []RelationshipInput{
+		{Source: "billing", Target: "stripe", Type: "depends_on"},
+		{Source: "stripe", Target: "visa", Type: "processes"},
+	} {
+		if _, _, err := db.CreateRelationship(rel); err != nil {
+			t.Fatalf("failed to create relationship: %v", err)
+		}
+	}
+
+	search := func(hops int) map[int64]SearchHit {
+		t.Helper()
+		result, err := db.Search(SearchOptions{Query: "How does Billing work?", Mode: QueryGraph, GraphHops: hops})
+		if err != nil {
+			t.Fatalf("failed to search: %v", err)
+		}
+		hits := map[int64]SearchHit{}
+		for i, hit := range result.Hits {
+			if i > 0 && hit.Score > result.Hits[i-1].Score {
+				t.Errorf("expected hits ordered by score, got %+v", result.Hits)
+			}
+			hits[hit.ID] = hit
+		}
+		if result.Hits[0].ID != billing {
+			t.Errorf("expected the lexical match first, got %+v", result.Hits[0])
+		}
+		return hits
+	}
+
+	hits := search(1)
+	if c := hits[webhooks].Components; c == nil || c.Via != "stripe" || c.Graph != DefaultGraphDecay || c.LexicalRank != 0 {
+		t.Errorf("expected the stripe memory through the relationship, got %+v", hits[webhooks])
+	}
+	if c := hits[database].Components; c == nil || c.Via != "postgres" || c.Graph != 1 {
+		t.Errorf("expected the postgres memory through the matched memory's entity, got %+v", hits[database])
+	}
+	if _, ok := hits[host]; !ok {
+		t.Errorf("expected the db-01 memory through the co-mention of postgres, got %v", hits)
+	}
+	if _, ok := hits[cards]; ok {
+		t.Errorf("expected the visa memory two hops away to be left out, got %+v", hits[cards])
+	}
+	if _, ok := hits[lunch]; ok {
+		t.Error("expected the unrelated memory to be left out")
+	}
+
+	if _, ok := hits[french]; !ok {
+		t.Errorf("expected the french stripe memory without a language filter, got %v", hits)
+	}
+
+	hits = search(2)
+	if c := hits[cards].Components; c == nil || c.Via != "visa" || c.Graph != DefaultGraphDecay*DefaultGraphDecay {
+		t.Errorf("expected the visa memory at two hops, got %+v", hits[cards])
+	}
+
+	// Memories reached through the graph are filtered by language too.
+	result, err := db.Search(SearchOptions{Query: "How does Billing work?", Mode: QueryGraph, Language: LanguageEnglish})
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	for _, hit := range result.Hits {
+		if hit.ID == french {
+			t.Errorf("expected the french memory to be filtered out, got %+v", hit)
+		}
+	}
+	if len(result.Hits) < 2 {
+		t.Errorf("expected the english memories, got %+v", result.Hits)
+	}
+
+	for _, opts := range []SearchOptions{{Mode: QueryGraph, GraphHops: MaxGraphHops + 1}, {Mode: QueryGraph, GraphDecay: 1.5}} {
+		if _, err := db.Search(opts); !errors.Is(err, ErrInvalidGraph) {
+			t.Errorf("%+v: expected ErrInvalidGraph, got %v", opts, err)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wassmi/nodimus-memory/internal/tokens"
+)
+
+// ErrInvalidContext is returned for a context that cannot be assembled
+// from the options given.
+var ErrInvalidContext = errors.New("invalid context request")
+
+const (
+	// contextCandidates is the number of memories a context is assembled
+	// from.
+	contextCandidates = 30
+	// duplicateSimilarity is the similarity above which a memory repeats
+	// one ranked above it and is left out of a context.
+	duplicateSimilarity = 0.9
+	// entityShare and relationshipShare are the share of the priority of
+	// the memories mentioning an entity that its summary gets, and of the
+	// priority of its entities that a relationship gets.
+	entityShare       = 0.8
+	relationshipShare = 0.6
+)
+
+// Sections of a context block, in the order they are written.
+const (
+	sectionMemories = iota
+	sectionEntities
+	sectionRelationships
+)
+
+var sectionTitles = [...]string{"Memories", "Entities", "Relationships"}
+
+// ContextOptions controls the assembly of a context block. Query is the
+// question or task the context is for and Budget the number of tokens the
+// block may take up, as counted by Tokenizer, a character estimate when
+// nil. Mode is the search mode memories are found with: QueryHybrid when
+// an embedder is set and QueryMatch otherwise by default. Language,
+// History and Scoring are those of SearchOptions.
+type ContextOptions struct {
+	Query     string
+	Budget    int
+	Tokenizer tokens.Estimator
+	Mode      string
+	Language  string
+	History   bool
+	Scoring   ScoreFunctions
+}
+
+// ContextBlock is an assembled context. Text holds the memories, entity
+// summaries and relationships that fit the budget as markdown sections,
+// taking up Tokens; Memories, Entities and Relationships identify them.
+// Omitted counts the candidates left out for lack of room and Duplicates
+// those left out for repeating something ranked above them.
+type ContextBlock struct {
+	Text          string   `json:"text"`
+	Tokens        int      `json:"tokens"`
+	Budget        int      `json:"budget"`
+	Memories      []int64  `json:"memories"`
+	Entities      []string `json:"entities"`
+	Relationships []int64  `json:"relationships"`
+	Omitted       int      `json:"omitted"`
+	Duplicates    int      `json:"duplicates"`
+}
+
+// contextItem is a candidate line of a context block. Priority ranks it
+// against the candidates of every section.
+type contextItem struct {
+	section      int
+	priority     float64
+	line         string
+	memory       int64
+	entity       string
+	relationship int64
+}
+
+// BuildContext assembles the context for a query within a token budget.
+// The best memories are searched for, and summaries of the entities they
+// or the query mention and the relationships between those entities join
+// them, each ranked below the memories it derives from. Near-duplicate
+// memories and observations repeating a memory are dropped, and the
+// candidates are packed greedily by priority: one that does not fit is
+// skipped in favor of smaller ones ranked below it. Only the memories
+// included in the block are recorded as accessed.
+func (db *DB) BuildContext(opts ContextOptions) (ContextBlock, error) {
+	if strings.TrimSpace(opts.Query) == "" {
+		return ContextBlock{}, fmt.Errorf("%w: query is empty", ErrInvalidContext)
+	}
+	if opts.Budget <= 0 {
+		return ContextBlock{}, fmt.Errorf("%w: budget must be positive, got %d", ErrInvalidContext, opts.Budget)
+	}
+	tokenizer := opts.Tokenizer
+	if tokenizer == nil {
+		tokenizer, _ = tokens.New(tokens.Chars)
+	}
+	mode := opts.Mode
+	if mode == "" {
+		mode = QueryMatch
+		if db.embedder != nil {
+			mode = QueryHybrid
+		}
+	}
+
+	result, err := db.Search(SearchOptions{
+		Query:     opts.Query,
+		Mode:      mode,
+		History:   opts.History,
+		Scoring:   opts.Scoring,
+		Language:  opts.Language,
+		size:      contextCandidates,
+		untracked: true,
+	})
+	if err != nil {
+		return ContextBlock{}, err
+	}
+	block := ContextBlock{Budget: opts.Budget, Memories: []int64{}, Entities: []string{}, Relationships: []int64{}}
+	items, duplicates, err := db.contextItems(opts.Query, result.Hits)
+	if err != nil {
+		return ContextBlock{}, err
+	}
+	block.Duplicates = duplicates
+
+	sort.SliceStable(items, func(a, b int) bool { return items[a].priority > items[b].priority })
+	var (
+		sections [len(sectionTitles)][]string
+		used     int
+	)
+	for _, item := range items {
+		cost := tokenizer.Count(item.line + "\n")
+		if len(sections[item.section]) == 0 {
+			cost += tokenizer.Count(sectionHeader(item.section))
+		}
+		if used+cost > opts.Budget {
+			block.Omitted++
+			continue
+		}
+		used += cost
+		sections[item.section] = append(sections[item.section], item.line)
+		switch item.section {
+		case sectionMemories:
+			block.Memories = append(block.Memories, item.memory)
+		case sectionEntities:
+			block.Entities = append(block.Entities, item.entity)
+		case sectionRelationships:
+			block.Relationships = append(block.Relationships, item.relationship)
+		}
+	}
+
+	var b strings.Builder
+	for section, lines := range sections {
+		if len(lines) == 0 {
+			continue
+		}
+		if b.Len() == 0 {
+			b.WriteString(strings.TrimPrefix(sectionHeader(section), "\n"))
+		} else {
+			b.WriteString(sectionHeader(section))
+		}
+		for _, line := range lines {
+			b.WriteString(line + "\n")
+		}
+	}
+	block.Text = b.String()
+	block.Tokens = tokenizer.Count(block.Text)
+	db.RecordAccess(block.Memories...)
+	return block, nil
+}
+
+// sectionHeader is the heading of a section of a context block, with the
+// blank line that separates it from the section before.
+func sectionHeader(section int) string {
+	return "\n## " + sectionTitles[section] + "\n"
+}
+
+// contextItems turns the hits of a context search into candidate lines:
+// the memories, less the near-duplicates, which it counts, and the
+// summaries of their entities and those named in the query, and the
+// relationships between them.
+func (db *DB) contextItems(query string, hits []SearchHit) ([]contextItem, int, error) {
+	var (
+		items      []contextItem
+		duplicates int
+	)
+	similarity, err := db.hitSimilarity(hits)
+	if err != nil {
+		return nil, 0, err
+	}
+	// Entities inherit the priority of the best memory mentioning them.
+	entityPriority := map[int64]float64{}
+	var (
+		kept  []int
+		terms []map[string]float64
+	)
+	for i, hit := range hits {
+		duplicate := false
+		for _, k := range kept {
+			if similarity(i, k) >= duplicateSimilarity {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			duplicates++
+			continue
+		}
+		kept = append(kept, i)
+		priority := 1 / float64(i+1)
+		items = append(items, contextItem{section: sectionMemories, priority: priority, line: memoryLine(hit.Memory), memory: hit.ID})
+		terms = append(terms, termVector(hit.Content))
+
+		ids, err := db.queryIDs("SELECT entity_id FROM memory_entities WHERE memory_id = ?", hit.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, id := range ids {
+			entityPriority[id] = max(entityPriority[id], entityShare*priority)
+		}
+	}
+	named, err := db.queryEntities(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, id := range named {
+		entityPriority[id] = 1
+	}
+	if len(entityPriority) == 0 {
+		return items, duplicates, nil
+	}
+
+	ids := make([]int64, 0, len(entityPriority))
+	for id := range entityPriority {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	placeholders, args := inList(ids)
+	cond, condArgs := validAt("o", time.Time{})
+	observations, err := db.queryObservations(observationColumns+" WHERE o.entity_id IN ("+placeholders+") AND "+cond+" ORDER BY o.id",
+		append(args, condArgs...)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	// An observation is left out when it repeats a memory or an
+	// observation of its entity before it.
+	byEntity := map[int64][]string{}
+	observed := map[int64][]map[string]float64{}
+	for _, o := range observations {
+		vector := termVector(o.Content)
+		if repeats(vector, terms) || repeats(vector, observed[o.EntityID]) {
+			duplicates++
+			continue
+		}
+		observed[o.EntityID] = append(observed[o.EntityID], vector)
+		byEntity[o.EntityID] = append(byEntity[o.EntityID], strings.Join(strings.Fields(o.Content), " "))
+	}
+	for _, id := range ids {
+		if len(byEntity[id]) == 0 {
+			// An entity without observations says no more than the
+			// memories mentioning it.
+			continue
+		}
+		entity, err := db.getEntityByID(id)
+		if err != nil {
+			return nil, 0, err
+		}
+		line := "- " + entity.Name
+		if entity.Type != "" {
+			line += " (" + entity.Type + ")"
+		}
+		line += ": " + strings.Join(byEntity[id], "; ")
+		items = append(items, contextItem{section: sectionEntities, priority: entityPriority[id], line: line, entity: entity.Name})
+	}
+
+	cond, condArgs = validAt("r", time.Time{})
+	rows, err := db.Query(relationshipColumns+" WHERE (r.source_id IN ("+placeholders+") OR r.target_id IN ("+placeholders+")) AND "+cond+" ORDER BY r.id",
+		append(append(args, args...), condArgs...)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	facts := map[string]bool{}
+	for rows.Next() {
+		rel, err := scanRelationship(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		line := fmt.Sprintf("- %s %s %s", rel.Source, rel.Type, rel.Target)
+		if facts[line] {
+			duplicates++
+			continue
+		}
+		facts[line] = true
+		priority := relationshipShare * (entityPriority[rel.SourceID] + entityPriority[rel.TargetID]) / 2
+		items = append(items, contextItem{section: sectionRelationships, priority: priority, line: line, relationship: rel.ID})
+	}
+	return items, duplicates, rows.Err()
+}
+
+// memoryLine formats a memory as an item of a context block, its content
+// indented under a bullet naming its ID and date.
+func memoryLine(memory Memory) string {
+	content := strings.ReplaceAll(strings.TrimSpace(memory.Content), "\n", "\n  ")
+	return fmt.Sprintf("- [#%d, %s] %s", memory.ID, memory.CreatedAt.Format("2006-01-02"), content)
+}
+
+// repeats reports whether the text with the given term vector is a near
+// duplicate of any of others.
+func repeats(vector map[string]float64, others []map[string]float64) bool {
+	for _, other := range others {
+		if termCosine(vector, other) >= duplicateSimilarity {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/wassmi/nodimus-memory/internal/tokens"
+)
+
+func TestBuildContext(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.CreateEntity("payments-api", "service"); err != nil {
+		t.Fatalf("failed to create entity: %v", err)
+	}
+	if _, err := db.AddObservations("payments-api", []string{"owned by the billing team", "We decided to version the payments API"}); err != nil {
+		t.Fatalf("failed to add observations: %v", err)
+	}
+	if _, _, err := db.CreateRelationship(RelationshipInput{Source: "payments-api", Target: "postgres", Type: "depends_on"}); err != nil {
+		t.Fatalf("failed to create relationship: %v", err)
+	}
+	add := func(content string, entities ...string) int64 {
+		t.Helper()
+		id, err := db.AddMemory(content, entities)
+		if err != nil {
+			t.Fatalf("failed to add memory: %v", err)
+		}
+		return id
+	}
+	decision := add("We decided to version the payments API", "payments-api")
+	repeated := add("we decided to version the  payments API!", "payments-api")
+	outage := add("The payments API went down when postgres ran out of connections", "payments-api", "postgres")
+	add("grandma's apple pie needs more cinnamon")
+
+	block, err := db.BuildContext(ContextOptions{Query: "payments api", Budget: 1000})
+	if err != nil {
+		t.Fatalf("failed to build context: %v", err)
+	}
+	// The two versions of the decision rank alike; either may be kept.
+	if len(block.Memories) != 2 || !slices.Contains(block.Memories, outage) ||
+		slices.Contains(block.Memories, decision) == slices.Contains(block.Memories, repeated) {
+		t.Errorf("expected one version of the decision and the outage, got %v", block.Memories)
+	}
+	if !slices.Equal(block.Entities, []string{"payments-api"}) || len(block.Relationships) != 1 {
+		t.Errorf("expected the entity and its relationship, got %v and %v", block.Entities, block.Relationships)
+	}
+	// The repeated memory and the observation repeating a memory are left out.
+	if block.Duplicates != 2 || block.Omitted != 0 {
+		t.Errorf("expected 2 duplicates and nothing omitted, got %d and %d", block.Duplicates, block.Omitted)
+	}
+	for _, want := range []string{"## Memories\n- [#", "## Entities\n- payments-api (service): owned by the billing team\n", "## Relationships\n- payments-api depends_on postgres\n"} {
+		if !strings.Contains(block.Text, want) {
+			t.Errorf("expected %q in the context:\n%s", want, block.Text)
+		}
+	}
+	if strings.Contains(block.Text, "cinnamon") || !strings.HasPrefix(block.Text, "## Memories") {
+		t.Errorf("unexpected context:\n%s", block.Text)
+	}
+	chars, _ := tokens.New(tokens.Chars)
+	if block.Tokens != chars.Count(block.Text) || block.Tokens > block.Budget {
+		t.Errorf("expected %d tokens within budget, got %d", chars.Count(block.Text), block.Tokens)
+	}
+
+	// A tight budget keeps the best candidates that fit and skips the rest.
+	words := tokens.Func(func(s string) int { return len(strings.Fields(s)) })
+	before := map[int64]int64{}
+	for _, id := range []int64{decision, repeated, outage} {
+		if err := db.FlushAccesses(); err != nil {
+			t.Fatalf("failed to flush accesses: %v", err)
+		}
+		stats, err := db.GetAccessStats(id)
+		if err != nil {
+			t.Fatalf("failed to get access stats: %v", err)
+		}
+		before[id] = stats.AccessCount
+	}
+	block, err = db.BuildContext(ContextOptions{Query: "payments api", Budget: 12, Tokenizer: words})
+	if err != nil {
+		t.Fatalf("failed to build context: %v", err)
+	}
+	if block.Tokens > 12 || len(block.Memories) != 1 || block.Omitted == 0 {
+		t.Errorf("expected one memory within 12 tokens, got %+v", block)
+	}
+	// Only the memory in the block counts as accessed.
+	if err := db.FlushAccesses(); err != nil {
+		t.Fatalf("failed to flush accesses: %v", err)
+	}
+	for id, count := range before {
+		stats, err := db.GetAccessStats(id)
+		if err != nil {
+			t.Fatalf("failed to get access stats: %v", err)
+		}
+		want := count
+		if slices.Contains(block.Memories, id) {
+			want++
+		}
+		if stats.AccessCount != want {
+			t.Errorf("memory %d: expected %d accesses, got %d", id, want, stats.AccessCount)
+		}
+	}
+
+	block, err = db.BuildContext(ContextOptions{Query: "quantum chromodynamics", Budget: 100})
+	if err != nil || block.Text != "" || block.Tokens != 0 || len(block.Memories) != 0 {
+		t.Errorf("expected an empty context, got %+v, %v", block, err)
+	}
+	for _, opts := range []ContextOptions{{Budget: 100}, {Query: "payments", Budget: 0}} {
+		if _, err := db.BuildContext(opts); !errors.Is(err, ErrInvalidContext) {
+			t.Errorf("%+v: expected ErrInvalidContext, got %v", opts, err)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"unicode"
+
+	"github.com/wassmi/nodimus-memory/internal/embed"
+)
+
+const (
+	// chunkWords and chunkOverlap size the chunks a memory is embedded in;
+	// consecutive chunks share chunkOverlap words so a sentence on a
+	// boundary is whole in one of them.
+	chunkWords   = 128
+	chunkOverlap = 32
+	// embedBatchSize is the number of memories SyncEmbeddings embeds at once.
+	embedBatchSize = 100
+)
+
+// Embedding is the vector of one chunk of a memory. Start and End are the
+// byte offsets of the chunk in the memory's content.
+type Embedding struct {
+	MemoryID int64     `json:"memory_id"`
+	Chunk    int       `json:"chunk"`
+	Start    int       `json:"start"`
+	End      int       `json:"end"`
+	Model    string    `json:"model"`
+	Vector   []float32 `json:"vector"`
+}
+
+// textChunk is a span of a memory's content that is embedded on its own.
+type textChunk struct {
+	start, end int
+}
+
+// SetEmbedder sets the embedder new memories are embedded with. A nil
+// embedder turns embedding off. It must be set before OpenVectorIndex.
+func (db *DB) SetEmbedder(e embed.Embedder) {
+	db.embedder = e
+}
+
+// Embedder returns the embedder set with SetEmbedder.
+func (db *DB) Embedder() embed.Embedder {
+	return db.embedder
+}
+
+// chunkText splits content into overlapping chunks of up to chunkWords
+// words. Content without words is a single chunk.
+func chunkText(content string) []textChunk {
+	type span struct{ start, end int }
+	var words []span
+	start := -1
+	for i, r := range content {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				words = append(words, span{start, i})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		words = append(words, span{start, len(content)})
+	}
+	if len(words) <= chunkWords {
+		return []textChunk{{0, len(content)}}
+	}
+
+	var chunks []textChunk
+	for first := 0; ; first += chunkWords - chunkOverlap {
+		last := first + chunkWords
+		if last >= len(words) {
+			chunks = append(chunks, textChunk{words[first].start, words[len(words)-1].end})
+			return chunks
+		}
+		chunks = append(chunks, textChunk{words[first].start, words[last-1].end})
+	}
+}
+
+// embedContents chunks and embeds the contents of several memories with one
+// call to the embedder. It returns the chunks of each content and their
+// vectors, or nil when there is no embedder.
+func (db *DB) embedContents(ctx context.Context, contents []string) ([][]textChunk, [][][]float32, error) {
+	if db.embedder == nil {
+		return nil, nil, nil
+	}
+	chunks := make([][]textChunk, len(contents))
+	var texts []string
+	for i, content := range contents {
+		chunks[i] = chunkText(content)
+		for _, c := range chunks[i] {
+			texts = append(texts, content[c.start:c.end])
+		}
+	}
+	embedded, err := db.embedder.Embed(ctx, texts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to embed: %w", err)
+	}
+	if len(embedded) != len(texts) {
+		return nil, nil, fmt.Errorf("failed to embed: expected %d vectors, got %d", len(texts), len(embedded))
+	}
+	vectors := make([][][]float32, len(contents))
+	for i := range contents {
+		vectors[i], embedded = embedded[:len(chunks[i])], embedded[len(chunks[i]):]
+	}
+	return chunks, vectors, nil
+}
+
+// storeEmbeddings replaces the embeddings of a memory and returns the
+// number of chunks it had before.
+func storeEmbeddings(tx *sql.Tx, memoryID int64, model string, chunks []textChunk, vectors [][]float32) (int, error) {
+	result, err := tx.Exec("DELETE FROM memory_embeddings WHERE memory_id = ?", memoryID)
+	if err != nil {
+		return 0, err
+	}
+	previous, _ := result.RowsAffected()
+	for i, c := range chunks {
+		if _, err := tx.Exec("INSERT INTO memory_embeddings (memory_id, chunk, start_offset, end_offset, model, vector) VALUES (?, ?, ?, ?, ?, ?)",
+			memoryID, i, c.start, c.end, model, encodeVector(vectors[i])); err != nil {
+			return 0, err
+		}
+	}
+	return int(previous), nil
+}
+
+// GetEmbeddings returns the embeddings of a memory's chunks, in order.
+func (db *DB) GetEmbeddings(memoryID int64) ([]Embedding, error) {
+	rows, err := db.Query("SELECT memory_id, chunk, start_offset, end_offset, model, vector FROM memory_embeddings WHERE memory_id = ? ORDER BY chunk", memoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var embeddings []Embedding
+	for rows.Next() {
+		var (
+			e    Embedding
+			blob []byte
+		)
+		if err := rows.Scan(&e.MemoryID, &e.Chunk, &e.Start, &e.End, &e.Model, &blob); err != nil {
+			return nil, err
+		}
+		if e.Vector, err = decodeVector(blob); err != nil {
+			return nil, fmt.Errorf("memory %d chunk %d: %w", e.MemoryID, e.Chunk, err)
+		}
+		embeddings = append(embeddings, e)
+	}
+	return embeddings, rows.Err()
+}
+
+// SyncEmbeddings embeds the memories that have no embeddings from the
+// current embedder, such as memories stored before embedding was turned on
+// or with another model. It returns the number of memories embedded.
+func (db *DB) SyncEmbeddings(ctx context.Context) (int, error) {
+	if db.embedder == nil {
+		return 0, nil
+	}
+	model := db.embedder.Model()
+	total := 0
+	for {
+		memories, err := db.queryMemories(memoryColumns+`
+			WHERE NOT EXISTS (SELECT 1 FROM memory_embeddings e WHERE e.memory_id = m.id AND e.model = ?)
+			ORDER BY m.id LIMIT ?`, model, embedBatchSize)
+		if err != nil {
+			return total, err
+		}
+		if len(memories) == 0 {
+			return total, nil
+		}
+		contents := make([]string, len(memories))
+		for i, m := range memories {
+			contents[i] = m.Content
+		}
+		chunks, vectors, err := db.embedContents(ctx, contents)
+		if err != nil {
+			return total, err
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return total, err
+		}
+		previous := make([]int, len(memories))
+		for i, m := range memories {
+			if previous[i], err = storeEmbeddings(tx, m.ID, model, chunks[i], vectors[i]); err != nil {
+				tx.Rollback()
+				return total, err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return total, err
+		}
+		// A crash before the vector index is updated is repaired when it
+		// is next opened.
+		for i, m := range memories {
+			if err := db.indexVectors(m.ID, previous[i], vectors[i]); err != nil {
+				return total, err
+			}
+		}
+		total += len(memories)
+	}
+}
+
+// encodeVector stores a vector as little-endian float32s.
+func encodeVector(v []float32) []byte {
+	buf := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(x))
+	}
+	return buf
+}
+
+// decodeVector reverses encodeVector.
+func decodeVector(buf []byte) ([]float32, error) {
+	if len(buf)%4 != 0 {
+		return nil, fmt.Errorf("corrupt vector of %d bytes", len(buf))
+	}
+	v := make([]float32, len(buf)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return v, nil
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/wassmi/nodimus-memory/internal/embed"
+)
+
+func TestMemoryEmbeddings(t *testing.T) {
+	db := newTestDB(t)
+	before, err := db.CreateMemory(MemoryInput{Content: "stored before embedding was turned on"})
+	if err != nil {
+		t.Fatalf("failed to create memory: %v", err)
+	}
+	if embeddings, err := db.GetEmbeddings(before); err != nil || len(embeddings) != 0 {
+		t.Fatalf("expected no embeddings without an embedder, got %v, %v", embeddings, err)
+	}
+
+	db.SetEmbedder(embed.NewHashEmbedder(32))
+	long := strings.Repeat("word ", 300)
+	id, err := db.CreateMemory(MemoryInput{Content: long})
+	if err != nil {
+		t.Fatalf("failed to create memory: %v", err)
+	}
+	embeddings, err := db.GetEmbeddings(id)
+	if err != nil {
+		t.Fatalf("failed to get embeddings: %v", err)
+	}
+	// 300 words in chunks of 128 words, each starting 96 words after the last.
+	if len(embeddings) != 3 {
+		t.Fatalf("expected three chunks, got %d", len(embeddings))
+	}
+	last := embeddings[2]
+	if embeddings[0].Start != 0 || last.End != len(long)-1 || last.Model != "hash-v1-32" || len(last.Vector) != 32 {
+		t.Errorf("unexpected last chunk %+v", last)
+	}
+	if embeddings[1].Start >= embeddings[0].End {
+		t.Errorf("expected overlapping chunks, got %d-%d and %d-%d", embeddings[0].Start, embeddings[0].End, embeddings[1].Start, embeddings[1].End)
+	}
+
+	n, err := db.SyncEmbeddings(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("expected the older memory to be embedded, got %d, %v", n, err)
+	}
+	if embeddings, _ := db.GetEmbeddings(before); len(embeddings) != 1 || embeddings[0].End != len("stored before embedding was turned on") {
+		t.Errorf("expected one chunk for a short memory, got %+v", embeddings)
+	}
+
+	// A new model embeds everything again.
+	db.SetEmbedder(embed.NewHashEmbedder(16))
+	if n, err := db.SyncEmbeddings(context.Background()); err != nil || n != 2 {
+		t.Errorf("expected both memories to be embedded again, got %d, %v", n, err)
+	}
+	if embeddings, _ := db.GetEmbeddings(id); len(embeddings) != 3 || embeddings[0].Model != "hash-v1-16" {
+		t.Errorf("expected the new model's embeddings, got %+v", embeddings)
+	}
+}
@@ -0,0 +1,477 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var (
+	// ErrEntityNotFound is returned when no entity or alias matches a name.
+	ErrEntityNotFound = errors.New("entity not found")
+	// ErrAliasConflict is returned when an alias already names another entity.
+	ErrAliasConflict = errors.New("alias already refers to another entity")
+)
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// NormalizeEntityName returns the canonical form of an entity name used for
+// lookups: lower case, trimmed, with runs of whitespace collapsed to a single
+// space.
+func NormalizeEntityName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+// findEntity resolves a name to an entity ID, first by canonical name and
+// then through the alias table.
+func findEntity(q queryer, name string) (int64, error) {
+	norm := NormalizeEntityName(name)
+	var id int64
+	err := q.QueryRow("SELECT id FROM entities WHERE norm_name = ? ORDER BY id LIMIT 1", norm).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = q.QueryRow("SELECT entity_id FROM entity_aliases WHERE norm_alias = ?", norm).Scan(&id)
+	}
+	if err == sql.ErrNoRows {
+		return 0, ErrEntityNotFound
+	}
+	return id, err
+}
+
+// findOrCreateEntity resolves a name to an entity ID, creating an entity of
+// type "unknown" when nothing matches.
+func findOrCreateEntity(q queryer, name string) (int64, error) {
+	id, err := findEntity(q, name)
+	if err != ErrEntityNotFound {
+		return id, err
+	}
+	name = strings.Join(strings.Fields(name), " ")
+	result, err := q.Exec("INSERT INTO entities (name, type, norm_name) VALUES (?, ?, ?)", name, "unknown", NormalizeEntityName(name))
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// backfillEntityNames computes canonical names for entities created before
+// the norm_name column existed.
+func (db *DB) backfillEntityNames() error {
+	rows, err := db.Query("SELECT id, name FROM entities WHERE norm_name IS NULL")
+	if err != nil {
+		return err
+	}
+	names := make(map[int64]string)
+	for rows.Next() {
+		var (
+			id   int64
+			name string
+		)
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		names[id] = name
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, name := range names {
+		if _, err := db.Exec("UPDATE entities SET norm_name = ? WHERE id = ?", NormalizeEntityName(name), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetEntity resolves a name or alias to its entity.
+func (db *DB) GetEntity(name string) (Entity, error) {
+	id, err := findEntity(db, name)
+	if err != nil {
+		return Entity{}, err
+	}
+	return db.getEntityByID(id)
+}
+
+func (db *DB) getEntityByID(id int64) (Entity, error) {
+	var entity Entity
+	err := db.QueryRow("SELECT id, name, type FROM entities WHERE id = ?", id).Scan(&entity.ID, &entity.Name, &entity.Type)
+	if err == sql.ErrNoRows {
+		return Entity{}, ErrEntityNotFound
+	}
+	return entity, err
+}
+
+// GetAliases lists the aliases of an entity.
+func (db *DB) GetAliases(entityID int64) ([]string, error) {
+	rows, err := db.Query("SELECT alias FROM entity_aliases WHERE entity_id = ? ORDER BY alias", entityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var aliases []string
+	for rows.Next() {
+		var alias string
+		if err := rows.Scan(&alias); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, rows.Err()
+}
+
+// AddAlias records alias as another name for the named entity. Adding an
+// alias that already points at the entity is a no-op.
+func (db *DB) AddAlias(entityName, alias string) error {
+	if NormalizeEntityName(alias) == "" {
+		return errors.New("alias must not be empty")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := addAlias(tx, entityName, alias); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func addAlias(tx *sql.Tx, entityName, alias string) error {
+	entityID, err := findEntity(tx, entityName)
+	if err != nil {
+		return err
+	}
+	existing, err := findEntity(tx, alias)
+	switch {
+	case err == ErrEntityNotFound:
+	case err != nil:
+		return err
+	case existing == entityID:
+		return nil
+	default:
+		return ErrAliasConflict
+	}
+
+	_, err = tx.Exec("INSERT INTO entity_aliases (alias, norm_alias, entity_id) VALUES (?, ?, ?)",
+		strings.Join(strings.Fields(alias), " "), NormalizeEntityName(alias), entityID)
+	return err
+}
+
+// RemoveAlias deletes an alias.
+func (db *DB) RemoveAlias(alias string) error {
+	result, err := db.Exec("DELETE FROM entity_aliases WHERE norm_alias = ?", NormalizeEntityName(alias))
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrEntityNotFound
+	}
+	return nil
+}
+
+// MergeEntities folds the source entity into the target. Memories,
+// relationships and observations that referred to the source are re-pointed
+// at the target, the source's aliases move to the target, and the source's
+// name is kept as an alias of the target.
+func (db *DB) MergeEntities(sourceName, targetName string) (Entity, error) {
+	sourceObservations, err := db.entityObservationIDs(sourceName)
+	if err != nil {
+		return Entity{}, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return Entity{}, err
+	}
+	target, err := mergeEntities(tx, sourceName, targetName)
+	if err != nil {
+		tx.Rollback()
+		return Entity{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Entity{}, err
+	}
+
+	// Observations that duplicated one of the target's were dropped; the
+	// rest now belong to the target and are re-indexed under its name.
+	if err := db.unindexObservations(sourceObservations); err != nil {
+		return Entity{}, err
+	}
+	if err := db.reindexObservations(target); err != nil {
+		return Entity{}, err
+	}
+	// Memories that mentioned the source now list the target.
+	memoryIDs, err := db.entityMemoryIDs(target.ID)
+	if err != nil {
+		return Entity{}, err
+	}
+	if err := db.reindexMemories(memoryIDs); err != nil {
+		return Entity{}, err
+	}
+	return target, nil
+}
+
+func mergeEntities(tx *sql.Tx, sourceName, targetName string) (Entity, error) {
+	sourceID, err := findEntity(tx, sourceName)
+	if err != nil {
+		return Entity{}, fmt.Errorf("source %q: %w", sourceName, err)
+	}
+	targetID, err := findEntity(tx, targetName)
+	if err != nil {
+		return Entity{}, fmt.Errorf("target %q: %w", targetName, err)
+	}
+	if sourceID == targetID {
+		return Entity{}, fmt.Errorf("%q and %q are already the same entity", sourceName, targetName)
+	}
+
+	var source, target Entity
+	if err := tx.QueryRow("SELECT id, name, type FROM entities WHERE id = ?", sourceID).Scan(&source.ID, &source.Name, &source.Type); err != nil {
+		return Entity{}, err
+	}
+	if err := tx.QueryRow("SELECT id, name, type FROM entities WHERE id = ?", targetID).Scan(&target.ID, &target.Name, &target.Type); err != nil {
+		return Entity{}, err
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		// The memories mentioning the source change what they mention.
+		{"UPDATE memories SET updated_at = CURRENT_TIMESTAMP WHERE id IN (SELECT memory_id FROM memory_entities WHERE entity_id = ?)", []interface{}{sourceID}},
+		{"INSERT OR IGNORE INTO memory_entities (memory_id, entity_id) SELECT memory_id, ? FROM memory_entities WHERE entity_id = ?", []interface{}{targetID, sourceID}},
+		{"DELETE FROM memory_entities WHERE entity_id = ?", []interface{}{sourceID}},
+		// Edges between the two entities would become self-loops.
+		{"DELETE FROM relationships WHERE (source_id = ? AND target_id = ?) OR (source_id = ? AND target_id = ?)", []interface{}{sourceID, targetID, targetID, sourceID}},
+		{"UPDATE OR IGNORE relationships SET source_id = ? WHERE source_id = ?", []interface{}{targetID, sourceID}},
+		{"UPDATE OR IGNORE relationships SET target_id = ? WHERE target_id = ?", []interface{}{targetID, sourceID}},
+		// Anything left would have duplicated an edge the target already has.
+		{"DELETE FROM relationships WHERE source_id = ? OR target_id = ?", []interface{}{sourceID, sourceID}},
+		{"UPDATE entity_aliases SET entity_id = ? WHERE entity_id = ?", []interface{}{targetID, sourceID}},
+		{"UPDATE OR IGNORE entity_observations SET entity_id = ? WHERE entity_id = ?", []interface{}{targetID, sourceID}},
+		{"DELETE FROM entity_observations WHERE entity_id = ?", []interface{}{sourceID}},
+		{"DELETE FROM entities WHERE id = ?", []interface{}{sourceID}},
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt.query, stmt.args...); err != nil {
+			return Entity{}, err
+		}
+	}
+
+	if NormalizeEntityName(source.Name) != NormalizeEntityName(target.Name) {
+		if _, err := tx.Exec("INSERT OR REPLACE INTO entity_aliases (alias, norm_alias, entity_id) VALUES (?, ?, ?)",
+			source.Name, NormalizeEntityName(source.Name), targetID); err != nil {
+			return Entity{}, err
+		}
+	}
+	if target.Type == "unknown" && source.Type != "unknown" {
+		if _, err := tx.Exec("UPDATE entities SET type = ? WHERE id = ?", source.Type, targetID); err != nil {
+			return Entity{}, err
+		}
+		target.Type = source.Type
+	}
+	return target, nil
+}
+
+// MergeSuggestion is a pair of entities that probably name the same thing.
+// Target is the entity mentioned by more memories, and so the one to keep.
+type MergeSuggestion struct {
+	Source         Entity  `json:"source"`
+	Target         Entity  `json:"target"`
+	Score          float64 `json:"score"`
+	EditSimilarity float64 `json:"edit_similarity"`
+	TokenOverlap   float64 `json:"token_overlap"`
+}
+
+// mergeBlockPrefix is the length of the compacted name prefix that, like a
+// shared word, puts two entities in the same block of merge candidates.
+const mergeBlockPrefix = 3
+
+// SuggestMerges reports pairs of entities whose names are similar enough to
+// be likely duplicates. Similarity is the larger of the normalized edit
+// similarity and the token overlap of the two names; pairs scoring below
+// threshold are omitted. Only entities whose names share a word or begin
+// alike are compared, so that the whole table is not compared pairwise.
+func (db *DB) SuggestMerges(threshold float64) ([]MergeSuggestion, error) {
+	rows, err := db.Query(`SELECT e.id, e.name, e.type, COUNT(me.memory_id)
+		FROM entities e LEFT JOIN memory_entities me ON me.entity_id = e.id
+		GROUP BY e.id ORDER BY e.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type candidate struct {
+		entity   Entity
+		mentions int
+		compact  string
+		tokens   map[string]bool
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		if err := rows.Scan(&c.entity.ID, &c.entity.Name, &c.entity.Type, &c.mentions); err != nil {
+			return nil, err
+		}
+		c.tokens = nameTokens(c.entity.Name)
+		c.compact = compactName(c.entity.Name)
+		candidates = append(candidates, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	blocks := map[string][]int{}
+	for i, c := range candidates {
+		for _, key := range blockKeys(c.compact, c.tokens) {
+			blocks[key] = append(blocks[key], i)
+		}
+	}
+	compared := map[[2]int]bool{}
+	var suggestions []MergeSuggestion
+	for _, block := range blocks {
+		for x := 0; x < len(block); x++ {
+			for y := x + 1; y < len(block); y++ {
+				pair := [2]int{block[x], block[y]}
+				if compared[pair] {
+					continue
+				}
+				compared[pair] = true
+				a, b := candidates[pair[0]], candidates[pair[1]]
+				edit := editSimilarity(a.compact, b.compact)
+				overlap := jaccard(a.tokens, b.tokens)
+				score := edit
+				if overlap > score {
+					score = overlap
+				}
+				if score < threshold {
+					continue
+				}
+				source, target := a, b
+				if a.mentions > b.mentions {
+					source, target = b, a
+				}
+				suggestions = append(suggestions, MergeSuggestion{
+					Source:         source.entity,
+					Target:         target.entity,
+					Score:          score,
+					EditSimilarity: edit,
+					TokenOverlap:   overlap,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		if suggestions[i].Source.ID != suggestions[j].Source.ID {
+			return suggestions[i].Source.ID < suggestions[j].Source.ID
+		}
+		return suggestions[i].Target.ID < suggestions[j].Target.ID
+	})
+	return suggestions, nil
+}
+
+// blockKeys are the blocks of merge candidates an entity falls in: one per
+// word of its name and one for the start of its compacted name.
+func blockKeys(compact string, tokens map[string]bool) []string {
+	keys := make([]string, 0, len(tokens)+1)
+	for token := range tokens {
+		keys = append(keys, "word:"+token)
+	}
+	prefix := []rune(compact)
+	if len(prefix) > mergeBlockPrefix {
+		prefix = prefix[:mergeBlockPrefix]
+	}
+	if len(prefix) > 0 {
+		keys = append(keys, "prefix:"+string(prefix))
+	}
+	return keys
+}
+
+// nameTokens splits a name into its lower-cased alphanumeric words.
+func nameTokens(name string) map[string]bool {
+	tokens := make(map[string]bool)
+	for _, field := range strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		tokens[field] = true
+	}
+	return tokens
+}
+
+// compactName lower-cases a name and strips everything but letters and
+// digits, so that "Postgre-SQL" and "postgresql" compare equal.
+func compactName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// editSimilarity is one minus the Levenshtein distance normalized by the
+// length of the longer string.
+func editSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+// levenshtein computes the edit distance between two rune slices.
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// jaccard computes the overlap of two token sets.
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for token := range a {
+		if b[token] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestEntityNormalization(t *testing.T) {
+	db := newTestDB(t)
+
+	if _, err := db.AddMemory("we run Postgres", []string{"Postgres"}); err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+	if _, err := db.AddMemory("postgres is slow", []string{"  postgres ", "POSTGRES"}); err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+
+	entities, err := db.GetEntities()
+	if err != nil {
+		t.Fatalf("failed to get entities: %v", err)
+	}
+	if len(entities) != 1 || entities[0].Name != "Postgres" {
+		t.Errorf("expected a single Postgres entity, got %+v", entities)
+	}
+}
+
+func TestAliasesAndMerge(t *testing.T) {
+	db := newTestDB(t)
+
+	if _, err := db.AddMemory("migrated to PostgreSQL", []string{"PostgreSQL", "billing"}); err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+	if _, err := db.AddMemory("PostgreSQL tuning", []string{"PostgreSQL"}); err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+	if _, err := db.AddMemory("postgres backups", []string{"Postgres"}); err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+	billing, err := db.GetEntity("billing")
+	if err != nil {
+		t.Fatalf("failed to get entity: %v", err)
+	}
+	pg, err := db.GetEntity("Postgres")
+	if err != nil {
+		t.Fatalf("failed to get entity: %v", err)
+	}
+	if _, err := db.AddRelationship(billing.ID, pg.ID, "uses"); err != nil {
+		t.Fatalf("failed to add relationship: %v", err)
+	}
+
+	suggestions, err := db.SuggestMerges(0.7)
+	if err != nil {
+		t.Fatalf("failed to suggest merges: %v", err)
+	}
+	if len(suggestions) != 1 || suggestions[0].Source.Name != "Postgres" || suggestions[0].Target.Name != "PostgreSQL" {
+		t.Fatalf("expected Postgres -> PostgreSQL suggestion, got %+v", suggestions)
+	}
+
+	target, err := db.MergeEntities("Postgres", "PostgreSQL")
+	if err != nil {
+		t.Fatalf("failed to merge entities: %v", err)
+	}
+	if target.Name != "PostgreSQL" {
+		t.Errorf("expected target PostgreSQL, got %s", target.Name)
+	}
+
+	resolved, err := db.GetEntity("postgres")
+	if err != nil {
+		t.Fatalf("failed to resolve alias: %v", err)
+	}
+	if resolved.ID != target.ID {
+		t.Errorf("expected alias to resolve to %d, got %d", target.ID, resolved.ID)
+	}
+
+	var links int
+	if err := db.QueryRow("SELECT COUNT(*) FROM memory_entities WHERE entity_id = ?", target.ID).Scan(&links); err != nil {
+		t.Fatal(err)
+	}
+	if links != 3 {
+		t.Errorf("expected 3 memories linked to the target, got %d", links)
+	}
+
+	relationships, err := db.GetRelationships()
+	if err != nil {
+		t.Fatalf("failed to get relationships: %v", err)
+	}
+	if len(relationships) != 1 || relationships[0].TargetID != target.ID {
+		t.Errorf("expected relationship re-pointed at target, got %+v", relationships)
+	}
+
+	// New memories mentioning the alias attach to the canonical entity.
+	if _, err := db.AddMemory("postgres upgrade", []string{"Postgres"}); err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+	entities, err := db.GetEntities()
+	if err != nil {
+		t.Fatalf("failed to get entities: %v", err)
+	}
+	if len(entities) != 2 {
+		t.Errorf("expected 2 entities after merge, got %+v", entities)
+	}
+
+	if err := db.AddAlias("billing", "PG"); err != nil {
+		t.Fatalf("failed to add alias: %v", err)
+	}
+	if err := db.AddAlias("PostgreSQL", "pg"); err != ErrAliasConflict {
+		t.Errorf("expected ErrAliasConflict, got %v", err)
+	}
+}
+
+func TestSuggestMergesBlocking(t *testing.T) {
+	db := newTestDB(t)
+	for _, name := range []string{"api gateway", "gateway-api", "kubernetes", "kubernets", "redis"} {
+		if _, err := db.CreateEntity(name, ""); err != nil {
+			t.Fatalf("failed to create entity: %v", err)
+		}
+	}
+
+	suggestions, err := db.SuggestMerges(0.8)
+	if err != nil {
+		t.Fatalf("failed to suggest merges: %v", err)
+	}
+	// The gateways only share words and the kubernetes entities only the
+	// start of their names; both pairs are compared, and nothing else pairs.
+	pairs := map[string]bool{}
+	for _, s := range suggestions {
+		pairs[s.Source.Name+"/"+s.Target.Name] = true
+	}
+	if len(suggestions) != 2 || !pairs["api gateway/gateway-api"] || !pairs["kubernetes/kubernets"] {
+		t.Errorf("expected the gateway and kubernetes pairs, got %+v", suggestions)
+	}
+}
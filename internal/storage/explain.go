@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SearchDebug describes how a search ran: its mode, the bleve query it
+// compiled to, which the lexical retriever of a hybrid or graph search
+// runs too, and the filters that narrowed down the memories it could
+// find. A semantic search has no query.
+type SearchDebug struct {
+	Mode    string          `json:"mode"`
+	Query   json.RawMessage `json:"query,omitempty"`
+	Filters []string        `json:"filters"`
+}
+
+// describeSearch describes the search run with opts.
+func (db *DB) describeSearch(opts SearchOptions) (*SearchDebug, error) {
+	debug := &SearchDebug{Mode: opts.Mode, Filters: []string{}}
+	if debug.Mode == "" {
+		debug.Mode = QueryMatch
+	}
+	if !opts.History {
+		superseded, err := db.supersededDocIDs()
+		if err != nil {
+			return nil, err
+		}
+		debug.Filters = append(debug.Filters, fmt.Sprintf("superseded memories excluded (%d)", len(superseded)))
+	}
+	if opts.Mode == QuerySemantic {
+		if opts.Language != "" {
+			debug.Filters = append(debug.Filters, "language = "+opts.Language)
+		}
+		return debug, nil
+	}
+
+	lexical := opts
+	if opts.Mode == QueryHybrid || opts.Mode == QueryGraph {
+		lexical.Mode = QueryMatch
+	}
+	q, err := db.buildQuery(lexical)
+	if err != nil {
+		return nil, err
+	}
+	if debug.Query, err = json.Marshal(q); err != nil {
+		return nil, err
+	}
+	debug.Filters = append(debug.Filters, "observations excluded")
+	if opts.Language != "" {
+		debug.Filters = append(debug.Filters, "language = "+opts.Language)
+	}
+	if lexical.Mode == QueryString && opts.Structured == nil {
+		clauses, err := parseQueryString(opts.Query)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range clauses {
+			filter, err := db.describeFilter(c)
+			if err != nil {
+				return nil, err
+			}
+			if filter != "" {
+				debug.Filters = append(debug.Filters, filter)
+			}
+		}
+	}
+	if opts.Mode == QueryGraph {
+		hops, decay, err := graphParams(opts)
+		if err != nil {
+			return nil, err
+		}
+		debug.Filters = append(debug.Filters, fmt.Sprintf("graph expansion: %d hops, decay %v, activation >= %v", hops, decay, minActivation))
+	}
+	return debug, nil
+}
+
+// describeFilter describes a query string clause that filters memories by
+// a field other than content, with the value it resolved to, or returns ""
+// for a clause on content.
+func (db *DB) describeFilter(c queryClause) (string, error) {
+	if c.Field == "" || c.Field == "content" {
+		return "", nil
+	}
+	if c.Field == "created" {
+		if c.Occur == '-' {
+			return fmt.Sprintf("not created %s %s", c.Op, c.Value), nil
+		}
+		return fmt.Sprintf("created %s %s", c.Op, c.Value), nil
+	}
+	value, err := db.fieldValue(queryFields[c.Field], c.Value)
+	if err != nil {
+		return "", err
+	}
+	op := "="
+	if c.Occur == '-' {
+		op = "!="
+	}
+	if c.Prefix {
+		value += "*"
+	}
+	return fmt.Sprintf("%s %s %s", c.Field, op, value), nil
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/blevesearch/bleve/v2/search"
+)
+
+func TestExplainSearch(t *testing.T) {
+	db := newTestDB(t)
+	old, err := db.AddMemory("the payments API is not versioned", []string{"payments-api"})
+	if err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+	current, err := db.AddMemory("we decided to version the payments API", []string{"payments-api"})
+	if err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+	if _, _, err := db.LinkMemories(current, old, LinkSupersedes); err != nil {
+		t.Fatalf("failed to link memories: %v", err)
+	}
+
+	result, err := db.Search(SearchOptions{Query: "versioned api", Explain: true})
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if len(result.Hits) != 1 || result.Hits[0].ID != current {
+		t.Fatalf("expected the current memory, got %+v", result.Hits)
+	}
+	hit := result.Hits[0]
+	if hit.Components == nil || hit.Components.LexicalRank != 1 || hit.Components.Lexical != hit.Explanation.Relevance {
+		t.Errorf("expected the lexical components, got %+v", hit.Components)
+	}
+	lexical := hit.Explanation.Lexical
+	if lexical == nil || lexical.Value != hit.Explanation.Relevance {
+		t.Fatalf("expected bleve's explanation of the relevance, got %+v", lexical)
+	}
+	var messages []string
+	var walk func(*search.Explanation)
+	walk = func(e *search.Explanation) {
+		messages = append(messages, e.Message)
+		for _, child := range e.Children {
+			walk(child)
+		}
+	}
+	walk(lexical)
+	joined := strings.Join(messages, "\n")
+	if !strings.Contains(joined, "content:version") || !strings.Contains(joined, " in "+strconv.FormatInt(current, 10)+")") {
+		t.Errorf("expected the term weights of the memory, got %q", joined)
+	}
+
+	debug := result.Debug
+	if debug == nil || debug.Mode != QueryMatch || !strings.Contains(string(debug.Query), `"match":"versioned api"`) {
+		t.Fatalf("expected the compiled query, got %+v", debug)
+	}
+	if !slices.Equal(debug.Filters, []string{"superseded memories excluded (1)", "observations excluded"}) {
+		t.Errorf("unexpected filters %q", debug.Filters)
+	}
+
+	result, err = db.Search(SearchOptions{Query: "+api entity:Payments-API -tag:draft created:>2020-01-01", Mode: QueryString, History: true, Language: LanguageEnglish, Explain: true})
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	want := []string{"observations excluded", "language = en", "entity = payments-api", "tag != draft", "created > 2020-01-01"}
+	if len(result.Hits) != 2 || result.Debug == nil || !slices.Equal(result.Debug.Filters, want) {
+		t.Errorf("expected both memories and the resolved filters, got %d hits and %+v", len(result.Hits), result.Debug)
+	}
+
+	result, err = db.Search(SearchOptions{Query: "versioned api"})
+	if err != nil || result.Debug != nil || result.Hits[0].Explanation != nil || result.Hits[0].Components != nil {
+		t.Errorf("expected no explanation unless asked for, got %+v, %v", result, err)
+	}
+}
+
+func TestExplainSemanticSearch(t *testing.T) {
+	db := newSemanticDB(t, filepath.Join(t.TempDir(), "test.db"), 256)
+	defer db.Close()
+	if _, err := db.CreateMemory(MemoryInput{Content: "we redeployed everything after the outage"}); err != nil {
+		t.Fatalf("failed to create memory: %v", err)
+	}
+
+	result, err := db.Search(SearchOptions{Query: "redeployment after the outage", Mode: QuerySemantic, Explain: true})
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if len(result.Hits) == 0 {
+		t.Fatal("expected a semantic hit")
+	}
+	hit := result.Hits[0]
+	if hit.Components == nil || hit.Components.SemanticRank != 1 || hit.Components.Semantic <= 0 || hit.Explanation.Lexical != nil {
+		t.Errorf("expected the semantic components only, got %+v, %+v", hit.Components, hit.Explanation)
+	}
+	if result.Debug == nil || result.Debug.Mode != QuerySemantic || result.Debug.Query != nil {
+		t.Errorf("expected a semantic search without a query, got %+v", result.Debug)
+	}
+	result, err = db.Search(SearchOptions{Query: "redeployment after the outage", Mode: QuerySemantic, Language: LanguageEnglish, Explain: true})
+	if err != nil || len(result.Hits) != 1 || !slices.Contains(result.Debug.Filters, "language = en") {
+		t.Errorf("expected the language filter to be reported, got %+v, %v", result.Debug, err)
+	}
+
+	result, err = db.Search(SearchOptions{Query: "redeployed outage", Mode: QueryHybrid, Explain: true})
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if len(result.Hits) == 0 || result.Hits[0].Explanation.Lexical == nil || result.Debug.Query == nil {
+		t.Errorf("expected a hybrid hit explained lexically, got %+v", result)
+	}
+}
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search"
+)
+
+// Facets a search can count its matches by.
+const (
+	FacetEntity    = "entity"
+	FacetTag       = "tag"
+	FacetSource    = "source"
+	FacetNamespace = "namespace"
+	FacetLanguage  = "language"
+	FacetCreated   = "created"
+)
+
+// Intervals of the created facet's date histogram.
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+	IntervalYear  = "year"
+)
+
+const (
+	// defaultFacetSize is the number of terms a term facet reports.
+	defaultFacetSize = 10
+	// maxDateBuckets bounds the date histogram; older memories are counted
+	// in one open-ended bucket.
+	maxDateBuckets = 120
+)
+
+// ErrInvalidFacet is returned for an unknown facet or histogram interval.
+var ErrInvalidFacet = errors.New("invalid facet")
+
+// Facet counts the memories matching a search by the values of one field.
+// Total is the number of values counted, Missing the number of matches
+// without a value and Other the count left out of Terms by the facet size.
+type Facet struct {
+	Total   int          `json:"total"`
+	Missing int          `json:"missing"`
+	Other   int          `json:"other"`
+	Terms   []FacetTerm  `json:"terms,omitempty"`
+	Dates   []DateBucket `json:"dates,omitempty"`
+}
+
+// FacetTerm is the number of matches with one field value.
+type FacetTerm struct {
+	Term  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+// DateBucket is the number of matches created in [Start, End). Start is
+// nil for the bucket collecting everything older than the histogram.
+type DateBucket struct {
+	Start *time.Time `json:"start,omitempty"`
+	End   time.Time  `json:"end"`
+	Count int        `json:"count"`
+}
+
+// addFacets adds the requested facets to a search request.
+func (db *DB) addFacets(req *bleve.SearchRequest, opts SearchOptions) error {
+	size := opts.FacetSize
+	if size <= 0 {
+		size = defaultFacetSize
+	}
+	for _, name := range opts.Facets {
+		if name != FacetCreated {
+			field, ok := queryFields[name]
+			if !ok || name == "content" {
+				return fmt.Errorf("%w %q: use entity, tag, source, namespace, language or created", ErrInvalidFacet, name)
+			}
+			req.AddFacet(name, bleve.NewFacetRequest(field, size))
+			continue
+		}
+
+		oldest, err := db.oldestMemory()
+		if err != nil {
+			return err
+		}
+		buckets, err := dateBuckets(oldest, time.Now().UTC(), opts.FacetInterval)
+		if err != nil {
+			return err
+		}
+		facet := bleve.NewFacetRequest("created_at", len(buckets))
+		for _, b := range buckets {
+			var start time.Time
+			if b.Start != nil {
+				start = *b.Start
+			}
+			facet.AddDateTimeRange(b.End.Format(time.RFC3339), start, b.End)
+		}
+		req.AddFacet(name, facet)
+	}
+	return nil
+}
+
+// oldestMemory returns the creation time of the oldest memory, or now when
+// there are none.
+func (db *DB) oldestMemory() (time.Time, error) {
+	var oldest time.Time
+	err := db.QueryRow("SELECT created_at FROM memories ORDER BY created_at LIMIT 1").Scan(&oldest)
+	if err == sql.ErrNoRows {
+		return time.Now().UTC(), nil
+	}
+	return oldest, err
+}
+
+// dateBuckets splits the time from oldest to now into interval-sized
+// buckets, the last of which contains now.
+func dateBuckets(oldest, now time.Time, interval string) ([]DateBucket, error) {
+	if interval == "" {
+		interval = IntervalMonth
+	}
+	var (
+		start time.Time
+		next  func(time.Time) time.Time
+	)
+	y, m, d := oldest.UTC().Date()
+	switch interval {
+	case IntervalDay:
+		start = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		next = func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	case IntervalWeek:
+		start = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		// Weeks start on Monday.
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+		next = func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }
+	case IntervalMonth:
+		start = time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+		next = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	case IntervalYear:
+		start = time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC)
+		next = func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }
+	default:
+		return nil, fmt.Errorf("%w interval %q: use day, week, month or year", ErrInvalidFacet, interval)
+	}
+
+	var buckets []DateBucket
+	for t := start; !t.After(now); t = next(t) {
+		bucketStart := t
+		buckets = append(buckets, DateBucket{Start: &bucketStart, End: next(t)})
+	}
+	if len(buckets) > maxDateBuckets {
+		buckets = buckets[len(buckets)-maxDateBuckets:]
+		buckets[0].Start = nil
+	}
+	return buckets, nil
+}
+
+// convertFacets turns bleve's facet results into Facets, with the dates of
+// the created facet in chronological order.
+func convertFacets(results search.FacetResults) map[string]Facet {
+	if len(results) == 0 {
+		return nil
+	}
+	facets := make(map[string]Facet, len(results))
+	for name, result := range results {
+		facet := Facet{Total: result.Total, Missing: result.Missing, Other: result.Other}
+		for _, term := range result.Terms.Terms() {
+			facet.Terms = append(facet.Terms, FacetTerm{Term: term.Term, Count: term.Count})
+		}
+		for _, r := range result.DateRanges {
+			bucket := DateBucket{Count: r.Count}
+			if r.Start != nil {
+				if start, err := time.Parse(time.RFC3339, *r.Start); err == nil {
+					bucket.Start = &start
+				}
+			}
+			if r.End != nil {
+				bucket.End, _ = time.Parse(time.RFC3339, *r.End)
+			}
+			facet.Dates = append(facet.Dates, bucket)
+		}
+		sort.Slice(facet.Dates, func(a, b int) bool { return facet.Dates[a].End.Before(facet.Dates[b].End) })
+		facets[name] = facet
+	}
+	return facets
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSearchFacets(t *testing.T) {
+	db := newTestDB(t)
+	for _, in := range []MemoryInput{
+		{Content: "database outage in eu-west", Entities: []string{"Postgres", "EU West"}, Tags: []string{"incident"}, Source: "pager"},
+		{Content: "second outage caused by failover", Entities: []string{"Postgres"}, Tags: []string{"incident"}},
+		{Content: "dns outage", Entities: []string{"DNS"}, Source: "pager"},
+		{Content: "quarterly planning notes", Entities: []string{"Postgres"}},
+	} {
+		if _, err := db.CreateMemory(in); err != nil {
+			t.Fatalf("failed to create memory: %v", err)
+		}
+	}
+
+	result, err := db.Search(SearchOptions{
+		Query:  "outage",
+		Facets: []string{FacetEntity, FacetTag, FacetSource, FacetCreated},
+	})
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if result.Total != 3 {
+		t.Errorf("expected three matches, got %d", result.Total)
+	}
+
+	entities := result.Facets[FacetEntity]
+	if len(entities.Terms) != 3 || entities.Terms[0] != (FacetTerm{Term: "Postgres", Count: 2}) {
+		t.Errorf("expected Postgres to dominate the entity facet, got %+v", entities)
+	}
+	if tags := result.Facets[FacetTag]; len(tags.Terms) != 1 || tags.Terms[0].Count != 2 || tags.Missing != 1 {
+		t.Errorf("expected two incident tags and one untagged match, got %+v", tags)
+	}
+	if sources := result.Facets[FacetSource]; len(sources.Terms) != 1 || sources.Terms[0] != (FacetTerm{Term: "pager", Count: 2}) {
+		t.Errorf("expected two pager matches, got %+v", sources)
+	}
+	dates := result.Facets[FacetCreated].Dates
+	if len(dates) != 1 || dates[0].Count != 3 || dates[0].Start == nil || !dates[0].End.After(time.Now()) {
+		t.Errorf("expected this month's bucket to hold all matches, got %+v", dates)
+	}
+
+	if _, err := db.Search(SearchOptions{Query: "outage", Facets: []string{"content"}}); !errors.Is(err, ErrInvalidFacet) {
+		t.Errorf("expected ErrInvalidFacet for an unknown facet, got %v", err)
+	}
+	if _, err := db.Search(SearchOptions{Query: "outage", Facets: []string{FacetCreated}, FacetInterval: "hour"}); !errors.Is(err, ErrInvalidFacet) {
+		t.Errorf("expected ErrInvalidFacet for an unknown interval, got %v", err)
+	}
+}
+
+func TestDateBuckets(t *testing.T) {
+	now := time.Date(2025, 3, 12, 15, 0, 0, 0, time.UTC)
+
+	// 2025-03-01 is a Saturday; its week starts on Monday 2025-02-24.
+	weeks, err := dateBuckets(time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC), now, IntervalWeek)
+	if err != nil {
+		t.Fatalf("failed to build buckets: %v", err)
+	}
+	if len(weeks) != 3 || !weeks[0].Start.Equal(time.Date(2025, 2, 24, 0, 0, 0, 0, time.UTC)) || !weeks[2].End.Equal(time.Date(2025, 3, 17, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected weekly buckets: %+v", weeks)
+	}
+
+	days, err := dateBuckets(now.AddDate(-1, 0, 0), now, IntervalDay)
+	if err != nil {
+		t.Fatalf("failed to build buckets: %v", err)
+	}
+	if len(days) != maxDateBuckets || days[0].Start != nil || days[1].Start == nil {
+		t.Errorf("expected %d buckets with an open-ended first one, got %d starting %v", maxDateBuckets, len(days), days[0].Start)
+	}
+}
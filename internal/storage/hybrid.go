@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Ways a hybrid search fuses its lexical and semantic results.
+const (
+	// FusionRRF scores memories by reciprocal rank fusion; it is the
+	// default.
+	FusionRRF = "rrf"
+	// FusionWeighted scores memories by the weighted sum of their scores,
+	// each scaled to [0, 1] within its own result list.
+	FusionWeighted = "weighted"
+)
+
+const (
+	// rrfK damps the influence of the top ranks in reciprocal rank fusion;
+	// 60 is the value from the original paper.
+	rrfK = 60
+	// hybridDepth is how many memories each retriever of a hybrid search
+	// returns for every memory the search returns.
+	hybridDepth = 3
+)
+
+// ErrInvalidFusion is returned for an unknown fusion method or a negative
+// fusion weight.
+var ErrInvalidFusion = errors.New("invalid fusion")
+
+// ScoreComponents are the scores a hybrid or graph hit got from the
+// lexical (BM25) and semantic (cosine similarity) retrievers, and its rank
+// in each result list. A zero rank means the retriever did not return the
+// memory. Graph is the activation a graph search reached the memory with,
+// through the entity named by Via.
+type ScoreComponents struct {
+	Lexical      float64 `json:"lexical"`
+	LexicalRank  int     `json:"lexical_rank,omitempty"`
+	Semantic     float64 `json:"semantic"`
+	SemanticRank int     `json:"semantic_rank,omitempty"`
+	Graph        float64 `json:"graph,omitempty"`
+	Via          string  `json:"via,omitempty"`
+}
+
+// hybridSearch runs the lexical and semantic searches of a QueryHybrid
+// search concurrently and fuses their results. Facets count the lexical
+// matches.
+func (db *DB) hybridSearch(opts SearchOptions) (SearchResult, error) {
+	if opts.Structured != nil {
+		return SearchResult{}, &QueryError{Pos: -1, Path: "query", Msg: "a structured query cannot be searched semantically"}
+	}
+	lexicalWeight, semanticWeight, err := fusionWeights(opts)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	depth := hybridDepth * searchDepth(opts)
+
+	var (
+		wg          sync.WaitGroup
+		matches     []semanticMatch
+		semanticErr error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		matches, semanticErr = db.semanticMatches(opts.Query, opts.History, opts.Language, depth)
+	}()
+	lexicalOpts := opts
+	lexicalOpts.Mode = QueryMatch
+	lexical, err := db.lexicalSearch(lexicalOpts, depth)
+	wg.Wait()
+	if err != nil {
+		return SearchResult{}, err
+	}
+	if semanticErr != nil {
+		return SearchResult{}, semanticErr
+	}
+	found, err := lexicalMatches(lexical)
+	if err != nil {
+		return SearchResult{}, err
+	}
+
+	fused := fuse(found, matches, opts.Fusion, lexicalWeight, semanticWeight)
+	hits, err := db.buildHits(fused, opts)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	return SearchResult{Hits: hits, Total: uint64(len(fused)), Facets: convertFacets(lexical.Facets)}, nil
+}
+
+// fusionWeights validates the fusion options of a search and returns the
+// weights of the lexical and semantic results. Both default to 1.
+func fusionWeights(opts SearchOptions) (float64, float64, error) {
+	switch opts.Fusion {
+	case "", FusionRRF, FusionWeighted:
+	default:
+		return 0, 0, fmt.Errorf("%w %q: use %s or %s", ErrInvalidFusion, opts.Fusion, FusionRRF, FusionWeighted)
+	}
+	if opts.LexicalWeight < 0 || opts.SemanticWeight < 0 {
+		return 0, 0, fmt.Errorf("%w: weights must not be negative", ErrInvalidFusion)
+	}
+	if opts.LexicalWeight == 0 && opts.SemanticWeight == 0 {
+		return 1, 1, nil
+	}
+	return opts.LexicalWeight, opts.SemanticWeight, nil
+}
+
+// fuse merges the lexical and semantic results of a hybrid search into one
+// list, best first.
+func fuse(lexical []scoredMemory, semantic []semanticMatch, fusion string, lexicalWeight, semanticWeight float64) []scoredMemory {
+	byID := map[int64]*scoredMemory{}
+	var fused []*scoredMemory
+	get := func(id int64) *scoredMemory {
+		if f, ok := byID[id]; ok {
+			return f
+		}
+		f := &scoredMemory{id: id, chunk: -1, components: &ScoreComponents{}}
+		byID[id] = f
+		fused = append(fused, f)
+		return f
+	}
+
+	lexicalNorm := normalizer(len(lexical), func(i int) float64 { return lexical[i].score })
+	for i, l := range lexical {
+		f := get(l.id)
+		f.locations, f.explanation = l.locations, l.explanation
+		f.components.Lexical, f.components.LexicalRank = l.score, i+1
+		if fusion == FusionWeighted {
+			f.score += lexicalWeight * lexicalNorm(l.score)
+		} else {
+			f.score += lexicalWeight / float64(rrfK+i+1)
+		}
+	}
+	semanticNorm := normalizer(len(semantic), func(i int) float64 { return semantic[i].similarity })
+	for i, m := range semantic {
+		f := get(m.id)
+		f.chunk = m.chunk
+		f.components.Semantic, f.components.SemanticRank = m.similarity, i+1
+		if fusion == FusionWeighted {
+			f.score += semanticWeight * semanticNorm(m.similarity)
+		} else {
+			f.score += semanticWeight / float64(rrfK+i+1)
+		}
+	}
+
+	sort.SliceStable(fused, func(a, b int) bool { return fused[a].score > fused[b].score })
+	result := make([]scoredMemory, len(fused))
+	for i, f := range fused {
+		result[i] = *f
+	}
+	return result
+}
+
+// normalizer returns a function scaling the scores of a result list to
+// [0, 1] by their minimum and maximum. A list whose scores are all equal
+// scales to 1.
+func normalizer(n int, score func(int) float64) func(float64) float64 {
+	if n == 0 {
+		return func(float64) float64 { return 0 }
+	}
+	lo, hi := score(0), score(0)
+	for i := 1; i < n; i++ {
+		lo, hi = min(lo, score(i)), max(hi, score(i))
+	}
+	if hi == lo {
+		return func(float64) float64 { return 1 }
+	}
+	return func(s float64) float64 { return (s - lo) / (hi - lo) }
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestHybridSearch(t *testing.T) {
+	db := newSemanticDB(t, filepath.Join(t.TempDir(), "test.db"), 256)
+	defer db.Close()
+
+	add := func(content string) int64 {
+		t.Helper()
+		id, err := db.CreateMemory(MemoryInput{Content: content, Tags: []string{"notes"}})
+		if err != nil {
+			t.Fatalf("failed to create memory: %v", err)
+		}
+		return id
+	}
+	identifier := add("call loadSettingsV2 before the server starts")
+	paraphrase := add("we redeployed everything after the outage")
+	add("grandma's apple pie needs more cinnamon")
+
+	result, err := db.Search(SearchOptions{Query: "loadSettingsV2 redeployment", Mode: QueryHybrid, Facets: []string{FacetTag}})
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	hits := map[int64]SearchHit{}
+	for _, hit := range result.Hits {
+		hits[hit.ID] = hit
+	}
+	exact, ok := hits[identifier]
+	if !ok || exact.Components == nil || exact.Components.LexicalRank != 1 || exact.Components.Lexical <= 0 {
+		t.Errorf("expected the identifier to be found lexically, got %+v", exact)
+	}
+	similar, ok := hits[paraphrase]
+	if !ok || similar.Components == nil || similar.Components.LexicalRank != 0 || similar.Components.SemanticRank == 0 || similar.Components.Semantic <= 0 {
+		t.Errorf("expected the paraphrase to be found semantically only, got %+v", similar)
+	}
+	if result.Hits[0].ID != identifier {
+		t.Errorf("expected the memory found by both retrievers first, got %d", result.Hits[0].ID)
+	}
+	if result.Facets[FacetTag].Total != 1 {
+		t.Errorf("expected the facets to count the lexical matches, got %+v", result.Facets)
+	}
+
+	// Weighing only the semantic results ranks by similarity alone.
+	result, err = db.Search(SearchOptions{Query: "loadSettingsV2 redeployment", Mode: QueryHybrid, Fusion: FusionWeighted, SemanticWeight: 1})
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	for i := 1; i < len(result.Hits); i++ {
+		if result.Hits[i].Components.Semantic > result.Hits[i-1].Components.Semantic {
+			t.Errorf("expected hits ordered by similarity, got %+v", result.Hits)
+		}
+	}
+
+	// A language restricts both retrievers.
+	french, err := db.CreateMemory(MemoryInput{Content: "we redeployed everything after the outage yesterday", Language: LanguageFrench})
+	if err != nil {
+		t.Fatalf("failed to create memory: %v", err)
+	}
+	result, err = db.Search(SearchOptions{Query: "loadSettingsV2 redeployment", Mode: QueryHybrid, Language: LanguageEnglish})
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	for _, hit := range result.Hits {
+		if hit.ID == french {
+			t.Errorf("expected the French memory to be left out, got %+v", result.Hits)
+		}
+	}
+
+	for _, opts := range []SearchOptions{
+		{Query: "x", Mode: QueryHybrid, Fusion: "borda"},
+		{Query: "x", Mode: QueryHybrid, LexicalWeight: -1},
+	} {
+		if _, err := db.Search(opts); !errors.Is(err, ErrInvalidFusion) {
+			t.Errorf("%+v: expected ErrInvalidFusion, got %v", opts, err)
+		}
+	}
+}
+
+func TestFuse(t *testing.T) {
+	lexical := []scoredMemory{{id: 1, score: 4, chunk: -1}, {id: 2, score: 2, chunk: -1}}
+	semantic := []semanticMatch{{id: 2, chunk: 0, similarity: 0.9}, {id: 3, chunk: 1, similarity: 0.5}}
+
+	rrf := fuse(lexical, semantic, FusionRRF, 1, 1)
+	if len(rrf) != 3 || rrf[0].id != 2 {
+		t.Fatalf("expected the memory both lists found first, got %+v", rrf)
+	}
+	if want := 1.0/62 + 1.0/61; math.Abs(rrf[0].score-want) > 1e-12 {
+		t.Errorf("expected an RRF score of %v, got %v", want, rrf[0].score)
+	}
+	if c := rrf[0].components; c.LexicalRank != 2 || c.SemanticRank != 1 || c.Lexical != 2 || c.Semantic != 0.9 || rrf[0].chunk != 0 {
+		t.Errorf("unexpected components %+v", c)
+	}
+
+	weighted := fuse(lexical, semantic, FusionWeighted, 2, 1)
+	scores := map[int64]float64{}
+	for _, f := range weighted {
+		scores[f.id] = f.score
+	}
+	if scores[1] != 2 || scores[2] != 1 || scores[3] != 0 {
+		t.Errorf("expected min-max scaled weighted scores, got %v", scores)
+	}
+}
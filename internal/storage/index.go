@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/standard"
+	"github.com/blevesearch/bleve/v2/analysis/lang/en"
+	"github.com/blevesearch/bleve/v2/mapping"
+)
+
+// indexMappingVersion identifies the layout of the search index. Bump it
+// whenever indexMapping or the indexed documents change; an index written
+// with another version is rebuilt from the database by Migrate.
+const indexMappingVersion = 4
+
+// indexVersionKey is the internal index key the mapping version is kept
+// under.
+var indexVersionKey = []byte("mapping_version")
+
+// reindexBatchSize is the number of memories written per batch when the
+// index is rebuilt.
+const reindexBatchSize = 500
+
+// memoryDocument is what gets indexed in bleve for a memory. Source and
+// Namespace are nil when empty, so facets count such memories as missing
+// rather than as an empty value.
+type memoryDocument struct {
+	Type      string    `json:"type"`
+	Content   string    `json:"content"`
+	Language  string    `json:"language"`
+	Entities  []string  `json:"entities,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+	Source    *string   `json:"source,omitempty"`
+	Namespace *string   `json:"namespace,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BleveType selects the memory document mapping of the memory's language.
+func (d memoryDocument) BleveType() string { return memoryMappingName(d.Language) }
+
+func memoryMappingName(lang string) string { return "memory_" + lang }
+
+func newMemoryDocument(memory Memory, entities []string) memoryDocument {
+	lang := memory.Language
+	if lang == "" {
+		lang = DetectLanguage(memory.Content)
+	}
+	return memoryDocument{
+		Type:      "memory",
+		Content:   memory.Content,
+		Language:  lang,
+		Entities:  entities,
+		Tags:      memory.Tags,
+		Source:    optional(memory.Source),
+		Namespace: optional(memory.Namespace),
+		CreatedAt: memory.CreatedAt,
+	}
+}
+
+// optional returns nil for an empty string and a pointer to s otherwise.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// indexMapping describes the memory and observation documents. Memory
+// content is analyzed as text in the memory's language, with one document
+// mapping per language, and also as plain words; observations are analyzed
+// as English. Names, tags and the like are kept verbatim so they can be
+// filtered and counted exactly. Only content goes into the composite _all
+// field.
+func indexMapping() mapping.IndexMapping {
+	text := func(analyzer string) *mapping.FieldMapping {
+		f := bleve.NewTextFieldMapping()
+		f.Analyzer = analyzer
+		return f
+	}
+	exact := func() *mapping.FieldMapping {
+		f := bleve.NewKeywordFieldMapping()
+		f.Analyzer = keyword.Name
+		f.IncludeInAll = false
+		return f
+	}
+	date := bleve.NewDateTimeFieldMapping()
+	date.IncludeInAll = false
+
+	// words indexes content a second time, unstemmed, for prefix and
+	// exact-word queries.
+	words := bleve.NewTextFieldMapping()
+	words.Name = "content_words"
+	words.Analyzer = standard.Name
+	words.Store = false
+	words.IncludeInAll = false
+
+	m := bleve.NewIndexMapping()
+	m.DefaultAnalyzer = en.AnalyzerName
+	for _, lang := range languages {
+		// The analyzers are named after their language.
+		memory := bleve.NewDocumentStaticMapping()
+		memory.AddFieldMappingsAt("type", exact())
+		memory.AddFieldMappingsAt("content", text(lang), words)
+		memory.AddFieldMappingsAt("language", exact())
+		memory.AddFieldMappingsAt("entities", exact())
+		memory.AddFieldMappingsAt("tags", exact())
+		memory.AddFieldMappingsAt("source", exact())
+		memory.AddFieldMappingsAt("namespace", exact())
+		memory.AddFieldMappingsAt("created_at", date)
+		m.AddDocumentMapping(memoryMappingName(lang), memory)
+	}
+
+	observation := bleve.NewDocumentStaticMapping()
+	observation.AddFieldMappingsAt("type", exact())
+	observation.AddFieldMappingsAt("entity", exact())
+	observation.AddFieldMappingsAt("content", text(en.AnalyzerName))
+	m.AddDocumentMapping("observation", observation)
+	return m
+}
+
+// openIndex opens the search index at path, creating it if it does not
+// exist and recreating it if it was written with another mapping version.
+// It reports whether the index is new and must be filled from the database.
+func openIndex(path string) (bleve.Index, bool, error) {
+	if _, err := os.Stat(path); err == nil {
+		index, err := bleve.Open(path)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to open bleve index: %w", err)
+		}
+		version, err := index.GetInternal(indexVersionKey)
+		if err != nil {
+			index.Close()
+			return nil, false, fmt.Errorf("failed to read index mapping version: %w", err)
+		}
+		if string(version) == strconv.Itoa(indexMappingVersion) {
+			return index, false, nil
+		}
+		if err := index.Close(); err != nil {
+			return nil, false, err
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return nil, false, fmt.Errorf("failed to remove outdated bleve index: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	index, err := bleve.New(path, indexMapping())
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create bleve index: %w", err)
+	}
+	return index, true, nil
+}
+
+// rebuildIndex writes every memory and observation in the database to the
+// index and then records the mapping version. An interrupted rebuild leaves
+// the version unset, so it is started over on the next run.
+func (db *DB) rebuildIndex() error {
+	var last int64
+	for {
+		memories, err := db.queryMemories(memoryColumns+" WHERE m.id > ? ORDER BY m.id LIMIT ?", last, reindexBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(memories) == 0 {
+			break
+		}
+		if err := db.indexMemories(memories); err != nil {
+			return err
+		}
+		last = memories[len(memories)-1].ID
+	}
+
+	entities, err := db.GetEntities()
+	if err != nil {
+		return err
+	}
+	for _, entity := range entities {
+		if err := db.reindexObservations(entity); err != nil {
+			return err
+		}
+	}
+	return db.index.SetInternal(indexVersionKey, []byte(strconv.Itoa(indexMappingVersion)))
+}
+
+// indexMemories writes the given memories to the index in one batch.
+func (db *DB) indexMemories(memories []Memory) error {
+	ids := make([]int64, len(memories))
+	for i, memory := range memories {
+		ids[i] = memory.ID
+	}
+	entities, err := db.loadEntityNames(ids)
+	if err != nil {
+		return err
+	}
+	batch := db.index.NewBatch()
+	for _, memory := range memories {
+		if err := batch.Index(strconv.FormatInt(memory.ID, 10), newMemoryDocument(memory, entities[memory.ID])); err != nil {
+			return fmt.Errorf("failed to index memory: %w", err)
+		}
+	}
+	if err := db.index.Batch(batch); err != nil {
+		return fmt.Errorf("failed to index memories: %w", err)
+	}
+	return nil
+}
+
+// reindexMemories rewrites the index documents of the given memories, for
+// instance after the entities they mention were merged or deleted.
+func (db *DB) reindexMemories(ids []int64) error {
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > reindexBatchSize {
+			n = reindexBatchSize
+		}
+		byID, err := db.loadMemories(ids[:n])
+		if err != nil {
+			return err
+		}
+		memories := make([]Memory, 0, len(byID))
+		for _, id := range ids[:n] {
+			if memory, ok := byID[id]; ok {
+				memories = append(memories, memory)
+			}
+		}
+		if err := db.indexMemories(memories); err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+// entityMemoryIDs returns the IDs of the memories that mention an entity.
+func (db *DB) entityMemoryIDs(entityID int64) ([]int64, error) {
+	return db.queryIDs("SELECT memory_id FROM memory_entities WHERE entity_id = ?", entityID)
+}
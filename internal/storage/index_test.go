@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+func TestIndexFields(t *testing.T) {
+	db := newTestDB(t)
+
+	id, err := db.CreateMemory(MemoryInput{
+		Content:   "the billing service moved to kubernetes",
+		Entities:  []string{"Billing Service"},
+		Tags:      []string{"Infra"},
+		Source:    "slack",
+		Namespace: "work",
+	})
+	if err != nil {
+		t.Fatalf("failed to create memory: %v", err)
+	}
+	if _, err := db.AddMemory("the billing team had lunch", nil); err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+
+	term := func(field, value string) *bleve.SearchRequest {
+		q := bleve.NewTermQuery(value)
+		q.SetField(field)
+		return bleve.NewSearchRequest(q)
+	}
+	day := time.Now().UTC().Add(-time.Hour)
+	dates := bleve.NewDateRangeQuery(day, day.Add(2*time.Hour))
+	dates.SetField("created_at")
+
+	for name, req := range map[string]*bleve.SearchRequest{
+		"entity":    term("entities", "Billing Service"),
+		"tag":       term("tags", "infra"),
+		"source":    term("source", "slack"),
+		"namespace": term("namespace", "work"),
+		"stemmed":   term("content", "move"),
+	} {
+		result, err := db.index.Search(req)
+		if err != nil {
+			t.Fatalf("%s: search failed: %v", name, err)
+		}
+		if result.Total != 1 || result.Hits[0].ID != "1" {
+			t.Errorf("%s: expected memory %d alone, got %v", name, id, result.Hits)
+		}
+	}
+	result, err := db.index.Search(bleve.NewSearchRequest(dates))
+	if err != nil {
+		t.Fatalf("date search failed: %v", err)
+	}
+	if result.Total != 2 {
+		t.Errorf("expected both memories within the date range, got %d", result.Total)
+	}
+
+	if _, err := db.CreateEntity("Payments", "service"); err != nil {
+		t.Fatalf("failed to create entity: %v", err)
+	}
+	if _, err := db.MergeEntities("Billing Service", "Payments"); err != nil {
+		t.Fatalf("failed to merge entities: %v", err)
+	}
+	if result, _ := db.index.Search(term("entities", "Payments")); result.Total != 1 {
+		t.Errorf("expected the memory to be re-indexed under the merged entity, got %d hits", result.Total)
+	}
+}
+
+func TestIndexRebuild(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	if _, err := db.AddMemory("deploys happen on thursdays", []string{"deploys"}); err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+	if _, err := db.AddObservations("deploys", []string{"need two approvals"}); err != nil {
+		t.Fatalf("failed to add observation: %v", err)
+	}
+	// Pretend the index was written by an older release: a bare content
+	// document and no mapping version.
+	if err := db.index.SetInternal(indexVersionKey, nil); err != nil {
+		t.Fatalf("failed to clear mapping version: %v", err)
+	}
+	if err := db.index.Index("1", "deploys happen on thursdays"); err != nil {
+		t.Fatalf("failed to write legacy document: %v", err)
+	}
+	db.Close()
+
+	db, err = NewDB(path)
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Close()
+	if !db.indexStale {
+		t.Fatal("expected an index without a mapping version to be rebuilt")
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	if version, _ := db.index.GetInternal(indexVersionKey); string(version) != strconv.Itoa(indexMappingVersion) {
+		t.Errorf("expected mapping version %d after the rebuild, got %q", indexMappingVersion, version)
+	}
+
+	if results, err := db.SearchMemories("thursday"); err != nil || len(results) != 1 {
+		t.Errorf("expected the memory to be found after the rebuild, got %v (err %v)", results, err)
+	}
+	if entities, err := db.SearchNodes("approvals"); err != nil || len(entities) != 1 {
+		t.Errorf("expected the observation to be found after the rebuild, got %v (err %v)", entities, err)
+	}
+	q := bleve.NewTermQuery("deploys")
+	q.SetField("entities")
+	if result, _ := db.index.Search(bleve.NewSearchRequest(q)); result.Total != 1 {
+		t.Errorf("expected the rebuilt memory document to list its entities, got %d hits", result.Total)
+	}
+}
@@ -0,0 +1,15 @@
+-- Indexes are created after column migrations have run, so they may refer to
+-- columns that older databases only gained through ALTER TABLE.
+
+CREATE INDEX IF NOT EXISTS idx_memories_session ON memories (session_id, turn_id);
+CREATE INDEX IF NOT EXISTS idx_entities_norm_name ON entities (norm_name);
+CREATE INDEX IF NOT EXISTS idx_entity_aliases_entity ON entity_aliases (entity_id);
+-- Only edges that are still valid need to be unique; closed edges are history.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_relationships_current_edge ON relationships (source_id, target_id, type) WHERE valid_to IS NULL;
+CREATE INDEX IF NOT EXISTS idx_relationships_target ON relationships (target_id, type);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_entity_observations_current ON entity_observations (entity_id, content) WHERE valid_to IS NULL;
+CREATE INDEX IF NOT EXISTS idx_memory_links_target ON memory_links (target_id, type);
+CREATE INDEX IF NOT EXISTS idx_memories_created ON memories (created_at, id);
+CREATE INDEX IF NOT EXISTS idx_memories_namespace ON memories (namespace, created_at);
+CREATE INDEX IF NOT EXISTS idx_memory_tags_tag ON memory_tags (tag, memory_id);
+CREATE INDEX IF NOT EXISTS idx_memory_embeddings_model ON memory_embeddings (model, memory_id);
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/blevesearch/bleve/v2/analysis/lang/de"
+	"github.com/blevesearch/bleve/v2/analysis/lang/en"
+	"github.com/blevesearch/bleve/v2/analysis/lang/fr"
+)
+
+// Languages memories are analyzed in. Each is indexed with the bleve
+// analyzer of the same name, which stems words and drops stop words.
+const (
+	LanguageEnglish = "en"
+	LanguageFrench  = "fr"
+	LanguageGerman  = "de"
+)
+
+// DefaultLanguage is the language of a memory whose language cannot be
+// told from its content.
+const DefaultLanguage = LanguageEnglish
+
+// languages lists the supported languages, the default first, so it wins
+// ties in detection.
+var languages = []string{LanguageEnglish, LanguageFrench, LanguageGerman}
+
+// ErrUnknownLanguage is returned for a language that is not supported.
+var ErrUnknownLanguage = errors.New("unknown language")
+
+// languageHints weighs the words and letters that give away the language
+// of a text: stop words count 1 divided among the languages sharing them,
+// and letters only one language uses count half.
+var languageHints = buildLanguageHints()
+
+func buildLanguageHints() map[string]map[string]float64 {
+	lists := map[string][]byte{
+		LanguageEnglish: en.EnglishStopWords,
+		LanguageFrench:  fr.FrenchStopWords,
+		LanguageGerman:  de.GermanStopWords,
+	}
+	shared := map[string][]string{}
+	for _, lang := range languages {
+		for _, line := range strings.Split(string(lists[lang]), "\n") {
+			// Snowball lists put comments after a '|'.
+			if i := strings.IndexByte(line, '|'); i >= 0 {
+				line = line[:i]
+			}
+			for _, word := range strings.Fields(line) {
+				shared[word] = append(shared[word], lang)
+			}
+		}
+	}
+	hints := map[string]map[string]float64{}
+	for word, langs := range shared {
+		hints[word] = map[string]float64{}
+		for _, lang := range langs {
+			hints[word][lang] += 1 / float64(len(langs))
+		}
+	}
+	for lang, letters := range map[string]string{
+		LanguageFrench: "àâæçèéêëîïôœùûÿ",
+		LanguageGerman: "äöüß",
+	} {
+		for _, r := range letters {
+			hints[string(r)] = map[string]float64{lang: 0.5}
+		}
+	}
+	return hints
+}
+
+// ValidLanguage checks that lang is empty or a supported language.
+func ValidLanguage(lang string) error {
+	if lang == "" {
+		return nil
+	}
+	for _, l := range languages {
+		if l == lang {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q; use %s", ErrUnknownLanguage, lang, strings.Join(languages, ", "))
+}
+
+// DetectLanguage guesses the language of text from the stop words and
+// accented letters it contains. Text without any hint is DefaultLanguage.
+func DetectLanguage(text string) string {
+	scores := map[string]float64{}
+	text = strings.ReplaceAll(strings.ToLower(text), "’", "'")
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	for _, word := range words {
+		hints, ok := languageHints[word]
+		if i := strings.IndexByte(word, '\''); !ok && i >= 0 {
+			// Elisions such as l'équipe or d'abord count as their
+			// article.
+			hints = languageHints[word[:i]]
+		}
+		for lang, w := range hints {
+			scores[lang] += w
+		}
+		for _, r := range word {
+			if r <= unicode.MaxASCII {
+				continue
+			}
+			for lang, w := range languageHints[string(r)] {
+				scores[lang] += w
+			}
+		}
+	}
+	ranked := append([]string(nil), languages...)
+	sort.SliceStable(ranked, func(a, b int) bool { return scores[ranked[a]] > scores[ranked[b]] })
+	return ranked[0]
+}
+
+// backfillLanguages detects the language of memories stored before
+// languages were recorded.
+func (db *DB) backfillLanguages() error {
+	rows, err := db.Query("SELECT id, content FROM memories WHERE language = ''")
+	if err != nil {
+		return err
+	}
+	detected := make(map[int64]string)
+	for rows.Next() {
+		var (
+			id      int64
+			content string
+		)
+		if err := rows.Scan(&id, &content); err != nil {
+			rows.Close()
+			return err
+		}
+		detected[id] = DetectLanguage(content)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, lang := range detected {
+		if _, err := db.Exec("UPDATE memories SET language = ? WHERE id = ?", lang, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		want string
+	}{
+		{"the billing service retries failed charges", LanguageEnglish},
+		{"le service de facturation relance les paiements échoués", LanguageFrench},
+		{"l’équipe a décidé d'utiliser postgres", LanguageFrench},
+		{"der Abrechnungsdienst wiederholt fehlgeschlagene Zahlungen", LanguageGerman},
+		{"Größe prüfen", LanguageGerman},
+		{"postgres db-01", DefaultLanguage},
+		{"", DefaultLanguage},
+	} {
+		if got := DetectLanguage(tc.text); got != tc.want {
+			t.Errorf("%q: expected %s, got %s", tc.text, tc.want, got)
+		}
+	}
+}
+
+func TestSearchLanguages(t *testing.T) {
+	db := newTestDB(t)
+	add := func(in MemoryInput) int64 {
+		t.Helper()
+		id, err := db.CreateMemory(in)
+		if err != nil {
+			t.Fatalf("failed to create memory: %v", err)
+		}
+		return id
+	}
+	english := add(MemoryInput{Content: "the deployments of the billing service are frozen"})
+	french := add(MemoryInput{Content: "les déploiements du service de facturation sont gelés"})
+	german := add(MemoryInput{Content: "die Bereitstellungen des Abrechnungsdienstes sind eingefroren"})
+	forced := add(MemoryInput{Content: "rollback", Language: LanguageFrench})
+
+	for id, want := range map[int64]string{english: LanguageEnglish, french: LanguageFrench, german: LanguageGerman, forced: LanguageFrench} {
+		memory, err := db.GetMemoryRecord(id)
+		if err != nil || memory.Language != want {
+			t.Errorf("memory %d: expected language %s, got %+v, %v", id, want, memory, err)
+		}
+	}
+
+	ids := func(opts SearchOptions) map[int64]bool {
+		t.Helper()
+		result, err := db.Search(opts)
+		if err != nil {
+			t.Fatalf("%+v: failed to search: %v", opts, err)
+		}
+		found := map[int64]bool{}
+		for _, hit := range result.Hits {
+			found[hit.ID] = true
+		}
+		return found
+	}
+
+	// Each memory is stemmed in its own language.
+	if found := ids(SearchOptions{Query: "déploiement"}); !found[french] || found[english] {
+		t.Errorf("expected the French plural to match the singular, got %v", found)
+	}
+	if found := ids(SearchOptions{Query: "Bereitstellung"}); !found[german] {
+		t.Errorf("expected the German plural to match the singular, got %v", found)
+	}
+	if found := ids(SearchOptions{Query: "deployment"}); !found[english] || found[french] {
+		t.Errorf("expected the English plural to match the singular, got %v", found)
+	}
+	// Stop words of one language do not match memories in another.
+	if found := ids(SearchOptions{Query: "les"}); len(found) != 0 {
+		t.Errorf("expected a French stop word to match nothing, got %v", found)
+	}
+
+	// Words shared by several languages match across them, unless the
+	// query forces one.
+	if found := ids(SearchOptions{Query: "service"}); !found[english] || !found[french] {
+		t.Errorf("expected both languages, got %v", found)
+	}
+	if found := ids(SearchOptions{Query: "service", Language: LanguageFrench}); !found[french] || found[english] {
+		t.Errorf("expected only the French memory, got %v", found)
+	}
+	if found := ids(SearchOptions{Query: "service language:en", Mode: QueryString}); !found[english] || found[french] {
+		t.Errorf("expected only the English memory, got %v", found)
+	}
+	if found := ids(SearchOptions{Structured: &QueryNode{Phrase: "service de facturation"}}); !found[french] {
+		t.Errorf("expected the French phrase to match, got %v", found)
+	}
+
+	result, err := db.Search(SearchOptions{Query: "service", Facets: []string{FacetLanguage}})
+	if err != nil || len(result.Facets[FacetLanguage].Terms) != 2 {
+		t.Errorf("expected two languages in the facet, got %+v, %v", result.Facets, err)
+	}
+
+	if _, err := db.Search(SearchOptions{Query: "service", Language: "xx"}); !errors.Is(err, ErrUnknownLanguage) {
+		t.Errorf("expected ErrUnknownLanguage, got %v", err)
+	}
+	if _, err := db.CreateMemory(MemoryInput{Content: "bonjour", Language: "xx"}); !errors.Is(err, ErrUnknownLanguage) {
+		t.Errorf("expected ErrUnknownLanguage, got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Types of links between memories. A link reads as "source <type> target",
+// e.g. a correction supersedes the memory it corrects.
+const (
+	LinkSupersedes  = "supersedes"
+	LinkContradicts = "contradicts"
+	LinkElaborates  = "elaborates"
+	LinkDerivedFrom = "derived_from"
+)
+
+var (
+	// ErrMemoryNotFound is returned when a memory does not exist.
+	ErrMemoryNotFound = errors.New("memory not found")
+	// ErrInvalidLinkType is returned for a link type other than the
+	// Link* constants.
+	ErrInvalidLinkType = errors.New("invalid link type")
+)
+
+// MemoryLink is a typed, directed link between two memories.
+type MemoryLink struct {
+	ID        int64     `json:"id"`
+	SourceID  int64     `json:"source_id"`
+	TargetID  int64     `json:"target_id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LinkedMemory is a memory reached through a link. Direction is DirectionOut
+// when the link starts at the memory it was looked up from and DirectionIn
+// when it ends there.
+type LinkedMemory struct {
+	Link      MemoryLink `json:"link"`
+	Direction string     `json:"direction"`
+	MemoryID  int64      `json:"memory_id"`
+	Content   string     `json:"content"`
+}
+
+func validLinkType(linkType string) bool {
+	switch linkType {
+	case LinkSupersedes, LinkContradicts, LinkElaborates, LinkDerivedFrom:
+		return true
+	}
+	return false
+}
+
+// LinkMemories links the source memory to the target memory. If the same
+// link already exists it is returned unchanged and created is false.
+func (db *DB) LinkMemories(sourceID, targetID int64, linkType string) (link MemoryLink, created bool, err error) {
+	linkType = strings.TrimSpace(linkType)
+	if !validLinkType(linkType) {
+		return MemoryLink{}, false, fmt.Errorf("%w %q", ErrInvalidLinkType, linkType)
+	}
+	if sourceID == targetID {
+		return MemoryLink{}, false, errors.New("a memory cannot link to itself")
+	}
+	for _, id := range []int64{sourceID, targetID} {
+		var exists int
+		if err := db.QueryRow("SELECT 1 FROM memories WHERE id = ?", id).Scan(&exists); err == sql.ErrNoRows {
+			return MemoryLink{}, false, fmt.Errorf("memory %d: %w", id, ErrMemoryNotFound)
+		} else if err != nil {
+			return MemoryLink{}, false, err
+		}
+	}
+
+	result, err := db.Exec("INSERT OR IGNORE INTO memory_links (source_id, target_id, type) VALUES (?, ?, ?)", sourceID, targetID, linkType)
+	if err != nil {
+		return MemoryLink{}, false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return MemoryLink{}, false, err
+	}
+	link, err = scanMemoryLink(db.QueryRow(memoryLinkColumns+" WHERE source_id = ? AND target_id = ? AND type = ?", sourceID, targetID, linkType))
+	return link, n > 0, err
+}
+
+// UnlinkMemories removes the links from the source memory to the target
+// memory. If linkType is empty, links of every type between them are
+// removed. It returns the number of links removed.
+func (db *DB) UnlinkMemories(sourceID, targetID int64, linkType string) (int64, error) {
+	query := "DELETE FROM memory_links WHERE source_id = ? AND target_id = ?"
+	args := []interface{}{sourceID, targetID}
+	if linkType != "" {
+		query += " AND type = ?"
+		args = append(args, linkType)
+	}
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// memoryLinkColumns selects a link in the order scanMemoryLink expects.
+const memoryLinkColumns = "SELECT id, source_id, target_id, type, created_at FROM memory_links"
+
+// GetMemoryLinks retrieves all links between memories.
+func (db *DB) GetMemoryLinks() ([]MemoryLink, error) {
+	rows, err := db.Query(memoryLinkColumns + " ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []MemoryLink
+	for rows.Next() {
+		link, err := scanMemoryLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// GetLinkedMemories lists the memories linked to or from the given memory.
+func (db *DB) GetLinkedMemories(memoryID int64) ([]LinkedMemory, error) {
+	rows, err := db.Query(`SELECT l.id, l.source_id, l.target_id, l.type, l.created_at, m.id, m.content
+		FROM memory_links l
+		JOIN memories m ON m.id = CASE WHEN l.source_id = ? THEN l.target_id ELSE l.source_id END
+		WHERE l.source_id = ? OR l.target_id = ?
+		ORDER BY l.id`, memoryID, memoryID, memoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var linked []LinkedMemory
+	for rows.Next() {
+		var lm LinkedMemory
+		if err := rows.Scan(&lm.Link.ID, &lm.Link.SourceID, &lm.Link.TargetID, &lm.Link.Type, &lm.Link.CreatedAt,
+			&lm.MemoryID, &lm.Content); err != nil {
+			return nil, err
+		}
+		lm.Direction = DirectionIn
+		if lm.Link.SourceID == memoryID {
+			lm.Direction = DirectionOut
+		}
+		linked = append(linked, lm)
+	}
+	return linked, rows.Err()
+}
+
+// supersededDocIDs returns the index document IDs of the memories that
+// another memory supersedes.
+func (db *DB) supersededDocIDs() ([]string, error) {
+	rows, err := db.Query("SELECT DISTINCT target_id FROM memory_links WHERE type = ?", LinkSupersedes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, strconv.FormatInt(id, 10))
+	}
+	return ids, rows.Err()
+}
+
+func scanMemoryLink(row scanner) (MemoryLink, error) {
+	var link MemoryLink
+	err := row.Scan(&link.ID, &link.SourceID, &link.TargetID, &link.Type, &link.CreatedAt)
+	return link, err
+}
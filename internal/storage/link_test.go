@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLinkMemories(t *testing.T) {
+	db := newTestDB(t)
+
+	old, err := db.AddMemory("the deploy window is Friday", nil)
+	if err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+	correction, err := db.AddMemory("the deploy window moved to Thursday", nil)
+	if err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+
+	if _, _, err := db.LinkMemories(correction, old, "replaces"); !errors.Is(err, ErrInvalidLinkType) {
+		t.Errorf("expected ErrInvalidLinkType, got %v", err)
+	}
+	if _, _, err := db.LinkMemories(correction, 999, LinkSupersedes); !errors.Is(err, ErrMemoryNotFound) {
+		t.Errorf("expected ErrMemoryNotFound, got %v", err)
+	}
+	if _, _, err := db.LinkMemories(old, old, LinkElaborates); err == nil {
+		t.Error("expected an error when linking a memory to itself")
+	}
+
+	link, created, err := db.LinkMemories(correction, old, LinkSupersedes)
+	if err != nil || !created {
+		t.Fatalf("failed to link memories: created=%v err=%v", created, err)
+	}
+	if link.SourceID != correction || link.TargetID != old || link.Type != LinkSupersedes {
+		t.Errorf("unexpected link %+v", link)
+	}
+	again, created, err := db.LinkMemories(correction, old, LinkSupersedes)
+	if err != nil || created || again.ID != link.ID {
+		t.Errorf("expected the existing link to be returned, got %+v created=%v err=%v", again, created, err)
+	}
+
+	// The superseded memory is hidden unless history is requested.
+	results, err := db.SearchMemories("deploy window")
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if len(results) != 1 || results[0] != "the deploy window moved to Thursday" {
+		t.Errorf("expected only the correction, got %v", results)
+	}
+	history, err := db.Search(SearchOptions{Query: "deploy window", History: true})
+	if err != nil {
+		t.Fatalf("failed to search with history: %v", err)
+	}
+	if len(history.Hits) != 2 {
+		t.Errorf("expected both memories with history, got %+v", history.Hits)
+	}
+
+	linked, err := db.GetLinkedMemories(old)
+	if err != nil {
+		t.Fatalf("failed to get linked memories: %v", err)
+	}
+	if len(linked) != 1 || linked[0].Direction != DirectionIn || linked[0].MemoryID != correction || linked[0].Content != "the deploy window moved to Thursday" {
+		t.Errorf("unexpected linked memories %+v", linked)
+	}
+	linked, err = db.GetLinkedMemories(correction)
+	if err != nil || len(linked) != 1 || linked[0].Direction != DirectionOut || linked[0].MemoryID != old {
+		t.Errorf("unexpected linked memories %+v (err %v)", linked, err)
+	}
+
+	if n, err := db.UnlinkMemories(correction, old, ""); err != nil || n != 1 {
+		t.Fatalf("expected one link to be removed, got %d (err %v)", n, err)
+	}
+	if results, _ := db.SearchMemories("deploy window"); len(results) != 2 {
+		t.Errorf("expected the old memory to be searchable again, got %v", results)
+	}
+	if links, _ := db.GetMemoryLinks(); len(links) != 0 {
+		t.Errorf("expected no links, got %+v", links)
+	}
+}
@@ -0,0 +1,334 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Sort orders for listing memories.
+const (
+	SortCreated    = "created"
+	SortUpdated    = "updated"
+	SortImportance = "importance"
+)
+
+const (
+	// DefaultImportance is the importance of a memory that was stored
+	// without one.
+	DefaultImportance = 0.5
+	// defaultListLimit and maxListLimit bound the page size of ListMemories.
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// ErrInvalidCursor is returned for a cursor that was not produced by
+// ListMemories with the same sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Memory is a stored memory together with its metadata and access
+// statistics.
+type Memory struct {
+	ID         int64      `json:"id"`
+	Content    string     `json:"content"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+	Source     string     `json:"source,omitempty"`
+	Namespace  string     `json:"namespace,omitempty"`
+	Importance float64    `json:"importance"`
+	Pinned     bool       `json:"pinned,omitempty"`
+	Language   string     `json:"language,omitempty"`
+	Tags       []string   `json:"tags,omitempty"`
+	AccessStats
+}
+
+// MemoryFilter selects memories to list. Zero values match everything.
+type MemoryFilter struct {
+	// Entity restricts the listing to memories mentioning the named entity.
+	Entity string
+	// Since and Until bound the creation time, inclusive and exclusive.
+	Since     time.Time
+	Until     time.Time
+	Tag       string
+	Source    string
+	Namespace string
+}
+
+// ListOptions controls the order and paging of ListMemories.
+type ListOptions struct {
+	// Sort is SortCreated, SortUpdated or SortImportance; it defaults to
+	// SortCreated.
+	Sort string
+	// Ascending lists the oldest or least important memories first.
+	Ascending bool
+	// Limit is the page size; it defaults to 50 and is capped at 500.
+	Limit int
+	// Cursor continues a previous listing where its page ended.
+	Cursor string
+}
+
+// MemoryPage is one page of a memory listing. NextCursor is empty on the
+// last page.
+type MemoryPage struct {
+	Memories   []Memory `json:"memories"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+// memoryCursor is the position after the last memory of a page: its sort
+// key and ID. Listing resumes strictly after it, so memories written in the
+// meantime never shift later pages.
+type memoryCursor struct {
+	Sort      string  `json:"s"`
+	Ascending bool    `json:"a,omitempty"`
+	Time      string  `json:"t,omitempty"`
+	Number    float64 `json:"n,omitempty"`
+	ID        int64   `json:"i"`
+}
+
+func (c memoryCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeMemoryCursor(s string) (memoryCursor, error) {
+	var c memoryCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// sortExpressions maps a sort order to the SQL expression it sorts by.
+var sortExpressions = map[string]string{
+	SortCreated:    "m.created_at",
+	SortUpdated:    "COALESCE(m.updated_at, m.created_at)",
+	SortImportance: "m.importance",
+}
+
+// memoryColumns selects a memory in the order scanMemory expects.
+const memoryColumns = `SELECT m.id, m.content, m.created_at, m.updated_at, m.source, m.namespace, m.importance,
+	m.pinned, m.language, m.access_count, m.last_accessed_at FROM memories m`
+
+// GetMemoryRecord returns a memory with its metadata and access statistics.
+func (db *DB) GetMemoryRecord(id int64) (Memory, error) {
+	memories, err := db.queryMemories(memoryColumns+" WHERE m.id = ?", id)
+	if err != nil {
+		return Memory{}, err
+	}
+	if len(memories) == 0 {
+		return Memory{}, sql.ErrNoRows
+	}
+	return memories[0], nil
+}
+
+// SetPinned pins or unpins a memory. It returns sql.ErrNoRows if the memory
+// does not exist.
+func (db *DB) SetPinned(id int64, pinned bool) error {
+	result, err := db.Exec("UPDATE memories SET pinned = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", pinned, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CountMemories returns the number of memories in the given namespace.
+func (db *DB) CountMemories(namespace string) (int64, error) {
+	var n int64
+	err := db.QueryRow("SELECT COUNT(*) FROM memories WHERE namespace = ?", strings.TrimSpace(namespace)).Scan(&n)
+	return n, err
+}
+
+// ListMemories lists the memories matching filter, one page at a time.
+func (db *DB) ListMemories(filter MemoryFilter, opts ListOptions) (MemoryPage, error) {
+	if opts.Sort == "" {
+		opts.Sort = SortCreated
+	}
+	sortExpr, ok := sortExpressions[opts.Sort]
+	if !ok {
+		return MemoryPage{}, fmt.Errorf("invalid sort %q", opts.Sort)
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = defaultListLimit
+	}
+	if opts.Limit > maxListLimit {
+		opts.Limit = maxListLimit
+	}
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	if filter.Entity != "" {
+		entityID, err := findEntity(db, filter.Entity)
+		if err != nil {
+			return MemoryPage{}, err
+		}
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM memory_entities me WHERE me.memory_id = m.id AND me.entity_id = ?)")
+		args = append(args, entityID)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "m.created_at >= ?")
+		args = append(args, formatTime(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "m.created_at < ?")
+		args = append(args, formatTime(filter.Until))
+	}
+	if filter.Tag != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM memory_tags mt WHERE mt.memory_id = m.id AND mt.tag = ?)")
+		args = append(args, normalizeTag(filter.Tag))
+	}
+	if filter.Source != "" {
+		conditions = append(conditions, "m.source = ?")
+		args = append(args, filter.Source)
+	}
+	if filter.Namespace != "" {
+		conditions = append(conditions, "m.namespace = ?")
+		args = append(args, filter.Namespace)
+	}
+
+	direction, cmp := "DESC", "<"
+	if opts.Ascending {
+		direction, cmp = "ASC", ">"
+	}
+	if opts.Cursor != "" {
+		cursor, err := decodeMemoryCursor(opts.Cursor)
+		if err != nil {
+			return MemoryPage{}, err
+		}
+		if cursor.Sort != opts.Sort || cursor.Ascending != opts.Ascending {
+			return MemoryPage{}, fmt.Errorf("%w: it was issued for a different sort order", ErrInvalidCursor)
+		}
+		var key interface{} = cursor.Time
+		if opts.Sort == SortImportance {
+			key = cursor.Number
+		}
+		conditions = append(conditions, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND m.id %[2]s ?))", sortExpr, cmp))
+		args = append(args, key, key, cursor.ID)
+	}
+
+	query := memoryColumns
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	// One extra row tells whether another page follows.
+	query += fmt.Sprintf(" ORDER BY %s %s, m.id %s LIMIT ?", sortExpr, direction, direction)
+	args = append(args, opts.Limit+1)
+
+	memories, err := db.queryMemories(query, args...)
+	if err != nil {
+		return MemoryPage{}, err
+	}
+	page := MemoryPage{Memories: memories}
+	if len(memories) > opts.Limit {
+		page.Memories = memories[:opts.Limit]
+		last := page.Memories[opts.Limit-1]
+		cursor := memoryCursor{Sort: opts.Sort, Ascending: opts.Ascending, ID: last.ID}
+		switch opts.Sort {
+		case SortCreated:
+			cursor.Time = formatTime(last.CreatedAt)
+		case SortUpdated:
+			cursor.Time = formatTime(last.CreatedAt)
+			if last.UpdatedAt != nil {
+				cursor.Time = formatTime(*last.UpdatedAt)
+			}
+		case SortImportance:
+			cursor.Number = last.Importance
+		}
+		page.NextCursor = cursor.encode()
+	}
+	if page.Memories == nil {
+		page.Memories = []Memory{}
+	}
+	return page, nil
+}
+
+// queryMemories runs a query selecting memoryColumns and fills in the tags
+// and unwritten accesses of the memories it returns.
+func (db *DB) queryMemories(query string, args ...interface{}) ([]Memory, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var memories []Memory
+	for rows.Next() {
+		memory, err := scanMemory(rows)
+		if err != nil {
+			return nil, err
+		}
+		memory.AccessStats = db.withPending(memory.ID, memory.AccessStats)
+		memories = append(memories, memory)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err := db.loadTags(memories); err != nil {
+		return nil, err
+	}
+	return memories, nil
+}
+
+func (db *DB) loadTags(memories []Memory) error {
+	if len(memories) == 0 {
+		return nil
+	}
+	index := make(map[int64]int, len(memories))
+	ids := make([]int64, len(memories))
+	for i, memory := range memories {
+		index[memory.ID] = i
+		ids[i] = memory.ID
+	}
+	placeholders, args := inList(ids)
+	rows, err := db.Query("SELECT memory_id, tag FROM memory_tags WHERE memory_id IN ("+placeholders+") ORDER BY tag", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id  int64
+			tag string
+		)
+		if err := rows.Scan(&id, &tag); err != nil {
+			return err
+		}
+		i := index[id]
+		memories[i].Tags = append(memories[i].Tags, tag)
+	}
+	return rows.Err()
+}
+
+func scanMemory(row scanner) (Memory, error) {
+	var (
+		memory                Memory
+		updated, lastAccessed sql.NullTime
+	)
+	if err := row.Scan(&memory.ID, &memory.Content, &memory.CreatedAt, &updated, &memory.Source, &memory.Namespace,
+		&memory.Importance, &memory.Pinned, &memory.Language, &memory.AccessCount, &lastAccessed); err != nil {
+		return Memory{}, err
+	}
+	memory.UpdatedAt = nullableTime(updated)
+	memory.LastAccessedAt = nullableTime(lastAccessed)
+	return memory, nil
+}
+
+// normalizeTag lower-cases a tag and trims surrounding whitespace.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
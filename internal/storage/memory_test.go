@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func importance(v float64) *float64 { return &v }
+
+func TestListMemoriesFilters(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, in := range []MemoryInput{
+		{Content: "billing uses stripe", Entities: []string{"billing"}, Tags: []string{"Payments"}, Source: "chat", Namespace: "work"},
+		{Content: "gym on tuesdays", Tags: []string{"health"}, Source: "import", Namespace: "home"},
+		{Content: "billing retries twice", Entities: []string{"Billing"}, Source: "chat", Namespace: "work", Importance: importance(0.9)},
+	} {
+		if _, err := db.CreateMemory(in); err != nil {
+			t.Fatalf("failed to create memory: %v", err)
+		}
+	}
+	if _, err := db.CreateMemory(MemoryInput{Content: "bad", Importance: importance(2)}); err == nil {
+		t.Error("expected an error for importance above 1")
+	}
+
+	tests := []struct {
+		name   string
+		filter MemoryFilter
+		want   int
+	}{
+		{"all", MemoryFilter{}, 3},
+		{"entity", MemoryFilter{Entity: "BILLING"}, 2},
+		{"tag", MemoryFilter{Tag: "payments"}, 1},
+		{"source", MemoryFilter{Source: "import"}, 1},
+		{"namespace", MemoryFilter{Namespace: "work"}, 2},
+		{"since", MemoryFilter{Since: time.Now().Add(time.Hour)}, 0},
+		{"until", MemoryFilter{Until: time.Now().Add(time.Hour)}, 3},
+	}
+	for _, tt := range tests {
+		page, err := db.ListMemories(tt.filter, ListOptions{})
+		if err != nil {
+			t.Fatalf("%s: failed to list memories: %v", tt.name, err)
+		}
+		if len(page.Memories) != tt.want {
+			t.Errorf("%s: expected %d memories, got %d", tt.name, tt.want, len(page.Memories))
+		}
+	}
+
+	page, err := db.ListMemories(MemoryFilter{Tag: "payments"}, ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list memories: %v", err)
+	}
+	m := page.Memories[0]
+	if m.Source != "chat" || m.Namespace != "work" || m.Importance != DefaultImportance || len(m.Tags) != 1 || m.Tags[0] != "payments" {
+		t.Errorf("unexpected memory metadata %+v", m)
+	}
+
+	page, err = db.ListMemories(MemoryFilter{}, ListOptions{Sort: SortImportance})
+	if err != nil {
+		t.Fatalf("failed to list by importance: %v", err)
+	}
+	if page.Memories[0].Content != "billing retries twice" {
+		t.Errorf("expected the most important memory first, got %+v", page.Memories[0])
+	}
+	if _, err := db.ListMemories(MemoryFilter{}, ListOptions{Sort: "size"}); err == nil {
+		t.Error("expected an error for an unknown sort")
+	}
+}
+
+func TestListMemoriesSortUpdated(t *testing.T) {
+	db := newTestDB(t)
+
+	var ids []int64
+	for i, in := range []MemoryInput{
+		{Content: "standup moved to ten"},
+		{Content: "pg needs a vacuum", Entities: []string{"pg"}},
+		{Content: "redis is a cache", Entities: []string{"redis"}},
+	} {
+		id, err := db.CreateMemory(in)
+		if err != nil {
+			t.Fatalf("failed to create memory: %v", err)
+		}
+		created := time.Date(2020, 1, i+1, 0, 0, 0, 0, time.UTC)
+		if _, err := db.Exec("UPDATE memories SET created_at = ? WHERE id = ?", formatTime(created), id); err != nil {
+			t.Fatalf("failed to backdate memory: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if _, err := db.CreateEntity("postgres", ""); err != nil {
+		t.Fatalf("failed to create entity: %v", err)
+	}
+
+	// Pinning the first memory and merging the entity of the second one
+	// update them; the third is untouched.
+	if err := db.SetPinned(ids[0], true); err != nil {
+		t.Fatalf("failed to pin memory: %v", err)
+	}
+	if _, err := db.MergeEntities("pg", "postgres"); err != nil {
+		t.Fatalf("failed to merge entities: %v", err)
+	}
+	order := func(sort string) []int64 {
+		t.Helper()
+		page, err := db.ListMemories(MemoryFilter{}, ListOptions{Sort: sort})
+		if err != nil {
+			t.Fatalf("failed to list by %s: %v", sort, err)
+		}
+		var got []int64
+		for _, m := range page.Memories {
+			if (m.UpdatedAt != nil) != (m.ID != ids[2]) {
+				t.Errorf("memory %d: unexpected updated_at %v", m.ID, m.UpdatedAt)
+			}
+			got = append(got, m.ID)
+		}
+		return got
+	}
+	if got, want := order(SortCreated), []int64{ids[2], ids[1], ids[0]}; !slices.Equal(got, want) {
+		t.Errorf("expected memories by creation %v, got %v", want, got)
+	}
+	if got, want := order(SortUpdated), []int64{ids[1], ids[0], ids[2]}; !slices.Equal(got, want) {
+		t.Errorf("expected memories by update %v, got %v", want, got)
+	}
+
+	if err := db.DeleteEntity("redis"); err != nil {
+		t.Fatalf("failed to delete entity: %v", err)
+	}
+	if memory, err := db.GetMemoryRecord(ids[2]); err != nil || memory.UpdatedAt == nil {
+		t.Errorf("expected deleting its entity to update the memory, got %+v, %v", memory, err)
+	}
+}
+
+func TestListMemoriesCursor(t *testing.T) {
+	db := newTestDB(t)
+
+	// Memories created within the same second share a sort key, so paging
+	// must also order by ID.
+	for i := 0; i < 5; i++ {
+		if _, err := db.AddMemory("memory", nil); err != nil {
+			t.Fatalf("failed to add memory: %v", err)
+		}
+	}
+
+	var seen []int64
+	page, err := db.ListMemories(MemoryFilter{}, ListOptions{Limit: 2})
+	for {
+		if err != nil {
+			t.Fatalf("failed to list memories: %v", err)
+		}
+		for _, m := range page.Memories {
+			seen = append(seen, m.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		// New memories must not shift the pages still to come.
+		if _, err := db.AddMemory("written while paging", nil); err != nil {
+			t.Fatalf("failed to add memory: %v", err)
+		}
+		page, err = db.ListMemories(MemoryFilter{}, ListOptions{Limit: 2, Cursor: page.NextCursor})
+	}
+	want := []int64{5, 4, 3, 2, 1}
+	if len(seen) != len(want) {
+		t.Fatalf("expected memories %v, got %v", want, seen)
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Fatalf("expected memories %v, got %v", want, seen)
+		}
+	}
+
+	first, err := db.ListMemories(MemoryFilter{}, ListOptions{Limit: 1})
+	if err != nil {
+		t.Fatalf("failed to list memories: %v", err)
+	}
+	if _, err := db.ListMemories(MemoryFilter{}, ListOptions{Limit: 1, Ascending: true, Cursor: first.NextCursor}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor for a cursor from another order, got %v", err)
+	}
+	if _, err := db.ListMemories(MemoryFilter{}, ListOptions{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor for garbage, got %v", err)
+	}
+}
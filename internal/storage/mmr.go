@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/wassmi/nodimus-memory/internal/embed"
+)
+
+// ErrInvalidMMR is returned for an MMR lambda outside [0, 1].
+var ErrInvalidMMR = errors.New("invalid MMR lambda")
+
+// diversify re-ranks hits by maximal marginal relevance and returns the
+// first n: each pick maximizes lambda times its relevance minus 1-lambda
+// times its similarity to the hits picked before it. Relevance is the hit
+// score scaled to [0, 1]. Memories are compared by their embeddings when
+// every hit has them, and by the overlap of their term vectors otherwise.
+func (db *DB) diversify(hits []SearchHit, lambda float64, n int) ([]SearchHit, error) {
+	if len(hits) < 2 {
+		return hits, nil
+	}
+	similarity, err := db.hitSimilarity(hits)
+	if err != nil {
+		return nil, err
+	}
+	relevance := normalizer(len(hits), func(i int) float64 { return hits[i].Score })
+
+	picked := make([]bool, len(hits))
+	// closest is the highest similarity of each hit to a picked hit.
+	closest := make([]float64, len(hits))
+	ranked := make([]SearchHit, 0, min(n, len(hits)))
+	for len(ranked) < cap(ranked) {
+		best, bestScore := -1, math.Inf(-1)
+		for i, hit := range hits {
+			if picked[i] {
+				continue
+			}
+			score := lambda*relevance(hit.Score) - (1-lambda)*closest[i]
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		picked[best] = true
+		ranked = append(ranked, hits[best])
+		for i := range hits {
+			if !picked[i] {
+				closest[i] = max(closest[i], similarity(best, i))
+			}
+		}
+	}
+	return ranked, nil
+}
+
+// hitSimilarity returns a function giving the similarity in [0, 1] of two
+// hits by their index.
+func (db *DB) hitSimilarity(hits []SearchHit) (func(a, b int) float64, error) {
+	if db.embedder != nil {
+		ids := make([]int64, len(hits))
+		for i, hit := range hits {
+			ids[i] = hit.ID
+		}
+		vectors, err := db.memoryVectors(ids)
+		if err != nil {
+			return nil, err
+		}
+		if len(vectors) == len(hits) {
+			return func(a, b int) float64 {
+				return max(0, embed.Cosine(vectors[hits[a].ID], vectors[hits[b].ID]))
+			}, nil
+		}
+	}
+	terms := make([]map[string]float64, len(hits))
+	for i, hit := range hits {
+		terms[i] = termVector(hit.Content)
+	}
+	return func(a, b int) float64 { return termCosine(terms[a], terms[b]) }, nil
+}
+
+// memoryVectors returns the mean of the chunk vectors of each of the given
+// memories that has embeddings from the current embedder.
+func (db *DB) memoryVectors(ids []int64) (map[int64][]float32, error) {
+	placeholders, args := inList(ids)
+	rows, err := db.Query("SELECT memory_id, vector FROM memory_embeddings WHERE model = ? AND memory_id IN ("+placeholders+")",
+		append([]interface{}{db.embedder.Model()}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	vectors := map[int64][]float32{}
+	for rows.Next() {
+		var (
+			id   int64
+			blob []byte
+		)
+		if err := rows.Scan(&id, &blob); err != nil {
+			return nil, err
+		}
+		v, err := decodeVector(blob)
+		if err != nil {
+			return nil, fmt.Errorf("memory %d: %w", id, err)
+		}
+		// Chunk vectors have unit length, so their sum points the same way
+		// as their mean.
+		sum, ok := vectors[id]
+		if !ok {
+			vectors[id] = v
+			continue
+		}
+		for i := range sum {
+			if i < len(v) {
+				sum[i] += v[i]
+			}
+		}
+	}
+	return vectors, rows.Err()
+}
+
+// termVector counts the lower-cased words of content.
+func termVector(content string) map[string]float64 {
+	terms := map[string]float64{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(content), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		terms[word]++
+	}
+	return terms
+}
+
+// termCosine is the cosine similarity of two term vectors.
+func termCosine(a, b map[string]float64) float64 {
+	var dot, na, nb float64
+	for term, x := range a {
+		dot += x * b[term]
+		na += x * x
+	}
+	for _, y := range b {
+		nb += y * y
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
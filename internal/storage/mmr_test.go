@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiversify(t *testing.T) {
+	db := newTestDB(t)
+	hits := []SearchHit{
+		{Memory: Memory{ID: 1, Content: "the staging deploy failed"}, Score: 1},
+		{Memory: Memory{ID: 2, Content: "the staging deploy failed again"}, Score: 0.95},
+		{Memory: Memory{ID: 3, Content: "production rollback worked"}, Score: 0.9},
+	}
+	order := func(lambda float64) []int64 {
+		t.Helper()
+		ranked, err := db.diversify(hits, lambda, 3)
+		if err != nil {
+			t.Fatalf("failed to diversify: %v", err)
+		}
+		ids := make([]int64, len(ranked))
+		for i, hit := range ranked {
+			ids[i] = hit.ID
+		}
+		return ids
+	}
+	if got := order(1); got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("expected a lambda of 1 to keep the ranking, got %v", got)
+	}
+	if got := order(0.5); got[0] != 1 || got[1] != 3 || got[2] != 2 {
+		t.Errorf("expected the near-duplicate to drop below the distinct memory, got %v", got)
+	}
+	if ranked, _ := db.diversify(hits, 0.5, 2); len(ranked) != 2 {
+		t.Errorf("expected two hits, got %d", len(ranked))
+	}
+}
+
+func TestSearchMMR(t *testing.T) {
+	db := newSemanticDB(t, filepath.Join(t.TempDir(), "test.db"), 256)
+	defer db.Close()
+	for _, content := range []string{
+		"the staging deploy failed on monday",
+		"the staging deploy failed on tuesday",
+		"the staging deploy failed on wednesday",
+		"deploy to production is frozen until friday",
+	} {
+		if _, err := db.CreateMemory(MemoryInput{Content: content}); err != nil {
+			t.Fatalf("failed to create memory: %v", err)
+		}
+	}
+
+	result, err := db.Search(SearchOptions{Query: "staging deploy failed", Mode: QuerySemantic, MMRLambda: 0.3})
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if len(result.Hits) != 4 || result.Hits[1].ID != 4 {
+		t.Errorf("expected the production memory second among the near-duplicates, got %+v", result.Hits)
+	}
+	if _, err := db.Search(SearchOptions{Query: "deploy", MMRLambda: 1.5}); !errors.Is(err, ErrInvalidMMR) {
+		t.Errorf("expected ErrInvalidMMR, got %v", err)
+	}
+}
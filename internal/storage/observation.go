@@ -0,0 +1,429 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/lang/en"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// observationDocPrefix distinguishes observation documents from memory
+// documents in the bleve index.
+const observationDocPrefix = "obs-"
+
+// Observation is an atomic fact attached to an entity, such as
+// "prefers tabs".
+type Observation struct {
+	ID        int64      `json:"id"`
+	EntityID  int64      `json:"entity_id"`
+	Content   string     `json:"content"`
+	CreatedAt time.Time  `json:"created_at"`
+	ValidFrom *time.Time `json:"valid_from,omitempty"`
+	ValidTo   *time.Time `json:"valid_to,omitempty"`
+}
+
+// observationColumns selects an observation in the order scanObservation
+// expects.
+const observationColumns = "SELECT o.id, o.entity_id, o.content, o.created_at, o.valid_from, o.valid_to FROM entity_observations o"
+
+// observationDocument is what gets indexed in bleve for an observation.
+type observationDocument struct {
+	Type    string `json:"type"`
+	Entity  string `json:"entity"`
+	Content string `json:"content"`
+}
+
+// BleveType selects the observation document mapping.
+func (observationDocument) BleveType() string { return "observation" }
+
+func observationDocID(id int64) string {
+	return observationDocPrefix + strconv.FormatInt(id, 10)
+}
+
+// CreateEntity returns the entity with the given name, creating it when it
+// does not exist. An entity whose type is still "unknown" takes entityType.
+func (db *DB) CreateEntity(name, entityType string) (Entity, error) {
+	if NormalizeEntityName(name) == "" {
+		return Entity{}, fmt.Errorf("entity name must not be empty")
+	}
+	id, err := findOrCreateEntity(db, name)
+	if err != nil {
+		return Entity{}, err
+	}
+	if entityType != "" {
+		if _, err := db.Exec("UPDATE entities SET type = ? WHERE id = ? AND type = 'unknown'", entityType, id); err != nil {
+			return Entity{}, err
+		}
+	}
+	return db.getEntityByID(id)
+}
+
+// DeleteEntity removes an entity together with its aliases, observations,
+// relationships and memory links. The memories themselves are kept.
+func (db *DB) DeleteEntity(name string) error {
+	id, err := findEntity(db, name)
+	if err != nil {
+		return err
+	}
+	observationIDs, err := db.queryIDs("SELECT id FROM entity_observations WHERE entity_id = ?", id)
+	if err != nil {
+		return err
+	}
+	memoryIDs, err := db.entityMemoryIDs(id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range []string{
+		"DELETE FROM entity_observations WHERE entity_id = ?",
+		"DELETE FROM entity_aliases WHERE entity_id = ?",
+		"UPDATE memories SET updated_at = CURRENT_TIMESTAMP WHERE id IN (SELECT memory_id FROM memory_entities WHERE entity_id = ?)",
+		"DELETE FROM memory_entities WHERE entity_id = ?",
+		"DELETE FROM relationships WHERE source_id = ?1 OR target_id = ?1",
+		"DELETE FROM entities WHERE id = ?",
+	} {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if err := db.unindexObservations(observationIDs); err != nil {
+		return err
+	}
+	return db.reindexMemories(memoryIDs)
+}
+
+// AddObservations attaches observations to the named entity, valid from now
+// on. Observations that currently hold for the entity are skipped; the ones
+// actually added are returned.
+func (db *DB) AddObservations(entityName string, contents []string) ([]Observation, error) {
+	entityID, err := findEntity(db, entityName)
+	if err != nil {
+		return nil, err
+	}
+	entity, err := db.getEntityByID(entityID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for _, content := range contents {
+		content = strings.TrimSpace(content)
+		if content == "" {
+			continue
+		}
+		result, err := tx.Exec("INSERT OR IGNORE INTO entity_observations (entity_id, content, valid_from) VALUES (?, ?, CURRENT_TIMESTAMP)", entityID, content)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			tx.Rollback()
+			return nil, err
+		} else if n == 0 {
+			continue
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	var added []Observation
+	batch := db.index.NewBatch()
+	for _, id := range ids {
+		observation, err := scanObservation(tx.QueryRow(observationColumns+" WHERE o.id = ?", id))
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		added = append(added, observation)
+		if err := batch.Index(observationDocID(id), observationDocument{Type: "observation", Entity: entity.Name, Content: observation.Content}); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to index observation: %w", err)
+		}
+	}
+	// As with memories, the observations are indexed before the commit and
+	// removed from the index again if it fails.
+	if err := db.index.Batch(batch); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to index observations: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Join(err, db.unindexObservations(ids))
+	}
+	return added, nil
+}
+
+// DeleteObservations removes the given observations, including their
+// history, from the named entity and returns how many were deleted. Contents
+// that do not match an observation are ignored. Use ExpireObservations to
+// record that a fact stopped holding instead.
+func (db *DB) DeleteObservations(entityName string, contents []string) (int64, error) {
+	entityID, err := findEntity(db, entityName)
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []int64
+	for _, content := range contents {
+		matched, err := db.queryIDs("SELECT id FROM entity_observations WHERE entity_id = ? AND content = ?", entityID, strings.TrimSpace(content))
+		if err != nil {
+			return 0, err
+		}
+		ids = append(ids, matched...)
+	}
+	for _, id := range ids {
+		if _, err := db.Exec("DELETE FROM entity_observations WHERE id = ?", id); err != nil {
+			return 0, err
+		}
+	}
+	if err := db.unindexObservations(ids); err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
+
+// ExpireObservations records that the given observations of the named
+// entity stopped holding at the given time, or now when at is zero. It
+// returns how many observations were closed.
+func (db *DB) ExpireObservations(entityName string, contents []string, at time.Time) (int64, error) {
+	entityID, err := findEntity(db, entityName)
+	if err != nil {
+		return 0, err
+	}
+	var closed int64
+	for _, content := range contents {
+		result, err := db.Exec("UPDATE entity_observations SET valid_to = ? WHERE entity_id = ? AND content = ? AND valid_to IS NULL",
+			formatTime(timeOrNow(at)), entityID, strings.TrimSpace(content))
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		closed += n
+	}
+	return closed, nil
+}
+
+// ListObservations lists the observations of the named entity that held at
+// asOf, or that hold now when asOf is zero, oldest first.
+func (db *DB) ListObservations(entityName string, asOf time.Time) ([]Observation, error) {
+	entityID, err := findEntity(db, entityName)
+	if err != nil {
+		return nil, err
+	}
+	cond, args := validAt("o", asOf)
+	return db.queryObservations(observationColumns+" WHERE o.entity_id = ? AND "+cond+" ORDER BY o.id", append([]interface{}{entityID}, args...)...)
+}
+
+// GetObservations retrieves all observations that currently hold.
+func (db *DB) GetObservations() ([]Observation, error) {
+	return db.GetObservationsAsOf(time.Time{})
+}
+
+// GetObservationsAsOf retrieves all observations that held at asOf.
+func (db *DB) GetObservationsAsOf(asOf time.Time) ([]Observation, error) {
+	cond, args := validAt("o", asOf)
+	return db.queryObservations(observationColumns+" WHERE "+cond+" ORDER BY o.id", args...)
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, with the backslash
+// as escape character.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// SearchNodes finds entities whose name, type or aliases contain the query,
+// or whose current observations match it in the search index.
+func (db *DB) SearchNodes(queryText string) ([]Entity, error) {
+	pattern := "%" + likeEscaper.Replace(strings.ToLower(strings.TrimSpace(queryText))) + "%"
+	rows, err := db.Query(`SELECT DISTINCT e.id FROM entities e
+		LEFT JOIN entity_aliases a ON a.entity_id = e.id
+		WHERE lower(e.name) LIKE ?1 ESCAPE '\' OR lower(e.type) LIKE ?1 ESCAPE '\' OR a.norm_alias LIKE ?1 ESCAPE '\'`, pattern)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[int64]bool)
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	match := bleve.NewMatchQuery(queryText)
+	match.SetField("content")
+	match.Analyzer = en.AnalyzerName
+	typeQuery := bleve.NewTermQuery("observation")
+	typeQuery.SetField("type")
+	searchRequest := bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(match, typeQuery), 100, 0, false)
+	searchResult, err := db.index.Search(searchRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search index: %w", err)
+	}
+	for _, hit := range searchResult.Hits {
+		observationID, err := strconv.ParseInt(strings.TrimPrefix(hit.ID, observationDocPrefix), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse observation ID: %w", err)
+		}
+		var entityID int64
+		cond, args := validAt("o", time.Time{})
+		err = db.QueryRow("SELECT o.entity_id FROM entity_observations o WHERE o.id = ? AND "+cond,
+			append([]interface{}{observationID}, args...)...).Scan(&entityID)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !seen[entityID] {
+			seen[entityID] = true
+			ids = append(ids, entityID)
+		}
+	}
+
+	entities := make([]Entity, 0, len(ids))
+	for _, id := range ids {
+		entity, err := db.getEntityByID(id)
+		if err != nil {
+			return nil, err
+		}
+		entities = append(entities, entity)
+	}
+	return entities, nil
+}
+
+// memoryDocumentsOnly restricts a query to memory documents, excluding the
+// observations that share the index.
+func memoryDocumentsOnly(q query.Query) query.Query {
+	observations := bleve.NewTermQuery("observation")
+	observations.SetField("type")
+	boolean := bleve.NewBooleanQuery()
+	boolean.AddMust(q)
+	boolean.AddMustNot(observations)
+	return boolean
+}
+
+func (db *DB) getObservation(id int64) (Observation, error) {
+	return scanObservation(db.QueryRow(observationColumns+" WHERE o.id = ?", id))
+}
+
+func scanObservation(row scanner) (Observation, error) {
+	var (
+		observation        Observation
+		validFrom, validTo sql.NullTime
+	)
+	if err := row.Scan(&observation.ID, &observation.EntityID, &observation.Content, &observation.CreatedAt, &validFrom, &validTo); err != nil {
+		return Observation{}, err
+	}
+	observation.ValidFrom = nullableTime(validFrom)
+	observation.ValidTo = nullableTime(validTo)
+	return observation, nil
+}
+
+func (db *DB) queryObservations(query string, args ...interface{}) ([]Observation, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var observations []Observation
+	for rows.Next() {
+		observation, err := scanObservation(rows)
+		if err != nil {
+			return nil, err
+		}
+		observations = append(observations, observation)
+	}
+	return observations, rows.Err()
+}
+
+func (db *DB) queryIDs(query string, args ...interface{}) ([]int64, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// entityObservationIDs lists the observation IDs of the named entity, or
+// none if the entity does not exist.
+func (db *DB) entityObservationIDs(entityName string) ([]int64, error) {
+	entityID, err := findEntity(db, entityName)
+	if err == ErrEntityNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return db.queryIDs("SELECT id FROM entity_observations WHERE entity_id = ?", entityID)
+}
+
+// reindexObservations writes all observations of an entity to the index.
+func (db *DB) reindexObservations(entity Entity) error {
+	observations, err := db.queryObservations(observationColumns+" WHERE o.entity_id = ?", entity.ID)
+	if err != nil {
+		return err
+	}
+	batch := db.index.NewBatch()
+	for _, observation := range observations {
+		if err := batch.Index(observationDocID(observation.ID), observationDocument{Type: "observation", Entity: entity.Name, Content: observation.Content}); err != nil {
+			return fmt.Errorf("failed to index observation: %w", err)
+		}
+	}
+	if err := db.index.Batch(batch); err != nil {
+		return fmt.Errorf("failed to index observations: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) unindexObservations(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	batch := db.index.NewBatch()
+	for _, id := range ids {
+		batch.Delete(observationDocID(id))
+	}
+	if err := db.index.Batch(batch); err != nil {
+		return fmt.Errorf("failed to remove observations from index: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+func TestObservations(t *testing.T) {
+	db := newTestDB(t)
+
+	if _, err := db.AddObservations("Alice", []string{"prefers tabs"}); err != ErrEntityNotFound {
+		t.Fatalf("expected ErrEntityNotFound for a missing entity, got %v", err)
+	}
+
+	alice, err := db.CreateEntity("Alice", "person")
+	if err != nil {
+		t.Fatalf("failed to create entity: %v", err)
+	}
+	if alice.Type != "person" {
+		t.Errorf("expected type person, got %s", alice.Type)
+	}
+	if _, err := db.AddMemory("Alice reviewed the indentation rules", []string{"Alice"}); err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+
+	added, err := db.AddObservations("alice", []string{"prefers tabs", "works on billing", "prefers tabs"})
+	if err != nil {
+		t.Fatalf("failed to add observations: %v", err)
+	}
+	if len(added) != 2 {
+		t.Fatalf("expected 2 observations to be added, got %d", len(added))
+	}
+
+	entities, err := db.SearchNodes("tabs")
+	if err != nil {
+		t.Fatalf("failed to search nodes: %v", err)
+	}
+	if len(entities) != 1 || entities[0].ID != alice.ID {
+		t.Errorf("expected to find Alice through her observation, got %+v", entities)
+	}
+
+	// Observations share the index with memories but must not show up as
+	// memory search results.
+	memories, err := db.SearchMemories("tabs")
+	if err != nil {
+		t.Fatalf("failed to search memories: %v", err)
+	}
+	if len(memories) != 0 {
+		t.Errorf("expected no memory results, got %v", memories)
+	}
+
+	deleted, err := db.DeleteObservations("Alice", []string{"prefers tabs", "unknown"})
+	if err != nil {
+		t.Fatalf("failed to delete observations: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("expected 1 observation deleted, got %d", deleted)
+	}
+	observations, err := db.ListObservations("Alice", time.Time{})
+	if err != nil {
+		t.Fatalf("failed to list observations: %v", err)
+	}
+	if len(observations) != 1 || observations[0].Content != "works on billing" {
+		t.Errorf("unexpected observations %+v", observations)
+	}
+	if entities, err := db.SearchNodes("tabs"); err != nil || len(entities) != 0 {
+		t.Errorf("expected deleted observation to be unsearchable, got %+v %v", entities, err)
+	}
+
+	if err := db.DeleteEntity("Alice"); err != nil {
+		t.Fatalf("failed to delete entity: %v", err)
+	}
+	if _, err := db.GetEntity("Alice"); err != ErrEntityNotFound {
+		t.Errorf("expected entity to be gone, got %v", err)
+	}
+	if all, err := db.GetObservations(); err != nil || len(all) != 0 {
+		t.Errorf("expected observations to be deleted with the entity, got %+v %v", all, err)
+	}
+}
+
+func TestSearchNodesWildcards(t *testing.T) {
+	db := newTestDB(t)
+	for _, name := range []string{"user_id", "userXid", "100% coverage", "100 coverage"} {
+		if _, err := db.CreateEntity(name, ""); err != nil {
+			t.Fatalf("failed to create entity: %v", err)
+		}
+	}
+	for query, want := range map[string]string{"user_id": "user_id", "100%": "100% coverage"} {
+		entities, err := db.SearchNodes(query)
+		if err != nil {
+			t.Fatalf("failed to search nodes: %v", err)
+		}
+		if len(entities) != 1 || entities[0].Name != want {
+			t.Errorf("%q: expected only %s, got %+v", query, want, entities)
+		}
+	}
+}
+
+func TestAddObservationsFailure(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.CreateEntity("Alice", "person"); err != nil {
+		t.Fatalf("failed to create entity: %v", err)
+	}
+
+	// An observation that cannot be indexed is not stored either.
+	closed, err := bleve.NewMemOnly(indexMapping())
+	if err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	closed.Close()
+	index := db.index
+	db.index = closed
+	_, err = db.AddObservations("Alice", []string{"prefers tabs"})
+	db.index = index
+	if err == nil {
+		t.Fatal("expected indexing to fail")
+	}
+	if observations, err := db.ListObservations("Alice", time.Time{}); err != nil || len(observations) != 0 {
+		t.Errorf("expected no observations, got %+v (err %v)", observations, err)
+	}
+
+	count, err := db.index.DocCount()
+	if err != nil {
+		t.Fatalf("failed to count documents: %v", err)
+	}
+	// A deferred foreign key that every new observation violates makes the
+	// commit fail after the observation was written and indexed.
+	db.SetMaxOpenConns(1)
+	for _, stmt := range []string{
+		"PRAGMA foreign_keys = ON",
+		"CREATE TABLE parents (id INTEGER PRIMARY KEY)",
+		"CREATE TABLE orphans (parent_id INTEGER REFERENCES parents(id) DEFERRABLE INITIALLY DEFERRED)",
+		"CREATE TRIGGER orphan_observations AFTER INSERT ON entity_observations BEGIN INSERT INTO orphans VALUES (NEW.id); END",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	if _, err := db.AddObservations("Alice", []string{"prefers tabs"}); err == nil {
+		t.Fatal("expected the commit to fail")
+	}
+	if after, err := db.index.DocCount(); err != nil || after != count {
+		t.Errorf("expected the uncommitted observation to be removed from the index, got %d documents instead of %d (err %v)", after, count, err)
+	}
+}
@@ -0,0 +1,590 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// Query modes for SearchOptions.Mode.
+const (
+	// QueryMatch analyzes the query as plain text; it is the default.
+	QueryMatch = "match"
+	// QueryString parses the query syntax described at parseQueryString.
+	QueryString = "query_string"
+	// QuerySemantic finds memories by the similarity of their embeddings
+	// to the query's; see OpenVectorIndex.
+	QuerySemantic = "semantic"
+	// QueryHybrid runs a QueryMatch and a QuerySemantic search and fuses
+	// their results; see SearchOptions.Fusion.
+	QueryHybrid = "hybrid"
+	// QueryGraph runs a QueryMatch search and adds the memories of the
+	// entities it activates in the knowledge graph; see graphSearch.
+	QueryGraph = "graph"
+)
+
+// maxFuzziness is the largest edit distance a fuzzy term may allow.
+const maxFuzziness = 2
+
+// FuzzinessAuto, as the fuzziness of a search, allows one typo in words of
+// three to five letters and two in longer words.
+const FuzzinessAuto = -1
+
+// QueryError reports an invalid query. Pos is the zero-based rune offset of
+// the problem in a query string, or -1 for a structured query, where Path
+// locates the offending node instead.
+type QueryError struct {
+	Pos  int
+	Path string
+	Msg  string
+}
+
+func (e *QueryError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("invalid query at %s: %s", e.Path, e.Msg)
+	}
+	return fmt.Sprintf("invalid query at column %d: %s", e.Pos+1, e.Msg)
+}
+
+// QueryNode is the structured form of a query for programmatic clients. A
+// node is either a boolean combination of other nodes or exactly one leaf:
+// Match analyzes text like a plain search, Phrase requires the words in
+// order, Term and Prefix compare whole index terms, and Since/Until bound
+// the creation time. Field names the field a leaf applies to; it is one of
+// the fields accepted by parseQueryString and defaults to content.
+type QueryNode struct {
+	Must    []QueryNode `json:"must,omitempty"`
+	Should  []QueryNode `json:"should,omitempty"`
+	MustNot []QueryNode `json:"must_not,omitempty"`
+
+	Field     string     `json:"field,omitempty"`
+	Match     string     `json:"match,omitempty"`
+	Phrase    string     `json:"phrase,omitempty"`
+	Term      string     `json:"term,omitempty"`
+	Prefix    string     `json:"prefix,omitempty"`
+	Fuzziness int        `json:"fuzziness,omitempty"`
+	Since     *time.Time `json:"since,omitempty"`
+	Until     *time.Time `json:"until,omitempty"`
+}
+
+// queryFields maps the field names clients use to index fields.
+var queryFields = map[string]string{
+	"content":   "content",
+	"entity":    "entities",
+	"tag":       "tags",
+	"source":    "source",
+	"namespace": "namespace",
+	"language":  "language",
+	"created":   "created_at",
+}
+
+// buildQuery turns the query of a search into a bleve query.
+func (db *DB) buildQuery(opts SearchOptions) (query.Query, error) {
+	if opts.Fuzziness < FuzzinessAuto || opts.Fuzziness > maxFuzziness {
+		return nil, fmt.Errorf("fuzziness must be between 0 and %d, got %d", maxFuzziness, opts.Fuzziness)
+	}
+	if err := ValidLanguage(opts.Language); err != nil {
+		return nil, err
+	}
+	if opts.Structured != nil {
+		return db.compileNode(*opts.Structured, "query", opts.Language)
+	}
+	switch opts.Mode {
+	case "", QueryMatch:
+		match := contentMatch(opts.Query, opts.Fuzziness, opts.Language)
+		if !opts.Prefix {
+			return match, nil
+		}
+		// Complete the word being typed: the last word also matches as
+		// the start of a longer term.
+		words := strings.Fields(opts.Query)
+		if len(words) == 0 {
+			return match, nil
+		}
+		prefix := bleve.NewPrefixQuery(strings.ToLower(words[len(words)-1]))
+		prefix.SetField("content_words")
+		return bleve.NewDisjunctionQuery(match, prefix), nil
+	case QueryString:
+		clauses, err := parseQueryString(opts.Query)
+		if err != nil {
+			return nil, err
+		}
+		return db.compileClauses(clauses, opts)
+	default:
+		return nil, fmt.Errorf("unknown query mode %q", opts.Mode)
+	}
+}
+
+// queryClause is one term of a parsed query string.
+type queryClause struct {
+	// Occur is '+' for a required clause, '-' for an excluded one and 0
+	// otherwise.
+	Occur byte
+	// Field is the client-facing field name, empty for content.
+	Field string
+	Value string
+	// Phrase is set for a quoted value.
+	Phrase bool
+	// Prefix is set for a value ending in '*'.
+	Prefix bool
+	// Fuzziness is the edit distance of a value ending in '~' or '~N'; it
+	// is -1 when the value does not ask for one.
+	Fuzziness int
+	// Op is the comparison of a created: clause: "=", "<", "<=", ">" or
+	// ">=".
+	Op string
+	// Pos is the rune offset of the clause in the query.
+	Pos int
+}
+
+// parseQueryString parses the query-string syntax. Words are separated by
+// whitespace and any of them may match; a leading '+' makes a word
+// required and a leading '-' excludes it. Double quotes match a phrase.
+// entity:, tag:, source:, namespace: and language: filter on those fields,
+// and created:>2025-01-01 (or <, <=, >=, or a bare date for that day)
+// bounds the creation time. A trailing '*' matches a prefix and a trailing
+// '~' or '~N' allows N (default 1) typos.
+func parseQueryString(s string) ([]queryClause, error) {
+	p := &queryParser{src: []rune(s)}
+	var clauses []queryClause
+	for {
+		p.skipSpace()
+		if p.done() {
+			return clauses, nil
+		}
+		clause, err := p.clause()
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+	}
+}
+
+type queryParser struct {
+	src []rune
+	pos int
+}
+
+func (p *queryParser) done() bool { return p.pos >= len(p.src) }
+
+func (p *queryParser) skipSpace() {
+	for !p.done() && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *queryParser) errorf(pos int, format string, args ...interface{}) error {
+	return &QueryError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *queryParser) clause() (queryClause, error) {
+	c := queryClause{Pos: p.pos, Fuzziness: -1}
+	if r := p.src[p.pos]; r == '+' || r == '-' {
+		c.Occur = byte(r)
+		p.pos++
+		if p.done() || unicode.IsSpace(p.src[p.pos]) {
+			return c, p.errorf(c.Pos, "%q must be followed by a word or phrase", r)
+		}
+	}
+
+	// A field name is a run of letters followed by a colon.
+	start := p.pos
+	end := start
+	for end < len(p.src) && unicode.IsLetter(p.src[end]) {
+		end++
+	}
+	if end > start && end < len(p.src) && p.src[end] == ':' {
+		c.Field = strings.ToLower(string(p.src[start:end]))
+		if _, ok := queryFields[c.Field]; !ok {
+			return c, p.errorf(start, "unknown field %q; use entity, tag, source, namespace, language, content or created", c.Field)
+		}
+		p.pos = end + 1
+		if p.done() || unicode.IsSpace(p.src[p.pos]) {
+			return c, p.errorf(p.pos, "missing value for field %q", c.Field)
+		}
+	}
+
+	if c.Field == "created" {
+		for _, op := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(string(p.src[p.pos:]), op) {
+				c.Op = op
+				p.pos += utf8.RuneCountInString(op)
+				break
+			}
+		}
+		if c.Op == "" {
+			c.Op = "="
+		}
+	}
+
+	valuePos := p.pos
+	if !p.done() && p.src[p.pos] == '"' {
+		p.pos++
+		closing := p.pos
+		for closing < len(p.src) && p.src[closing] != '"' {
+			closing++
+		}
+		if closing == len(p.src) {
+			return c, p.errorf(valuePos, "unterminated phrase")
+		}
+		c.Value = string(p.src[p.pos:closing])
+		c.Phrase = true
+		p.pos = closing + 1
+		if strings.TrimSpace(c.Value) == "" {
+			return c, p.errorf(valuePos, "empty phrase")
+		}
+	} else {
+		for !p.done() && !unicode.IsSpace(p.src[p.pos]) {
+			p.pos++
+		}
+		c.Value = string(p.src[valuePos:p.pos])
+		if c.Field != "created" {
+			if err := p.suffixes(&c, valuePos); err != nil {
+				return c, err
+			}
+		}
+		if c.Value == "" {
+			return c, p.errorf(valuePos, "missing word")
+		}
+	}
+	if c.Field == "created" {
+		if _, err := parseQueryDate(c.Value); err != nil {
+			return c, p.errorf(valuePos, "%v", err)
+		}
+	}
+	if !p.done() && !unicode.IsSpace(p.src[p.pos]) {
+		return c, p.errorf(p.pos, "unexpected %q after %q", p.src[p.pos], c.Value)
+	}
+	return c, nil
+}
+
+// suffixes strips a trailing '*' or '~N' from the word c.Value.
+func (p *queryParser) suffixes(c *queryClause, valuePos int) error {
+	if strings.HasSuffix(c.Value, "*") {
+		c.Value = strings.TrimSuffix(c.Value, "*")
+		c.Prefix = true
+		return nil
+	}
+	i := strings.LastIndex(c.Value, "~")
+	if i < 0 {
+		return nil
+	}
+	distance := c.Value[i+1:]
+	c.Value = c.Value[:i]
+	c.Fuzziness = 1
+	if distance != "" {
+		n, err := strconv.Atoi(distance)
+		if err != nil || n < 0 || n > maxFuzziness {
+			return p.errorf(valuePos+utf8.RuneCountInString(c.Value), "fuzziness must be a number from 0 to %d", maxFuzziness)
+		}
+		c.Fuzziness = n
+	}
+	return nil
+}
+
+// parseQueryDate accepts an RFC 3339 timestamp or a plain UTC date.
+func parseQueryDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: want YYYY-MM-DD or RFC 3339", s)
+	}
+	return t, nil
+}
+
+// compileClauses combines parsed clauses into a boolean query. Clauses on
+// fields other than content are filters and always required unless
+// excluded. Of the remaining words at least one has to match, unless some
+// are marked required, in which case the others only add to the score.
+func (db *DB) compileClauses(clauses []queryClause, opts SearchOptions) (query.Query, error) {
+	boolean := bleve.NewBooleanQuery()
+	var must, required, should int
+	for _, c := range clauses {
+		q, err := db.compileClause(c, opts)
+		if err != nil {
+			return nil, err
+		}
+		filter := c.Field != "" && c.Field != "content"
+		switch {
+		case c.Occur == '-':
+			boolean.AddMustNot(q)
+		case c.Occur == '+':
+			boolean.AddMust(q)
+			must++
+			required++
+		case filter:
+			boolean.AddMust(q)
+			must++
+		default:
+			boolean.AddShould(q)
+			should++
+		}
+	}
+	if should > 0 && required == 0 {
+		boolean.SetMinShould(1)
+	}
+	if must == 0 && should == 0 {
+		// Only exclusions, or nothing at all: start from every memory.
+		boolean.AddMust(bleve.NewMatchAllQuery())
+	}
+	return boolean, nil
+}
+
+func (db *DB) compileClause(c queryClause, opts SearchOptions) (query.Query, error) {
+	if c.Field == "created" {
+		t, _ := parseQueryDate(c.Value)
+		return createdRange(c.Op, t), nil
+	}
+	field := queryFields[c.Field]
+	if field == "" {
+		field = "content"
+	}
+	if field != "content" {
+		value, err := db.fieldValue(field, c.Value)
+		if err != nil {
+			return nil, err
+		}
+		if c.Prefix {
+			return fieldQuery(bleve.NewPrefixQuery(value), field), nil
+		}
+		return fieldQuery(bleve.NewTermQuery(value), field), nil
+	}
+
+	switch {
+	case c.Phrase:
+		return contentPhrase(c.Value, opts.Language), nil
+	case c.Prefix:
+		return fieldQuery(bleve.NewPrefixQuery(strings.ToLower(c.Value)), "content_words"), nil
+	default:
+		fuzziness := opts.Fuzziness
+		if c.Fuzziness >= 0 {
+			fuzziness = c.Fuzziness
+		}
+		return contentMatch(c.Value, fuzziness, opts.Language), nil
+	}
+}
+
+// compileNode turns a structured query node into a bleve query. path names
+// the node in error messages, and lang, when set, is the language content
+// is matched in.
+func (db *DB) compileNode(n QueryNode, path, lang string) (query.Query, error) {
+	invalid := func(format string, args ...interface{}) error {
+		return &QueryError{Pos: -1, Path: path, Msg: fmt.Sprintf(format, args...)}
+	}
+
+	leaves := 0
+	for _, set := range []bool{n.Match != "", n.Phrase != "", n.Term != "", n.Prefix != "", n.Since != nil || n.Until != nil} {
+		if set {
+			leaves++
+		}
+	}
+	compound := len(n.Must)+len(n.Should)+len(n.MustNot) > 0
+	switch {
+	case compound && leaves > 0:
+		return nil, invalid("a node combines either other nodes or a single leaf, not both")
+	case compound:
+		boolean := bleve.NewBooleanQuery()
+		for _, group := range []struct {
+			name  string
+			nodes []QueryNode
+			add   func(...query.Query)
+		}{
+			{"must", n.Must, boolean.AddMust},
+			{"should", n.Should, boolean.AddShould},
+			{"must_not", n.MustNot, boolean.AddMustNot},
+		} {
+			for i, child := range group.nodes {
+				q, err := db.compileNode(child, fmt.Sprintf("%s.%s[%d]", path, group.name, i), lang)
+				if err != nil {
+					return nil, err
+				}
+				group.add(q)
+			}
+		}
+		if len(n.Must) == 0 && len(n.Should) == 0 {
+			boolean.AddMust(bleve.NewMatchAllQuery())
+		}
+		return boolean, nil
+	case leaves == 0:
+		return nil, invalid("the node is empty; set must, should, must_not, match, phrase, term, prefix or since/until")
+	case leaves > 1:
+		return nil, invalid("set only one of match, phrase, term, prefix or since/until")
+	}
+
+	if n.Fuzziness < 0 || n.Fuzziness > maxFuzziness {
+		return nil, invalid("fuzziness must be between 0 and %d", maxFuzziness)
+	}
+	if n.Since != nil || n.Until != nil {
+		if n.Field != "" && n.Field != "created" {
+			return nil, invalid("since and until apply to the created field only")
+		}
+		var since, until time.Time
+		if n.Since != nil {
+			since = *n.Since
+		}
+		if n.Until != nil {
+			until = *n.Until
+		}
+		q := bleve.NewDateRangeQuery(since, until)
+		q.SetField("created_at")
+		return q, nil
+	}
+
+	name := n.Field
+	if name == "" {
+		name = "content"
+	}
+	field, ok := queryFields[name]
+	if !ok || field == "created_at" {
+		return nil, invalid("unknown field %q; use entity, tag, source, namespace, language or content", n.Field)
+	}
+	switch {
+	case n.Match != "" && field == "content":
+		return contentMatch(n.Match, n.Fuzziness, lang), nil
+	case n.Phrase != "" && field == "content":
+		return contentPhrase(n.Phrase, lang), nil
+	case n.Match != "":
+		q := bleve.NewMatchQuery(n.Match)
+		q.SetField(field)
+		q.SetFuzziness(n.Fuzziness)
+		return q, nil
+	case n.Phrase != "":
+		q := bleve.NewMatchPhraseQuery(n.Phrase)
+		q.SetField(field)
+		return q, nil
+	}
+	value := n.Term
+	if value == "" {
+		value = n.Prefix
+	}
+	if field == "content" {
+		// Terms and prefixes compare whole words, before stemming.
+		field, value = "content_words", strings.ToLower(value)
+	} else {
+		var err error
+		if value, err = db.fieldValue(field, value); err != nil {
+			return nil, err
+		}
+	}
+	if n.Prefix != "" {
+		return fieldQuery(bleve.NewPrefixQuery(value), field), nil
+	}
+	return fieldQuery(bleve.NewTermQuery(value), field), nil
+}
+
+// fieldValue returns the indexed form of a value of a keyword field:
+// entities are looked up by name or alias, tags are normalized.
+func (db *DB) fieldValue(field, value string) (string, error) {
+	switch field {
+	case "entities":
+		id, err := findEntity(db, value)
+		if errors.Is(err, ErrEntityNotFound) {
+			// No memory mentions an unknown entity; keep the name
+			// so the clause matches nothing.
+			return value, nil
+		} else if err != nil {
+			return "", err
+		}
+		entity, err := db.getEntityByID(id)
+		if err != nil {
+			return "", err
+		}
+		return entity.Name, nil
+	case "tags":
+		return normalizeTag(value), nil
+	case "language":
+		return strings.ToLower(strings.TrimSpace(value)), nil
+	}
+	return strings.TrimSpace(value), nil
+}
+
+// contentMatch matches text against the content of memories. Content is
+// analyzed in the language of its memory, so the text is analyzed once per
+// language and each analysis only matches memories of that language. lang,
+// when set, restricts the match to that one language.
+func contentMatch(text string, fuzziness int, lang string) query.Query {
+	return inLanguages(lang, func(analyzer string) query.Query {
+		q := bleve.NewMatchQuery(text)
+		q.SetField("content")
+		q.Analyzer = analyzer
+		if fuzziness == FuzzinessAuto {
+			q.SetAutoFuzziness(true)
+		} else {
+			q.SetFuzziness(fuzziness)
+		}
+		return q
+	})
+}
+
+// contentPhrase matches a phrase against the content of memories, like
+// contentMatch.
+func contentPhrase(phrase, lang string) query.Query {
+	return inLanguages(lang, func(analyzer string) query.Query {
+		q := bleve.NewMatchPhraseQuery(phrase)
+		q.SetField("content")
+		q.Analyzer = analyzer
+		return q
+	})
+}
+
+// inLanguages runs the query build returns for the analyzer of each
+// language, or only of lang when set, over the memories of that language.
+// The language filter does not score, so memories in a language whose
+// memories are rare do not rank higher.
+func inLanguages(lang string, build func(analyzer string) query.Query) query.Query {
+	langs := languages
+	if lang != "" {
+		langs = []string{lang}
+	}
+	queries := make([]query.Query, len(langs))
+	for i, l := range langs {
+		filter := bleve.NewTermQuery(l)
+		filter.SetField("language")
+		filter.SetBoost(0)
+		// The analyzers are named after their language.
+		queries[i] = bleve.NewConjunctionQuery(build(l), filter)
+	}
+	if len(queries) == 1 {
+		return queries[0]
+	}
+	return bleve.NewDisjunctionQuery(queries...)
+}
+
+// fieldQuery restricts a term or prefix query to a field.
+func fieldQuery(q query.FieldableQuery, field string) query.Query {
+	q.SetField(field)
+	return q
+}
+
+// createdRange matches memories created before, after or on the day t
+// starts. The operators compare whole days: > matches from the next day on
+// and <= up to the end of the day.
+func createdRange(op string, t time.Time) query.Query {
+	inclusive, exclusive := true, false
+	next := t.AddDate(0, 0, 1)
+	var q *query.DateRangeQuery
+	switch op {
+	case ">":
+		q = bleve.NewDateRangeInclusiveQuery(next, time.Time{}, &inclusive, nil)
+	case ">=":
+		q = bleve.NewDateRangeInclusiveQuery(t, time.Time{}, &inclusive, nil)
+	case "<":
+		q = bleve.NewDateRangeInclusiveQuery(time.Time{}, t, nil, &exclusive)
+	case "<=":
+		q = bleve.NewDateRangeInclusiveQuery(time.Time{}, next, nil, &exclusive)
+	default:
+		// A bare date covers the whole day.
+		q = bleve.NewDateRangeInclusiveQuery(t, next, &inclusive, &exclusive)
+	}
+	q.SetField("created_at")
+	return q
+}
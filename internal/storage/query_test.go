@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseQueryString(t *testing.T) {
+	clauses, err := parseQueryString(`+auth -legacy "token refresh" entity:billing created:>2025-01-01 deplo* tokn~2`)
+	if err != nil {
+		t.Fatalf("failed to parse query: %v", err)
+	}
+	want := []queryClause{
+		{Occur: '+', Value: "auth", Fuzziness: -1, Pos: 0},
+		{Occur: '-', Value: "legacy", Fuzziness: -1, Pos: 6},
+		{Value: "token refresh", Phrase: true, Fuzziness: -1, Pos: 14},
+		{Field: "entity", Value: "billing", Fuzziness: -1, Pos: 30},
+		{Field: "created", Value: "2025-01-01", Op: ">", Fuzziness: -1, Pos: 45},
+		{Value: "deplo", Prefix: true, Fuzziness: -1, Pos: 65},
+		{Value: "tokn", Fuzziness: 2, Pos: 72},
+	}
+	if len(clauses) != len(want) {
+		t.Fatalf("expected %d clauses, got %+v", len(want), clauses)
+	}
+	for i := range want {
+		if clauses[i] != want[i] {
+			t.Errorf("clause %d: got %+v, want %+v", i, clauses[i], want[i])
+		}
+	}
+
+	for query, pos := range map[string]int{
+		`auth "token refresh`:   5,
+		`auth + legacy`:         5,
+		`owner:alice`:           0,
+		`auth entity: billing`:  12,
+		`created:>2025-13-01`:   9,
+		`token~5`:               5,
+		`"token"refresh`:        7,
+		`über tag:"" x`:         9,
+		`-`:                     0,
+		`créé:>2025-01-01 auth`: 0,
+	} {
+		_, err := parseQueryString(query)
+		var qerr *QueryError
+		if !errors.As(err, &qerr) {
+			t.Errorf("%q: expected a QueryError, got %v", query, err)
+			continue
+		}
+		if qerr.Pos != pos {
+			t.Errorf("%q: expected the error at %d, got %d (%v)", query, pos, qerr.Pos, qerr)
+		}
+	}
+}
+
+func TestSearchQueryString(t *testing.T) {
+	db := newTestDB(t)
+
+	add := func(in MemoryInput) int64 {
+		t.Helper()
+		id, err := db.CreateMemory(in)
+		if err != nil {
+			t.Fatalf("failed to create memory: %v", err)
+		}
+		return id
+	}
+	authToken := add(MemoryInput{Content: "auth uses a token refresh every hour", Entities: []string{"Billing"}, Tags: []string{"security"}})
+	legacyAuth := add(MemoryInput{Content: "legacy auth still uses session cookies"})
+	deploys := add(MemoryInput{Content: "deployments run through the pipeline", Source: "slack"})
+	if _, err := db.CreateEntity("billing-svc", "service"); err != nil {
+		t.Fatalf("failed to create entity: %v", err)
+	}
+	if err := db.AddAlias("Billing", "billing service"); err != nil {
+		t.Fatalf("failed to add alias: %v", err)
+	}
+
+	search := func(opts SearchOptions) []int64 {
+		t.Helper()
+		result, err := db.Search(opts)
+		if err != nil {
+			t.Fatalf("search %+v failed: %v", opts, err)
+		}
+		ids := make([]int64, len(result.Hits))
+		for i, hit := range result.Hits {
+			ids[i] = hit.ID
+		}
+		return ids
+	}
+	same := func(got []int64, want ...int64) bool {
+		if len(got) != len(want) {
+			return false
+		}
+		seen := map[int64]bool{}
+		for _, id := range got {
+			seen[id] = true
+		}
+		for _, id := range want {
+			if !seen[id] {
+				return false
+			}
+		}
+		return true
+	}
+
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format("2006-01-02")
+	// The day the memories were created, which > excludes and <= includes.
+	created, err := db.GetMemoryRecord(authToken)
+	if err != nil {
+		t.Fatalf("failed to get memory: %v", err)
+	}
+	today := created.CreatedAt.UTC().Format("2006-01-02")
+	for query, want := range map[string][]int64{
+		`+auth -legacy`:                   {authToken},
+		`"token refresh"`:                 {authToken},
+		`"refresh token"`:                 nil,
+		`entity:"billing service"`:        {authToken},
+		`tag:SECURITY`:                    {authToken},
+		`source:slack`:                    {deploys},
+		`-auth`:                           {deploys},
+		`auth created:<` + tomorrow:       {authToken, legacyAuth},
+		`auth created:>=` + tomorrow:      nil,
+		`auth created:>` + today:          nil,
+		`auth created:<=` + today:         {authToken, legacyAuth},
+		`auth created:` + today:           {authToken, legacyAuth},
+		`+created:` + tomorrow[:8] + "01": nil,
+		`pipelin*`:                        {deploys},
+		`cookeis~2`:                       {legacyAuth},
+	} {
+		if got := search(SearchOptions{Query: query, Mode: QueryString}); !same(got, want...) {
+			t.Errorf("%q: got %v, want %v", query, got, want)
+		}
+	}
+
+	if got := search(SearchOptions{Query: "cookeis", Fuzziness: 2}); !same(got, legacyAuth) {
+		t.Errorf("expected a fuzzy match, got %v", got)
+	}
+	if got := search(SearchOptions{Query: "deploym", Prefix: true}); !same(got, deploys) {
+		t.Errorf("expected a prefix match, got %v", got)
+	}
+	if _, err := db.Search(SearchOptions{Query: `auth "token`, Mode: QueryString}); err == nil {
+		t.Error("expected a parse error")
+	}
+}
+
+func TestSearchStructured(t *testing.T) {
+	db := newTestDB(t)
+	keep, err := db.CreateMemory(MemoryInput{Content: "auth tokens rotate daily", Namespace: "work"})
+	if err != nil {
+		t.Fatalf("failed to create memory: %v", err)
+	}
+	if _, err := db.CreateMemory(MemoryInput{Content: "auth tokens for the home lab", Namespace: "home"}); err != nil {
+		t.Fatalf("failed to create memory: %v", err)
+	}
+
+	result, err := db.Search(SearchOptions{Structured: &QueryNode{
+		Must: []QueryNode{
+			{Match: "token"},
+			{Field: "namespace", Term: "work"},
+		},
+		MustNot: []QueryNode{{Phrase: "home lab"}},
+	}})
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if len(result.Hits) != 1 || result.Hits[0].ID != keep {
+		t.Errorf("expected memory %d alone, got %+v", keep, result.Hits)
+	}
+
+	_, err = db.Search(SearchOptions{Structured: &QueryNode{
+		Must: []QueryNode{{Match: "auth"}, {Match: "x", Term: "y"}},
+	}})
+	var qerr *QueryError
+	if !errors.As(err, &qerr) || qerr.Path != "query.must[1]" {
+		t.Errorf("expected an error at query.must[1], got %v", err)
+	}
+	_, err = db.Search(SearchOptions{Structured: &QueryNode{Field: "owner", Term: "x"}})
+	if !errors.As(err, &qerr) || qerr.Path != "query" {
+		t.Errorf("expected an unknown field error, got %v", err)
+	}
+}
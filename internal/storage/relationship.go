@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Directions for listing the relationships of an entity.
+const (
+	DirectionOut  = "out"
+	DirectionIn   = "in"
+	DirectionBoth = "both"
+)
+
+// ErrRelationshipNotFound is returned when no relationship matches.
+var ErrRelationshipNotFound = errors.New("relationship not found")
+
+// RelationshipInput describes a relationship between two named entities.
+// Entities that do not exist yet are created.
+type RelationshipInput struct {
+	Source string
+	Target string
+	Type   string
+	// Weight and Confidence default to 1 when nil.
+	Weight     *float64
+	Confidence *float64
+	// Properties is an optional JSON object of free-form attributes.
+	Properties json.RawMessage
+	// SourceMemoryID optionally records the memory the relationship was
+	// learned from.
+	SourceMemoryID int64
+	// ValidFrom is when the relationship started to hold; zero means now.
+	ValidFrom time.Time
+	// Functional marks the type as allowing one current target per source:
+	// other current relationships of the same type from the source are
+	// closed at ValidFrom. A backdated relationship also cuts short a past
+	// one that held at ValidFrom, and ends when the next one started.
+	Functional bool
+}
+
+// RelationshipFilter selects relationships to list. Zero values match
+// everything.
+type RelationshipFilter struct {
+	// Entity restricts the listing to relationships touching the named
+	// entity, in the given Direction relative to it.
+	Entity    string
+	Direction string
+	Type      string
+	// AsOf selects the relationships that held at that time; zero means
+	// now. History ignores validity and lists every version.
+	AsOf    time.Time
+	History bool
+}
+
+// CreateRelationship stores a relationship between two named entities. If
+// the same relationship already exists it is returned unchanged and created
+// is false.
+func (db *DB) CreateRelationship(in RelationshipInput) (rel Relationship, created bool, err error) {
+	if NormalizeEntityName(in.Source) == "" || NormalizeEntityName(in.Target) == "" {
+		return Relationship{}, false, errors.New("relationship source and target must not be empty")
+	}
+	if strings.TrimSpace(in.Type) == "" {
+		return Relationship{}, false, errors.New("relationship type must not be empty")
+	}
+	if in.Confidence != nil && (*in.Confidence < 0 || *in.Confidence > 1) {
+		return Relationship{}, false, fmt.Errorf("confidence must be between 0 and 1, got %v", *in.Confidence)
+	}
+	if len(in.Properties) > 0 {
+		var properties map[string]interface{}
+		if err := json.Unmarshal(in.Properties, &properties); err != nil {
+			return Relationship{}, false, fmt.Errorf("properties must be a JSON object: %w", err)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return Relationship{}, false, err
+	}
+	if in.SourceMemoryID != 0 {
+		var exists int
+		if err := tx.QueryRow("SELECT 1 FROM memories WHERE id = ?", in.SourceMemoryID).Scan(&exists); err != nil {
+			tx.Rollback()
+			if err == sql.ErrNoRows {
+				return Relationship{}, false, fmt.Errorf("source memory %d does not exist", in.SourceMemoryID)
+			}
+			return Relationship{}, false, err
+		}
+	}
+	sourceID, err := findOrCreateEntity(tx, in.Source)
+	if err != nil {
+		tx.Rollback()
+		return Relationship{}, false, err
+	}
+	targetID, err := findOrCreateEntity(tx, in.Target)
+	if err != nil {
+		tx.Rollback()
+		return Relationship{}, false, err
+	}
+	id, created, err := insertRelationship(tx, sourceID, targetID, strings.TrimSpace(in.Type), in)
+	if err != nil {
+		tx.Rollback()
+		return Relationship{}, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Relationship{}, false, err
+	}
+
+	rel, err = db.getRelationship(id)
+	return rel, created, err
+}
+
+// insertRelationship adds an edge unless an identical current one exists,
+// in which case the existing edge's ID is returned.
+func insertRelationship(tx *sql.Tx, sourceID, targetID int64, relType string, in RelationshipInput) (int64, bool, error) {
+	var id int64
+	err := tx.QueryRow("SELECT id FROM relationships WHERE source_id = ? AND target_id = ? AND type = ? AND valid_to IS NULL",
+		sourceID, targetID, relType).Scan(&id)
+	if err == nil {
+		return id, false, nil
+	} else if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+
+	weight, confidence := 1.0, 1.0
+	if in.Weight != nil {
+		weight = *in.Weight
+	}
+	if in.Confidence != nil {
+		confidence = *in.Confidence
+	}
+	var properties, sourceMemory interface{}
+	if len(in.Properties) > 0 {
+		properties = string(in.Properties)
+	}
+	if in.SourceMemoryID != 0 {
+		sourceMemory = in.SourceMemoryID
+	}
+	validFrom := formatTime(timeOrNow(in.ValidFrom))
+
+	var validTo sql.NullString
+	if in.Functional {
+		if _, err := tx.Exec(`UPDATE relationships SET valid_to = ?
+			WHERE source_id = ? AND type = ? AND target_id != ? AND (valid_to IS NULL OR valid_to > ?)
+			AND (valid_from IS NULL OR valid_from <= ?)`,
+			validFrom, sourceID, relType, targetID, validFrom, validFrom); err != nil {
+			return 0, false, err
+		}
+		// A relationship that started later superseded this one.
+		if err := tx.QueryRow(`SELECT MIN(valid_from) FROM relationships
+			WHERE source_id = ? AND type = ? AND target_id != ? AND valid_from > ?`,
+			sourceID, relType, targetID, validFrom).Scan(&validTo); err != nil {
+			return 0, false, err
+		}
+	}
+
+	result, err := tx.Exec(`INSERT INTO relationships (source_id, target_id, type, weight, confidence, properties, source_memory_id, created_at, valid_from, valid_to)
+		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, ?, ?)`,
+		sourceID, targetID, relType, weight, confidence, properties, sourceMemory, validFrom, validTo)
+	if err != nil {
+		return 0, false, err
+	}
+	id, err = result.LastInsertId()
+	return id, true, err
+}
+
+// DeleteRelationships removes the relationships from source to target. If
+// relType is empty, relationships of every type between them are removed.
+// It returns the number of relationships deleted.
+func (db *DB) DeleteRelationships(source, target, relType string) (int64, error) {
+	sourceID, err := findEntity(db, source)
+	if err != nil {
+		return 0, fmt.Errorf("source %q: %w", source, err)
+	}
+	targetID, err := findEntity(db, target)
+	if err != nil {
+		return 0, fmt.Errorf("target %q: %w", target, err)
+	}
+
+	query := "DELETE FROM relationships WHERE source_id = ? AND target_id = ?"
+	args := []interface{}{sourceID, targetID}
+	if relType != "" {
+		query += " AND type = ?"
+		args = append(args, relType)
+	}
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ExpireRelationships records that the current relationships from source to
+// target stopped holding at the given time, or now when at is zero. If
+// relType is empty, relationships of every type between them are closed.
+// It returns the number of relationships closed.
+func (db *DB) ExpireRelationships(source, target, relType string, at time.Time) (int64, error) {
+	sourceID, err := findEntity(db, source)
+	if err != nil {
+		return 0, fmt.Errorf("source %q: %w", source, err)
+	}
+	targetID, err := findEntity(db, target)
+	if err != nil {
+		return 0, fmt.Errorf("target %q: %w", target, err)
+	}
+
+	query := "UPDATE relationships SET valid_to = ? WHERE source_id = ? AND target_id = ? AND valid_to IS NULL"
+	args := []interface{}{formatTime(timeOrNow(at)), sourceID, targetID}
+	if relType != "" {
+		query += " AND type = ?"
+		args = append(args, relType)
+	}
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetRelationshipsAsOf retrieves all relationships that held at asOf.
+func (db *DB) GetRelationshipsAsOf(asOf time.Time) ([]Relationship, error) {
+	return db.ListRelationships(RelationshipFilter{AsOf: asOf})
+}
+
+// relationshipColumns selects a relationship joined with its entity names,
+// in the order scanRelationship expects.
+const relationshipColumns = `SELECT r.id, r.source_id, r.target_id, r.type, s.name, t.name,
+	r.weight, r.confidence, r.properties, r.source_memory_id, r.valid_from, r.valid_to
+	FROM relationships r
+	JOIN entities s ON s.id = r.source_id
+	JOIN entities t ON t.id = r.target_id`
+
+// ListRelationships lists the relationships matching filter.
+func (db *DB) ListRelationships(filter RelationshipFilter) ([]Relationship, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	if filter.Entity != "" {
+		entityID, err := findEntity(db, filter.Entity)
+		if err != nil {
+			return nil, err
+		}
+		switch filter.Direction {
+		case DirectionOut:
+			conditions = append(conditions, "r.source_id = ?")
+			args = append(args, entityID)
+		case DirectionIn:
+			conditions = append(conditions, "r.target_id = ?")
+			args = append(args, entityID)
+		case DirectionBoth, "":
+			conditions = append(conditions, "(r.source_id = ? OR r.target_id = ?)")
+			args = append(args, entityID, entityID)
+		default:
+			return nil, fmt.Errorf("invalid direction %q", filter.Direction)
+		}
+	}
+	if filter.Type != "" {
+		conditions = append(conditions, "r.type = ?")
+		args = append(args, filter.Type)
+	}
+	if !filter.History {
+		cond, condArgs := validAt("r", filter.AsOf)
+		conditions = append(conditions, cond)
+		args = append(args, condArgs...)
+	}
+
+	query := relationshipColumns
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY r.id"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var relationships []Relationship
+	for rows.Next() {
+		rel, err := scanRelationship(rows)
+		if err != nil {
+			return nil, err
+		}
+		relationships = append(relationships, rel)
+	}
+	return relationships, rows.Err()
+}
+
+func (db *DB) getRelationship(id int64) (Relationship, error) {
+	rel, err := scanRelationship(db.QueryRow(relationshipColumns+" WHERE r.id = ?", id))
+	if err == sql.ErrNoRows {
+		return Relationship{}, ErrRelationshipNotFound
+	}
+	return rel, err
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRelationship(row scanner) (Relationship, error) {
+	var (
+		rel          Relationship
+		properties   sql.NullString
+		sourceMemory sql.NullInt64
+		validFrom    sql.NullTime
+		validTo      sql.NullTime
+	)
+	if err := row.Scan(&rel.ID, &rel.SourceID, &rel.TargetID, &rel.Type, &rel.Source, &rel.Target,
+		&rel.Weight, &rel.Confidence, &properties, &sourceMemory, &validFrom, &validTo); err != nil {
+		return Relationship{}, err
+	}
+	rel.ValidFrom = nullableTime(validFrom)
+	rel.ValidTo = nullableTime(validTo)
+	if properties.Valid {
+		rel.Properties = json.RawMessage(properties.String)
+	}
+	rel.SourceMemoryID = sourceMemory.Int64
+	return rel, nil
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCreateRelationship(t *testing.T) {
+	db := newTestDB(t)
+
+	memoryID, err := db.AddMemory("Alice owns the billing service", []string{"Alice", "billing"})
+	if err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+
+	weight, confidence := 2.5, 0.9
+	rel, created, err := db.CreateRelationship(RelationshipInput{
+		Source:         "alice",
+		Target:         "Billing",
+		Type:           "owns",
+		Weight:         &weight,
+		Confidence:     &confidence,
+		Properties:     json.RawMessage(`{"since":"2024"}`),
+		SourceMemoryID: memoryID,
+	})
+	if err != nil {
+		t.Fatalf("failed to create relationship: %v", err)
+	}
+	if !created {
+		t.Error("expected the relationship to be created")
+	}
+	if rel.Source != "Alice" || rel.Target != "billing" || rel.Weight != 2.5 || rel.Confidence != 0.9 || rel.SourceMemoryID != memoryID {
+		t.Errorf("unexpected relationship %+v", rel)
+	}
+	if string(rel.Properties) != `{"since":"2024"}` {
+		t.Errorf("unexpected properties %s", rel.Properties)
+	}
+
+	again, created, err := db.CreateRelationship(RelationshipInput{Source: "Alice", Target: "billing", Type: "owns"})
+	if err != nil {
+		t.Fatalf("failed to create duplicate relationship: %v", err)
+	}
+	if created || again.ID != rel.ID {
+		t.Errorf("expected the existing relationship %d, got %+v (created %v)", rel.ID, again, created)
+	}
+	if id, err := db.AddRelationship(rel.SourceID, rel.TargetID, "owns"); err != nil || id != rel.ID {
+		t.Errorf("expected AddRelationship to return the existing edge %d, got %d %v", rel.ID, id, err)
+	}
+
+	// Entities are created on demand.
+	if _, _, err := db.CreateRelationship(RelationshipInput{Source: "Bob", Target: "Alice", Type: "reports_to"}); err != nil {
+		t.Fatalf("failed to create relationship: %v", err)
+	}
+	if _, err := db.GetEntity("Bob"); err != nil {
+		t.Errorf("expected Bob to be created: %v", err)
+	}
+
+	if _, _, err := db.CreateRelationship(RelationshipInput{Source: "a", Target: "b", Type: "x", Properties: json.RawMessage(`[1]`)}); err == nil {
+		t.Error("expected an error for non-object properties")
+	}
+	bad := 1.5
+	if _, _, err := db.CreateRelationship(RelationshipInput{Source: "a", Target: "b", Type: "x", Confidence: &bad}); err == nil {
+		t.Error("expected an error for confidence above 1")
+	}
+}
+
+func TestListAndDeleteRelationships(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, in := range []RelationshipInput{
+		{Source: "Alice", Target: "billing", Type: "owns"},
+		{Source: "Alice", Target: "payments", Type: "owns"},
+		{Source: "Bob", Target: "Alice", Type: "reports_to"},
+		{Source: "Alice", Target: "Carol", Type: "mentors"},
+	} {
+		if _, _, err := db.CreateRelationship(in); err != nil {
+			t.Fatalf("failed to create relationship: %v", err)
+		}
+	}
+
+	tests := []struct {
+		filter RelationshipFilter
+		want   int
+	}{
+		{RelationshipFilter{}, 4},
+		{RelationshipFilter{Entity: "alice"}, 4},
+		{RelationshipFilter{Entity: "Alice", Direction: DirectionOut}, 3},
+		{RelationshipFilter{Entity: "Alice", Direction: DirectionIn}, 1},
+		{RelationshipFilter{Entity: "Alice", Direction: DirectionOut, Type: "owns"}, 2},
+		{RelationshipFilter{Type: "mentors"}, 1},
+	}
+	for _, tt := range tests {
+		got, err := db.ListRelationships(tt.filter)
+		if err != nil {
+			t.Fatalf("failed to list relationships for %+v: %v", tt.filter, err)
+		}
+		if len(got) != tt.want {
+			t.Errorf("filter %+v: expected %d relationships, got %d", tt.filter, tt.want, len(got))
+		}
+	}
+	if _, err := db.ListRelationships(RelationshipFilter{Entity: "Alice", Direction: "sideways"}); err == nil {
+		t.Error("expected an error for an invalid direction")
+	}
+
+	n, err := db.DeleteRelationships("Alice", "billing", "owns")
+	if err != nil {
+		t.Fatalf("failed to delete relationship: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 relationship deleted, got %d", n)
+	}
+	remaining, err := db.GetRelationships()
+	if err != nil {
+		t.Fatalf("failed to get relationships: %v", err)
+	}
+	if len(remaining) != 3 {
+		t.Errorf("expected 3 relationships left, got %d", len(remaining))
+	}
+}
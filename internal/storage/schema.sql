@@ -4,14 +4,42 @@
 CREATE TABLE IF NOT EXISTS memories (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     content TEXT NOT NULL,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    session_id INTEGER REFERENCES sessions (id) ON DELETE SET NULL,
+    turn_id INTEGER REFERENCES turns (id) ON DELETE SET NULL,
+    access_count INTEGER NOT NULL DEFAULT 0, -- times the memory was returned by a search or context lookup
+    last_accessed_at DATETIME,
+    source TEXT NOT NULL DEFAULT '', -- where the memory came from, e.g. 'chat' or 'import'
+    namespace TEXT NOT NULL DEFAULT '', -- separates the memories of different projects or users
+    importance REAL NOT NULL DEFAULT 0.5, -- between 0 and 1
+    updated_at DATETIME, -- NULL until the memory is first changed
+    pinned INTEGER NOT NULL DEFAULT 0, -- 1 for memories that should rank higher in searches
+    language TEXT NOT NULL DEFAULT '' -- language the content is analyzed in, e.g. 'en' or 'fr'
+);
+
+-- Stores free-form labels attached to memories
+CREATE TABLE IF NOT EXISTS memory_tags (
+    memory_id INTEGER NOT NULL,
+    tag TEXT NOT NULL, -- lower-cased
+    PRIMARY KEY (memory_id, tag),
+    FOREIGN KEY (memory_id) REFERENCES memories (id) ON DELETE CASCADE
 );
 
 -- Stores unique, named entities (e.g., files, libraries, concepts)
 CREATE TABLE IF NOT EXISTS entities (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     name TEXT NOT NULL UNIQUE,
-    type TEXT NOT NULL -- e.g., 'file', 'person', 'library'
+    type TEXT NOT NULL, -- e.g., 'file', 'person', 'library'
+    norm_name TEXT -- lower-cased, whitespace-collapsed name used for lookups
+);
+
+-- Stores alternative names that resolve to an entity
+CREATE TABLE IF NOT EXISTS entity_aliases (
+    norm_alias TEXT PRIMARY KEY,
+    alias TEXT NOT NULL,
+    entity_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (entity_id) REFERENCES entities (id) ON DELETE CASCADE
 );
 
 -- Links memories to the entities mentioned within them, creating the graph
@@ -29,6 +57,83 @@ CREATE TABLE IF NOT EXISTS relationships (
     source_id INTEGER NOT NULL,
     target_id INTEGER NOT NULL,
     type TEXT NOT NULL,
+    weight REAL NOT NULL DEFAULT 1,
+    confidence REAL NOT NULL DEFAULT 1, -- between 0 and 1
+    properties TEXT, -- JSON object
+    source_memory_id INTEGER REFERENCES memories (id) ON DELETE SET NULL, -- memory the relationship was learned from
+    created_at DATETIME,
+    valid_from DATETIME, -- NULL means the relationship has always held
+    valid_to DATETIME, -- NULL means the relationship still holds
     FOREIGN KEY (source_id) REFERENCES entities (id) ON DELETE CASCADE,
     FOREIGN KEY (target_id) REFERENCES entities (id) ON DELETE CASCADE
-);
\ No newline at end of file
+);
+
+-- Stores conversations between a client and the assistant
+CREATE TABLE IF NOT EXISTS sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    client_id TEXT NOT NULL DEFAULT '',
+    started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    closed_at DATETIME
+);
+
+-- Stores the individual messages exchanged within a session
+CREATE TABLE IF NOT EXISTS turns (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_id INTEGER NOT NULL,
+    seq INTEGER NOT NULL, -- position of the turn within its session, starting at 1
+    role TEXT NOT NULL, -- 'user', 'assistant', 'system' or 'tool'
+    content TEXT NOT NULL,
+    client_id TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (session_id, seq),
+    FOREIGN KEY (session_id) REFERENCES sessions (id) ON DELETE CASCADE
+);
+
+-- Stores atomic facts attached to an entity, e.g. 'prefers tabs'
+CREATE TABLE IF NOT EXISTS entity_observations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    entity_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    valid_from DATETIME, -- NULL means the observation has always held
+    valid_to DATETIME, -- NULL means the observation still holds
+    FOREIGN KEY (entity_id) REFERENCES entities (id) ON DELETE CASCADE
+);
+
+-- Stores the embedding vectors of memories, one per chunk of the content
+CREATE TABLE IF NOT EXISTS memory_embeddings (
+    memory_id INTEGER NOT NULL,
+    chunk INTEGER NOT NULL, -- position of the chunk within the memory, starting at 0
+    start_offset INTEGER NOT NULL, -- byte offsets of the chunk in the content
+    end_offset INTEGER NOT NULL,
+    model TEXT NOT NULL, -- embedder that made the vector
+    vector BLOB NOT NULL, -- little-endian float32s
+    PRIMARY KEY (memory_id, chunk),
+    FOREIGN KEY (memory_id) REFERENCES memories (id) ON DELETE CASCADE
+);
+
+-- Stores typed, directed links between memories, e.g. a correction that
+-- supersedes an earlier memory
+CREATE TABLE IF NOT EXISTS memory_links (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source_id INTEGER NOT NULL,
+    target_id INTEGER NOT NULL,
+    type TEXT NOT NULL, -- 'supersedes', 'contradicts', 'elaborates' or 'derived_from'
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (source_id, target_id, type),
+    FOREIGN KEY (source_id) REFERENCES memories (id) ON DELETE CASCADE,
+    FOREIGN KEY (target_id) REFERENCES memories (id) ON DELETE CASCADE
+);
+
+-- Stores saved queries that new memories are matched against as they are
+-- added
+CREATE TABLE IF NOT EXISTS standing_queries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL DEFAULT '',
+    query TEXT NOT NULL DEFAULT '',
+    mode TEXT NOT NULL DEFAULT '', -- 'match' or 'query_string'
+    structured TEXT, -- JSON query tree used instead of query
+    language TEXT NOT NULL DEFAULT '',
+    webhook TEXT NOT NULL DEFAULT '', -- URL posted to on a match, if any
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/blevesearch/bleve/v2/search"
+)
+
+// Times the recency of a memory can be measured from.
+const (
+	DecayCreated  = "created"
+	DecayAccessed = "accessed"
+)
+
+// ErrInvalidScoring is returned for score functions with a value out of
+// range.
+var ErrInvalidScoring = errors.New("invalid score functions")
+
+// ScoreFunctions combine the relevance of a search hit with the age,
+// importance and pinned status of its memory. Each multiplies the score by
+// a factor; the zero value leaves scores alone.
+type ScoreFunctions struct {
+	// HalfLifeDays is the age in days at which the recency factor has
+	// dropped halfway to 1-RecencyWeight. Zero turns decay off.
+	HalfLifeDays float64 `json:"half_life_days"`
+	// DecayFrom is DecayCreated (the default) to age memories from their
+	// creation, or DecayAccessed to age them from their last access.
+	DecayFrom string `json:"decay_from"`
+	// RecencyWeight, between 0 and 1, is how much of the score decays.
+	RecencyWeight float64 `json:"recency_weight"`
+	// ImportanceWeight, between 0 and 1, is how much of the score scales
+	// with importance, relative to DefaultImportance.
+	ImportanceWeight float64 `json:"importance_weight"`
+	// PinnedBoost multiplies the score of pinned memories. Zero and one
+	// leave them alone.
+	PinnedBoost float64 `json:"pinned_boost"`
+}
+
+// ScoreExplanation breaks the score of a hit down into the factors that
+// multiply to it. Lexical is bleve's explanation of the lexical score the
+// relevance derives from, when the hit was found by its terms.
+type ScoreExplanation struct {
+	Relevance  float64             `json:"relevance"`
+	Usage      float64             `json:"usage"`
+	Recency    float64             `json:"recency"`
+	Importance float64             `json:"importance"`
+	Pinned     float64             `json:"pinned"`
+	Lexical    *search.Explanation `json:"lexical,omitempty"`
+}
+
+// Score is the product of the factors.
+func (e ScoreExplanation) Score() float64 {
+	return e.Relevance * e.Usage * e.Recency * e.Importance * e.Pinned
+}
+
+// validate checks that the values of f are in range.
+func (f ScoreFunctions) validate() error {
+	switch {
+	case f.HalfLifeDays < 0:
+		return fmt.Errorf("%w: half_life_days must not be negative", ErrInvalidScoring)
+	case f.DecayFrom != "" && f.DecayFrom != DecayCreated && f.DecayFrom != DecayAccessed:
+		return fmt.Errorf("%w: decay_from must be %s or %s, got %q", ErrInvalidScoring, DecayCreated, DecayAccessed, f.DecayFrom)
+	case f.RecencyWeight < 0 || f.RecencyWeight > 1:
+		return fmt.Errorf("%w: recency_weight must be between 0 and 1", ErrInvalidScoring)
+	case f.ImportanceWeight < 0 || f.ImportanceWeight > 1:
+		return fmt.Errorf("%w: importance_weight must be between 0 and 1", ErrInvalidScoring)
+	case f.PinnedBoost < 0:
+		return fmt.Errorf("%w: pinned_boost must not be negative", ErrInvalidScoring)
+	}
+	return nil
+}
+
+// active reports whether f changes any score.
+func (f ScoreFunctions) active() bool {
+	return f.HalfLifeDays > 0 && f.RecencyWeight > 0 || f.ImportanceWeight > 0 || f.PinnedBoost > 0 && f.PinnedBoost != 1
+}
+
+// explain scores a memory found with the given relevance.
+func (f ScoreFunctions) explain(relevance, usageBoost float64, memory Memory, now time.Time) ScoreExplanation {
+	e := ScoreExplanation{
+		Relevance:  relevance,
+		Usage:      1 + usageBoost*usageScore(memory.AccessStats, now),
+		Recency:    1,
+		Importance: 1 - f.ImportanceWeight + f.ImportanceWeight*memory.Importance/DefaultImportance,
+		Pinned:     1,
+	}
+	if f.HalfLifeDays > 0 {
+		from := memory.CreatedAt
+		if f.DecayFrom == DecayAccessed && memory.LastAccessedAt != nil {
+			from = *memory.LastAccessedAt
+		}
+		age := max(now.Sub(from), 0)
+		decay := math.Pow(0.5, age.Hours()/24/f.HalfLifeDays)
+		e.Recency = 1 - f.RecencyWeight + f.RecencyWeight*decay
+	}
+	if memory.Pinned && f.PinnedBoost > 0 {
+		e.Pinned = f.PinnedBoost
+	}
+	return e
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestScoreFunctions(t *testing.T) {
+	now := time.Now()
+	f := ScoreFunctions{HalfLifeDays: 30, RecencyWeight: 0.5, ImportanceWeight: 1, PinnedBoost: 2}
+	memory := Memory{CreatedAt: now.Add(-30 * 24 * time.Hour), Importance: 1, Pinned: true}
+	e := f.explain(2, 0, memory, now)
+	if e.Relevance != 2 || e.Usage != 1 || math.Abs(e.Recency-0.75) > 1e-9 || e.Importance != 2 || e.Pinned != 2 {
+		t.Errorf("unexpected factors %+v", e)
+	}
+	if math.Abs(e.Score()-6) > 1e-9 {
+		t.Errorf("expected a score of 6, got %v", e.Score())
+	}
+
+	// Decaying from the last access uses it when there is one.
+	accessed := now.Add(-time.Hour)
+	memory.LastAccessedAt = &accessed
+	f.DecayFrom = DecayAccessed
+	if e := f.explain(1, 0, memory, now); e.Recency < 0.99 {
+		t.Errorf("expected a recent access to keep the score, got %+v", e)
+	}
+
+	if (ScoreFunctions{}).active() || (ScoreFunctions{PinnedBoost: 1}).active() {
+		t.Error("expected neutral score functions to be inactive")
+	}
+	for _, f := range []ScoreFunctions{{HalfLifeDays: -1}, {DecayFrom: "updated"}, {RecencyWeight: 2}, {ImportanceWeight: -1}, {PinnedBoost: -1}} {
+		if err := f.validate(); !errors.Is(err, ErrInvalidScoring) {
+			t.Errorf("%+v: expected ErrInvalidScoring, got %v", f, err)
+		}
+	}
+}
+
+func TestSearchScoring(t *testing.T) {
+	db := newTestDB(t)
+	old, err := db.AddMemory("use postgres for billing", nil)
+	if err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+	recent, err := db.AddMemory("decided last week: use postgres for billing", nil)
+	if err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+	if _, err := db.Exec("UPDATE memories SET created_at = ? WHERE id = ?", formatTime(time.Now().AddDate(-2, 0, 0)), old); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := db.Search(SearchOptions{Query: "postgres billing"})
+	if err != nil || len(result.Hits) != 2 || result.Hits[0].ID != old || result.Hits[0].Explanation != nil {
+		t.Fatalf("expected the shorter memory first by relevance alone, got %+v, %v", result.Hits, err)
+	}
+
+	scoring := ScoreFunctions{HalfLifeDays: 90, RecencyWeight: 0.5}
+	result, err = db.Search(SearchOptions{Query: "postgres billing", Scoring: scoring, Explain: true})
+	if err != nil || len(result.Hits) != 2 || result.Hits[0].ID != recent {
+		t.Fatalf("expected last week's decision first, got %+v, %v", result.Hits, err)
+	}
+	for _, hit := range result.Hits {
+		if hit.Explanation == nil || math.Abs(hit.Explanation.Score()-hit.Score) > 1e-9 {
+			t.Errorf("expected an explanation adding up to the score, got %+v", hit)
+		}
+	}
+
+	// Pinning the old memory outweighs its age.
+	if err := db.SetPinned(old, true); err != nil {
+		t.Fatalf("failed to pin memory: %v", err)
+	}
+	scoring.PinnedBoost = 3
+	result, err = db.Search(SearchOptions{Query: "postgres billing", Scoring: scoring})
+	if err != nil || result.Hits[0].ID != old || !result.Hits[0].Pinned {
+		t.Errorf("expected the pinned memory first, got %+v, %v", result.Hits, err)
+	}
+
+	if err := db.SetPinned(999, true); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a missing memory, got %v", err)
+	}
+	if _, err := db.Search(SearchOptions{Query: "postgres", Scoring: ScoreFunctions{RecencyWeight: 2}}); !errors.Is(err, ErrInvalidScoring) {
+		t.Errorf("expected ErrInvalidScoring, got %v", err)
+	}
+}
@@ -0,0 +1,498 @@
+package storage
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search"
+)
+
+// SearchOptions controls a memory search.
+type SearchOptions struct {
+	Query string
+	// Mode is QueryMatch (the default), QueryString, QuerySemantic,
+	// QueryHybrid or QueryGraph.
+	Mode string
+	// Structured, when set, is used instead of Query.
+	Structured *QueryNode
+	// Fuzziness allows up to that many typos (at most 2) per word, or
+	// FuzzinessAuto for a number depending on the length of the word.
+	Fuzziness int
+	// Prefix lets the last word of a QueryMatch query match the start of
+	// longer words, for search-as-you-type.
+	Prefix bool
+	// History includes memories that another memory supersedes, which are
+	// otherwise left out.
+	History bool
+	// UsageBoost, when positive, ranks frequently and recently accessed
+	// memories higher; see usageScore.
+	UsageBoost float64
+	// Facets lists the facets to count all matches by: FacetEntity,
+	// FacetTag, FacetSource, FacetNamespace, FacetLanguage or
+	// FacetCreated. FacetSize bounds the terms per facet and FacetInterval
+	// is the bucket size of the created histogram, IntervalMonth by
+	// default.
+	Facets        []string
+	FacetSize     int
+	FacetInterval string
+	// Fusion is how a QueryHybrid search combines its results: FusionRRF
+	// (the default) or FusionWeighted. LexicalWeight and SemanticWeight
+	// weigh the two result lists; when both are zero they weigh 1 each.
+	Fusion         string
+	LexicalWeight  float64
+	SemanticWeight float64
+	// MMRLambda, when positive, re-ranks the results by maximal marginal
+	// relevance so that near-duplicates do not crowd out other memories.
+	// It trades relevance against diversity: 1 keeps the ranking by
+	// relevance, smaller values favor memories unlike those ranked above
+	// them.
+	MMRLambda float64
+	// GraphHops and GraphDecay shape a QueryGraph search: how many hops
+	// activation spreads from the seed entities, DefaultGraphHops when
+	// zero, and the share of it passed on with each hop, DefaultGraphDecay
+	// when zero.
+	GraphHops  int
+	GraphDecay float64
+	// Scoring weighs the relevance of the results by the recency,
+	// importance and pinned status of their memories.
+	Scoring ScoreFunctions
+	// Explain adds the factors of each score to its hit, along with
+	// bleve's explanation of its lexical score, and describes the query
+	// and filters the search ran in the Debug of its result.
+	Explain bool
+	// FuzzyRetry repeats a search that found nothing with FuzzinessAuto,
+	// unless it already allowed typos.
+	FuzzyRetry bool
+	// Language, when set, forces the language of the query: its words are
+	// analyzed in that language only and only memories in it match. By
+	// default a lexical search matches memories in every language, each
+	// with the query analyzed in its language.
+	Language string
+	// size, when positive, is the number of hits to return instead of
+	// defaultSearchSize.
+	size int
+	// untracked leaves the access statistics of the hits alone, for a
+	// caller that records access to those it uses itself.
+	untracked bool
+}
+
+// hitCount is the number of hits a search returns.
+func (opts SearchOptions) hitCount() int {
+	if opts.size > 0 {
+		return opts.size
+	}
+	return defaultSearchSize
+}
+
+// SearchResult is the outcome of a search: the best hits, the number of
+// memories that matched and the requested facets. A search that found
+// nothing suggests corrections of its query; Retried is set when its hits
+// come from repeating it with typos allowed. Debug is set for a search
+// that asked to explain its scores.
+type SearchResult struct {
+	Hits        []SearchHit      `json:"hits"`
+	Total       uint64           `json:"total"`
+	Facets      map[string]Facet `json:"facets,omitempty"`
+	Suggestions []string         `json:"suggestions,omitempty"`
+	Retried     bool             `json:"retried,omitempty"`
+	Debug       *SearchDebug     `json:"debug,omitempty"`
+}
+
+// SearchHit is a memory found by a search. Snippets are HTML-escaped
+// excerpts of the content with the matched terms wrapped in <mark> tags.
+// Components holds the scores a hybrid or graph search fused into Score,
+// and Explanation the factors of Score when the search asked for them; an
+// explained search also sets Components in the other modes.
+type SearchHit struct {
+	Memory
+	Score       float64           `json:"score"`
+	Snippets    []string          `json:"snippets,omitempty"`
+	Entities    []string          `json:"entities,omitempty"`
+	Components  *ScoreComponents  `json:"components,omitempty"`
+	Explanation *ScoreExplanation `json:"explanation,omitempty"`
+}
+
+const (
+	// defaultSearchSize is the number of memories a search returns.
+	defaultSearchSize = 10
+	// maxSnippets, snippetContext and snippetSize shape the snippets of a
+	// hit: at most maxSnippets excerpts of about snippetSize bytes, each
+	// starting snippetContext bytes before its first match.
+	maxSnippets    = 3
+	snippetContext = 60
+	snippetSize    = 200
+)
+
+// SearchMemories searches for memories in the bleve index, leaving out
+// superseded memories.
+func (db *DB) SearchMemories(query string) ([]string, error) {
+	result, err := db.Search(SearchOptions{Query: query})
+	if err != nil {
+		return nil, err
+	}
+	var contents []string
+	for _, hit := range result.Hits {
+		contents = append(contents, hit.Content)
+	}
+	return contents, nil
+}
+
+// Search searches for memories and records that the returned memories
+// were accessed. The access statistics on the results are those from
+// before this search. When nothing matches a text query, the result
+// suggests corrections of it, and FuzzyRetry runs it again allowing typos.
+func (db *DB) Search(opts SearchOptions) (SearchResult, error) {
+	result, ran, err := db.searchWithRetry(opts)
+	if err != nil || !opts.Explain {
+		return result, err
+	}
+	if result.Debug, err = db.describeSearch(ran); err != nil {
+		return SearchResult{}, fmt.Errorf("failed to describe search: %w", err)
+	}
+	return result, nil
+}
+
+// searchWithRetry runs a search, suggesting corrections of a text query
+// that found nothing and retrying it if asked to. It also returns the
+// options of the search that produced the result.
+func (db *DB) searchWithRetry(opts SearchOptions) (SearchResult, SearchOptions, error) {
+	if opts.MMRLambda < 0 || opts.MMRLambda > 1 {
+		return SearchResult{}, opts, fmt.Errorf("%w %v: must be between 0 and 1", ErrInvalidMMR, opts.MMRLambda)
+	}
+	if err := opts.Scoring.validate(); err != nil {
+		return SearchResult{}, opts, err
+	}
+	result, err := db.search(opts)
+	if err != nil || len(result.Hits) > 0 || strings.TrimSpace(opts.Query) == "" || opts.Structured != nil {
+		return result, opts, err
+	}
+	if result.Suggestions, err = db.Suggest(opts.Query); err != nil {
+		return SearchResult{}, opts, fmt.Errorf("failed to suggest corrections: %w", err)
+	}
+	if !opts.FuzzyRetry || opts.Fuzziness != 0 || opts.Mode == QuerySemantic {
+		return result, opts, nil
+	}
+	retry := opts
+	retry.Fuzziness = FuzzinessAuto
+	retried, err := db.search(retry)
+	if err != nil || len(retried.Hits) == 0 {
+		return result, opts, err
+	}
+	retried.Suggestions, retried.Retried = result.Suggestions, true
+	return retried, retry, nil
+}
+
+// search runs a search in its mode.
+func (db *DB) search(opts SearchOptions) (SearchResult, error) {
+	switch opts.Mode {
+	case QuerySemantic:
+		return db.semanticSearch(opts)
+	case QueryHybrid:
+		return db.hybridSearch(opts)
+	case QueryGraph:
+		return db.graphSearch(opts)
+	}
+	lexical, err := db.lexicalSearch(opts, searchDepth(opts))
+	if err != nil {
+		return SearchResult{}, err
+	}
+	found, err := lexicalMatches(lexical)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	if opts.Explain {
+		for i := range found {
+			found[i].components = &ScoreComponents{Lexical: found[i].score, LexicalRank: i + 1}
+		}
+	}
+	hits, err := db.buildHits(found, opts)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	return SearchResult{Hits: hits, Total: lexical.Total, Facets: convertFacets(lexical.Facets)}, nil
+}
+
+// searchDepth is the number of memories a retriever should return for a
+// search: more than are returned when a usage boost, score functions or
+// diversity re-ranking may lift memories from further down the list into
+// the results.
+func searchDepth(opts SearchOptions) int {
+	if opts.UsageBoost > 0 || opts.Scoring.active() || opts.MMRLambda > 0 {
+		return 3 * opts.hitCount()
+	}
+	return opts.hitCount()
+}
+
+// lexicalSearch runs the bleve query of a search, leaving out superseded
+// memories unless History is set.
+func (db *DB) lexicalSearch(opts SearchOptions, size int) (*bleve.SearchResult, error) {
+	q, err := db.buildQuery(opts)
+	if err != nil {
+		return nil, err
+	}
+	q = memoryDocumentsOnly(q)
+	if !opts.History {
+		superseded, err := db.supersededDocIDs()
+		if err != nil {
+			return nil, err
+		}
+		if len(superseded) > 0 {
+			boolean := bleve.NewBooleanQuery()
+			boolean.AddMust(q)
+			boolean.AddMustNot(bleve.NewDocIDQuery(superseded))
+			q = boolean
+		}
+	}
+	searchRequest := bleve.NewSearchRequestOptions(q, size, 0, false)
+	searchRequest.IncludeLocations = true
+	searchRequest.Explain = opts.Explain
+	if err := db.addFacets(searchRequest, opts); err != nil {
+		return nil, err
+	}
+	searchResult, err := db.index.Search(searchRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search index: %w", err)
+	}
+	return searchResult, nil
+}
+
+// scoredMemory is a memory a search found, before it is loaded. Locations
+// are its lexical matches and chunk its best semantic match, or -1.
+// Explanation is bleve's explanation of its lexical score, when the search
+// asked for it.
+type scoredMemory struct {
+	id          int64
+	score       float64
+	locations   search.FieldTermLocationMap
+	chunk       int
+	components  *ScoreComponents
+	explanation *search.Explanation
+}
+
+// lexicalMatches turns the hits of a bleve search into scored memories.
+func lexicalMatches(result *bleve.SearchResult) ([]scoredMemory, error) {
+	found := make([]scoredMemory, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		id, err := strconv.ParseInt(hit.ID, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse memory ID: %w", err)
+		}
+		if hit.Expl != nil {
+			relabelExplanation(hit.Expl, string(hit.IndexInternalID), hit.ID)
+		}
+		found = append(found, scoredMemory{id: id, score: hit.Score, locations: hit.Locations, chunk: -1, explanation: hit.Expl})
+	}
+	return found, nil
+}
+
+// relabelExplanation replaces the internal document number bleve writes
+// into the messages of a score explanation, raw bytes, with the ID of the
+// memory.
+func relabelExplanation(expl *search.Explanation, internal, id string) {
+	if internal != "" {
+		expl.Message = strings.ReplaceAll(expl.Message, internal, id)
+	}
+	for _, child := range expl.Children {
+		relabelExplanation(child, internal, id)
+	}
+}
+
+// buildHits loads the memories a search found, applies the usage boost,
+// score functions and diversity re-ranking and returns the best of them as
+// hits, recording that they were accessed unless the search is untracked.
+func (db *DB) buildHits(found []scoredMemory, opts SearchOptions) ([]SearchHit, error) {
+	ids := make([]int64, len(found))
+	for i, f := range found {
+		ids[i] = f.id
+	}
+	memories, err := db.loadMemories(ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load memories: %w", err)
+	}
+	entities, err := db.loadEntityNames(ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load memory entities: %w", err)
+	}
+
+	hits := make([]SearchHit, 0, len(found))
+	now := time.Now()
+	for _, f := range found {
+		memory, ok := memories[f.id]
+		if !ok {
+			// The index is ahead of a deletion; skip the stale document.
+			continue
+		}
+		explanation := opts.Scoring.explain(f.score, opts.UsageBoost, memory, now)
+		hit := SearchHit{
+			Memory:     memory,
+			Score:      explanation.Score(),
+			Snippets:   snippets(memory.Content, f.locations),
+			Entities:   entities[memory.ID],
+			Components: f.components,
+		}
+		if opts.Explain {
+			explanation.Lexical = f.explanation
+			hit.Explanation = &explanation
+		}
+		if hit.Snippets == nil && f.chunk >= 0 {
+			hit.Snippets = chunkSnippet(memory.Content, f.chunk)
+		}
+		hits = append(hits, hit)
+	}
+	if opts.UsageBoost > 0 || opts.Scoring.active() {
+		sort.SliceStable(hits, func(a, b int) bool { return hits[a].Score > hits[b].Score })
+	}
+	if opts.MMRLambda > 0 {
+		if hits, err = db.diversify(hits, opts.MMRLambda, opts.hitCount()); err != nil {
+			return nil, fmt.Errorf("failed to diversify results: %w", err)
+		}
+	}
+	if len(hits) > opts.hitCount() {
+		hits = hits[:opts.hitCount()]
+	}
+	if opts.untracked {
+		return hits, nil
+	}
+
+	accessed := make([]int64, len(hits))
+	for i, hit := range hits {
+		accessed[i] = hit.ID
+	}
+	db.RecordAccess(accessed...)
+	return hits, nil
+}
+
+// loadMemories returns the memories with the given IDs, keyed by ID.
+func (db *DB) loadMemories(ids []int64) (map[int64]Memory, error) {
+	byID := make(map[int64]Memory, len(ids))
+	if len(ids) == 0 {
+		return byID, nil
+	}
+	placeholders, args := inList(ids)
+	memories, err := db.queryMemories(memoryColumns+" WHERE m.id IN ("+placeholders+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	for _, memory := range memories {
+		byID[memory.ID] = memory
+	}
+	return byID, nil
+}
+
+// loadEntityNames returns the names of the entities each of the given
+// memories mentions, sorted by name.
+func (db *DB) loadEntityNames(ids []int64) (map[int64][]string, error) {
+	names := make(map[int64][]string, len(ids))
+	if len(ids) == 0 {
+		return names, nil
+	}
+	placeholders, args := inList(ids)
+	rows, err := db.Query(`SELECT me.memory_id, e.name FROM memory_entities me
+		JOIN entities e ON e.id = me.entity_id
+		WHERE me.memory_id IN (`+placeholders+`) ORDER BY e.name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id   int64
+			name string
+		)
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = append(names[id], name)
+	}
+	return names, rows.Err()
+}
+
+// inList returns the placeholders and arguments of an SQL IN list.
+func inList(ids []int64) (string, []interface{}) {
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	return strings.Join(placeholders, ", "), args
+}
+
+// snippets cuts excerpts around the matched terms of a hit out of content.
+func snippets(content string, locations search.FieldTermLocationMap) []string {
+	type span struct{ start, end int }
+	var spans []span
+	// Matches in other fields have offsets into those fields.
+	for _, field := range []string{"content", "content_words"} {
+		for _, locs := range locations[field] {
+			for _, loc := range locs {
+				start, end := int(loc.Start), int(loc.End)
+				if start < 0 || end > len(content) || start >= end {
+					continue
+				}
+				spans = append(spans, span{start, end})
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+	sort.Slice(spans, func(a, b int) bool { return spans[a].start < spans[b].start })
+
+	var fragments []string
+	for i := 0; i < len(spans) && len(fragments) < maxSnippets; {
+		from := runeStart(content, spans[i].start-snippetContext)
+		to := runeStart(content, from+snippetSize)
+		if to < spans[i].end {
+			to = spans[i].end
+		}
+
+		var b strings.Builder
+		if from > 0 {
+			b.WriteString("…")
+		}
+		pos := from
+		for ; i < len(spans) && spans[i].end <= to; i++ {
+			if spans[i].start < pos {
+				// Overlaps a term already marked.
+				continue
+			}
+			b.WriteString(html.EscapeString(content[pos:spans[i].start]))
+			b.WriteString("<mark>")
+			b.WriteString(html.EscapeString(content[spans[i].start:spans[i].end]))
+			b.WriteString("</mark>")
+			pos = spans[i].end
+		}
+		b.WriteString(html.EscapeString(content[pos:to]))
+		if to < len(content) {
+			b.WriteString("…")
+		}
+		fragments = append(fragments, b.String())
+		// Skip terms cut off at the end of this fragment.
+		for i < len(spans) && spans[i].start < to {
+			i++
+		}
+	}
+	return fragments
+}
+
+// runeStart clamps offset to content and moves it back to the start of the
+// rune it falls in.
+func runeStart(content string, offset int) int {
+	if offset <= 0 {
+		return 0
+	}
+	if offset >= len(content) {
+		return len(content)
+	}
+	for offset > 0 && !utf8.RuneStart(content[offset]) {
+		offset--
+	}
+	return offset
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/blevesearch/bleve/v2/search"
+)
+
+func TestSearchHits(t *testing.T) {
+	db := newTestDB(t)
+
+	id, err := db.AddMemory("the staging database runs postgres 16", []string{"staging", "postgres"})
+	if err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+	if _, err := db.AddMemory("lunch is at noon", nil); err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+
+	result, err := db.Search(SearchOptions{Query: "postgres"})
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if len(result.Hits) != 1 || result.Total != 1 {
+		t.Fatalf("expected one hit, got %+v", result)
+	}
+	hit := result.Hits[0]
+	if hit.ID != id || hit.Score <= 0 || hit.CreatedAt.IsZero() {
+		t.Errorf("expected the ID, a score and the creation time, got %+v", hit)
+	}
+	if len(hit.Entities) != 2 || hit.Entities[0] != "postgres" || hit.Entities[1] != "staging" {
+		t.Errorf("expected the memory's entities, got %v", hit.Entities)
+	}
+	if len(hit.Snippets) != 1 || !strings.Contains(hit.Snippets[0], "<mark>postgres</mark>") {
+		t.Errorf("expected a highlighted snippet, got %v", hit.Snippets)
+	}
+}
+
+func TestSnippets(t *testing.T) {
+	locations := func(spans ...[2]uint64) search.FieldTermLocationMap {
+		var locs search.Locations
+		for _, s := range spans {
+			locs = append(locs, &search.Location{Start: s[0], End: s[1]})
+		}
+		return search.FieldTermLocationMap{"content": search.TermLocationMap{"term": locs}}
+	}
+
+	if got := snippets("a <b> & c", locations([2]uint64{8, 9})); len(got) != 1 || got[0] != "a &lt;b&gt; &amp; <mark>c</mark>" {
+		t.Errorf("expected an escaped snippet, got %q", got)
+	}
+
+	long := strings.Repeat("é", 100) + "match" + strings.Repeat("x", 300) + "match"
+	got := snippets(long, locations([2]uint64{200, 205}, [2]uint64{505, 510}))
+	if len(got) != 2 {
+		t.Fatalf("expected two snippets for distant matches, got %q", got)
+	}
+	for _, s := range got {
+		if !strings.HasPrefix(s, "…") || !strings.Contains(s, "<mark>match</mark>") {
+			t.Errorf("expected a truncated snippet around the match, got %q", s)
+		}
+		if !strings.HasPrefix(strings.TrimPrefix(s, "…"), "é") && !strings.HasPrefix(strings.TrimPrefix(s, "…"), "x") {
+			t.Errorf("expected the snippet to start on a rune boundary, got %q", s)
+		}
+	}
+
+	if got := snippets("content", nil); got != nil {
+		t.Errorf("expected no snippets without matches, got %q", got)
+	}
+}
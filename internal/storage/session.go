@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrSessionNotFound is returned when a session does not exist.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSessionClosed is returned when appending to a closed session.
+	ErrSessionClosed = errors.New("session is closed")
+	// ErrTurnNotFound is returned when a turn does not exist.
+	ErrTurnNotFound = errors.New("turn not found")
+)
+
+// Roles that a turn may have.
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleSystem    = "system"
+	RoleTool      = "tool"
+)
+
+// Session is a conversation between a client and the assistant.
+type Session struct {
+	ID        int64      `json:"id"`
+	ClientID  string     `json:"client_id"`
+	StartedAt time.Time  `json:"started_at"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty"`
+}
+
+// Turn is a single message exchanged within a session.
+type Turn struct {
+	ID        int64     `json:"id"`
+	SessionID int64     `json:"session_id"`
+	Seq       int64     `json:"seq"`
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	ClientID  string    `json:"client_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StartSession opens a new session for the given client.
+func (db *DB) StartSession(clientID string) (int64, error) {
+	result, err := db.Exec("INSERT INTO sessions (client_id) VALUES (?)", clientID)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// GetSession retrieves a session by ID.
+func (db *DB) GetSession(id int64) (Session, error) {
+	var (
+		session  Session
+		closedAt sql.NullTime
+	)
+	err := db.QueryRow("SELECT id, client_id, started_at, closed_at FROM sessions WHERE id = ?", id).
+		Scan(&session.ID, &session.ClientID, &session.StartedAt, &closedAt)
+	if err == sql.ErrNoRows {
+		return Session{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return Session{}, err
+	}
+	if closedAt.Valid {
+		session.ClosedAt = &closedAt.Time
+	}
+	return session, nil
+}
+
+// CloseSession marks a session as closed. Closing an already closed session
+// is a no-op.
+func (db *DB) CloseSession(id int64) error {
+	session, err := db.GetSession(id)
+	if err != nil {
+		return err
+	}
+	if session.ClosedAt != nil {
+		return nil
+	}
+	_, err = db.Exec("UPDATE sessions SET closed_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	return err
+}
+
+// AppendTurn adds a turn to the end of an open session.
+func (db *DB) AppendTurn(sessionID int64, role, content, clientID string) (Turn, error) {
+	switch role {
+	case RoleUser, RoleAssistant, RoleSystem, RoleTool:
+	default:
+		return Turn{}, fmt.Errorf("invalid turn role %q", role)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return Turn{}, err
+	}
+
+	var closedAt sql.NullTime
+	err = tx.QueryRow("SELECT closed_at FROM sessions WHERE id = ?", sessionID).Scan(&closedAt)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return Turn{}, ErrSessionNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return Turn{}, err
+	}
+	if closedAt.Valid {
+		tx.Rollback()
+		return Turn{}, ErrSessionClosed
+	}
+
+	var seq int64
+	if err := tx.QueryRow("SELECT COALESCE(MAX(seq), 0) + 1 FROM turns WHERE session_id = ?", sessionID).Scan(&seq); err != nil {
+		tx.Rollback()
+		return Turn{}, err
+	}
+
+	result, err := tx.Exec("INSERT INTO turns (session_id, seq, role, content, client_id) VALUES (?, ?, ?, ?, ?)",
+		sessionID, seq, role, content, clientID)
+	if err != nil {
+		tx.Rollback()
+		return Turn{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return Turn{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Turn{}, err
+	}
+
+	return db.getTurn(id)
+}
+
+// getTurn retrieves a turn by ID.
+func (db *DB) getTurn(id int64) (Turn, error) {
+	var turn Turn
+	err := db.QueryRow("SELECT id, session_id, seq, role, content, client_id, created_at FROM turns WHERE id = ?", id).
+		Scan(&turn.ID, &turn.SessionID, &turn.Seq, &turn.Role, &turn.Content, &turn.ClientID, &turn.CreatedAt)
+	if err == sql.ErrNoRows {
+		return Turn{}, ErrTurnNotFound
+	}
+	return turn, err
+}
+
+// GetTurns retrieves the turns of a session in order.
+func (db *DB) GetTurns(sessionID int64) ([]Turn, error) {
+	if _, err := db.GetSession(sessionID); err != nil {
+		return nil, err
+	}
+	return db.queryTurns("SELECT id, session_id, seq, role, content, client_id, created_at FROM turns WHERE session_id = ? ORDER BY seq", sessionID)
+}
+
+// GetTurnsAround retrieves the conversation a memory was recorded in, along
+// with up to window turns on either side of the turn it came from. If the
+// memory was not tied to a specific turn, the window is centred on the last
+// turn written before the memory. Memories recorded outside of any session
+// return a nil session and no turns.
+func (db *DB) GetTurnsAround(memoryID int64, window int) (*Session, []Turn, error) {
+	var (
+		sessionID, turnID sql.NullInt64
+		createdAt         time.Time
+	)
+	err := db.QueryRow("SELECT session_id, turn_id, created_at FROM memories WHERE id = ?", memoryID).
+		Scan(&sessionID, &turnID, &createdAt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !sessionID.Valid {
+		return nil, nil, nil
+	}
+
+	session, err := db.GetSession(sessionID.Int64)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var anchor int64
+	if turnID.Valid {
+		err = db.QueryRow("SELECT seq FROM turns WHERE id = ?", turnID.Int64).Scan(&anchor)
+	} else {
+		err = db.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM turns WHERE session_id = ? AND created_at <= ?",
+			sessionID.Int64, formatTime(createdAt)).Scan(&anchor)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if window < 0 {
+		window = 0
+	}
+
+	turns, err := db.queryTurns("SELECT id, session_id, seq, role, content, client_id, created_at FROM turns WHERE session_id = ? AND seq BETWEEN ? AND ? ORDER BY seq",
+		sessionID.Int64, anchor-int64(window), anchor+int64(window))
+	if err != nil {
+		return nil, nil, err
+	}
+	return &session, turns, nil
+}
+
+func (db *DB) queryTurns(query string, args ...interface{}) ([]Turn, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var turns []Turn
+	for rows.Next() {
+		var turn Turn
+		if err := rows.Scan(&turn.ID, &turn.SessionID, &turn.Seq, &turn.Role, &turn.Content, &turn.ClientID, &turn.CreatedAt); err != nil {
+			return nil, err
+		}
+		turns = append(turns, turn)
+	}
+	return turns, rows.Err()
+}
+
+// resolveConversation validates the session and turn a new memory refers to
+// and returns them as nullable column values.
+func resolveConversation(tx *sql.Tx, sessionID, turnID int64) (sql.NullInt64, sql.NullInt64, error) {
+	if turnID != 0 {
+		var turnSession int64
+		err := tx.QueryRow("SELECT session_id FROM turns WHERE id = ?", turnID).Scan(&turnSession)
+		if err == sql.ErrNoRows {
+			return sql.NullInt64{}, sql.NullInt64{}, ErrTurnNotFound
+		} else if err != nil {
+			return sql.NullInt64{}, sql.NullInt64{}, err
+		}
+		if sessionID != 0 && sessionID != turnSession {
+			return sql.NullInt64{}, sql.NullInt64{}, fmt.Errorf("turn %d does not belong to session %d", turnID, sessionID)
+		}
+		return sql.NullInt64{Int64: turnSession, Valid: true}, sql.NullInt64{Int64: turnID, Valid: true}, nil
+	}
+	if sessionID != 0 {
+		var exists int
+		err := tx.QueryRow("SELECT 1 FROM sessions WHERE id = ?", sessionID).Scan(&exists)
+		if err == sql.ErrNoRows {
+			return sql.NullInt64{}, sql.NullInt64{}, ErrSessionNotFound
+		} else if err != nil {
+			return sql.NullInt64{}, sql.NullInt64{}, err
+		}
+		return sql.NullInt64{Int64: sessionID, Valid: true}, sql.NullInt64{}, nil
+	}
+	return sql.NullInt64{}, sql.NullInt64{}, nil
+}
+
+// formatTime formats t the way SQLite's CURRENT_TIMESTAMP does, so that it
+// compares correctly against stored timestamps.
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
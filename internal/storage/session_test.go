@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestSessionTurns(t *testing.T) {
+	db := newTestDB(t)
+
+	sessionID, err := db.StartSession("cli")
+	if err != nil {
+		t.Fatalf("failed to start session: %v", err)
+	}
+
+	var turns []Turn
+	for i, content := range []string{"hi", "hello", "let's use sqlite", "agreed", "bye"} {
+		role := RoleUser
+		if i%2 == 1 {
+			role = RoleAssistant
+		}
+		turn, err := db.AppendTurn(sessionID, role, content, "cli")
+		if err != nil {
+			t.Fatalf("failed to append turn: %v", err)
+		}
+		if turn.Seq != int64(i+1) {
+			t.Errorf("expected seq %d, got %d", i+1, turn.Seq)
+		}
+		turns = append(turns, turn)
+	}
+
+	if _, err := db.AppendTurn(sessionID, "narrator", "x", "cli"); err == nil {
+		t.Error("expected an error for an invalid role")
+	}
+
+	memoryID, err := db.CreateMemory(MemoryInput{Content: "the project uses sqlite", TurnID: turns[2].ID})
+	if err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+
+	session, window, err := db.GetTurnsAround(memoryID, 1)
+	if err != nil {
+		t.Fatalf("failed to get turns around memory: %v", err)
+	}
+	if session == nil || session.ID != sessionID {
+		t.Fatalf("expected session %d, got %+v", sessionID, session)
+	}
+	if len(window) != 3 || window[0].Seq != 2 || window[2].Seq != 4 {
+		t.Errorf("expected turns 2-4, got %+v", window)
+	}
+
+	if err := db.CloseSession(sessionID); err != nil {
+		t.Fatalf("failed to close session: %v", err)
+	}
+	if _, err := db.AppendTurn(sessionID, RoleUser, "again", "cli"); err != ErrSessionClosed {
+		t.Errorf("expected ErrSessionClosed, got %v", err)
+	}
+
+	all, err := db.GetTurns(sessionID)
+	if err != nil {
+		t.Fatalf("failed to get turns: %v", err)
+	}
+	if len(all) != 5 {
+		t.Errorf("expected 5 turns, got %d", len(all))
+	}
+}
+
+func TestGetTurnsAroundWithoutSession(t *testing.T) {
+	db := newTestDB(t)
+
+	memoryID, err := db.AddMemory("standalone", nil)
+	if err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+	session, turns, err := db.GetTurnsAround(memoryID, 2)
+	if err != nil {
+		t.Fatalf("failed to get turns around memory: %v", err)
+	}
+	if session != nil || len(turns) != 0 {
+		t.Errorf("expected no conversation, got %+v %+v", session, turns)
+	}
+
+	if _, err := db.CreateMemory(MemoryInput{Content: "orphan", SessionID: 42}); err != ErrSessionNotFound {
+		t.Errorf("expected ErrSessionNotFound, got %v", err)
+	}
+}
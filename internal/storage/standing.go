@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// ErrInvalidStandingQuery is returned for a standing query that cannot be
+// matched against new memories.
+var ErrInvalidStandingQuery = errors.New("invalid standing query")
+
+// StandingQuery is a saved query that every new memory is matched against.
+// Query, Mode, Structured and Language have the meaning they have in
+// SearchOptions; Mode is QueryMatch or QueryString. Webhook, when set, is
+// a URL to post matches to.
+type StandingQuery struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name,omitempty"`
+	Query      string     `json:"query,omitempty"`
+	Mode       string     `json:"mode,omitempty"`
+	Structured *QueryNode `json:"structured_query,omitempty"`
+	Language   string     `json:"language,omitempty"`
+	Webhook    string     `json:"webhook,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// searchOptions returns the options of a search running q.
+func (q StandingQuery) searchOptions() SearchOptions {
+	return SearchOptions{Query: q.Query, Mode: q.Mode, Structured: q.Structured, Language: q.Language}
+}
+
+// CreateStandingQuery saves a standing query and returns it with its ID.
+// The query is compiled first, so that one that could never match is
+// rejected.
+func (db *DB) CreateStandingQuery(q StandingQuery) (StandingQuery, error) {
+	q.Name, q.Query, q.Webhook = strings.TrimSpace(q.Name), strings.TrimSpace(q.Query), strings.TrimSpace(q.Webhook)
+	switch {
+	case q.Mode != "" && q.Mode != QueryMatch && q.Mode != QueryString:
+		return StandingQuery{}, fmt.Errorf("%w: mode must be %s or %s, got %q", ErrInvalidStandingQuery, QueryMatch, QueryString, q.Mode)
+	case q.Query == "" && q.Structured == nil:
+		return StandingQuery{}, fmt.Errorf("%w: set query or structured_query", ErrInvalidStandingQuery)
+	}
+	if _, err := db.buildQuery(q.searchOptions()); err != nil {
+		return StandingQuery{}, err
+	}
+
+	var structured sql.NullString
+	if q.Structured != nil {
+		data, err := json.Marshal(q.Structured)
+		if err != nil {
+			return StandingQuery{}, err
+		}
+		structured = sql.NullString{String: string(data), Valid: true}
+	}
+	result, err := db.Exec("INSERT INTO standing_queries (name, query, mode, structured, language, webhook) VALUES (?, ?, ?, ?, ?, ?)",
+		q.Name, q.Query, q.Mode, structured, q.Language, q.Webhook)
+	if err != nil {
+		return StandingQuery{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return StandingQuery{}, err
+	}
+	return scanStandingQuery(db.QueryRow(standingQueryColumns+" WHERE id = ?", id))
+}
+
+// ListStandingQueries returns the standing queries, oldest first.
+func (db *DB) ListStandingQueries() ([]StandingQuery, error) {
+	rows, err := db.Query(standingQueryColumns + " ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var queries []StandingQuery
+	for rows.Next() {
+		q, err := scanStandingQuery(rows)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+	return queries, rows.Err()
+}
+
+// DeleteStandingQuery deletes a standing query. It returns sql.ErrNoRows
+// when there is no query with that ID.
+func (db *DB) DeleteStandingQuery(id int64) error {
+	result, err := db.Exec("DELETE FROM standing_queries WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// StandingMatch is a stored memory, with the canonical names of the
+// entities it mentions, and the standing queries it matches.
+type StandingMatch struct {
+	Memory   Memory
+	Entities []string
+	Queries  []StandingQuery
+}
+
+// MatchStandingQueries returns the standing queries a memory matches. Like
+// a percolator, it runs each query against the memory's document alone
+// instead of the whole index, so its cost does not grow with the number of
+// memories. A query that fails to run is skipped: the other matches are
+// returned along with its error.
+func (db *DB) MatchStandingQueries(memoryID int64) (StandingMatch, error) {
+	queries, err := db.ListStandingQueries()
+	if err != nil || len(queries) == 0 {
+		return StandingMatch{}, err
+	}
+	doc := bleve.NewDocIDQuery([]string{strconv.FormatInt(memoryID, 10)})
+	var (
+		match StandingMatch
+		errs  []error
+	)
+	for _, q := range queries {
+		compiled, err := db.buildQuery(q.searchOptions())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("standing query %d: %w", q.ID, err))
+			continue
+		}
+		result, err := db.index.Search(bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(compiled, doc), 1, 0, false))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("standing query %d: failed to search index: %w", q.ID, err))
+			continue
+		}
+		if result.Total > 0 {
+			match.Queries = append(match.Queries, q)
+		}
+	}
+	if len(match.Queries) > 0 {
+		memories, err := db.loadMemories([]int64{memoryID})
+		if err != nil {
+			return StandingMatch{}, err
+		}
+		entities, err := db.loadEntityNames([]int64{memoryID})
+		if err != nil {
+			return StandingMatch{}, err
+		}
+		match.Memory, match.Entities = memories[memoryID], entities[memoryID]
+	}
+	return match, errors.Join(errs...)
+}
+
+// standingQueryColumns selects a standing query in the order
+// scanStandingQuery expects.
+const standingQueryColumns = "SELECT id, name, query, mode, structured, language, webhook, created_at FROM standing_queries"
+
+func scanStandingQuery(row scanner) (StandingQuery, error) {
+	var (
+		q          StandingQuery
+		structured sql.NullString
+	)
+	if err := row.Scan(&q.ID, &q.Name, &q.Query, &q.Mode, &structured, &q.Language, &q.Webhook, &q.CreatedAt); err != nil {
+		return StandingQuery{}, err
+	}
+	if structured.Valid {
+		q.Structured = &QueryNode{}
+		if err := json.Unmarshal([]byte(structured.String), q.Structured); err != nil {
+			return StandingQuery{}, fmt.Errorf("failed to decode standing query %d: %w", q.ID, err)
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestStandingQueries(t *testing.T) {
+	db := newTestDB(t)
+	decisions, err := db.CreateStandingQuery(StandingQuery{Name: "payments decisions", Query: "+decided entity:payments-api", Mode: QueryString, Webhook: "http://example.com/hook"})
+	if err != nil {
+		t.Fatalf("failed to create standing query: %v", err)
+	}
+	outages, err := db.CreateStandingQuery(StandingQuery{Structured: &QueryNode{Must: []QueryNode{{Match: "outage"}}}})
+	if err != nil {
+		t.Fatalf("failed to create standing query: %v", err)
+	}
+	if decisions.ID == 0 || decisions.Webhook != "http://example.com/hook" || outages.Structured == nil || outages.Structured.Must[0].Match != "outage" {
+		t.Errorf("unexpected standing queries %+v, %+v", decisions, outages)
+	}
+
+	var last StandingMatch
+	match := func(content string, entities ...string) []int64 {
+		t.Helper()
+		id, err := db.AddMemory(content, entities)
+		if err != nil {
+			t.Fatalf("failed to add memory: %v", err)
+		}
+		last, err = db.MatchStandingQueries(id)
+		if err != nil {
+			t.Fatalf("failed to match standing queries: %v", err)
+		}
+		ids := make([]int64, len(last.Queries))
+		for i, q := range last.Queries {
+			ids[i] = q.ID
+		}
+		return ids
+	}
+	if got := match("we decided to version the payments API", "payments-api"); len(got) != 1 || got[0] != decisions.ID {
+		t.Errorf("expected the decision query to match, got %v", got)
+	}
+	// The match names the entities as stored, not as the memory spelled them.
+	if got := match("we decided to deprecate v1 of the payments API", " Payments-API "); len(got) != 1 ||
+		last.Memory.Content != "we decided to deprecate v1 of the payments API" || len(last.Entities) != 1 || last.Entities[0] != "payments-api" {
+		t.Errorf("expected the memory with its canonical entity, got %v and %+v", got, last)
+	}
+	// Only the new memory is matched, not those already stored.
+	if got := match("the outages were caused by the queue"); len(got) != 1 || got[0] != outages.ID {
+		t.Errorf("expected the outage query to match, got %v", got)
+	}
+	if got := match("we decided to use postgres", "postgres"); len(got) != 0 {
+		t.Errorf("expected no match, got %v", got)
+	}
+
+	// A query that no longer compiles is skipped without hiding the others.
+	if _, err := db.Exec("INSERT INTO standing_queries (name, query, mode, language, webhook) VALUES ('broken', '\"unclosed', ?, '', '')", QueryString); err != nil {
+		t.Fatalf("failed to insert standing query: %v", err)
+	}
+	id, err := db.AddMemory("another outage in the queue", nil)
+	if err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+	matched, err := db.MatchStandingQueries(id)
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) || len(matched.Queries) != 1 || matched.Queries[0].ID != outages.ID {
+		t.Errorf("expected the outage query to match despite the broken one, got %+v, %v", matched.Queries, err)
+	}
+	if _, err := db.Exec("DELETE FROM standing_queries WHERE name = 'broken'"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DeleteStandingQuery(outages.ID); err != nil {
+		t.Fatalf("failed to delete standing query: %v", err)
+	}
+	queries, err := db.ListStandingQueries()
+	if err != nil || len(queries) != 1 || queries[0].ID != decisions.ID {
+		t.Errorf("expected the decision query to remain, got %+v, %v", queries, err)
+	}
+	if err := db.DeleteStandingQuery(outages.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+
+	for _, q := range []StandingQuery{{}, {Query: "payments", Mode: QuerySemantic}} {
+		if _, err := db.CreateStandingQuery(q); !errors.Is(err, ErrInvalidStandingQuery) {
+			t.Errorf("%+v: expected ErrInvalidStandingQuery, got %v", q, err)
+		}
+	}
+	if _, err := db.CreateStandingQuery(StandingQuery{Query: `"unclosed`, Mode: QueryString}); !errors.As(err, &queryErr) {
+		t.Errorf("expected a query error, got %v", err)
+	}
+}
@@ -1,28 +1,85 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	_ "embed"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/blevesearch/bleve/v2"
+	"github.com/wassmi/nodimus-memory/internal/embed"
+	"github.com/wassmi/nodimus-memory/internal/hnsw"
 	_ "modernc.org/sqlite"
 )
 
 //go:embed schema.sql
 var schema string
 
+//go:embed indexes.sql
+var indexes string
+
+// columnMigrations lists columns added to tables after their first release.
+// CREATE TABLE IF NOT EXISTS leaves existing tables untouched, so Migrate adds
+// any of these columns that an older database is missing.
+var columnMigrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"memories", "session_id", "INTEGER REFERENCES sessions (id) ON DELETE SET NULL"},
+	{"memories", "turn_id", "INTEGER REFERENCES turns (id) ON DELETE SET NULL"},
+	{"memories", "access_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"memories", "last_accessed_at", "DATETIME"},
+	{"memories", "source", "TEXT NOT NULL DEFAULT ''"},
+	{"memories", "namespace", "TEXT NOT NULL DEFAULT ''"},
+	{"memories", "importance", "REAL NOT NULL DEFAULT 0.5"},
+	{"memories", "updated_at", "DATETIME"},
+	{"memories", "pinned", "INTEGER NOT NULL DEFAULT 0"},
+	{"memories", "language", "TEXT NOT NULL DEFAULT ''"},
+	{"entities", "norm_name", "TEXT"},
+	{"relationships", "weight", "REAL NOT NULL DEFAULT 1"},
+	{"relationships", "confidence", "REAL NOT NULL DEFAULT 1"},
+	{"relationships", "properties", "TEXT"},
+	{"relationships", "source_memory_id", "INTEGER REFERENCES memories (id) ON DELETE SET NULL"},
+	{"relationships", "created_at", "DATETIME"},
+	{"relationships", "valid_from", "DATETIME"},
+	{"relationships", "valid_to", "DATETIME"},
+}
+
 // DB is a wrapper around the SQL database connection.
 type DB struct {
 	*sql.DB
-	index bleve.Index
+	index     bleve.Index
+	indexPath string
+	// indexStale is set when the index was created empty and Migrate must
+	// fill it from the database.
+	indexStale bool
+	access     *accessTracker
+	// embedder embeds new memories; nil leaves them without embeddings.
+	embedder embed.Embedder
+	// vectors indexes the embeddings for semantic search once
+	// OpenVectorIndex has opened it.
+	vectors    *hnsw.Index
+	vectorPath string
 }
 
 // NewDB creates a new database connection.
 func NewDB(dataSourceName string) (*DB, error) {
-	db, err := sql.Open("sqlite", dataSourceName)
+	// Accesses are written in the background, so wait for a busy database
+	// instead of failing right away.
+	dsn := dataSourceName
+	if !strings.Contains(dsn, "?") {
+		dsn += "?_pragma=busy_timeout(5000)"
+	}
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -30,41 +87,143 @@ func NewDB(dataSourceName string) (*DB, error) {
 		return nil, err
 	}
 
-	// Open or create a bleve index
 	indexPath := dataSourceName + ".bleve"
-	var index bleve.Index
-	if _, err := os.Stat(indexPath); os.IsNotExist(err) {
-		// Index does not exist, create it
-		mapping := bleve.NewIndexMapping()
-		index, err = bleve.New(indexPath, mapping)
+	index, stale, err := openIndex(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DB{DB: db, index: index, indexPath: indexPath, indexStale: stale, access: newAccessTracker(), vectorPath: dataSourceName + ".hnsw"}, nil
+}
+
+// Migrate runs the database migrations.
+func (db *DB) Migrate() error {
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+	for _, m := range columnMigrations {
+		exists, err := db.hasColumn(m.table, m.column)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create bleve index: %w", err)
+			return err
 		}
-	} else {
-		// Index exists, open it
-		index, err = bleve.Open(indexPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open bleve index: %w", err)
+		if exists {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.definition)); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", m.table, m.column, err)
 		}
 	}
+	// Older databases may hold duplicate edges, which would prevent the
+	// unique index on current relationships from being created.
+	if _, err := db.Exec(`DELETE FROM relationships WHERE valid_to IS NULL AND id NOT IN (
+		SELECT MIN(id) FROM relationships WHERE valid_to IS NULL GROUP BY source_id, target_id, type)`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(indexes); err != nil {
+		return err
+	}
+	if err := db.backfillEntityNames(); err != nil {
+		return err
+	}
+	if err := db.backfillLanguages(); err != nil {
+		return err
+	}
+	if db.indexStale {
+		if err := db.rebuildIndex(); err != nil {
+			return fmt.Errorf("failed to rebuild search index: %w", err)
+		}
+		db.indexStale = false
+	}
+	return nil
+}
+
+// hasColumn reports whether the given table has a column with the given name.
+func (db *DB) hasColumn(table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
 
-	return &DB{DB: db, index: index}, nil
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, colType    string
+			defaultValue     sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
 }
 
-// Migrate runs the database migrations.
-func (db *DB) Migrate() error {
-	_, err := db.Exec(schema)
-	return err
+// MemoryInput describes a memory to be stored.
+type MemoryInput struct {
+	Content  string
+	Entities []string
+	// SessionID and TurnID optionally record the conversation the memory
+	// came from. When only TurnID is set, the session is taken from the turn.
+	SessionID int64
+	TurnID    int64
+	Tags      []string
+	Source    string
+	Namespace string
+	// Importance is between 0 and 1; it defaults to DefaultImportance when
+	// nil.
+	Importance *float64
+	// Pinned memories rank higher in searches that boost them.
+	Pinned bool
+	// Language is the language the content is analyzed in; it is detected
+	// from the content when empty.
+	Language string
 }
 
 // AddMemory adds a new memory and links it to the given entities.
 func (db *DB) AddMemory(content string, entityNames []string) (int64, error) {
+	return db.CreateMemory(MemoryInput{Content: content, Entities: entityNames})
+}
+
+// CreateMemory stores a new memory described by in.
+func (db *DB) CreateMemory(in MemoryInput) (int64, error) {
+	content, entityNames := in.Content, in.Entities
+	importance := DefaultImportance
+	if in.Importance != nil {
+		importance = *in.Importance
+		if importance < 0 || importance > 1 {
+			return 0, fmt.Errorf("importance must be between 0 and 1, got %v", importance)
+		}
+	}
+	language := in.Language
+	if err := ValidLanguage(language); err != nil {
+		return 0, err
+	}
+	if language == "" {
+		language = DetectLanguage(content)
+	}
+
+	// Embedding may be slow, so it happens before the transaction starts.
+	chunks, vectors, err := db.embedContents(context.Background(), []string{content})
+	if err != nil {
+		return 0, err
+	}
+
 	tx, err := db.Begin()
 	if err != nil {
 		return 0, err
 	}
 
-	result, err := tx.Exec("INSERT INTO memories (content) VALUES (?)", content)
+	sessionID, turnID, err := resolveConversation(tx, in.SessionID, in.TurnID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	result, err := tx.Exec("INSERT INTO memories (content, session_id, turn_id, source, namespace, importance, pinned, language) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		content, sessionID, turnID, strings.TrimSpace(in.Source), strings.TrimSpace(in.Namespace), importance, in.Pinned, language)
 	if err != nil {
 		tx.Rollback()
 		return 0, err
@@ -75,63 +234,106 @@ func (db *DB) AddMemory(content string, entityNames []string) (int64, error) {
 		return 0, err
 	}
 
+	// The canonical entity names and tags go into the index document.
+	var entities, tags []string
 	for _, entityName := range entityNames {
-		var entityID int64
-		err := tx.QueryRow("SELECT id FROM entities WHERE name = ?", entityName).Scan(&entityID)
-		if err == sql.ErrNoRows {
-			result, err := tx.Exec("INSERT INTO entities (name, type) VALUES (?, ?)", entityName, "unknown")
-			if err != nil {
-				tx.Rollback()
-				return 0, err
-			}
-			entityID, err = result.LastInsertId()
-			if err != nil {
+		if NormalizeEntityName(entityName) == "" {
+			continue
+		}
+		entityID, err := findOrCreateEntity(tx, entityName)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+
+		result, err := tx.Exec("INSERT OR IGNORE INTO memory_entities (memory_id, entity_id) VALUES (?, ?)", memoryID, entityID)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			var name string
+			if err := tx.QueryRow("SELECT name FROM entities WHERE id = ?", entityID).Scan(&name); err != nil {
 				tx.Rollback()
 				return 0, err
 			}
-		} else if err != nil {
+			entities = append(entities, name)
+		}
+	}
+
+	for _, tag := range in.Tags {
+		if tag = normalizeTag(tag); tag == "" {
+			continue
+		}
+		result, err := tx.Exec("INSERT OR IGNORE INTO memory_tags (memory_id, tag) VALUES (?, ?)", memoryID, tag)
+		if err != nil {
 			tx.Rollback()
 			return 0, err
 		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			tags = append(tags, tag)
+		}
+	}
 
-		_, err = tx.Exec("INSERT INTO memory_entities (memory_id, entity_id) VALUES (?, ?)", memoryID, entityID)
-		if err != nil {
+	if chunks != nil {
+		if _, err := storeEmbeddings(tx, memoryID, db.embedder.Model(), chunks[0], vectors[0]); err != nil {
 			tx.Rollback()
 			return 0, err
 		}
 	}
 
-	if err := db.index.Index(strconv.FormatInt(memoryID, 10), content); err != nil {
+	doc := memoryDocument{
+		Type:      "memory",
+		Content:   content,
+		Language:  language,
+		Source:    optional(strings.TrimSpace(in.Source)),
+		Namespace: optional(strings.TrimSpace(in.Namespace)),
+		Tags:      tags,
+		Entities:  entities,
+	}
+	if err := tx.QueryRow("SELECT created_at FROM memories WHERE id = ?", memoryID).Scan(&doc.CreatedAt); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	// The memory is indexed before the transaction commits, so that a
+	// failure to index it leaves nothing behind; the index entries are
+	// removed again if the commit fails.
+	if err := db.index.Index(strconv.FormatInt(memoryID, 10), doc); err != nil {
 		tx.Rollback()
 		return 0, fmt.Errorf("failed to index memory: %w", err)
 	}
+	var chunkCount int
+	if chunks != nil {
+		chunkCount = len(vectors[0])
+		if err := db.indexVectors(memoryID, 0, vectors[0]); err != nil {
+			tx.Rollback()
+			return 0, errors.Join(err, db.unindexMemory(memoryID, chunkCount))
+		}
+	}
 
-	return memoryID, tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Join(err, db.unindexMemory(memoryID, chunkCount))
+	}
+	return memoryID, nil
 }
 
-// SearchMemories searches for memories in the bleve index.
-func (db *DB) SearchMemories(query string) ([]string, error) {
-	queryObj := bleve.NewMatchQuery(query)
-	searchRequest := bleve.NewSearchRequest(queryObj)
-	searchResult, err := db.index.Search(searchRequest)
-	if err != nil {
-		return nil, fmt.Errorf("failed to search index: %w", err)
+// unindexMemory removes a memory and the vectors of its first chunks from
+// the search and vector indexes.
+func (db *DB) unindexMemory(memoryID int64, chunks int) error {
+	if err := db.index.Delete(strconv.FormatInt(memoryID, 10)); err != nil {
+		return fmt.Errorf("failed to remove memory from index: %w", err)
 	}
-
-	var memories []string
-	for _, hit := range searchResult.Hits {
-		id, err := strconv.ParseInt(hit.ID, 10, 64)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse memory ID: %w", err)
-		}
-		content, err := db.GetMemory(id)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get memory content: %w", err)
-		}
-		memories = append(memories, content)
+	if db.vectors == nil || chunks == 0 {
+		return nil
 	}
-
-	return memories, nil
+	keys := make([]uint64, chunks)
+	for i := range keys {
+		keys[i] = vectorKey(memoryID, i)
+	}
+	if err := db.vectors.Delete(keys...); err != nil {
+		return fmt.Errorf("failed to update vector index: %w", err)
+	}
+	return nil
 }
 
 // GetMemory gets a memory from the database.
@@ -177,35 +379,35 @@ type Relationship struct {
 	SourceID int64  `json:"source_id"`
 	TargetID int64  `json:"target_id"`
 	Type     string `json:"type"`
+	// Source and Target are the names of the related entities.
+	Source         string          `json:"source,omitempty"`
+	Target         string          `json:"target,omitempty"`
+	Weight         float64         `json:"weight"`
+	Confidence     float64         `json:"confidence"`
+	Properties     json.RawMessage `json:"properties,omitempty"`
+	SourceMemoryID int64           `json:"source_memory_id,omitempty"`
+	ValidFrom      *time.Time      `json:"valid_from,omitempty"`
+	ValidTo        *time.Time      `json:"valid_to,omitempty"`
 }
 
 // GetRelationships retrieves all relationships from the database.
 func (db *DB) GetRelationships() ([]Relationship, error) {
-	rows, err := db.Query("SELECT id, source_id, target_id, type FROM relationships")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var relationships []Relationship
-	for rows.Next() {
-		var rel Relationship
-		if err := rows.Scan(&rel.ID, &rel.SourceID, &rel.TargetID, &rel.Type); err != nil {
-			return nil, err
-		}
-		relationships = append(relationships, rel)
-	}
-
-	return relationships, nil
+	return db.ListRelationships(RelationshipFilter{})
 }
 
-// AddRelationship adds a new relationship to the database.
+// AddRelationship adds a new relationship to the database. If an identical
+// relationship already exists, its ID is returned instead.
 func (db *DB) AddRelationship(sourceID, targetID int64, relType string) (int64, error) {
-	result, err := db.Exec("INSERT INTO relationships (source_id, target_id, type) VALUES (?, ?, ?)", sourceID, targetID, relType)
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	id, _, err := insertRelationship(tx, sourceID, targetID, relType, RelationshipInput{})
 	if err != nil {
+		tx.Rollback()
 		return 0, err
 	}
-	return result.LastInsertId()
+	return id, tx.Commit()
 }
 
 // GetSnapshot gets a snapshot of the database.
@@ -224,8 +426,44 @@ func (db *DB) GetDB() *sql.DB {
 	return db.DB
 }
 
+// StoreSize returns the number of bytes the database and its search and
+// vector indexes take up.
+func (db *DB) StoreSize() (int64, error) {
+	var pages, pageSize int64
+	if err := db.QueryRow("PRAGMA page_count").Scan(&pages); err != nil {
+		return 0, err
+	}
+	if err := db.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	size := pages * pageSize
+	for _, dir := range []string{db.indexPath, db.vectorPath} {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return 0, fmt.Errorf("failed to measure %s: %w", filepath.Base(dir), err)
+		}
+	}
+	return size, nil
+}
+
 // Close closes the database connection.
 func (db *DB) Close() error {
+	if err := db.stopAccessTracking(); err != nil {
+		return fmt.Errorf("failed to write memory accesses: %w", err)
+	}
 	err := db.DB.Close()
 	if err != nil {
 		return err
@@ -235,5 +473,10 @@ func (db *DB) Close() error {
 			return fmt.Errorf("failed to close bleve index: %w", err)
 		}
 	}
+	if db.vectors != nil {
+		if err := db.vectors.Close(); err != nil {
+			return fmt.Errorf("failed to close vector index: %w", err)
+		}
+	}
 	return nil
 }
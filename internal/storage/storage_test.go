@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"database/sql"
+	"path/filepath"
 	"testing"
 )
 
@@ -37,4 +39,105 @@ func TestDB(t *testing.T) {
 	if memories[0] != content {
 		t.Errorf("expected memory content to be '%s', got '%s'", content, memories[0])
 	}
-}
\ No newline at end of file
+}
+
+// newTestDB opens a migrated database in a temporary directory.
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return db
+}
+
+// legacySchema is the schema databases were created with before columns
+// started being added through migrations.
+const legacySchema = `
+CREATE TABLE memories (id INTEGER PRIMARY KEY AUTOINCREMENT, content TEXT NOT NULL, created_at DATETIME DEFAULT CURRENT_TIMESTAMP);
+CREATE TABLE entities (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL UNIQUE, type TEXT NOT NULL);
+CREATE TABLE memory_entities (memory_id INTEGER NOT NULL, entity_id INTEGER NOT NULL, PRIMARY KEY (memory_id, entity_id));
+CREATE TABLE relationships (id INTEGER PRIMARY KEY AUTOINCREMENT, source_id INTEGER NOT NULL, target_id INTEGER NOT NULL, type TEXT NOT NULL);
+INSERT INTO memories (content) VALUES ('legacy memory');
+INSERT INTO entities (name, type) VALUES ('Legacy Service', 'service'), ('Team', 'team');
+INSERT INTO memory_entities VALUES (1, 1);
+INSERT INTO relationships (source_id, target_id, type) VALUES (2, 1, 'owns'), (2, 1, 'owns');
+`
+
+func TestMigrateLegacySchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	legacy, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("failed to open legacy database: %v", err)
+	}
+	if _, err := legacy.Exec(legacySchema); err != nil {
+		t.Fatalf("failed to create legacy schema: %v", err)
+	}
+	legacy.Close()
+
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("failed to migrate legacy database: %v", err)
+	}
+	// Migrating twice must be harmless.
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("failed to re-run migrations: %v", err)
+	}
+
+	entity, err := db.GetEntity("legacy   service")
+	if err != nil {
+		t.Fatalf("expected legacy entity to be found by its normalized name: %v", err)
+	}
+	if entity.ID != 1 {
+		t.Errorf("expected entity 1, got %d", entity.ID)
+	}
+	relationships, err := db.GetRelationships()
+	if err != nil {
+		t.Fatalf("failed to get relationships: %v", err)
+	}
+	if len(relationships) != 1 || relationships[0].Weight != 1 {
+		t.Errorf("expected duplicate legacy relationships to collapse into one, got %+v", relationships)
+	}
+	if _, err := db.AddMemory("new memory", []string{"Team"}); err != nil {
+		t.Errorf("failed to add memory after migration: %v", err)
+	}
+}
+
+func TestCreateMemoryCommitFailure(t *testing.T) {
+	db := newTestDB(t)
+	// A deferred foreign key that every new memory violates makes the
+	// commit fail after the memory was written and indexed.
+	db.SetMaxOpenConns(1)
+	for _, stmt := range []string{
+		"PRAGMA foreign_keys = ON",
+		"CREATE TABLE parents (id INTEGER PRIMARY KEY)",
+		"CREATE TABLE orphans (parent_id INTEGER REFERENCES parents(id) DEFERRABLE INITIALLY DEFERRED)",
+		"CREATE TRIGGER orphan_memories AFTER INSERT ON memories BEGIN INSERT INTO orphans VALUES (NEW.id); END",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	if _, err := db.AddMemory("the deploy freeze starts friday", nil); err == nil {
+		t.Fatal("expected the commit to fail")
+	}
+	result, err := db.Search(SearchOptions{Query: "deploy freeze"})
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if len(result.Hits) != 0 {
+		t.Errorf("expected the uncommitted memory to be removed from the index, got %+v", result.Hits)
+	}
+	if count, err := db.index.DocCount(); err != nil || count != 0 {
+		t.Errorf("expected an empty index, got %d documents (err %v)", count, err)
+	}
+}
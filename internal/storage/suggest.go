@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxSuggestions is the number of corrected queries a search that
+	// found nothing suggests.
+	maxSuggestions = 3
+	// minSuggestLength is the length in runes of the shortest word that
+	// gets corrected.
+	minSuggestLength = 3
+)
+
+// spellingCandidate is a known word or entity name close to a word of a
+// query. Count is the number of memories using it.
+type spellingCandidate struct {
+	text     string
+	distance int
+	count    uint64
+}
+
+// queryWord is a run of a query that may need correcting: letters, digits,
+// hyphens and underscores. start and end are byte offsets into the query.
+type queryWord struct {
+	start, end int
+	text       string
+	candidates []spellingCandidate
+}
+
+// Suggest returns up to maxSuggestions corrections of a query whose words
+// are not found in any memory, best first. Words are compared with the
+// words of memory content and with entity names and aliases, and replaced
+// by those at most one edit away, or two for words longer than four
+// letters; the ones used by more memories come first. Stop words, numbers,
+// field names and filter values other than entities are left alone. A
+// query without misspelled words has no suggestions.
+func (db *DB) Suggest(query string) ([]string, error) {
+	words := suggestableWords(query)
+	if len(words) == 0 {
+		return nil, nil
+	}
+	known := make([]bool, len(words))
+	consider := func(i int, text string, count uint64) {
+		w := &words[i]
+		if text == w.text {
+			known[i] = true
+			return
+		}
+		limit := maxEdits(w.text)
+		if d := utf8.RuneCountInString(text) - utf8.RuneCountInString(w.text); d > limit || d < -limit {
+			return
+		}
+		if d := levenshtein([]rune(w.text), []rune(text)); d <= limit {
+			w.candidates = append(w.candidates, spellingCandidate{text: text, distance: d, count: count})
+		}
+	}
+
+	dict, err := db.index.FieldDict("content_words")
+	if err != nil {
+		return nil, err
+	}
+	for {
+		entry, err := dict.Next()
+		if err != nil {
+			dict.Close()
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+		for i, w := range words {
+			// Hyphenated words are split in content, so only entity
+			// names can match them whole.
+			if !strings.ContainsAny(w.text, "-_") {
+				consider(i, entry.Term, entry.Count)
+			}
+		}
+	}
+	if err := dict.Close(); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT e.norm_name, COUNT(me.memory_id) FROM entities e
+			LEFT JOIN memory_entities me ON me.entity_id = e.id GROUP BY e.id
+		UNION ALL SELECT a.norm_alias, COUNT(me.memory_id) FROM entity_aliases a
+			LEFT JOIN memory_entities me ON me.entity_id = a.entity_id GROUP BY a.norm_alias`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			name  string
+			count uint64
+		)
+		if err := rows.Scan(&name, &count); err != nil {
+			return nil, err
+		}
+		if strings.Contains(name, " ") {
+			continue
+		}
+		for i := range words {
+			consider(i, name, count)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var misspelled []queryWord
+	for i, w := range words {
+		if known[i] || len(w.candidates) == 0 {
+			continue
+		}
+		sort.Slice(w.candidates, func(a, b int) bool {
+			ca, cb := w.candidates[a], w.candidates[b]
+			if ca.distance != cb.distance {
+				return ca.distance < cb.distance
+			}
+			if ca.count != cb.count {
+				return ca.count > cb.count
+			}
+			return ca.text < cb.text
+		})
+		misspelled = append(misspelled, w)
+	}
+	return corrections(query, misspelled), nil
+}
+
+// corrections builds the suggested queries: the first replaces every
+// misspelled word with its best candidate, the next ones with their second
+// and third best where they have them.
+func corrections(query string, misspelled []queryWord) []string {
+	if len(misspelled) == 0 {
+		return nil
+	}
+	var suggestions []string
+	seen := map[string]bool{}
+	for k := 0; k < maxSuggestions; k++ {
+		var b strings.Builder
+		last, more := 0, false
+		for _, w := range misspelled {
+			b.WriteString(query[last:w.start])
+			b.WriteString(w.candidates[min(k, len(w.candidates)-1)].text)
+			last = w.end
+			more = more || k < len(w.candidates)-1
+		}
+		b.WriteString(query[last:])
+		if s := b.String(); !seen[s] {
+			seen[s] = true
+			suggestions = append(suggestions, s)
+		}
+		if !more {
+			break
+		}
+	}
+	return suggestions
+}
+
+// suggestableWords returns the words of a query that may be misspelled.
+func suggestableWords(query string) []queryWord {
+	isWordRune := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_'
+	}
+	var words []queryWord
+	field := ""
+	for i := 0; i < len(query); {
+		r, size := utf8.DecodeRuneInString(query[i:])
+		if !isWordRune(r) {
+			if unicode.IsSpace(r) {
+				field = ""
+			}
+			i += size
+			continue
+		}
+		start := i
+		for i < len(query) {
+			r, size := utf8.DecodeRuneInString(query[i:])
+			if !isWordRune(r) {
+				break
+			}
+			i += size
+		}
+		// A leading '-' excludes the word in the query-string syntax.
+		raw := strings.TrimLeft(query[start:i], "-_")
+		start += i - start - len(raw)
+		raw = strings.TrimRight(raw, "-_")
+		end := start + len(raw)
+		text := strings.ToLower(raw)
+		if i < len(query) && query[i] == ':' {
+			if _, ok := queryFields[text]; ok {
+				field = text
+				continue
+			}
+		}
+		if field != "" && field != "entity" && field != "content" {
+			continue
+		}
+		if utf8.RuneCountInString(text) < minSuggestLength || !strings.ContainsFunc(text, unicode.IsLetter) {
+			continue
+		}
+		if _, stop := languageHints[text]; stop {
+			continue
+		}
+		words = append(words, queryWord{start: start, end: end, text: text})
+	}
+	return words
+}
+
+// maxEdits is the number of edits a correction of word may make.
+func maxEdits(word string) int {
+	if utf8.RuneCountInString(word) > 4 {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSuggest(t *testing.T) {
+	db := newTestDB(t)
+	for _, m := range []struct {
+		content  string
+		entities []string
+	}{
+		{"the billing service retries failed charges", []string{"billing-service"}},
+		{"the billing team owns invoices", []string{"billing-service"}},
+		{"the bidding engine runs nightly", nil},
+	} {
+		if _, err := db.AddMemory(m.content, m.entities); err != nil {
+			t.Fatalf("failed to add memory: %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		// The more common of two words as close comes first.
+		{"biling retries", []string{"billing retries", "bidding retries"}},
+		{"who owns biling-servce?", []string{"who owns billing-service?"}},
+		{"+billing -invoces", []string{"+billing -invoices"}},
+		{"entity:biling-service tag:billng", []string{"entity:billing-service tag:billng"}},
+		{"billing service", nil},
+		{"the of and", nil},
+		{"zzzzzz", nil},
+	} {
+		got, err := db.Suggest(tc.query)
+		if err != nil {
+			t.Fatalf("%q: failed to suggest: %v", tc.query, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: expected %q, got %q", tc.query, tc.want, got)
+		}
+	}
+}
+
+func TestSearchSuggestions(t *testing.T) {
+	db := newTestDB(t)
+	id, err := db.AddMemory("the payments gateway times out under load", []string{"payments-gateway"})
+	if err != nil {
+		t.Fatalf("failed to add memory: %v", err)
+	}
+
+	result, err := db.Search(SearchOptions{Query: "paymnets timeouts"})
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if len(result.Hits) != 0 || result.Retried || len(result.Suggestions) == 0 || result.Suggestions[0] != "payments timeouts" {
+		t.Errorf("expected no hits and a suggestion, got %+v", result)
+	}
+
+	result, err = db.Search(SearchOptions{Query: "paymnets timeouts", FuzzyRetry: true})
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if len(result.Hits) != 1 || result.Hits[0].ID != id || !result.Retried || len(result.Suggestions) == 0 {
+		t.Errorf("expected the retry to find the memory, got %+v", result)
+	}
+
+	// A search that finds something suggests nothing.
+	result, err = db.Search(SearchOptions{Query: "payments", FuzzyRetry: true})
+	if err != nil || len(result.Hits) != 1 || result.Retried || result.Suggestions != nil {
+		t.Errorf("expected a plain result, got %+v, %v", result, err)
+	}
+}
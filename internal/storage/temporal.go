@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// validAt returns a SQL condition, with its arguments, that selects the rows
+// of the given table alias whose validity interval contains t. A zero t
+// means the current time. Rows are valid from valid_from inclusive to
+// valid_to exclusive, and a NULL bound is open-ended.
+func validAt(alias string, t time.Time) (string, []interface{}) {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	ts := formatTime(t)
+	return fmt.Sprintf("(%[1]s.valid_from IS NULL OR %[1]s.valid_from <= ?) AND (%[1]s.valid_to IS NULL OR %[1]s.valid_to > ?)", alias),
+		[]interface{}{ts, ts}
+}
+
+// nullableTime converts a scanned nullable timestamp to a pointer.
+func nullableTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	v := t.Time
+	return &v
+}
+
+// timeOrNow returns t, or the current time when t is zero.
+func timeOrNow(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Now()
+	}
+	return t
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestFunctionalRelationshipHistory(t *testing.T) {
+	db := newTestDB(t)
+
+	first, _, err := db.CreateRelationship(RelationshipInput{
+		Source: "Alice", Target: "Acme", Type: "works_at", Functional: true, ValidFrom: date(2020, 1, 1),
+	})
+	if err != nil {
+		t.Fatalf("failed to create relationship: %v", err)
+	}
+	if first.ValidFrom == nil || !first.ValidFrom.Equal(date(2020, 1, 1)) || first.ValidTo != nil {
+		t.Fatalf("expected an open relationship from 2020, got %+v", first)
+	}
+	second, created, err := db.CreateRelationship(RelationshipInput{
+		Source: "Alice", Target: "Globex", Type: "works_at", Functional: true, ValidFrom: date(2023, 6, 1),
+	})
+	if err != nil || !created {
+		t.Fatalf("failed to create second relationship: created=%v err=%v", created, err)
+	}
+
+	current, err := db.ListRelationships(RelationshipFilter{Entity: "Alice", Type: "works_at"})
+	if err != nil {
+		t.Fatalf("failed to list relationships: %v", err)
+	}
+	if len(current) != 1 || current[0].ID != second.ID {
+		t.Fatalf("expected only the Globex relationship to be current, got %+v", current)
+	}
+
+	past, err := db.GetRelationshipsAsOf(date(2021, 1, 1))
+	if err != nil {
+		t.Fatalf("failed to get relationships as of 2021: %v", err)
+	}
+	if len(past) != 1 || past[0].Target != "Acme" {
+		t.Fatalf("expected Acme as of 2021, got %+v", past)
+	}
+	if past[0].ValidTo == nil || !past[0].ValidTo.Equal(date(2023, 6, 1)) {
+		t.Errorf("expected the Acme relationship to end when Globex started, got %v", past[0].ValidTo)
+	}
+
+	history, err := db.ListRelationships(RelationshipFilter{Entity: "Alice", History: true})
+	if err != nil {
+		t.Fatalf("failed to list history: %v", err)
+	}
+	if len(history) != 2 {
+		t.Errorf("expected both versions in the history, got %d", len(history))
+	}
+
+	// A backdated relationship ends when the current one started, leaving
+	// one current target.
+	backdated, created, err := db.CreateRelationship(RelationshipInput{
+		Source: "Alice", Target: "Initech", Type: "works_at", Functional: true, ValidFrom: date(2022, 1, 1),
+	})
+	if err != nil || !created {
+		t.Fatalf("failed to create backdated relationship: created=%v err=%v", created, err)
+	}
+	if backdated.ValidTo == nil || !backdated.ValidTo.Equal(date(2023, 6, 1)) {
+		t.Errorf("expected the backdated relationship to end when Globex started, got %v", backdated.ValidTo)
+	}
+	current, err = db.ListRelationships(RelationshipFilter{Entity: "Alice", Type: "works_at"})
+	if err != nil || len(current) != 1 || current[0].ID != second.ID {
+		t.Errorf("expected Globex to stay the only current relationship, got %+v, %v", current, err)
+	}
+	// It also ends the relationship that held when it started.
+	past, err = db.GetRelationshipsAsOf(date(2022, 6, 1))
+	if err != nil || len(past) != 1 || past[0].Target != "Initech" {
+		t.Errorf("expected Initech as of mid-2022, got %+v, %v", past, err)
+	}
+	past, err = db.GetRelationshipsAsOf(date(2021, 1, 1))
+	if err != nil || len(past) != 1 || past[0].Target != "Acme" || !past[0].ValidTo.Equal(date(2022, 1, 1)) {
+		t.Errorf("expected Acme until 2022 as of 2021, got %+v, %v", past, err)
+	}
+
+	// A non-functional type keeps several current targets.
+	for _, target := range []string{"Go", "Rust"} {
+		if _, _, err := db.CreateRelationship(RelationshipInput{Source: "Alice", Target: target, Type: "knows"}); err != nil {
+			t.Fatalf("failed to create relationship: %v", err)
+		}
+	}
+	knows, err := db.ListRelationships(RelationshipFilter{Entity: "Alice", Type: "knows"})
+	if err != nil || len(knows) != 2 {
+		t.Errorf("expected two current knows relationships, got %d (err %v)", len(knows), err)
+	}
+}
+
+func TestExpireRelationships(t *testing.T) {
+	db := newTestDB(t)
+
+	if _, _, err := db.CreateRelationship(RelationshipInput{Source: "Alice", Target: "Bob", Type: "manages", ValidFrom: date(2020, 1, 1)}); err != nil {
+		t.Fatalf("failed to create relationship: %v", err)
+	}
+	n, err := db.ExpireRelationships("Alice", "Bob", "", date(2022, 1, 1))
+	if err != nil || n != 1 {
+		t.Fatalf("expected one relationship to expire, got %d (err %v)", n, err)
+	}
+	if current, _ := db.GetRelationships(); len(current) != 0 {
+		t.Errorf("expected no current relationships, got %+v", current)
+	}
+	if past, _ := db.GetRelationshipsAsOf(date(2021, 1, 1)); len(past) != 1 {
+		t.Errorf("expected the relationship to hold in 2021, got %+v", past)
+	}
+
+	// The same edge can start holding again.
+	_, created, err := db.CreateRelationship(RelationshipInput{Source: "Alice", Target: "Bob", Type: "manages"})
+	if err != nil || !created {
+		t.Fatalf("expected a new version of the relationship, created=%v err=%v", created, err)
+	}
+	if n, _ := db.ExpireRelationships("Alice", "Bob", "", time.Time{}); n != 1 {
+		t.Errorf("expected only the current version to expire, got %d", n)
+	}
+}
+
+func TestExpireObservations(t *testing.T) {
+	db := newTestDB(t)
+
+	if _, err := db.CreateEntity("Alice", "person"); err != nil {
+		t.Fatalf("failed to create entity: %v", err)
+	}
+	if _, err := db.AddObservations("Alice", []string{"lives in Paris", "likes tea"}); err != nil {
+		t.Fatalf("failed to add observations: %v", err)
+	}
+	if n, err := db.ExpireObservations("Alice", []string{"lives in Paris"}, time.Time{}); err != nil || n != 1 {
+		t.Fatalf("expected one observation to expire, got %d (err %v)", n, err)
+	}
+
+	current, err := db.ListObservations("Alice", time.Time{})
+	if err != nil {
+		t.Fatalf("failed to list observations: %v", err)
+	}
+	if len(current) != 1 || current[0].Content != "likes tea" {
+		t.Fatalf("expected only the current observation, got %+v", current)
+	}
+	if all, _ := db.GetObservations(); len(all) != 1 {
+		t.Errorf("expected one current observation overall, got %+v", all)
+	}
+	if past, _ := db.GetObservationsAsOf(time.Now().Add(-time.Hour)); len(past) != 0 {
+		t.Errorf("expected no observations before they were added, got %+v", past)
+	}
+
+	// Re-adding an expired observation starts a new version.
+	added, err := db.AddObservations("Alice", []string{"lives in Paris"})
+	if err != nil || len(added) != 1 {
+		t.Fatalf("expected the observation to be added again, got %+v (err %v)", added, err)
+	}
+	if nodes, _ := db.SearchNodes("Paris"); len(nodes) != 1 {
+		t.Errorf("expected Alice to match the current observation, got %+v", nodes)
+	}
+}
@@ -0,0 +1,317 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"strings"
+
+	"github.com/wassmi/nodimus-memory/internal/hnsw"
+)
+
+const (
+	// chunkBits is the number of low bits of a vector key that hold the
+	// chunk; the memory ID is in the bits above.
+	chunkBits = 20
+	// semanticCandidates is how many chunks a semantic search looks at for
+	// each memory it returns, since a memory may match with several chunks
+	// and superseded memories are dropped.
+	semanticCandidates = 4
+	// vectorBatchSize is the number of vectors added to the index at once
+	// when it is filled from the database.
+	vectorBatchSize = 500
+)
+
+// ErrNoEmbedder is returned for a semantic search or vector index
+// operation while no embedder is set.
+var ErrNoEmbedder = errors.New("semantic search needs embeddings to be enabled")
+
+// vectorKey identifies the vector of a memory chunk in the vector index.
+func vectorKey(memoryID int64, chunk int) uint64 {
+	return uint64(memoryID)<<chunkBits | uint64(chunk)
+}
+
+// splitVectorKey reverses vectorKey.
+func splitVectorKey(key uint64) (int64, int) {
+	return int64(key >> chunkBits), int(key & (1<<chunkBits - 1))
+}
+
+// OpenVectorIndex opens the vector index next to the search index and
+// brings it in line with the stored embeddings of the current embedder. An
+// index of another model, or one that is corrupt, is rebuilt.
+func (db *DB) OpenVectorIndex(params hnsw.Params) error {
+	if db.embedder == nil {
+		return ErrNoEmbedder
+	}
+	index, err := hnsw.Open(db.vectorPath, params)
+	if errors.Is(err, hnsw.ErrCorrupt) {
+		// The embeddings in the database are enough to rebuild it.
+		if err := os.RemoveAll(db.vectorPath); err != nil {
+			return err
+		}
+		index, err = hnsw.Open(db.vectorPath, params)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open vector index: %w", err)
+	}
+	db.vectors = index
+
+	if index.Model() != db.embedder.Model() || index.Dimensions() != db.embedder.Dimensions() {
+		if err := index.Reset(db.embedder.Model(), db.embedder.Dimensions()); err != nil {
+			return fmt.Errorf("failed to reset vector index: %w", err)
+		}
+	}
+	_, err = db.syncVectorIndex()
+	return err
+}
+
+// RebuildVectorIndex empties the vector index and fills it from the stored
+// embeddings of the current embedder. It returns the number of vectors
+// indexed.
+func (db *DB) RebuildVectorIndex() (int, error) {
+	if db.embedder == nil || db.vectors == nil {
+		return 0, ErrNoEmbedder
+	}
+	if err := db.vectors.Reset(db.embedder.Model(), db.embedder.Dimensions()); err != nil {
+		return 0, fmt.Errorf("failed to reset vector index: %w", err)
+	}
+	return db.syncVectorIndex()
+}
+
+// syncVectorIndex adds the stored embeddings missing from the vector index
+// and removes the vectors that have no embedding, repairing changes a crash
+// kept from reaching one of the two. It returns the number of vectors
+// added.
+func (db *DB) syncVectorIndex() (int, error) {
+	model := db.embedder.Model()
+	indexed := map[uint64]bool{}
+	for _, key := range db.vectors.Keys() {
+		indexed[key] = true
+	}
+
+	rows, err := db.Query("SELECT memory_id, chunk FROM memory_embeddings WHERE model = ?", model)
+	if err != nil {
+		return 0, err
+	}
+	missing := 0
+	for rows.Next() {
+		var (
+			id    int64
+			chunk int
+		)
+		if err := rows.Scan(&id, &chunk); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		key := vectorKey(id, chunk)
+		if indexed[key] {
+			delete(indexed, key)
+		} else {
+			missing++
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// The keys left have no embedding any more.
+	stale := make([]uint64, 0, len(indexed))
+	for key := range indexed {
+		stale = append(stale, key)
+	}
+	if err := db.vectors.Delete(stale...); err != nil {
+		return 0, fmt.Errorf("failed to update vector index: %w", err)
+	}
+	if missing == 0 {
+		return 0, nil
+	}
+
+	present := map[uint64]bool{}
+	for _, key := range db.vectors.Keys() {
+		present[key] = true
+	}
+	rows, err = db.Query("SELECT memory_id, chunk, vector FROM memory_embeddings WHERE model = ?", model)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	added := 0
+	var batch []hnsw.Item
+	for rows.Next() {
+		var (
+			id    int64
+			chunk int
+			blob  []byte
+		)
+		if err := rows.Scan(&id, &chunk, &blob); err != nil {
+			return added, err
+		}
+		key := vectorKey(id, chunk)
+		if present[key] {
+			continue
+		}
+		vector, err := decodeVector(blob)
+		if err != nil {
+			return added, fmt.Errorf("memory %d chunk %d: %w", id, chunk, err)
+		}
+		batch = append(batch, hnsw.Item{Key: key, Vector: vector})
+		if len(batch) == vectorBatchSize {
+			if err := db.vectors.Add(batch...); err != nil {
+				return added, fmt.Errorf("failed to update vector index: %w", err)
+			}
+			added += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return added, err
+	}
+	if err := db.vectors.Add(batch...); err != nil {
+		return added, fmt.Errorf("failed to update vector index: %w", err)
+	}
+	return added + len(batch), nil
+}
+
+// indexVectors puts the chunk vectors of a memory into the vector index,
+// removing those of chunks beyond the memory's previous chunk count.
+func (db *DB) indexVectors(memoryID int64, previous int, vectors [][]float32) error {
+	if db.vectors == nil {
+		return nil
+	}
+	items := make([]hnsw.Item, len(vectors))
+	for i, v := range vectors {
+		items[i] = hnsw.Item{Key: vectorKey(memoryID, i), Vector: v}
+	}
+	var gone []uint64
+	for chunk := len(vectors); chunk < previous; chunk++ {
+		gone = append(gone, vectorKey(memoryID, chunk))
+	}
+	if err := db.vectors.Delete(gone...); err != nil {
+		return fmt.Errorf("failed to update vector index: %w", err)
+	}
+	if err := db.vectors.Add(items...); err != nil {
+		return fmt.Errorf("failed to update vector index: %w", err)
+	}
+	return nil
+}
+
+// semanticMatch is a memory found by its meaning, with its most similar
+// chunk.
+type semanticMatch struct {
+	id         int64
+	chunk      int
+	similarity float64
+}
+
+// semanticMatches returns up to n memories whose chunks are most similar to
+// text, most similar first. Superseded memories are left out unless
+// history is set, and memories in another language when language is.
+func (db *DB) semanticMatches(text string, history bool, language string, n int) ([]semanticMatch, error) {
+	if db.embedder == nil || db.vectors == nil {
+		return nil, ErrNoEmbedder
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	vectors, err := db.embedder.Embed(context.Background(), []string{text})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+	results, err := db.vectors.Search(vectors[0], n*semanticCandidates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search vector index: %w", err)
+	}
+
+	skip := map[int64]bool{}
+	if !history {
+		superseded, err := db.queryIDs("SELECT DISTINCT target_id FROM memory_links WHERE type = ?", LinkSupersedes)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range superseded {
+			skip[id] = true
+		}
+	}
+	if language != "" {
+		var ids []int64
+		for _, r := range results {
+			id, _ := splitVectorKey(r.Key)
+			ids = append(ids, id)
+		}
+		if len(ids) > 0 {
+			placeholders, args := inList(ids)
+			other, err := db.queryIDs("SELECT id FROM memories WHERE id IN ("+placeholders+") AND language != ?", append(args, language)...)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range other {
+				skip[id] = true
+			}
+		}
+	}
+	var matches []semanticMatch
+	for _, r := range results {
+		id, chunk := splitVectorKey(r.Key)
+		if skip[id] {
+			continue
+		}
+		// Results come most similar first, so the first chunk of a
+		// memory is its best.
+		skip[id] = true
+		matches = append(matches, semanticMatch{id: id, chunk: chunk, similarity: r.Similarity})
+		if len(matches) == n {
+			break
+		}
+	}
+	return matches, nil
+}
+
+// semanticSearch runs a search in QuerySemantic mode.
+func (db *DB) semanticSearch(opts SearchOptions) (SearchResult, error) {
+	if opts.Structured != nil {
+		return SearchResult{}, &QueryError{Pos: -1, Path: "query", Msg: "a structured query cannot be searched semantically"}
+	}
+	if len(opts.Facets) > 0 {
+		return SearchResult{}, fmt.Errorf("%w: facets need a keyword search mode", ErrInvalidFacet)
+	}
+	matches, err := db.semanticMatches(opts.Query, opts.History, opts.Language, searchDepth(opts))
+	if err != nil {
+		return SearchResult{}, err
+	}
+	found := make([]scoredMemory, len(matches))
+	for i, m := range matches {
+		found[i] = scoredMemory{id: m.id, score: m.similarity, chunk: m.chunk}
+		if opts.Explain {
+			found[i].components = &ScoreComponents{Semantic: m.similarity, SemanticRank: i + 1}
+		}
+	}
+	hits, err := db.buildHits(found, opts)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	return SearchResult{Hits: hits, Total: uint64(len(matches))}, nil
+}
+
+// chunkSnippet returns the start of the matched chunk of content as an
+// HTML-escaped snippet. A semantic match has no terms to mark.
+func chunkSnippet(content string, chunk int) []string {
+	chunks := chunkText(content)
+	if chunk >= len(chunks) {
+		return nil
+	}
+	from, to := chunks[chunk].start, runeStart(content, chunks[chunk].start+snippetSize)
+	if to > chunks[chunk].end {
+		to = chunks[chunk].end
+	}
+	snippet := html.EscapeString(content[from:to])
+	if from > 0 {
+		snippet = "…" + snippet
+	}
+	if to < len(content) {
+		snippet += "…"
+	}
+	return []string{snippet}
+}
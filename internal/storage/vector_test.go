@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wassmi/nodimus-memory/internal/embed"
+	"github.com/wassmi/nodimus-memory/internal/hnsw"
+)
+
+// newSemanticDB returns a database at path with a hashing embedder and an
+// open vector index.
+func newSemanticDB(t *testing.T, path string, dims int) *DB {
+	t.Helper()
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	db.SetEmbedder(embed.NewHashEmbedder(dims))
+	if err := db.OpenVectorIndex(hnsw.Params{}); err != nil {
+		t.Fatalf("failed to open vector index: %v", err)
+	}
+	return db
+}
+
+func TestSemanticSearch(t *testing.T) {
+	db := newSemanticDB(t, filepath.Join(t.TempDir(), "test.db"), 256)
+	defer db.Close()
+
+	deploy, err := db.CreateMemory(MemoryInput{Content: "The deployment pipeline failed on staging again"})
+	if err != nil {
+		t.Fatalf("failed to create memory: %v", err)
+	}
+	old, err := db.CreateMemory(MemoryInput{Content: "The deployment pipeline failed on staging last week"})
+	if err != nil {
+		t.Fatalf("failed to create memory: %v", err)
+	}
+	if _, err := db.CreateMemory(MemoryInput{Content: "Grandma's apple pie needs more cinnamon"}); err != nil {
+		t.Fatalf("failed to create memory: %v", err)
+	}
+	if _, _, err := db.LinkMemories(deploy, old, LinkSupersedes); err != nil {
+		t.Fatalf("failed to link memories: %v", err)
+	}
+
+	result, err := db.Search(SearchOptions{Query: "staging deployments failing", Mode: QuerySemantic})
+	if err != nil {
+		t.Fatalf("failed to search: %v", err)
+	}
+	if len(result.Hits) != 2 || result.Hits[0].ID != deploy || result.Hits[0].Score <= result.Hits[1].Score {
+		t.Fatalf("expected the current deployment memory first and the superseded one left out, got %+v", result.Hits)
+	}
+	if len(result.Hits[0].Snippets) != 1 || !strings.HasPrefix(result.Hits[0].Snippets[0], "The deployment") {
+		t.Errorf("expected the matching chunk as a snippet, got %v", result.Hits[0].Snippets)
+	}
+
+	result, err = db.Search(SearchOptions{Query: "staging deployments failing", Mode: QuerySemantic, History: true})
+	if err != nil || len(result.Hits) != 3 {
+		t.Errorf("expected all memories with history, got %+v, %v", result.Hits, err)
+	}
+	// A language restricts the memories found by meaning too.
+	french, err := db.CreateMemory(MemoryInput{Content: "The deployment pipeline failed on staging yesterday", Language: LanguageFrench})
+	if err != nil {
+		t.Fatalf("failed to create memory: %v", err)
+	}
+	result, err = db.Search(SearchOptions{Query: "staging deployments failing", Mode: QuerySemantic, Language: LanguageFrench})
+	if err != nil || len(result.Hits) != 1 || result.Hits[0].ID != french {
+		t.Errorf("expected only the French memory, got %+v, %v", result.Hits, err)
+	}
+	result, err = db.Search(SearchOptions{Query: "staging deployments failing", Mode: QuerySemantic, Language: LanguageEnglish})
+	if err != nil || len(result.Hits) != 2 || result.Hits[0].ID != deploy {
+		t.Errorf("expected the English memories only, got %+v, %v", result.Hits, err)
+	}
+	for _, hit := range result.Hits {
+		if hit.Language != LanguageEnglish {
+			t.Errorf("expected an English memory, got %+v", hit.Memory)
+		}
+	}
+
+	if _, err := db.Search(SearchOptions{Query: "x", Mode: QuerySemantic, Facets: []string{FacetTag}}); !errors.Is(err, ErrInvalidFacet) {
+		t.Errorf("expected facets to be rejected, got %v", err)
+	}
+
+	keyword := newTestDB(t)
+	if _, err := keyword.Search(SearchOptions{Query: "x", Mode: QuerySemantic}); !errors.Is(err, ErrNoEmbedder) {
+		t.Errorf("expected semantic search without embeddings to fail, got %v", err)
+	}
+}
+
+func TestVectorIndexRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db := newSemanticDB(t, path, 32)
+	for _, content := range []string{"first memory", "second memory", "third memory"} {
+		if _, err := db.CreateMemory(MemoryInput{Content: content}); err != nil {
+			t.Fatalf("failed to create memory: %v", err)
+		}
+	}
+	// Lose a vector, as a crash between the database and the index would.
+	if err := db.vectors.Delete(vectorKey(2, 0)); err != nil {
+		t.Fatalf("failed to delete vector: %v", err)
+	}
+	db.Close()
+
+	db = newSemanticDB(t, path, 32)
+	if db.vectors.Len() != 3 {
+		t.Errorf("expected the lost vector to be restored, got %d vectors", db.vectors.Len())
+	}
+	n, err := db.RebuildVectorIndex()
+	if err != nil || n != 3 {
+		t.Errorf("expected a rebuild to index three vectors, got %d, %v", n, err)
+	}
+	db.Close()
+
+	// A corrupt index is rebuilt from the database.
+	if err := os.WriteFile(filepath.Join(path+".hnsw", "graph"), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db = newSemanticDB(t, path, 32)
+	if db.vectors.Len() != 3 {
+		t.Errorf("expected a corrupt index to be rebuilt, got %d vectors", db.vectors.Len())
+	}
+	db.Close()
+
+	// Another model starts the index over; its vectors come with the
+	// embeddings SyncEmbeddings stores.
+	db = newSemanticDB(t, path, 16)
+	defer db.Close()
+	if db.vectors.Len() != 0 || db.vectors.Model() != "hash-v1-16" {
+		t.Errorf("expected an empty index for the new model, got %d vectors of %s", db.vectors.Len(), db.vectors.Model())
+	}
+	if _, err := db.SyncEmbeddings(t.Context()); err != nil {
+		t.Fatalf("failed to sync embeddings: %v", err)
+	}
+	result, err := db.Search(SearchOptions{Query: "second memory", Mode: QuerySemantic})
+	if err != nil || len(result.Hits) != 3 || result.Hits[0].ID != 2 {
+		t.Errorf("expected the new model's vectors to be searched, got %+v, %v", result.Hits, err)
+	}
+}
+
+func TestVectorKey(t *testing.T) {
+	id, chunk := splitVectorKey(vectorKey(123456789, 42))
+	if id != 123456789 || chunk != 42 {
+		t.Errorf("expected memory 123456789 chunk 42, got %d %d", id, chunk)
+	}
+}
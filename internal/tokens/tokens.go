@@ -0,0 +1,90 @@
+// Package tokens estimates how many tokens a text takes up in the context
+// window of a language model. The estimators need no vocabulary, so they
+// are approximate; callers that know their model's tokenizer can plug it in
+// through Estimator.
+package tokens
+
+import (
+	"fmt"
+	"math"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Names of the built-in estimators.
+const (
+	// Chars counts characters; it is the default.
+	Chars = "chars"
+	// Words counts words and punctuation, which suits code and other text
+	// dense in symbols better.
+	Words = "words"
+)
+
+const (
+	// charsPerToken is the average length of a token of English prose in
+	// the common BPE vocabularies.
+	charsPerToken = 4
+	// tokensPerWord is the average number of tokens a word splits into.
+	tokensPerWord = 1.3
+)
+
+// Estimator estimates the number of tokens of a text. The estimate of a
+// concatenation should not exceed the sum of the estimates of its parts,
+// so that texts counted one by one stay within a budget together.
+type Estimator interface {
+	Count(text string) int
+}
+
+// Func adapts a function to the Estimator interface.
+type Func func(text string) int
+
+// Count calls f.
+func (f Func) Count(text string) int { return f(text) }
+
+// CharEstimator counts a token for every PerToken characters, rounding up.
+type CharEstimator struct {
+	PerToken float64
+}
+
+// Count estimates the tokens of text.
+func (e CharEstimator) Count(text string) int {
+	return int(math.Ceil(float64(utf8.RuneCountInString(text)) / e.PerToken))
+}
+
+// WordEstimator counts PerWord tokens for every run of letters and digits,
+// rounding up, and one for every other character that is not a space.
+type WordEstimator struct {
+	PerWord float64
+}
+
+// Count estimates the tokens of text.
+func (e WordEstimator) Count(text string) int {
+	var words, symbols int
+	inWord := false
+	for _, r := range text {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if !inWord {
+				words++
+			}
+			inWord = true
+			continue
+		case !unicode.IsSpace(r):
+			symbols++
+		}
+		inWord = false
+	}
+	return int(math.Ceil(float64(words)*e.PerWord)) + symbols
+}
+
+// New returns the built-in estimator with the given name, Chars when it is
+// empty.
+func New(name string) (Estimator, error) {
+	switch name {
+	case "", Chars:
+		return CharEstimator{PerToken: charsPerToken}, nil
+	case Words:
+		return WordEstimator{PerWord: tokensPerWord}, nil
+	}
+	return nil, fmt.Errorf("unknown tokenizer %q: use %s or %s", name, Chars, Words)
+}
@@ -0,0 +1,46 @@
+package tokens
+
+import "testing"
+
+func TestEstimators(t *testing.T) {
+	chars, err := New("")
+	if err != nil {
+		t.Fatalf("failed to create the default estimator: %v", err)
+	}
+	words, err := New(Words)
+	if err != nil {
+		t.Fatalf("failed to create the word estimator: %v", err)
+	}
+	for _, tc := range []struct {
+		text         string
+		chars, words int
+	}{
+		{"", 0, 0},
+		{"hello", 2, 2},
+		{"we decided to version the payments API", 10, 10},
+		{"db.Query(ctx, id)", 5, 10},
+		{"déjà vu", 2, 3},
+	} {
+		if got := chars.Count(tc.text); got != tc.chars {
+			t.Errorf("chars %q: expected %d tokens, got %d", tc.text, tc.chars, got)
+		}
+		if got := words.Count(tc.text); got != tc.words {
+			t.Errorf("words %q: expected %d tokens, got %d", tc.text, tc.words, got)
+		}
+	}
+
+	// Counting parts apart never undercounts their concatenation.
+	for _, e := range []Estimator{chars, words} {
+		a, b := "the payments API ", "depends on postgres."
+		if e.Count(a)+e.Count(b) < e.Count(a+b) {
+			t.Errorf("%T: expected the parts to count at least as much as the whole", e)
+		}
+	}
+
+	if _, err := New("tiktoken"); err == nil {
+		t.Error("expected an error for an unknown tokenizer")
+	}
+	if got := Func(func(s string) int { return len(s) }).Count("abc"); got != 3 {
+		t.Errorf("expected the function to be called, got %d", got)
+	}
+}